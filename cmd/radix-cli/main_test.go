@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "testing"
+)
+
+func TestSplitArgs(t *T) {
+	for _, test := range []struct {
+		line string
+		args []string
+	}{
+		{"", nil},
+		{"  PING \n", []string{"PING"}},
+		{"SET foo bar", []string{"SET", "foo", "bar"}},
+		{`SET "foo bar" 'baz qux'`, []string{"SET", "foo bar", "baz qux"}},
+		{`SET k "a\tb\x41\"\\"`, []string{"SET", "k", "a\tbA\"\\"}},
+		{`SET k "\xZZ"`, []string{"SET", "k", "xZZ"}},
+		{`SET k 'it\'s'`, []string{"SET", "k", "it's"}},
+		{`SET k ""`, []string{"SET", "k", ""}},
+	} {
+		args, err := splitArgs(test.line)
+		assert.Nil(t, err, test.line)
+		assert.Equal(t, test.args, args, test.line)
+	}
+	for _, line := range []string{`SET "foo`, `SET 'foo`, `SET "foo"bar`} {
+		_, err := splitArgs(line)
+		assert.NotNil(t, err, line)
+	}
+}
@@ -0,0 +1,240 @@
+package admin
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// ACLUser describes an ACL user (redis 6 and up). It can be built up and
+// passed to ACLSetUser, and is what ACLGetUser returns.
+type ACLUser struct {
+	Name    string
+	Enabled bool
+
+	// If true the user can authenticate with any password
+	NoPass bool
+
+	// Plaintext passwords to add to the user. These are never returned by the
+	// server, only their hashes are.
+	Passwords []string
+
+	// SHA256 hashes (in hex) of the user's passwords
+	PasswordHashes []string
+
+	// Key patterns the user may access, e.g. "*" or "cache:*". Patterns
+	// starting with "%" (e.g. "%R~cache:*", redis 7 and up) are used as-is.
+	Keys []string
+
+	// Pub/sub channel patterns the user may access (redis 6.2 and up)
+	Channels []string
+
+	// Command rules, e.g. "+@read" or "-flushall". These are applied in
+	// order, so later rules override earlier ones. Normally built up using
+	// the Allow and Deny methods.
+	Commands []string
+}
+
+// AllowCategory adds a rule allowing all commands in the given category (e.g.
+// "read" or "all") and returns the ACLUser
+func (u *ACLUser) AllowCategory(cat string) *ACLUser {
+	u.Commands = append(u.Commands, "+@"+cat)
+	return u
+}
+
+// DenyCategory adds a rule denying all commands in the given category and
+// returns the ACLUser
+func (u *ACLUser) DenyCategory(cat string) *ACLUser {
+	u.Commands = append(u.Commands, "-@"+cat)
+	return u
+}
+
+// Allow adds rules allowing each of the given commands (e.g. "get" or
+// "config|get") and returns the ACLUser
+func (u *ACLUser) Allow(cmds ...string) *ACLUser {
+	for _, cmd := range cmds {
+		u.Commands = append(u.Commands, "+"+cmd)
+	}
+	return u
+}
+
+// Deny adds rules denying each of the given commands and returns the ACLUser
+func (u *ACLUser) Deny(cmds ...string) *ACLUser {
+	for _, cmd := range cmds {
+		u.Commands = append(u.Commands, "-"+cmd)
+	}
+	return u
+}
+
+// Rules returns the ACL rules describing the user, in the form ACL SETUSER
+// takes them. The first rule is always "reset", so applying the rules fully
+// replaces whatever the user had before.
+func (u *ACLUser) Rules() []string {
+	rules := []string{"reset"}
+	if u.Enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if u.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range u.Passwords {
+		rules = append(rules, ">"+p)
+	}
+	for _, h := range u.PasswordHashes {
+		rules = append(rules, "#"+h)
+	}
+	for _, k := range u.Keys {
+		if strings.HasPrefix(k, "%") {
+			rules = append(rules, k)
+		} else {
+			rules = append(rules, "~"+k)
+		}
+	}
+	for _, ch := range u.Channels {
+		rules = append(rules, "&"+ch)
+	}
+	return append(rules, u.Commands...)
+}
+
+// ACLSetUser creates the given user, or replaces all of its rules if it
+// already exists
+func ACLSetUser(c redis.Cmder, u *ACLUser) error {
+	if u.Name == "" {
+		return errors.New("user has no name")
+	}
+	rules := u.Rules()
+	args := make([]interface{}, 0, len(rules)+2)
+	args = append(args, "SETUSER", u.Name)
+	for _, r := range rules {
+		args = append(args, r)
+	}
+	return c.Cmd("ACL", args...).Err
+}
+
+// ACLGetUser returns the user with the given name. redis.ErrNil is returned if
+// the user doesn't exist.
+func ACLGetUser(c redis.Cmder, name string) (*ACLUser, error) {
+	u, err := ParseACLUser(c.Cmd("ACL", "GETUSER", name))
+	if err != nil {
+		return nil, err
+	}
+	u.Name = name
+	return u, nil
+}
+
+// ACLDelUser deletes the given users, returning the number which existed
+func ACLDelUser(c redis.Cmder, names ...string) (int64, error) {
+	args := make([]interface{}, 0, len(names)+1)
+	args = append(args, "DELUSER")
+	for _, n := range names {
+		args = append(args, n)
+	}
+	return c.Cmd("ACL", args...).Int64()
+}
+
+// ACLUsers returns the names of all ACL users
+func ACLUsers(c redis.Cmder) ([]string, error) {
+	return c.Cmd("ACL", "USERS").List()
+}
+
+// ACLGenPass returns a random password generated by the server, suitable for
+// use with ACLUser.Passwords. If bits is zero or less the server's default
+// (256) is used.
+func ACLGenPass(c redis.Cmder, bits int) (string, error) {
+	if bits <= 0 {
+		return c.Cmd("ACL", "GENPASS").Str()
+	}
+	return c.Cmd("ACL", "GENPASS", bits).Str()
+}
+
+// ParseACLUser parses the reply to an ACL GETUSER command. The returned
+// user's Name is not set, since the reply doesn't contain it. Replies from
+// redis 6.0 (where keys and channels are lists) and 7 and up (where they are
+// space separated strings) are both understood. Selectors are ignored.
+func ParseACLUser(r *redis.Reply) (*ACLUser, error) {
+	m, err := r.Map()
+	if err != nil {
+		return nil, err
+	}
+
+	u := new(ACLUser)
+	var allKeys, allChannels bool
+	if fr, ok := m["flags"]; ok {
+		flags, err := fr.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range flags {
+			switch f {
+			case "on":
+				u.Enabled = true
+			case "nopass":
+				u.NoPass = true
+			case "allkeys":
+				allKeys = true
+			case "allchannels":
+				allChannels = true
+			}
+		}
+	}
+	if pr, ok := m["passwords"]; ok {
+		if u.PasswordHashes, err = pr.List(); err != nil {
+			return nil, err
+		}
+	}
+	if cr, ok := m["commands"]; ok {
+		s, err := cr.Str()
+		if err != nil {
+			return nil, err
+		}
+		u.Commands = strings.Fields(s)
+	}
+	if kr, ok := m["keys"]; ok {
+		if u.Keys, err = aclPatterns(kr, "~"); err != nil {
+			return nil, err
+		}
+	}
+	if cr, ok := m["channels"]; ok {
+		if u.Channels, err = aclPatterns(cr, "&"); err != nil {
+			return nil, err
+		}
+	}
+
+	// redis 6.0 only indicates these with flags
+	if allKeys && !contains(u.Keys, "*") {
+		u.Keys = append([]string{"*"}, u.Keys...)
+	}
+	if allChannels && !contains(u.Channels, "*") {
+		u.Channels = append([]string{"*"}, u.Channels...)
+	}
+	return u, nil
+}
+
+// aclPatterns parses a list of key or channel patterns, which is either a
+// MultiReply of bare patterns or a single string of prefixed ones
+func aclPatterns(r *redis.Reply, prefix string) ([]string, error) {
+	if r.Type == redis.MultiReply || r.Type == redis.SetReply {
+		return r.List()
+	}
+	s, err := r.Str()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(s)
+	for i := range fields {
+		fields[i] = strings.TrimPrefix(fields[i], prefix)
+	}
+	return fields, nil
+}
+
+func contains(l []string, s string) bool {
+	for i := range l {
+		if l[i] == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+)
+
+func TestACL(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	pass, err := ACLGenPass(c, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 64, len(pass))
+
+	u := &ACLUser{
+		Name:      "radix-test",
+		Enabled:   true,
+		Passwords: []string{pass},
+		Keys:      []string{"cache:*"},
+		Channels:  []string{"events"},
+	}
+	u.AllowCategory("read").Deny("keys").Allow("set")
+	assert.Nil(t, ACLSetUser(c, u))
+	defer ACLDelUser(c, u.Name)
+
+	users, err := ACLUsers(c)
+	assert.Nil(t, err)
+	assert.True(t, contains(users, u.Name))
+
+	got, err := ACLGetUser(c, u.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, u.Name, got.Name)
+	assert.True(t, got.Enabled)
+	assert.False(t, got.NoPass)
+	assert.Equal(t, 1, len(got.PasswordHashes))
+	assert.Equal(t, []string{"cache:*"}, got.Keys)
+	assert.Equal(t, []string{"events"}, got.Channels)
+	assert.True(t, contains(got.Commands, "+@read"))
+	assert.True(t, contains(got.Commands, "-keys"))
+	assert.True(t, contains(got.Commands, "+set"))
+
+	// Setting the user again fully replaces its rules
+	assert.Nil(t, ACLSetUser(c, &ACLUser{Name: u.Name, NoPass: true}))
+	got, err = ACLGetUser(c, u.Name)
+	assert.Nil(t, err)
+	assert.False(t, got.Enabled)
+	assert.True(t, got.NoPass)
+	assert.Equal(t, 0, len(got.Keys))
+
+	n, err := ACLDelUser(c, u.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	_, err = ACLGetUser(c, u.Name)
+	assert.Equal(t, redis.ErrNil, err)
+}
@@ -0,0 +1,9 @@
+// The admin package provides typed wrappers around redis' server
+// administration and introspection commands, for use in things like ops
+// dashboards and health checks.
+//
+// Each wrapper takes a redis.Cmder, such as a *redis.Client or *pool.Pool.
+// Note that the commands in this package are server-specific, so a Cmder which
+// spreads commands over multiple servers (e.g. a cluster) doesn't make sense
+// here.
+package admin
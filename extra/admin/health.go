@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// NodeHealth describes the health of a single redis node, see DiagnoseNode
+type NodeHealth struct {
+	Addr      string
+	Reachable bool
+
+	// Why the node isn't reachable, or why the rest of the fields are
+	// (partially) missing
+	Err error
+
+	// Round-trip time of a PING
+	Latency time.Duration
+
+	// "master" or "slave", as reported by INFO
+	Role string
+
+	// Memory used by the node and its maxmemory setting (0 if unlimited), and
+	// the former as a fraction of the latter (0 if unlimited)
+	UsedMemory, MaxMemory int64
+	MemoryPressure        float64
+
+	// For a replica, how long ago it last heard from its master. For a master,
+	// the largest lag reported for any of its replicas.
+	ReplicationLag time.Duration
+}
+
+// HealthReport describes the health of all the nodes behind a client, see
+// pool.Pool.Diagnose and cluster.Cluster.Diagnose
+type HealthReport struct {
+	Nodes []NodeHealth
+}
+
+// Healthy returns whether all nodes in the report were reachable and fully
+// diagnosed
+func (r *HealthReport) Healthy() bool {
+	for _, n := range r.Nodes {
+		if !n.Reachable || n.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// DiagnoseNode checks the health of the node the Client is connected to (whose
+// address is only used to fill in the result), using PING, INFO replication
+// and INFO memory. Every command is performed using CmdContext, so the
+// diagnosis stops early if ctx is cancelled.
+func DiagnoseNode(ctx context.Context, c *redis.Client, addr string) NodeHealth {
+	h := NodeHealth{Addr: addr}
+
+	start := time.Now()
+	if h.Err = c.CmdContext(ctx, "PING").Err; h.Err != nil {
+		return h
+	}
+	h.Latency = time.Since(start)
+	h.Reachable = true
+
+	var repl, mem map[string]string
+	if repl, h.Err = info(ctx, c, "replication"); h.Err != nil {
+		return h
+	}
+	if mem, h.Err = info(ctx, c, "memory"); h.Err != nil {
+		return h
+	}
+
+	h.Role = repl["role"]
+	if h.Role == "slave" {
+		if s, err := strconv.ParseInt(repl["master_last_io_seconds_ago"], 10, 64); err == nil && s >= 0 {
+			h.ReplicationLag = time.Duration(s) * time.Second
+		}
+	} else {
+		// slave0:ip=...,port=...,state=online,offset=...,lag=0
+		for k, v := range repl {
+			if !strings.HasPrefix(k, "slave") {
+				continue
+			}
+			for _, f := range strings.Split(v, ",") {
+				if !strings.HasPrefix(f, "lag=") {
+					continue
+				}
+				s, err := strconv.ParseInt(f[4:], 10, 64)
+				if lag := time.Duration(s) * time.Second; err == nil && lag > h.ReplicationLag {
+					h.ReplicationLag = lag
+				}
+			}
+		}
+	}
+
+	h.UsedMemory, _ = strconv.ParseInt(mem["used_memory"], 10, 64)
+	h.MaxMemory, _ = strconv.ParseInt(mem["maxmemory"], 10, 64)
+	if h.MaxMemory > 0 {
+		h.MemoryPressure = float64(h.UsedMemory) / float64(h.MaxMemory)
+	}
+	return h
+}
+
+func info(ctx context.Context, c *redis.Client, section string) (map[string]string, error) {
+	s, err := c.CmdContext(ctx, "INFO", section).Str()
+	if err != nil {
+		return nil, err
+	}
+	return ParseInfo(s), nil
+}
+
+// ParseInfo parses the reply to an INFO command into its fields, ignoring the
+// section headers
+func ParseInfo(s string) map[string]string {
+	m := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			m[line[:i]] = line[i+1:]
+		}
+	}
+	return m
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+)
+
+func TestParseInfo(t *T) {
+	m := ParseInfo("# Replication\r\nrole:master\r\nslave0:ip=127.0.0.1,port=6380,lag=1\r\n\r\n# Memory\r\nused_memory:1024\r\n")
+	assert.Equal(t, map[string]string{
+		"role":        "master",
+		"slave0":      "ip=127.0.0.1,port=6380,lag=1",
+		"used_memory": "1024",
+	}, m)
+}
+
+func TestDiagnoseNode(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	h := DiagnoseNode(context.Background(), c, "127.0.0.1:6379")
+	assert.Nil(t, h.Err)
+	assert.True(t, h.Reachable)
+	assert.Equal(t, "master", h.Role)
+	assert.True(t, h.UsedMemory > 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h = DiagnoseNode(ctx, c, "127.0.0.1:6379")
+	assert.False(t, h.Reachable)
+	assert.Equal(t, context.Canceled, h.Err)
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"fmt"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// KeyType is the type of a key, as returned by TYPE
+type KeyType string
+
+// The key types InspectKey knows how to fetch
+const (
+	TypeString KeyType = "string"
+	TypeHash   KeyType = "hash"
+	TypeList   KeyType = "list"
+	TypeSet    KeyType = "set"
+	TypeZSet   KeyType = "zset"
+	TypeStream KeyType = "stream"
+)
+
+// StreamEntry is a single entry of a stream
+type StreamEntry = redis.StreamEntry
+
+// KeyContents is the full contents of a key, as returned by InspectKey. Only
+// the field corresponding to Type is set.
+type KeyContents struct {
+	Key  string
+	Type KeyType
+
+	String string            // TypeString
+	Hash   map[string]string // TypeHash
+	List   []string          // TypeList, from head to tail
+	Set    []string          // TypeSet, in no particular order
+	ZSet   []redis.ZMember   // TypeZSet, from the lowest score to the highest
+	Stream []StreamEntry     // TypeStream, from the oldest entry to the newest
+}
+
+// InspectKey looks up the type of the given key and fetches its full contents
+// with the command appropriate to it (GET, HGETALL, LRANGE, SMEMBERS, ZRANGE
+// WITHSCORES or XRANGE). redis.ErrNil is returned if the key doesn't exist,
+// and an error if it's of some other type, e.g. one defined by a module.
+//
+// The contents are fetched in full, so this is meant for inspecting
+// individual keys rather than for keys which may be very large. The key may
+// also change type between the two commands, in which case the server's
+// WRONGTYPE error is returned.
+func InspectKey(c redis.Cmder, key string) (*KeyContents, error) {
+	typ, err := c.Cmd("TYPE", key).Str()
+	if err != nil {
+		return nil, err
+	}
+
+	kc := &KeyContents{Key: key, Type: KeyType(typ)}
+	switch kc.Type {
+	case "none":
+		return nil, redis.ErrNil
+	case TypeString:
+		kc.String, err = c.Cmd("GET", key).Str()
+	case TypeHash:
+		kc.Hash, err = c.Cmd("HGETALL", key).Hash()
+	case TypeList:
+		kc.List, err = c.Cmd("LRANGE", key, 0, -1).List()
+	case TypeSet:
+		kc.Set, err = c.Cmd("SMEMBERS", key).List()
+	case TypeZSet:
+		kc.ZSet, err = c.Cmd("ZRANGE", key, 0, -1, "WITHSCORES").ZMembers()
+	case TypeStream:
+		kc.Stream, err = c.Cmd("XRANGE", key, "-", "+").StreamEntries()
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kc, nil
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"github.com/fzzy/radix/extra/redistest"
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+)
+
+func TestInspectKey(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := redis.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s.Handle("TYPE",
+		redistest.Status("string"),
+		redistest.Status("hash"),
+		redistest.Status("list"),
+		redistest.Status("set"),
+		redistest.Status("zset"),
+		redistest.Status("stream"),
+		redistest.Status("none"),
+		redistest.Status("ReJSON-RL"),
+	)
+	s.Handle("GET", redistest.Reply("bar"))
+	s.Handle("HGETALL", redistest.Reply([]string{"a", "1", "b", "2"}))
+	s.Handle("LRANGE", redistest.Reply([]string{"a", "b", "a"}))
+	s.Handle("SMEMBERS", redistest.Reply([]string{"a", "b"}))
+	s.Handle("ZRANGE", redistest.Reply([]string{"a", "1", "b", "2.5"}))
+	s.Handle("XRANGE", redistest.Reply([]interface{}{
+		[]interface{}{"1-0", []string{"a", "1"}},
+		[]interface{}{"2-0", []string{"b", "2"}},
+	}))
+
+	kc, err := InspectKey(c, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, &KeyContents{Key: "foo", Type: TypeString, String: "bar"}, kc)
+
+	kc, err = InspectKey(c, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, kc.Hash)
+
+	kc, err = InspectKey(c, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "a"}, kc.List)
+
+	kc, err = InspectKey(c, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, kc.Set)
+
+	kc, err = InspectKey(c, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "a", Score: 1}, {Member: "b", Score: 2.5}}, kc.ZSet)
+
+	kc, err = InspectKey(c, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, TypeStream, kc.Type)
+	assert.Equal(t, []StreamEntry{
+		{ID: "1-0", Fields: map[string]string{"a": "1"}},
+		{ID: "2-0", Fields: map[string]string{"b": "2"}},
+	}, kc.Stream)
+
+	_, err = InspectKey(c, "foo")
+	assert.Equal(t, redis.ErrNil, err)
+	_, err = InspectKey(c, "foo")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, s.ExpectCmds(
+		"TYPE foo", "GET foo",
+		"TYPE foo", "HGETALL foo",
+		"TYPE foo", "LRANGE foo 0 -1",
+		"TYPE foo", "SMEMBERS foo",
+		"TYPE foo", "ZRANGE foo 0 -1 WITHSCORES",
+		"TYPE foo", "XRANGE foo - +",
+		"TYPE foo",
+		"TYPE foo",
+	))
+}
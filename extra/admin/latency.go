@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// LatencySample is a single latency spike recorded by the latency monitor
+type LatencySample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// LatencyEvent is the latest and maximum recorded latency for a single event
+// (e.g. "command" or "fork"), as returned by LATENCY LATEST
+type LatencyEvent struct {
+	Name   string
+	Latest LatencySample
+	Max    time.Duration
+}
+
+// LatencyLatest returns the latest latency sample for every event the latency
+// monitor has recorded
+func LatencyLatest(c redis.Cmder) ([]LatencyEvent, error) {
+	r := c.Cmd("LATENCY", "LATEST")
+	if r.Err != nil {
+		return nil, r.Err
+	} else if r.Type != redis.MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+
+	events := make([]LatencyEvent, len(r.Elems))
+	for i, er := range r.Elems {
+		if er.Type != redis.MultiReply || len(er.Elems) < 4 {
+			return nil, errors.New("malformed LATENCY LATEST entry")
+		}
+		var err error
+		if events[i].Name, err = er.Elems[0].Str(); err != nil {
+			return nil, err
+		}
+		if events[i].Latest, err = parseLatencySample(er.Elems[1], er.Elems[2]); err != nil {
+			return nil, err
+		}
+		max, err := er.Elems[3].Int64()
+		if err != nil {
+			return nil, err
+		}
+		events[i].Max = time.Duration(max) * time.Millisecond
+	}
+	return events, nil
+}
+
+// LatencyHistory returns the time series of latency samples recorded for the
+// given event, oldest first
+func LatencyHistory(c redis.Cmder, event string) ([]LatencySample, error) {
+	r := c.Cmd("LATENCY", "HISTORY", event)
+	if r.Err != nil {
+		return nil, r.Err
+	} else if r.Type != redis.MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+
+	samples := make([]LatencySample, len(r.Elems))
+	for i, sr := range r.Elems {
+		if sr.Type != redis.MultiReply || len(sr.Elems) < 2 {
+			return nil, errors.New("malformed LATENCY HISTORY entry")
+		}
+		var err error
+		if samples[i], err = parseLatencySample(sr.Elems[0], sr.Elems[1]); err != nil {
+			return nil, err
+		}
+	}
+	return samples, nil
+}
+
+func parseLatencySample(ts, ms *redis.Reply) (LatencySample, error) {
+	t, err := ts.Int64()
+	if err != nil {
+		return LatencySample{}, err
+	}
+	l, err := ms.Int64()
+	if err != nil {
+		return LatencySample{}, err
+	}
+	return LatencySample{
+		Time:    time.Unix(t, 0),
+		Latency: time.Duration(l) * time.Millisecond,
+	}, nil
+}
+
+// LatencyReset resets the recorded samples for the given events, or all events
+// if none are given. It returns the number of event time series which were
+// reset.
+func LatencyReset(c redis.Cmder, events ...string) (int64, error) {
+	args := make([]interface{}, 0, len(events)+1)
+	args = append(args, "RESET")
+	for _, e := range events {
+		args = append(args, e)
+	}
+	return c.Cmd("LATENCY", args...).Int64()
+}
+
+// LatencyDoctor returns the human readable analysis of the server's latency
+// issues produced by LATENCY DOCTOR
+func LatencyDoctor(c redis.Cmder) (string, error) {
+	return c.Cmd("LATENCY", "DOCTOR").Str()
+}
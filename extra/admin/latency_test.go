@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "testing"
+)
+
+func TestLatency(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	old, err := c.Cmd("CONFIG", "GET", "latency-monitor-threshold").List()
+	assert.Nil(t, err)
+	defer c.Cmd("CONFIG", "SET", "latency-monitor-threshold", old[1])
+	assert.Nil(t, c.Cmd("CONFIG", "SET", "latency-monitor-threshold", 1).Err)
+
+	_, err = LatencyReset(c)
+	assert.Nil(t, err)
+
+	// DEBUG SLEEP causes a "command" latency event
+	assert.Nil(t, c.Cmd("DEBUG", "SLEEP", 0.01).Err)
+
+	events, err := LatencyLatest(c)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "command", events[0].Name)
+	assert.True(t, events[0].Max > 0)
+
+	samples, err := LatencyHistory(c, "command")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(samples))
+	assert.True(t, samples[0].Latency > 0)
+
+	doc, err := LatencyDoctor(c)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", doc)
+
+	n, err := LatencyReset(c, "command")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+}
@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// SlowlogEntry is a single entry in the server's slow log
+type SlowlogEntry struct {
+	ID       int64
+	Time     time.Time     // When the command was processed
+	Duration time.Duration // How long the command took to execute
+	Args     []string      // The command and its arguments
+
+	// Only filled in by redis 4 and up
+	ClientAddr, ClientName string
+}
+
+// SlowlogGet returns up to n of the most recent entries in the slow log, most
+// recent first. If n is negative the server's default (10) is used.
+func SlowlogGet(c redis.Cmder, n int) ([]SlowlogEntry, error) {
+	var r *redis.Reply
+	if n < 0 {
+		r = c.Cmd("SLOWLOG", "GET")
+	} else {
+		r = c.Cmd("SLOWLOG", "GET", n)
+	}
+	return ParseSlowlog(r)
+}
+
+// SlowlogLen returns the number of entries currently in the slow log
+func SlowlogLen(c redis.Cmder) (int64, error) {
+	return c.Cmd("SLOWLOG", "LEN").Int64()
+}
+
+// SlowlogReset clears the slow log
+func SlowlogReset(c redis.Cmder) error {
+	return c.Cmd("SLOWLOG", "RESET").Err
+}
+
+// ParseSlowlog parses the reply to a SLOWLOG GET command
+func ParseSlowlog(r *redis.Reply) ([]SlowlogEntry, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	} else if r.Type != redis.MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+
+	entries := make([]SlowlogEntry, len(r.Elems))
+	for i, er := range r.Elems {
+		if er.Type != redis.MultiReply || len(er.Elems) < 4 {
+			return nil, errors.New("malformed slowlog entry")
+		}
+		e := &entries[i]
+
+		var err error
+		if e.ID, err = er.Elems[0].Int64(); err != nil {
+			return nil, err
+		}
+		ts, err := er.Elems[1].Int64()
+		if err != nil {
+			return nil, err
+		}
+		e.Time = time.Unix(ts, 0)
+		us, err := er.Elems[2].Int64()
+		if err != nil {
+			return nil, err
+		}
+		e.Duration = time.Duration(us) * time.Microsecond
+		if e.Args, err = er.Elems[3].List(); err != nil {
+			return nil, err
+		}
+		if len(er.Elems) >= 6 {
+			if e.ClientAddr, err = er.Elems[4].Str(); err != nil {
+				return nil, err
+			}
+			if e.ClientName, err = er.Elems[5].Str(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "testing"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// These tests assume there is a redis instance listening on port 6379, and
+// will modify its slowlog settings
+
+func dial(t *T) *redis.Client {
+	c, err := redis.Dial("tcp", "127.0.0.1:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSlowlog(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	// Log everything
+	old, err := c.Cmd("CONFIG", "GET", "slowlog-log-slower-than").List()
+	assert.Nil(t, err)
+	defer c.Cmd("CONFIG", "SET", "slowlog-log-slower-than", old[1])
+	assert.Nil(t, c.Cmd("CONFIG", "SET", "slowlog-log-slower-than", 0).Err)
+
+	assert.Nil(t, SlowlogReset(c))
+	assert.Nil(t, c.Cmd("ECHO", "slowlog-test").Err)
+
+	n, err := SlowlogLen(c)
+	assert.Nil(t, err)
+	assert.True(t, n > 0)
+
+	entries, err := SlowlogGet(c, -1)
+	assert.Nil(t, err)
+	assert.True(t, len(entries) > 0)
+
+	var found bool
+	for _, e := range entries {
+		if len(e.Args) == 2 && e.Args[0] == "ECHO" && e.Args[1] == "slowlog-test" {
+			found = true
+			assert.False(t, e.Time.IsZero())
+		}
+	}
+	assert.True(t, found)
+}
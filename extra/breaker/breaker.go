@@ -0,0 +1,219 @@
+// The breaker package implements a circuit breaker around a pool.Pool, so that
+// when redis goes down callers fail straight away instead of each waiting on
+// their own dial or timeout, piling up behind one another. After Threshold
+// consecutive failures the breaker opens, and every call fails with ErrOpen
+// until a background probe finds redis reachable again:
+//
+//	b := breaker.New(p, breaker.Opts{Threshold: 5, Cooldown: 5 * time.Second})
+//	defer b.Close()
+//
+//	r := b.Cmd("GET", "foo")
+//	if r.Err == breaker.ErrOpen {
+//		// redis is down, serve from elsewhere
+//	}
+//
+// Only failures which say something about redis being reachable count: failing
+// to dial, and network errors (see redis.IsNetworkErr) such as timeouts and
+// dropped connections. Error replies sent by the server don't.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/extra/pool"
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults for Opts fields which aren't set
+const (
+	DefaultThreshold = 5
+	DefaultCooldown  = 5 * time.Second
+)
+
+// ErrOpen is returned in place of performing anything while the Breaker is
+// open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a Breaker
+type State int
+
+const (
+	// Closed means calls go through to the Pool
+	Closed State = iota
+
+	// Open means calls fail with ErrOpen
+	Open
+)
+
+func (s State) String() string {
+	if s == Open {
+		return "open"
+	}
+	return "closed"
+}
+
+// Opts are the options which can be given to New
+type Opts struct {
+	// Number of consecutive failures after which the Breaker opens. Defaults
+	// to DefaultThreshold.
+	Threshold int
+
+	// How long the Breaker waits after opening, and after each failed probe,
+	// before probing redis with a PING. Defaults to DefaultCooldown.
+	Cooldown time.Duration
+
+	// If set, called whenever the Breaker opens or closes. It's called from
+	// the routine which caused the change, the Breaker's own in the case of a
+	// successful probe.
+	OnStateChange func(State)
+}
+
+// Breaker wraps a Pool's Get, Cmd and Do, see the package docs. Its methods
+// are safe to use from multiple routines at once.
+type Breaker struct {
+	pool *pool.Pool
+	opts Opts
+
+	lock     sync.Mutex
+	state    State
+	failures int
+	closed   bool
+	closeCh  chan struct{}
+}
+
+// New returns a Breaker, initially closed, which wraps the given Pool
+func New(p *pool.Pool, opts Opts) *Breaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCooldown
+	}
+	return &Breaker{
+		pool:    p,
+		opts:    opts,
+		closeCh: make(chan struct{}),
+	}
+}
+
+// State returns the current state of the Breaker
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// Get retrieves a connection from the Pool, as pool.Pool.Get does, or returns
+// ErrOpen if the Breaker is open. The connection should be given back with
+// CarefullyPut (or Put, if nothing went wrong), so that any network error
+// encountered on it counts towards opening the Breaker.
+func (b *Breaker) Get() (*redis.Client, error) {
+	if b.State() == Open {
+		return nil, ErrOpen
+	}
+	conn, err := b.pool.Get()
+	b.record(err)
+	return conn, err
+}
+
+// Put returns a connection retrieved with Get to the Pool, as pool.Pool.Put
+// does
+func (b *Breaker) Put(conn *redis.Client) {
+	b.pool.Put(conn)
+}
+
+// CarefullyPut records whether potentialErr is a failure and then returns the
+// connection to the Pool, as pool.Pool.CarefullyPut does
+func (b *Breaker) CarefullyPut(conn *redis.Client, potentialErr *error) {
+	if potentialErr != nil {
+		b.record(*potentialErr)
+	}
+	b.pool.CarefullyPut(conn, potentialErr)
+}
+
+// Cmd performs the given command using the Pool, as pool.Pool.Cmd does, or
+// returns a reply of ErrOpen if the Breaker is open
+func (b *Breaker) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	if b.State() == Open {
+		return &redis.Reply{Type: redis.ErrorReply, Err: ErrOpen}
+	}
+	r := b.pool.Cmd(cmd, args...)
+	b.record(r.Err)
+	return r
+}
+
+// Do runs the Action using the Pool, as pool.Pool.Do does, or returns ErrOpen
+// if the Breaker is open
+func (b *Breaker) Do(a redis.Action) error {
+	if b.State() == Open {
+		return ErrOpen
+	}
+	err := b.pool.Do(a)
+	b.record(err)
+	return err
+}
+
+// Close stops the Breaker's background probing, so that if it's open it stays
+// that way. It doesn't close the Pool. Calling Close more than once is a no-op.
+func (b *Breaker) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.closeCh)
+	}
+}
+
+// record counts the outcome of a call, opening the Breaker if it's the
+// Threshold'th failure in a row
+func (b *Breaker) record(err error) {
+	b.lock.Lock()
+	if !redis.IsNetworkErr(err) {
+		if b.state == Closed {
+			b.failures = 0
+		}
+		b.lock.Unlock()
+		return
+	}
+	b.failures++
+	if b.state == Open || b.failures < b.opts.Threshold {
+		b.lock.Unlock()
+		return
+	}
+	b.state = Open
+	if !b.closed {
+		go b.probe()
+	}
+	b.lock.Unlock()
+	b.stateChanged(Open)
+}
+
+// probe PINGs redis every Cooldown until it replies, and then closes the
+// Breaker
+func (b *Breaker) probe() {
+	for {
+		select {
+		case <-time.After(b.opts.Cooldown):
+		case <-b.closeCh:
+			return
+		}
+		if err := b.pool.CmdNoRetry("PING").Err; redis.IsNetworkErr(err) {
+			continue
+		}
+
+		b.lock.Lock()
+		b.state = Closed
+		b.failures = 0
+		b.lock.Unlock()
+		b.stateChanged(Closed)
+		return
+	}
+}
+
+func (b *Breaker) stateChanged(s State) {
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(s)
+	}
+}
@@ -0,0 +1,62 @@
+package breaker
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+
+	"github.com/fzzy/radix/extra/pool"
+	"github.com/fzzy/radix/extra/redistest"
+	"github.com/fzzy/radix/redis"
+)
+
+func TestBreaker(t *T) {
+	s, err := redistest.NewServer()
+	assert.Nil(t, err)
+	defer s.Close()
+	p, err := pool.NewPool("tcp", s.Addr, 1)
+	assert.Nil(t, err)
+	defer p.Close()
+
+	states := make(chan State, 10)
+	b := New(p, Opts{
+		Threshold:     2,
+		Cooldown:      20 * time.Millisecond,
+		OnStateChange: func(s State) { states <- s },
+	})
+	defer b.Close()
+
+	// Error replies from the server don't count, nor do they let a run of
+	// failures carry on
+	s.Handle("GET", redistest.Drop(), redistest.Error("ERR nope"), redistest.Drop(), redistest.Drop())
+	assert.True(t, redis.IsNetworkErr(b.Cmd("GET", "foo").Err))
+	assert.NotNil(t, b.Cmd("GET", "foo").Err)
+	assert.True(t, redis.IsNetworkErr(b.Cmd("GET", "foo").Err))
+	assert.Equal(t, Closed, b.State())
+	assert.True(t, redis.IsNetworkErr(b.Cmd("GET", "foo").Err))
+	assert.Equal(t, Open, <-states)
+	assert.Equal(t, Open, b.State())
+
+	// Calls fail fast while it's open
+	s.Reset()
+	s.Handle("PING", redistest.Drop(), redistest.Status("PONG"))
+	assert.Equal(t, ErrOpen, b.Cmd("GET", "foo").Err)
+	assert.Equal(t, ErrOpen, b.Do(redis.Cmd(nil, "GET", "foo")))
+	_, err = b.Get()
+	assert.Equal(t, ErrOpen, err)
+
+	// The first probe fails, the second closes it
+	select {
+	case s := <-states:
+		assert.Equal(t, Closed, s)
+	case <-time.After(time.Second):
+		t.Fatal("breaker didn't close")
+	}
+	assert.Nil(t, s.ExpectCmds("PING", "PING"))
+
+	s.Handle("GET", redistest.Reply("bar"))
+	conn, err := b.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", conn.Cmd("GET", "foo").String())
+	b.CarefullyPut(conn, &err)
+}
@@ -0,0 +1,271 @@
+// The bulk package loads large numbers of commands into redis quickly, for
+// things like mass imports and cache warm-ups. Commands are collected into
+// batches which are pipelined over connections from a Pool, several batches at
+// a time, and callers are made to wait when they're queueing commands faster
+// than redis can take them, so that memory use stays bounded.
+//
+//	l := bulk.NewLoader(p, bulk.Opts{
+//		BatchSize: 1000,
+//		Workers:   4,
+//		ErrHandler: func(err *bulk.BatchError) {
+//			log.Printf("batch %d: %s", err.Batch, err)
+//		},
+//	})
+//	for _, u := range users {
+//		if err := l.Cmd("HSET", "user:"+u.ID, u.Fields()); err != nil {
+//			break
+//		}
+//	}
+//	stats := l.Close()
+//
+// Commands within a batch are written in the order they were queued, but
+// batches are written concurrently, so commands which depend on each other's
+// ordering should be loaded with a single Worker.
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/fzzy/radix/extra/pool"
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults for Opts fields which aren't set
+const (
+	DefaultBatchSize = 1000
+	DefaultWorkers   = 1
+)
+
+// ErrClosed is returned when queueing commands on a Loader which has been
+// closed
+var ErrClosed = errors.New("bulk loader closed")
+
+// Opts are the options which can be given to NewLoader
+type Opts struct {
+	// Number of commands pipelined together in a single batch. Defaults to
+	// DefaultBatchSize.
+	BatchSize int
+
+	// Number of batches written at once, each on its own connection from the
+	// Pool. Once that many are being written and another is full, queueing
+	// more commands blocks until one of them is done. Defaults to
+	// DefaultWorkers.
+	Workers int
+
+	// If set, called with each batch which had any commands fail. It's called
+	// from the routine which wrote the batch, so it may be called concurrently
+	// when there's more than one Worker.
+	ErrHandler func(*BatchError)
+}
+
+// Cmd is a single command to be loaded
+type Cmd struct {
+	Cmd  string
+	Args []interface{}
+}
+
+// BatchError describes the commands of a batch which failed
+type BatchError struct {
+	// Sequence number of the batch, starting at 0, in the order batches were
+	// filled
+	Batch int
+
+	// The batch's commands
+	Cmds []Cmd
+
+	// If set the batch couldn't be written in full, e.g. because a connection
+	// couldn't be retrieved or was lost part way through. Only the first
+	// Written commands are known to have been performed, the rest may or may
+	// not have been.
+	Err     error
+	Written int
+
+	// The errors replied to individual commands, keyed by their index in Cmds
+	CmdErrs map[int]error
+}
+
+func (e *BatchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d of %d commands not written: %s", len(e.Cmds)-e.Written, len(e.Cmds), e.Err)
+	}
+	return fmt.Sprintf("%d of %d commands failed", len(e.CmdErrs), len(e.Cmds))
+}
+
+// Stats are the totals of a Loader's work
+type Stats struct {
+	// Number of batches written, including those which failed
+	Batches int
+
+	// Number of commands written, and the number of those replied to with an
+	// error
+	Cmds, CmdErrs int
+
+	// Number of commands which may not have been written due to a batch's Err,
+	// see BatchError.Written
+	Unwritten int
+}
+
+type batch struct {
+	seq  int
+	cmds []Cmd
+}
+
+// Loader queues commands and writes them in batches, see the package docs.
+// Its methods are safe to use from multiple routines at once.
+type Loader struct {
+	pool *pool.Pool
+	opts Opts
+
+	// lock is held while filling the current batch and handing full ones to
+	// the workers, so that batches are handed off in sequence order
+	lock   sync.Mutex
+	cur    *batch
+	seq    int
+	closed bool
+
+	batchCh chan *batch
+	wg      sync.WaitGroup
+
+	statsLock sync.Mutex
+	stats     Stats
+}
+
+// NewLoader returns a Loader which writes using connections from the given
+// Pool. Close must be called to write the last batch and stop its routines.
+func NewLoader(p *pool.Pool, opts Opts) *Loader {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	l := &Loader{
+		pool:    p,
+		opts:    opts,
+		batchCh: make(chan *batch),
+	}
+	l.wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go l.work()
+	}
+	return l
+}
+
+// Cmd queues the given command. If the batch it's added to is full, and all
+// of the workers are busy, Cmd blocks until one of them can take it. It
+// returns ErrClosed if the Loader has been closed.
+func (l *Loader) Cmd(cmd string, args ...interface{}) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.cur == nil {
+		l.cur = &batch{seq: l.seq, cmds: make([]Cmd, 0, l.opts.BatchSize)}
+		l.seq++
+	}
+	l.cur.cmds = append(l.cur.cmds, Cmd{cmd, args})
+	if len(l.cur.cmds) >= l.opts.BatchSize {
+		l.batchCh <- l.cur
+		l.cur = nil
+	}
+	return nil
+}
+
+// Set queues a SET of the given key to the given value, as Cmd does
+func (l *Loader) Set(key string, value interface{}) error {
+	return l.Cmd("SET", key, value)
+}
+
+// Load queues every command received on the given channel, until it's closed
+func (l *Loader) Load(ch <-chan Cmd) error {
+	for c := range ch {
+		if err := l.Cmd(c.Cmd, c.Args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the totals of the batches written so far
+func (l *Loader) Stats() Stats {
+	l.statsLock.Lock()
+	defer l.statsLock.Unlock()
+	return l.stats
+}
+
+// Close writes the last, partially filled, batch, waits for all batches to be
+// written, and returns the final Stats. It may be called more than once.
+func (l *Loader) Close() Stats {
+	l.lock.Lock()
+	if !l.closed {
+		l.closed = true
+		if l.cur != nil {
+			l.batchCh <- l.cur
+			l.cur = nil
+		}
+		close(l.batchCh)
+	}
+	l.lock.Unlock()
+	l.wg.Wait()
+	return l.Stats()
+}
+
+func (l *Loader) work() {
+	defer l.wg.Done()
+	for b := range l.batchCh {
+		berr := l.write(b)
+
+		l.statsLock.Lock()
+		l.stats.Batches++
+		written := len(b.cmds)
+		if berr != nil {
+			l.stats.CmdErrs += len(berr.CmdErrs)
+			if berr.Err != nil {
+				written = berr.Written
+			}
+		}
+		l.stats.Cmds += written
+		l.stats.Unwritten += len(b.cmds) - written
+		l.statsLock.Unlock()
+
+		if berr != nil && l.opts.ErrHandler != nil {
+			l.opts.ErrHandler(berr)
+		}
+	}
+}
+
+// write pipelines the batch's commands on a single connection, returning a
+// BatchError if any of them failed
+func (l *Loader) write(b *batch) *BatchError {
+	berr := &BatchError{Batch: b.seq, Cmds: b.cmds}
+	conn, err := l.pool.Get()
+	if err != nil {
+		berr.Err = err
+		return berr
+	}
+	for _, c := range b.cmds {
+		conn.Append(c.Cmd, c.Args...)
+	}
+	for i := range b.cmds {
+		err := conn.GetReply().Err
+		if redis.IsNetworkErr(err) {
+			// The connection is gone, so are the rest of the replies
+			conn.Close()
+			berr.Err, berr.Written = err, i
+			return berr
+		} else if err != nil {
+			if berr.CmdErrs == nil {
+				berr.CmdErrs = map[int]error{}
+			}
+			berr.CmdErrs[i] = err
+		}
+	}
+	l.pool.Put(conn)
+	if berr.CmdErrs == nil {
+		return nil
+	}
+	return berr
+}
@@ -0,0 +1,80 @@
+package bulk
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	. "testing"
+
+	"github.com/fzzy/radix/extra/pool"
+	"github.com/fzzy/radix/extra/redistest"
+)
+
+func TestLoader(t *T) {
+	s, err := redistest.NewServer()
+	assert.Nil(t, err)
+	defer s.Close()
+	p, err := pool.NewPool("tcp", s.Addr, 2)
+	assert.Nil(t, err)
+	defer p.Close()
+
+	s.Handle("SET", redistest.Status("OK"), redistest.Error("ERR nope"), redistest.Status("OK"))
+	var lock sync.Mutex
+	var berrs []*BatchError
+	l := NewLoader(p, Opts{
+		BatchSize: 2,
+		ErrHandler: func(err *BatchError) {
+			lock.Lock()
+			berrs = append(berrs, err)
+			lock.Unlock()
+		},
+	})
+
+	ch := make(chan Cmd)
+	go func() {
+		for _, k := range []string{"a", "b", "c"} {
+			ch <- Cmd{"SET", []interface{}{k, 1}}
+		}
+		close(ch)
+	}()
+	assert.Nil(t, l.Load(ch))
+	assert.Nil(t, l.Set("d", 1))
+	assert.Nil(t, l.Set("e", 1))
+
+	stats := l.Close()
+	assert.Equal(t, Stats{Batches: 3, Cmds: 5, CmdErrs: 1}, stats)
+	assert.Equal(t, ErrClosed, l.Set("f", 1))
+	assert.Equal(t, stats, l.Close())
+
+	assert.Nil(t, s.ExpectCmds("SET a 1", "SET b 1", "SET c 1", "SET d 1", "SET e 1"))
+	assert.Equal(t, 1, len(berrs))
+	assert.Equal(t, 0, berrs[0].Batch)
+	assert.Equal(t, map[int]error{1: berrs[0].CmdErrs[1]}, berrs[0].CmdErrs)
+	assert.NotNil(t, berrs[0].CmdErrs[1])
+	assert.Equal(t, "1 of 2 commands failed", berrs[0].Error())
+}
+
+func TestLoaderConnLost(t *T) {
+	s, err := redistest.NewServer()
+	assert.Nil(t, err)
+	defer s.Close()
+	p, err := pool.NewPool("tcp", s.Addr, 1)
+	assert.Nil(t, err)
+	defer p.Close()
+
+	s.Handle("SET", redistest.Drop(), redistest.Status("OK"))
+	var berr *BatchError
+	l := NewLoader(p, Opts{
+		BatchSize:  3,
+		ErrHandler: func(err *BatchError) { berr = err },
+	})
+	for _, k := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, l.Set(k, 1))
+	}
+
+	stats := l.Close()
+	assert.Equal(t, Stats{Batches: 2, Cmds: 1, Unwritten: 3}, stats)
+	assert.NotNil(t, berr)
+	assert.Equal(t, 0, berr.Batch)
+	assert.Equal(t, 0, berr.Written)
+	assert.NotNil(t, berr.Err)
+}
@@ -0,0 +1,299 @@
+// The cache package implements client-side caching of GET and HGETALL results
+// on top of redis 6's CLIENT TRACKING. The server keeps track of which keys
+// have been read and pushes an invalidation message whenever one of them is
+// modified, at which point the locally cached copy is dropped.
+//
+// A Cache uses two connections. Commands are performed on the first, which has
+// tracking enabled with its invalidation messages redirected to the second.
+// The second uses RESP3 and is read from continuously in the background, so
+// invalidations are processed as they arrive even when the cache is otherwise
+// idle. If either connection is lost everything cached is dropped and the Cache
+// stops caching, passing all calls straight through to redis.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults for Opts fields which aren't set
+const (
+	DefaultMaxSize = 10000
+	DefaultTTL     = time.Minute
+)
+
+// Opts are the options which can be given to New
+type Opts struct {
+	// Maximum number of keys to hold locally. When full, the least recently
+	// used key is evicted.
+	MaxSize int
+
+	// Maximum amount of time a key is held locally, even if no invalidation is
+	// received for it. This limits the damage should an invalidation ever be
+	// missed.
+	TTL time.Duration
+
+	// Read/write timeout for the command connection
+	Timeout time.Duration
+}
+
+type entry struct {
+	key          string
+	get, hgetall *redis.Reply
+	expires      time.Time
+	elem         *list.Element
+}
+
+// Cache is a client-side caching client. It is safe to use from multiple
+// routines at once, though all commands are performed on a single connection.
+type Cache struct {
+	opts Opts
+
+	// held while a command is in progress on conn
+	connLock sync.Mutex
+	conn     *redis.Client
+	inv      *redis.Client
+
+	lock    sync.Mutex
+	entries map[string]*entry
+	lru     *list.List
+
+	// keys which are currently being fetched, and whether an invalidation for
+	// them has been received since the fetch began
+	inflight map[string]bool
+	disabled bool
+}
+
+// New connects to the given redis instance, which must support RESP3 and
+// CLIENT TRACKING (redis 6 and up), and returns a Cache for it. If the server
+// doesn't support RESP3 redis.ErrRequiresRESP3 is returned.
+func New(network, addr string, opts Opts) (*Cache, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+
+	inv, err := redis.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := redis.DialTimeout(network, addr, opts.Timeout)
+	if err != nil {
+		inv.Close()
+		return nil, err
+	}
+	c := &Cache{
+		opts:     opts,
+		conn:     conn,
+		inv:      inv,
+		entries:  map[string]*entry{},
+		lru:      list.New(),
+		inflight: map[string]bool{},
+	}
+	if err := c.setup(); err != nil {
+		c.conn.Close()
+		c.inv.Close()
+		return nil, err
+	}
+
+	go c.spin()
+	return c, nil
+}
+
+func (c *Cache) setup() error {
+	// Invalidation messages are only recognized as push replies
+	if err := c.inv.HelloFallback().Err; err != nil {
+		return err
+	}
+	if err := c.inv.RequireRESP3(); err != nil {
+		return err
+	}
+	id, err := c.inv.Cmd("CLIENT", "ID").Int64()
+	if err != nil {
+		return err
+	}
+	if err := c.inv.Cmd("SUBSCRIBE", "__redis__:invalidate").Err; err != nil {
+		return err
+	}
+	return c.conn.Cmd("CLIENT", "TRACKING", "ON", "REDIRECT", id).Err
+}
+
+// spin reads invalidation messages off of the invalidation connection until it
+// errors
+func (c *Cache) spin() {
+	for {
+		r := c.inv.ReadReply()
+		if r.Err != nil {
+			c.lock.Lock()
+			c.disabled = true
+			c.flush()
+			c.lock.Unlock()
+			return
+		}
+
+		keys := invalidated(r)
+		if keys == nil {
+			continue
+		}
+
+		c.lock.Lock()
+		if keys.Type == redis.NilReply {
+			// The server flushed, and so do we
+			c.flush()
+		} else if keys, err := keys.List(); err == nil {
+			for _, key := range keys {
+				c.invalidate(key)
+			}
+		}
+		c.lock.Unlock()
+	}
+}
+
+// invalidated returns the keys held by an invalidation message, which are nil
+// if the server flushed, or returns nil if the reply isn't one. Since the
+// invalidation connection uses RESP3 they arrive as: invalidate [keys...]. A
+// RESP2 connection would get them as pub/sub messages instead, of the form:
+// message __redis__:invalidate [keys...]
+func invalidated(r *redis.Reply) *redis.Reply {
+	if r.Type != redis.PushReply || len(r.Elems) == 0 {
+		return nil
+	}
+	typ, _ := r.Elems[0].Str()
+	if typ == "invalidate" && len(r.Elems) == 2 {
+		return r.Elems[1]
+	} else if typ != "message" || len(r.Elems) != 3 {
+		return nil
+	}
+	if ch, _ := r.Elems[1].Str(); ch != "__redis__:invalidate" {
+		return nil
+	}
+	return r.Elems[2]
+}
+
+// must be called with lock held
+func (c *Cache) invalidate(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e.elem)
+		delete(c.entries, key)
+	}
+	if _, ok := c.inflight[key]; ok {
+		c.inflight[key] = true
+	}
+}
+
+// must be called with lock held
+func (c *Cache) flush() {
+	c.entries = map[string]*entry{}
+	c.lru.Init()
+	for key := range c.inflight {
+		c.inflight[key] = true
+	}
+}
+
+// lookup returns the cached reply for the given command (as returned by
+// sel) on the given key, or nil. If nil is returned the key is marked as in
+// flight, and store must be called after it's been fetched.
+func (c *Cache) lookup(key string, sel func(*entry) **redis.Reply) *redis.Reply {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[key]; ok {
+		if r := *sel(e); r != nil && time.Now().Before(e.expires) {
+			c.lru.MoveToFront(e.elem)
+			return r
+		}
+	}
+	if _, ok := c.inflight[key]; !ok && !c.disabled {
+		c.inflight[key] = false
+	}
+	return nil
+}
+
+func (c *Cache) store(key string, r *redis.Reply, sel func(*entry) **redis.Reply) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	invalidated, ok := c.inflight[key]
+	delete(c.inflight, key)
+	if !ok || invalidated || c.disabled || r.Type == redis.ErrorReply {
+		return
+	}
+
+	e, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(e.elem)
+	} else {
+		e = &entry{key: key}
+		e.elem = c.lru.PushFront(e)
+		c.entries[key] = e
+	}
+	*sel(e) = r
+	e.expires = time.Now().Add(c.opts.TTL)
+
+	for c.lru.Len() > c.opts.MaxSize {
+		old := c.lru.Remove(c.lru.Back()).(*entry)
+		delete(c.entries, old.key)
+	}
+}
+
+func (c *Cache) cached(cmd, key string, sel func(*entry) **redis.Reply) *redis.Reply {
+	if r := c.lookup(key, sel); r != nil {
+		return r
+	}
+	r := c.Cmd(cmd, key)
+	c.store(key, r, sel)
+	return r
+}
+
+func selGet(e *entry) **redis.Reply     { return &e.get }
+func selHGetAll(e *entry) **redis.Reply { return &e.hgetall }
+
+// Get returns the reply to GET on the given key, either from the local cache
+// or from redis (in which case it's cached for later). The returned Reply is
+// shared with other callers and must not be modified.
+func (c *Cache) Get(key string) *redis.Reply {
+	return c.cached("GET", key, selGet)
+}
+
+// HGetAll is like Get, but for HGETALL
+func (c *Cache) HGetAll(key string) *redis.Reply {
+	return c.cached("HGETALL", key, selHGetAll)
+}
+
+// Cmd performs the given command on the underlying connection, bypassing the
+// cache. Any keys it reads will be tracked by the server, but the replies are
+// not cached locally. Writes made through Cmd to locally cached keys will
+// cause them to be invalidated, just as writes from other clients do.
+func (c *Cache) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	c.connLock.Lock()
+	r := c.conn.Cmd(cmd, args...)
+	c.connLock.Unlock()
+
+	// The server forgets which keys a connection was tracking once it's gone,
+	// so if it's broken nothing cached can be trusted anymore
+	if redis.IsNetworkErr(r.Err) {
+		c.lock.Lock()
+		c.disabled = true
+		c.flush()
+		c.lock.Unlock()
+	}
+	return r
+}
+
+// Len returns the number of keys currently cached locally
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.entries)
+}
+
+// Close closes both of the Cache's connections
+func (c *Cache) Close() {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	c.conn.Close()
+	c.inv.Close()
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	. "testing"
+	"time"
+
+	"github.com/fzzy/radix/extra/server"
+	"github.com/fzzy/radix/redis"
+	"github.com/fzzy/radix/redis/resp"
+)
+
+// These tests assume a redis 6+ instance listening on port 6379
+
+func TestCache(t *T) {
+	c, err := New("tcp", "127.0.0.1:6379", Opts{MaxSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	other, err := redis.Dial("tcp", "127.0.0.1:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	assert.Nil(t, other.Cmd("SET", "cache-test-a", "foo").Err)
+	v, err := c.Get("cache-test-a").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", v)
+	assert.Equal(t, 1, c.Len())
+
+	// Modifying the key from another connection should invalidate it
+	assert.Nil(t, other.Cmd("SET", "cache-test-a", "bar").Err)
+	for i := 0; i < 100 && c.Len() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 0, c.Len())
+	v, err = c.Get("cache-test-a").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", v)
+
+	// Hashes, and eviction of the oldest key once full
+	assert.Nil(t, other.Cmd("HSET", "cache-test-b", "foo", "bar").Err)
+	assert.Nil(t, other.Cmd("SET", "cache-test-c", "baz").Err)
+	h, err := c.HGetAll("cache-test-b").Hash()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", h["foo"])
+	c.Get("cache-test-c")
+	assert.Equal(t, 2, c.Len())
+	_, ok := c.entries["cache-test-a"]
+	assert.False(t, ok)
+}
+
+// fakeTracking starts a server which does just enough to stand in for redis's
+// CLIENT TRACKING, replying to every GET with "foo". The connection which
+// subscribed to invalidations is sent on the returned channel.
+func fakeTracking(t *T) (*server.Server, string, chan *server.Conn) {
+	invCh := make(chan *server.Conn, 1)
+	s := server.New(func(c *server.Conn, cmd string, args []string) interface{} {
+		switch strings.ToUpper(cmd) {
+		case "HELLO":
+			return server.Raw("%1\r\n$5\r\nproto\r\n:3\r\n")
+		case "CLIENT":
+			if strings.EqualFold(args[0], "ID") {
+				return 1
+			}
+			return resp.NewSimpleString("OK")
+		case "SUBSCRIBE":
+			invCh <- c
+			return server.Raw(">3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n")
+		case "GET":
+			return "foo"
+		}
+		return nil
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	return s, l.Addr().String(), invCh
+}
+
+func waitLen(c *Cache, n int) int {
+	for i := 0; i < 100 && c.Len() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c.Len()
+}
+
+func TestInvalidatePush(t *T) {
+	s, addr, invCh := fakeTracking(t)
+	defer s.Close()
+	c, err := New("tcp", addr, Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	inv := <-invCh
+
+	v, err := c.Get("a").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", v)
+	c.Get("b")
+	assert.Equal(t, 2, c.Len())
+
+	// A RESP3 redirect target gets invalidations as pushes of their own
+	// rather than as pub/sub messages
+	assert.Nil(t, inv.Push(server.Raw(">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\na\r\n")))
+	assert.Equal(t, 1, waitLen(c, 1))
+	_, ok := c.entries["b"]
+	assert.True(t, ok)
+
+	// A nil list of keys means the server flushed
+	assert.Nil(t, inv.Push(server.Raw(">2\r\n$10\r\ninvalidate\r\n_\r\n")))
+	assert.Equal(t, 0, waitLen(c, 0))
+}
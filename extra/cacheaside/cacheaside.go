@@ -0,0 +1,174 @@
+// The cacheaside package implements read-through caching of values which are
+// expensive to produce, e.g. the results of database queries, with protection
+// against cache stampedes:
+//
+//	c := cacheaside.New(p, cacheaside.Opts{})
+//	b, err := c.Get("user:1", time.Minute, func() ([]byte, error) {
+//		return loadUser(1)
+//	})
+//
+// When a value is missing only one caller, across every process sharing the
+// cache, regenerates it. That caller takes a short-lived lock on the key (see
+// the lock package) while it calls fetch, and the others wait for the value to
+// appear rather than all calling fetch at once. If StaleTTL is set values are
+// kept around for that much longer than their ttl, and while one caller
+// regenerates a value which has gone stale the others are given the stale copy
+// straight away instead of waiting.
+package cacheaside
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fzzy/radix/extra/lock"
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults for Opts fields which aren't set
+const (
+	DefaultLockTTL      = 5 * time.Second
+	DefaultPollInterval = 50 * time.Millisecond
+)
+
+// LockSuffix is appended to a key to make the key of the lock taken while its
+// value is being regenerated
+const LockSuffix = ":lock"
+
+// ErrTimeout is returned by Get when it's waited for another caller to
+// regenerate a value for longer than Opts.Wait
+var ErrTimeout = errors.New("cacheaside: timed out waiting for value")
+
+// Opts are the options which can be given to New
+type Opts struct {
+	// How long the lock taken while regenerating a value lasts, which should
+	// be longer than fetch ever takes. If the caller regenerating a value goes
+	// away the lock expires after this long, and another caller takes over.
+	// Defaults to DefaultLockTTL.
+	LockTTL time.Duration
+
+	// How long a caller waits for another to regenerate a value before giving
+	// up with ErrTimeout. Defaults to LockTTL.
+	Wait time.Duration
+
+	// How often a waiting caller checks whether the value has been
+	// regenerated. Defaults to DefaultPollInterval.
+	PollInterval time.Duration
+
+	// If set, values are kept for this long after their ttl has passed, and
+	// served while they're being regenerated, see the package docs
+	StaleTTL time.Duration
+}
+
+// Cache reads values through redis, see the package docs. It's safe to use
+// from multiple routines at once as long as its redis.Cmder is, e.g. if it's a
+// pool.Pool.
+type Cache struct {
+	db   redis.Cmder
+	opts Opts
+}
+
+// New returns a Cache which keeps its values in db
+func New(db redis.Cmder, opts Opts) *Cache {
+	if opts.LockTTL <= 0 {
+		opts.LockTTL = DefaultLockTTL
+	}
+	if opts.Wait <= 0 {
+		opts.Wait = opts.LockTTL
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	return &Cache{db: db, opts: opts}
+}
+
+// Get returns the value of the key, calling fetch to produce it if it's
+// missing (or stale) and caching what's returned for ttl. If ttl isn't
+// positive the value is cached until it's Invalidated. Errors returned by
+// fetch are returned as-is, and nothing is cached. If caching the value fails
+// it's returned along with the error.
+func (c *Cache) Get(key string, ttl time.Duration, fetch func() ([]byte, error)) ([]byte, error) {
+	val, found, fresh, err := c.get(key)
+	if err != nil {
+		return nil, err
+	} else if found && fresh {
+		return val, nil
+	}
+
+	locker := lock.NewLocker(key+LockSuffix, lock.Opts{TTL: c.opts.LockTTL}, c.db)
+	deadline := time.Now().Add(c.opts.Wait)
+	for {
+		lk, err := locker.TryLock()
+		if err == nil {
+			defer lk.Unlock()
+			return c.fill(key, ttl, fetch)
+		} else if err != lock.ErrNotAcquired {
+			return nil, err
+		}
+
+		// Someone else is regenerating the value
+		if found {
+			return val, nil
+		} else if !time.Now().Before(deadline) {
+			return nil, ErrTimeout
+		}
+		time.Sleep(c.opts.PollInterval)
+		if val, found, _, err = c.get(key); err != nil {
+			return nil, err
+		} else if found {
+			return val, nil
+		}
+	}
+}
+
+// Invalidate deletes the value of the key, so that the next Get regenerates
+// it
+func (c *Cache) Invalidate(key string) error {
+	return c.db.Cmd("DEL", key).Err
+}
+
+// get returns the cached value of the key, whether there is one, and whether
+// it's fresh (rather than stale)
+func (c *Cache) get(key string) ([]byte, bool, bool, error) {
+	val, err := c.db.Cmd("GET", key).Bytes()
+	if err == redis.ErrNil {
+		return nil, false, false, nil
+	} else if err != nil {
+		return nil, false, false, err
+	}
+	if c.opts.StaleTTL <= 0 {
+		return val, true, true, nil
+	}
+
+	// A value which is stale is one which has less than StaleTTL to live
+	pttl, err := c.db.Cmd("PTTL", key).Int64()
+	if err != nil {
+		return nil, false, false, err
+	}
+	fresh := pttl == -1 || time.Duration(pttl)*time.Millisecond > c.opts.StaleTTL
+	return val, true, fresh, nil
+}
+
+// fill calls fetch and caches its result, which must only be done while
+// holding the key's lock
+func (c *Cache) fill(key string, ttl time.Duration, fetch func() ([]byte, error)) ([]byte, error) {
+	// The value may have been regenerated between when it was found to be
+	// missing and when the lock was acquired
+	if val, found, fresh, err := c.get(key); err != nil {
+		return nil, err
+	} else if found && fresh {
+		return val, nil
+	}
+
+	val, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	var r *redis.Reply
+	if ttl > 0 {
+		px := int64((ttl + c.opts.StaleTTL) / time.Millisecond)
+		r = c.db.Cmd("SET", key, val, "PX", px)
+	} else {
+		r = c.db.Cmd("SET", key, val)
+	}
+	return val, r.Err
+}
@@ -0,0 +1,161 @@
+package cacheaside
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// fakeDB is an in-memory redis.Cmder implementing just the commands used by a
+// Cache and its locks
+type fakeDB struct {
+	sync.Mutex
+	vals    map[string]string
+	expires map[string]time.Time
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{vals: map[string]string{}, expires: map[string]time.Time{}}
+}
+
+func (db *fakeDB) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	db.Lock()
+	defer db.Unlock()
+	str := func(i int) string {
+		if b, ok := args[i].([]byte); ok {
+			return string(b)
+		}
+		return args[i].(string)
+	}
+	key := str(0)
+	if exp, ok := db.expires[key]; ok && !time.Now().Before(exp) {
+		delete(db.vals, key)
+		delete(db.expires, key)
+	}
+	val, ok := db.vals[key]
+
+	switch cmd {
+	case "GET":
+		if !ok {
+			return redis.NewReply(nil)
+		}
+		return redis.NewReply(val)
+	case "PTTL":
+		if !ok {
+			return redis.NewReply(-2)
+		} else if exp, ok := db.expires[key]; ok {
+			return redis.NewReply(int(time.Until(exp) / time.Millisecond))
+		}
+		return redis.NewReply(-1)
+	case "SET":
+		var nx bool
+		var px time.Duration
+		for i := 2; i < len(args); i++ {
+			switch str(i) {
+			case "NX":
+				nx = true
+			case "PX":
+				i++
+				px = time.Duration(args[i].(int64)) * time.Millisecond
+			}
+		}
+		if nx && ok {
+			return redis.NewReply(nil)
+		}
+		db.vals[key] = str(1)
+		delete(db.expires, key)
+		if px > 0 {
+			db.expires[key] = time.Now().Add(px)
+		}
+		return redis.NewReply("OK")
+	case "DEL":
+		delete(db.vals, key)
+		return redis.NewReply(1)
+	case "EVALSHA":
+		// The only script is the lock's unlock, which deletes the key if it
+		// holds the token
+		key = str(2)
+		if db.vals[key] == str(3) {
+			delete(db.vals, key)
+			return redis.NewReply(1)
+		}
+		return redis.NewReply(0)
+	}
+	return redis.NewReply(errors.New("ERR unknown command"))
+}
+
+func TestGet(t *T) {
+	db := newFakeDB()
+	c := New(db, Opts{PollInterval: time.Millisecond})
+
+	// Many callers at once only fetch the value once
+	var fetches int32
+	fetch := func() ([]byte, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		return []byte("bar"), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := c.Get("foo", time.Minute, fetch)
+			assert.Nil(t, err)
+			assert.Equal(t, "bar", string(b))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	_, held := db.vals["foo"+LockSuffix]
+	assert.False(t, held)
+
+	b, err := c.Get("foo", time.Minute, fetch)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", string(b))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// Errors from fetch aren't cached
+	errFetch := errors.New("fetch failed")
+	_, err = c.Get("baz", time.Minute, func() ([]byte, error) { return nil, errFetch })
+	assert.Equal(t, errFetch, err)
+	_, cached := db.vals["baz"]
+	assert.False(t, cached)
+
+	// Waiting on a lock which is never released times out
+	db.vals["qux"+LockSuffix] = "someone"
+	c = New(db, Opts{Wait: 10 * time.Millisecond, PollInterval: time.Millisecond})
+	_, err = c.Get("qux", time.Minute, fetch)
+	assert.Equal(t, ErrTimeout, err)
+
+	assert.Nil(t, c.Invalidate("foo"))
+	c.Get("foo", time.Minute, fetch)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestGetStale(t *T) {
+	db := newFakeDB()
+	c := New(db, Opts{StaleTTL: time.Minute})
+	fetch := func() ([]byte, error) { return []byte("new"), nil }
+
+	// A value with less than StaleTTL to live is stale, and is served as-is
+	// while someone else holds the lock
+	db.vals["foo"] = "old"
+	db.expires["foo"] = time.Now().Add(30 * time.Second)
+	db.vals["foo"+LockSuffix] = "someone"
+	b, err := c.Get("foo", time.Minute, fetch)
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(b))
+
+	// Otherwise it's regenerated, and kept for ttl plus StaleTTL
+	delete(db.vals, "foo"+LockSuffix)
+	b, err = c.Get("foo", time.Minute, fetch)
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(b))
+	assert.True(t, time.Until(db.expires["foo"]) > 119*time.Second)
+}
@@ -10,6 +10,7 @@
 package cluster
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -17,6 +18,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fzzy/radix/extra/admin"
 	"github.com/fzzy/radix/redis"
 )
 
@@ -59,7 +61,14 @@ func (o *clientCmdOpts) haveTried(addr string) bool {
 	return ok
 }
 
-// Cluster wraps a Client and accounts for all redis cluster logic
+var (
+	_ redis.Cmder = (*Cluster)(nil)
+	_ redis.Doer  = (*Cluster)(nil)
+)
+
+// Cluster wraps a Client and accounts for all redis cluster logic. It
+// implements redis.Cmder and redis.Doer, which code using it can take instead
+// so that it can be given a mock in tests, see redis.Conn.
 type Cluster struct {
 	mapping
 	clients map[string]*redis.Client
@@ -74,6 +83,20 @@ type Cluster struct {
 	// Number of slot misses. This is incremented everytime a command's reply is
 	// a MOVED or ASK message
 	Misses uint64
+
+	// If set, MGET, MSET, DEL, UNLINK, EXISTS and TOUCH commands whose keys
+	// belong to more than one slot are split up by slot and their replies
+	// merged, rather than a CrossSlotError being returned. The parts are
+	// performed one after the other, so not atomically.
+	SplitMultiKey bool
+
+	// How commands which a node refuses while in a transient state (see
+	// redis.IsTransientErr) are retried. This is separate from the handling
+	// of MOVED and ASK redirections and of network errors, which Cmd always
+	// does. READONLY and CLUSTERDOWN usually mean a failover is under way, so
+	// the topology is refreshed (see Reset) before they're retried. Set to
+	// redis.DefaultServerRetryPolicy by NewCluster, nil disables retries.
+	ServerRetryPolicy *redis.RetryPolicy
 }
 
 // NewCluster will perform the following steps to initialize:
@@ -107,7 +130,8 @@ func NewClusterTimeout(addr string, timeout time.Duration) (*Cluster, error) {
 		clients: map[string]*redis.Client{
 			addr: initialClient,
 		},
-		timeout: timeout,
+		timeout:           timeout,
+		ServerRetryPolicy: redis.DefaultServerRetryPolicy,
 	}
 	if err := c.Reset(); err != nil {
 		return nil, err
@@ -226,32 +250,73 @@ func (c *Cluster) Reset() error {
 
 // Cmd performs the given command on the correct cluster node and gives back the
 // command's reply. The command *must* have a key parameter (i.e. len(args) >=
-// 1). If any MOVED or ASK errors are returned they will be transparently
-// handled by this method. This method will also increment the Misses field on
-// the Cluster struct whenever a redirection occurs
+// 1, or for EVAL and EVALSHA at least one key after the numkeys argument). If
+// any MOVED or ASK errors are returned they will be transparently handled by
+// this method. This method will also increment the Misses field on the Cluster
+// struct whenever a redirection occurs
+//
+// The keys of commands which take more than one (e.g. MGET, MSET, DEL, EVAL)
+// are checked before the command is sent, and a CrossSlotError is returned if
+// they don't all belong to the same slot, unless SplitMultiKey applies.
+// Commands refused because a node is loading, read-only, etc... are retried
+// according to ServerRetryPolicy.
 func (c *Cluster) Cmd(cmd string, args ...interface{}) *redis.Reply {
-	if len(args) < 1 {
-		return errorReply(BadCmdNoKey)
+	if err := checkSlots(cmd, commandKeys(cmd, args)); err != nil {
+		if c.SplitMultiKey && splittable[strings.ToUpper(cmd)] {
+			return c.splitCmd(cmd, args)
+		}
+		return errorReply(err)
 	}
 
-	key, err := keyFromArg(args[0])
-	if err != nil {
-		return errorReply(err)
+	i := keyIndex(cmd)
+	if len(args) <= i {
+		return errorReply(BadCmdNoKey)
 	}
 
-	client, addr, err := c.ClientForKey(key)
+	key, err := keyFromArg(args[i])
 	if err != nil {
 		return errorReply(err)
 	}
 
-	c.clientCmdOpts = clientCmdOpts{
-		clientAddr: addr,
-		client:     client,
-		cmd:        cmd,
-		args:       args,
+	for attempt := 1; ; attempt++ {
+		client, addr, err := c.ClientForKey(key)
+		if err != nil {
+			return errorReply(err)
+		}
+
+		c.clientCmdOpts = clientCmdOpts{
+			clientAddr: addr,
+			client:     client,
+			cmd:        cmd,
+			args:       args,
+		}
+
+		r := c.clientCmd(&c.clientCmdOpts)
+		if !c.ServerRetryPolicy.ShouldRetry(attempt, r.Err) {
+			return r
+		}
+		if needsReset(r.Err) {
+			// The node may be gone for good, so if the topology can't be
+			// refreshed the retry is made on what we know already
+			c.Reset()
+		}
+		time.Sleep(c.ServerRetryPolicy.Backoff(attempt))
 	}
+}
+
+// needsReset returns whether the given error suggests that the cluster's
+// topology has changed in a way MOVED won't tell us about, e.g. a failover
+// which turned the node we sent the command to into a replica
+func needsReset(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "READONLY ") || strings.HasPrefix(msg, "CLUSTERDOWN ")
+}
 
-	return c.clientCmd(&c.clientCmdOpts)
+// Do runs the Action using the Cluster, so that each of its commands is
+// performed on the node its key belongs to as Cmd does. As with Cmd, every
+// command the Action performs must have a key.
+func (c *Cluster) Do(a redis.Action) error {
+	return a.Run(c)
 }
 
 // Logic for doing a command:
@@ -299,9 +364,9 @@ func (c *Cluster) clientCmd(o *clientCmdOpts) *redis.Reply {
 	haveTriedBefore := o.haveTried(o.clientAddr)
 	o.justTried(o.clientAddr)
 
-	// If we're not dealing with a CmdError (application error) then it's a
-	// network error, deal with that here
-	if _, ok := err.(*redis.CmdError); !ok {
+	// If we're dealing with a network error (as opposed to an application
+	// error) deal with that here
+	if redis.IsNetworkErr(err) {
 		if !haveTriedBefore {
 			o.client.Close()
 			o.client, err = redis.DialTimeout("tcp", o.clientAddr, c.timeout)
@@ -397,6 +462,16 @@ func redirectInfo(msg string) (int, string) {
 	return slot, addr
 }
 
+// keyIndex returns the index in a command's arguments of its first key
+func keyIndex(cmd string) int {
+	switch strings.ToUpper(cmd) {
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		// script/sha/function, numkeys, key...
+		return 2
+	}
+	return 0
+}
+
 // We unfortunately support some weird stuff for command arguments, such as
 // automatically flattening slices and things like that. So this gets
 // complicated. Usually the user will do something normal like pass in a string
@@ -425,18 +500,23 @@ func keyFromArg(arg interface{}) (string, error) {
 	}
 }
 
-// ClientForKey returns the Client which *ought* to handle the given key (along
-// with the node address for that client), based on Cluster's understanding of
-// the cluster topology at the given moment. If the slot isn't known or there is
-// an error contacting the correct node, a random client is returned
-func (c *Cluster) ClientForKey(key string) (*redis.Client, string, error) {
+// Slot returns the slot the given key (or shard channel) belongs to, taking
+// hash tags into account
+func Slot(key string) uint16 {
 	if start := strings.Index(key, "{"); start >= 0 {
 		if end := strings.Index(key[start+2:], "}"); end >= 0 {
 			key = key[start+1 : start+2+end]
 		}
 	}
-	i := CRC16([]byte(key)) % NUM_SLOTS
-	addr := c.mapping[i]
+	return CRC16([]byte(key)) % NUM_SLOTS
+}
+
+// ClientForKey returns the Client which *ought* to handle the given key (along
+// with the node address for that client), based on Cluster's understanding of
+// the cluster topology at the given moment. If the slot isn't known or there is
+// an error contacting the correct node, a random client is returned
+func (c *Cluster) ClientForKey(key string) (*redis.Client, string, error) {
+	addr := c.mapping[Slot(key)]
 	if addr != "" {
 		client, err := c.getClient(addr, false)
 		if err == nil {
@@ -451,6 +531,90 @@ func (c *Cluster) ClientForKey(key string) (*redis.Client, string, error) {
 	return client, addr, nil
 }
 
+// ClientForSlot is like ClientForKey, but for a slot number
+func (c *Cluster) ClientForSlot(slot uint16) (*redis.Client, string, error) {
+	if slot >= NUM_SLOTS {
+		return nil, "", fmt.Errorf("invalid slot %d", slot)
+	}
+	addr := c.mapping[slot]
+	if addr == "" {
+		return nil, "", fmt.Errorf("No node known for slot %d", slot)
+	}
+	client, err := c.getClient(addr, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, addr, nil
+}
+
+// slotCmd performs the given command on the node owning the given slot. It's
+// for commands which take a slot rather than a key, and which any node will
+// answer regardless of whether it owns the slot, so unlike with Cmd there's no
+// redirection or falling back to other nodes.
+func (c *Cluster) slotCmd(slot uint16, cmd string, args ...interface{}) *redis.Reply {
+	client, addr, err := c.ClientForSlot(slot)
+	if err != nil {
+		return errorReply(err)
+	}
+	r := client.Cmd(cmd, args...)
+	if redis.IsNetworkErr(r.Err) {
+		// The next call will make a new connection
+		delete(c.clients, addr)
+		client.Close()
+	}
+	return r
+}
+
+// CountKeysInSlot returns the number of keys in the given slot, using CLUSTER
+// COUNTKEYSINSLOT on the node which owns it
+func (c *Cluster) CountKeysInSlot(slot uint16) (int64, error) {
+	return c.slotCmd(slot, "CLUSTER", "COUNTKEYSINSLOT", slot).Int64()
+}
+
+// GetKeysInSlot returns up to count keys from the given slot, using CLUSTER
+// GETKEYSINSLOT on the node which owns it. Since there's no cursor, all keys in
+// a slot can only be listed in batches if each batch is moved or deleted
+// before the next is retrieved, e.g. while resharding:
+//
+//	for {
+//		keys, err := c.GetKeysInSlot(slot, 100)
+//		if err != nil || len(keys) == 0 {
+//			break
+//		}
+//		// MIGRATE keys to the slot's new node
+//	}
+func (c *Cluster) GetKeysInSlot(slot uint16, count int) ([]string, error) {
+	return c.slotCmd(slot, "CLUSTER", "GETKEYSINSLOT", slot, count).List()
+}
+
+// Diagnose checks the health of every master node in the cluster, see
+// admin.DiagnoseNode. Nodes are checked one after the other, with
+// connections to them being made if need be.
+func (c *Cluster) Diagnose(ctx context.Context) *admin.HealthReport {
+	seen := map[string]bool{}
+	report := &admin.HealthReport{}
+	for i := range c.mapping {
+		addr := c.mapping[i]
+		if addr == "" || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		client, err := c.getClient(addr, false)
+		if err != nil {
+			report.Nodes = append(report.Nodes, admin.NodeHealth{Addr: addr, Err: err})
+			continue
+		}
+		h := admin.DiagnoseNode(ctx, client, addr)
+		if redis.IsNetworkErr(h.Err) || ctx.Err() != nil {
+			delete(c.clients, addr)
+			client.Close()
+		}
+		report.Nodes = append(report.Nodes, h)
+	}
+	return report
+}
+
 // Close calls Close on all connected clients
 func (c *Cluster) Close() {
 	for i := range c.clients {
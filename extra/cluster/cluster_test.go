@@ -5,7 +5,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"strings"
 	. "testing"
+	"time"
 
+	"github.com/fzzy/radix/extra/redistest"
 	"github.com/fzzy/radix/redis"
 )
 
@@ -35,6 +37,12 @@ func TestKeyFromArg(t *T) {
 	}
 }
 
+func TestKeyIndex(t *T) {
+	assert.Equal(t, 0, keyIndex("GET"))
+	assert.Equal(t, 2, keyIndex("evalsha"))
+	assert.Equal(t, 2, keyIndex("EVAL"))
+}
+
 func getCluster(t *T) *Cluster {
 	cluster, err := NewCluster("127.0.0.1:7000")
 	if err != nil {
@@ -163,3 +171,230 @@ func TestCmdAsk(t *T) {
 	assert.Nil(t, dst.Cmd("CLUSTER", "SETSLOT", slot, "NODE", srcId).Err)
 	assert.Nil(t, src.Cmd("CLUSTER", "SETSLOT", slot, "NODE", srcId).Err)
 }
+
+func TestSlot(t *T) {
+	assert.Equal(t, uint16(12182), Slot("foo"))
+	assert.Equal(t, Slot("bar"), Slot("{bar}foo"))
+	assert.Equal(t, Slot("{}foo"), Slot("{}foo"))
+}
+
+func TestShardedSub(t *T) {
+	cluster := getCluster(t)
+	defer cluster.Close()
+	s, err := NewShardedSub("127.0.0.1:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// "foo" and "bar" are on different nodes
+	assert.Nil(t, s.SSubscribe("foo", "bar"))
+	assert.Equal(t, 2, len(s.subs))
+
+	for _, ch := range []string{"foo", "bar"} {
+		assert.Nil(t, cluster.Cmd("SPUBLISH", ch, "hi "+ch).Err)
+		sr := <-s.Ch
+		assert.Nil(t, sr.Err)
+		assert.True(t, sr.Shard)
+		assert.Equal(t, ch, sr.Channel)
+		assert.Equal(t, "hi "+ch, sr.Message)
+	}
+
+	assert.Nil(t, s.SUnsubscribe("foo", "bar"))
+	assert.Equal(t, 0, len(s.channels))
+}
+
+func TestKeysInSlot(t *T) {
+	cluster := getCluster(t)
+	defer cluster.Close()
+
+	// Both nodes are asked, depending on the slot
+	for _, key := range []string{"{bar}keysInSlot", "{foo}keysInSlot"} {
+		assert.Nil(t, cluster.Cmd("SET", key, "bar").Err)
+		slot := Slot(key)
+		n, err := cluster.CountKeysInSlot(slot)
+		assert.Nil(t, err)
+		assert.True(t, n >= 1)
+
+		keys, err := cluster.GetKeysInSlot(slot, 1000)
+		assert.Nil(t, err)
+		found := false
+		for _, k := range keys {
+			found = found || k == key
+		}
+		assert.True(t, found)
+		cluster.Cmd("DEL", key)
+	}
+
+	_, _, err := cluster.ClientForSlot(NUM_SLOTS)
+	assert.NotNil(t, err)
+}
+
+func TestMGetResult(t *T) {
+	r := &MGetResult{Keys: make([]KeyResult, 5)}
+	errFoo := errors.New("foo")
+	r.set([]int{3, 0}, redis.NewReply([]interface{}{"d", nil}))
+	r.set([]int{1, 4}, redis.NewReply(errFoo))
+	r.set([]int{2}, redis.NewReply([]interface{}{"c", "extra"}))
+
+	v, _ := r.Keys[3].Reply.Str()
+	assert.Equal(t, "d", v)
+	assert.Equal(t, redis.NilReply, r.Keys[0].Reply.Type)
+	assert.Equal(t, errFoo, r.Keys[1].Err)
+	assert.Equal(t, errFoo, r.Keys[4].Err)
+	assert.NotNil(t, r.Keys[2].Err)
+	assert.Nil(t, r.Keys[2].Reply)
+	assert.Equal(t, errFoo, r.Err())
+
+	r = &MGetResult{Keys: make([]KeyResult, 2)}
+	r.set([]int{1, 0}, redis.NewReply([]interface{}{"b", nil}))
+	strs, err := r.Strs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "b"}, strs)
+}
+
+func TestGroupBySlot(t *T) {
+	keys := []string{"{a}1", "{b}1", "{a}2"}
+	groups := groupBySlot(keys, 1)
+	assert.Equal(t, []slotGroup{
+		{slot: Slot("a"), idxs: []int{0, 2}},
+		{slot: Slot("b"), idxs: []int{1}},
+	}, groups)
+	assert.Equal(t, []interface{}{"{a}1", "{a}2"}, groups[0].args(keys, 1))
+
+	// Key value pairs, as MSET takes
+	args := []string{"{a}1", "x", "{b}1", "y", "{a}2", "z"}
+	groups = groupBySlot(args, 2)
+	assert.Equal(t, []int{0, 4}, groups[0].idxs)
+	assert.Equal(t, []interface{}{"{a}1", "x", "{a}2", "z"}, groups[0].args(args, 2))
+	assert.Equal(t, []interface{}{"{b}1", "y"}, groups[1].args(args, 2))
+}
+
+func TestMGet(t *T) {
+	cluster := getCluster(t)
+	// foo and bar are on different nodes, {foo}baz shares foo's slot
+	assert.Nil(t, cluster.Cmd("SET", "foo", "1").Err)
+	assert.Nil(t, cluster.Cmd("SET", "bar", "2").Err)
+	assert.Nil(t, cluster.Cmd("SET", "{foo}baz", "3").Err)
+	assert.Nil(t, cluster.Cmd("DEL", "{bar}nope").Err)
+
+	keys := []string{"bar", "foo", "{bar}nope", "{foo}baz", "bar"}
+	r := cluster.MGet(keys...)
+	assert.Nil(t, r.Err())
+	for i := range keys {
+		assert.Equal(t, keys[i], r.Keys[i].Key)
+	}
+	strs, err := r.Strs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2", "1", "", "3", "2"}, strs)
+
+	// Have foo's slot be redirected, only its MGET should be retried
+	misses := cluster.Misses
+	fooSlot := Slot("foo")
+	cluster.mapping[fooSlot] = cluster.mapping[Slot("bar")]
+	strs, err = cluster.MGet(keys...).Strs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2", "1", "", "3", "2"}, strs)
+	assert.Equal(t, misses+1, cluster.Misses)
+}
+
+func TestCommandKeys(t *T) {
+	assert.Nil(t, commandKeys("GET", []interface{}{"a"}))
+	assert.Equal(t, []string{"a", "b"}, commandKeys("mget", []interface{}{"a", []string{"b"}}))
+	assert.Equal(t, []string{"a", "b"}, commandKeys("MSET", []interface{}{"a", 1, "b", 2}))
+	assert.Equal(t, []string{"a", "b"}, commandKeys("BLPOP", []interface{}{"a", "b", 0}))
+	assert.Equal(t, []string{"a", "b"}, commandKeys("EVALSHA", []interface{}{"sha", 2, "a", "b", "arg"}))
+}
+
+func TestCheckSlots(t *T) {
+	assert.Nil(t, checkSlots("MGET", []string{"{user1}:name", "{user1}:email"}))
+	err := checkSlots("mget", []string{"foo", "foo", "bar"})
+	assert.Equal(t, &CrossSlotError{
+		Cmd:  "MGET",
+		Key1: "foo", Slot1: Slot("foo"),
+		Key2: "bar", Slot2: Slot("bar"),
+	}, err)
+	assert.True(t, strings.Contains(err.Error(), `"foo" (slot 12182) and "bar" (slot 5061)`))
+}
+
+func TestSplitMultiKey(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	client, err := redis.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every slot belongs to the one fake node
+	c := &Cluster{clients: map[string]*redis.Client{s.Addr: client}}
+	for i := range c.mapping {
+		c.mapping[i] = s.Addr
+	}
+	defer c.Close()
+
+	r := c.Cmd("MGET", "foo", "bar")
+	_, ok := r.Err.(*CrossSlotError)
+	assert.True(t, ok)
+	assert.Nil(t, s.ExpectCmds())
+
+	c.SplitMultiKey = true
+	s.Handle("MGET", redistest.Reply([]interface{}{"1", nil}), redistest.Reply([]string{"2"}))
+	s.Handle("DEL", redistest.Reply(2), redistest.Reply(1))
+	l, err := c.Cmd("MGET", "foo", "bar", "{foo}x").ListBytes()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), nil}, l)
+	n, err := c.Cmd("DEL", "foo", "{foo}x", "bar").Int()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Nil(t, s.ExpectCmds("MGET foo {foo}x", "MGET bar", "DEL foo {foo}x", "DEL bar"))
+}
+
+func TestServerRetry(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	client, err := redis.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Cluster{
+		clients: map[string]*redis.Client{s.Addr: client},
+		ServerRetryPolicy: &redis.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			RetryOn:        redis.IsTransientErr,
+		},
+	}
+	for i := range c.mapping {
+		c.mapping[i] = s.Addr
+	}
+	defer c.Close()
+
+	// READONLY refreshes the topology before retrying, LOADING only waits
+	s.Handle("CLUSTER", redistest.Reply([]interface{}{
+		[]interface{}{0, NUM_SLOTS - 1, []interface{}{"", 0}},
+	}))
+	s.Handle("GET",
+		redistest.Error("READONLY You can't write against a read only replica."),
+		redistest.Error("LOADING Redis is loading the dataset in memory"),
+		redistest.Reply("bar"),
+	)
+	v, err := c.Cmd("GET", "foo").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", v)
+	assert.Nil(t, s.ExpectCmds("GET foo", "PING", "CLUSTER SLOTS", "GET foo", "GET foo"))
+
+	// Other errors aren't retried, and nor is anything past MaxAttempts
+	s.Reset()
+	s.Handle("GET", redistest.Error("ERR nope"))
+	assert.NotNil(t, c.Cmd("GET", "foo").Err)
+	s.Handle("GET", redistest.Error("TRYAGAIN Multiple keys request during rehashing of slot"))
+	assert.True(t, redis.IsTransientErr(c.Cmd("GET", "foo").Err))
+	assert.Nil(t, s.ExpectCmds("GET foo", "GET foo", "GET foo", "GET foo"))
+}
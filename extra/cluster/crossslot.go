@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// CrossSlotError is returned by Cmd when the keys of a multi-key command don't
+// all belong to the same slot, which redis cluster would otherwise reject
+// with a CROSSSLOT error. Keys can be made to share a slot using hash tags,
+// e.g. "{user1}:name" and "{user1}:email".
+type CrossSlotError struct {
+	Cmd string
+
+	// Two of the keys which belong to different slots, along with those slots
+	Key1, Key2   string
+	Slot1, Slot2 uint16
+}
+
+func (e *CrossSlotError) Error() string {
+	return fmt.Sprintf(
+		"%s keys belong to different slots: %q (slot %d) and %q (slot %d), use hash tags to keep them together",
+		e.Cmd, e.Key1, e.Slot1, e.Key2, e.Slot2,
+	)
+}
+
+// keySpec describes where a multi-key command's keys are in its arguments:
+// every step'th argument from first to last, with a negative last counting
+// back from the end (-1 being the last argument)
+type keySpec struct {
+	first, last, step int
+}
+
+var multiKeyCmds = map[string]keySpec{
+	"MGET":        {0, -1, 1},
+	"MSET":        {0, -1, 2},
+	"MSETNX":      {0, -1, 2},
+	"DEL":         {0, -1, 1},
+	"UNLINK":      {0, -1, 1},
+	"EXISTS":      {0, -1, 1},
+	"TOUCH":       {0, -1, 1},
+	"WATCH":       {0, -1, 1},
+	"RENAME":      {0, 1, 1},
+	"RENAMENX":    {0, 1, 1},
+	"COPY":        {0, 1, 1},
+	"RPOPLPUSH":   {0, 1, 1},
+	"LMOVE":       {0, 1, 1},
+	"BLMOVE":      {0, 1, 1},
+	"SMOVE":       {0, 1, 1},
+	"BLPOP":       {0, -2, 1},
+	"BRPOP":       {0, -2, 1},
+	"SDIFF":       {0, -1, 1},
+	"SINTER":      {0, -1, 1},
+	"SUNION":      {0, -1, 1},
+	"SDIFFSTORE":  {0, -1, 1},
+	"SINTERSTORE": {0, -1, 1},
+	"SUNIONSTORE": {0, -1, 1},
+	"PFCOUNT":     {0, -1, 1},
+	"PFMERGE":     {0, -1, 1},
+}
+
+// commandKeys returns the keys of the given command, if it's one which may
+// have more than one, with its arguments flattened as they would be when
+// written
+func commandKeys(cmd string, args []interface{}) []string {
+	cmd = strings.ToUpper(cmd)
+	switch cmd {
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		flat := flattenArgs(args)
+		if len(flat) < 2 {
+			return nil
+		}
+		var n int
+		if _, err := fmt.Sscan(flat[1], &n); err != nil || n < 0 {
+			return nil
+		}
+		if 2+n > len(flat) {
+			n = len(flat) - 2
+		}
+		return flat[2 : 2+n]
+	}
+
+	spec, ok := multiKeyCmds[cmd]
+	if !ok {
+		return nil
+	}
+	flat := flattenArgs(args)
+	last := spec.last
+	if last < 0 {
+		last = len(flat) + last
+	}
+	var keys []string
+	for i := spec.first; i <= last && i < len(flat); i += spec.step {
+		keys = append(keys, flat[i])
+	}
+	return keys
+}
+
+// checkSlots returns a CrossSlotError if the given keys don't all belong to
+// the same slot
+func checkSlots(cmd string, keys []string) error {
+	if len(keys) < 2 {
+		return nil
+	}
+	slot := Slot(keys[0])
+	for _, key := range keys[1:] {
+		if s := Slot(key); s != slot {
+			return &CrossSlotError{
+				Cmd:  strings.ToUpper(cmd),
+				Key1: keys[0], Slot1: slot,
+				Key2: key, Slot2: s,
+			}
+		}
+	}
+	return nil
+}
+
+// flattenArgs flattens the given arguments into strings the way they'd be
+// written: slices are flattened into their elements, and maps into their keys
+// and values
+func flattenArgs(args []interface{}) []string {
+	flat := make([]string, 0, len(args))
+	for _, arg := range args {
+		flat = appendFlattened(flat, arg)
+	}
+	return flat
+}
+
+func appendFlattened(flat []string, arg interface{}) []string {
+	switch argv := arg.(type) {
+	case string:
+		return append(flat, argv)
+	case []byte:
+		return append(flat, string(argv))
+	case nil:
+		return append(flat, "")
+	}
+
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			flat = appendFlattened(flat, v.Index(i).Interface())
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			flat = appendFlattened(flat, k.Interface())
+			flat = appendFlattened(flat, v.MapIndex(k).Interface())
+		}
+	default:
+		flat = append(flat, fmt.Sprint(arg))
+	}
+	return flat
+}
+
+// splittable are the multi-key commands which Cmd splits up by slot when
+// SplitMultiKey is set, all of which are made up of nothing but keys (or key
+// value pairs, for MSET)
+var splittable = map[string]bool{
+	"MGET": true, "MSET": true, "DEL": true, "UNLINK": true, "EXISTS": true, "TOUCH": true,
+}
+
+// splitCmd performs a splittable command one slot at a time, in the order the
+// slots first appear in keys, and merges the replies: MGET's values are put
+// back in the order of the keys, MSET replies OK if every part did, and the
+// counts of the others are summed. If any part fails its error is returned,
+// though the parts before it will already have been performed.
+func (c *Cluster) splitCmd(cmd string, args []interface{}) *redis.Reply {
+	cmd = strings.ToUpper(cmd)
+	step := multiKeyCmds[cmd].step
+	flat := flattenArgs(args)
+	if len(flat)%step != 0 {
+		return errorReplyf("wrong number of arguments for %s", cmd)
+	}
+
+	var values []*redis.Reply
+	if cmd == "MGET" {
+		values = make([]*redis.Reply, len(flat))
+	}
+	var last *redis.Reply
+	var sum int64
+	for _, g := range groupBySlot(flat, step) {
+		r := c.Cmd(cmd, g.args(flat, step)...)
+		if r.Err != nil {
+			return r
+		}
+		last = r
+
+		switch cmd {
+		case "MGET":
+			vals, err := mgetValues(r, len(g.idxs))
+			if err != nil {
+				return errorReply(err)
+			}
+			for j, i := range g.idxs {
+				values[i] = vals[j]
+			}
+		case "MSET":
+			// Nothing to merge
+		default:
+			n, err := r.Int64()
+			if err != nil {
+				return errorReply(err)
+			}
+			sum += n
+		}
+	}
+
+	switch cmd {
+	case "MGET":
+		return redis.NewReply(values)
+	case "MSET":
+		return last
+	}
+	return redis.NewReply(sum)
+}
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// KeyResult is a single key's slot in an MGetResult
+type KeyResult struct {
+	Key string
+
+	// The key's value, a NilReply if the key doesn't exist. Nil if Err is set.
+	Reply *redis.Reply
+
+	// Why the key's value couldn't be retrieved. Every key which shares a slot
+	// is retrieved using the same MGET, so they fail together.
+	Err error
+}
+
+// MGetResult holds the values of the keys given to MGet
+type MGetResult struct {
+	// One entry per key given to MGet, in the same order and including any
+	// duplicates, so that Keys[i].Key is always the i'th key given
+	Keys []KeyResult
+}
+
+// Err returns the first error in the result, if any
+func (r *MGetResult) Err() error {
+	for i := range r.Keys {
+		if r.Keys[i].Err != nil {
+			return r.Keys[i].Err
+		}
+	}
+	return nil
+}
+
+// Strs returns the values of the keys in order, with an empty string for keys
+// which don't exist, or the first error in the result
+func (r *MGetResult) Strs() ([]string, error) {
+	strs := make([]string, len(r.Keys))
+	for i := range r.Keys {
+		if r.Keys[i].Err != nil {
+			return nil, r.Keys[i].Err
+		} else if r.Keys[i].Reply.Type == redis.NilReply {
+			continue
+		}
+		s, err := r.Keys[i].Reply.Str()
+		if err != nil {
+			return nil, err
+		}
+		strs[i] = s
+	}
+	return strs, nil
+}
+
+// set fills in the results for the keys at the given indexes from the reply
+// to the MGET of those keys
+func (r *MGetResult) set(idxs []int, reply *redis.Reply) {
+	vals, err := mgetValues(reply, len(idxs))
+	for j, i := range idxs {
+		if err != nil {
+			r.Keys[i].Err = err
+		} else {
+			r.Keys[i].Reply = vals[j]
+		}
+	}
+}
+
+// mgetValues returns the values in the reply to an MGET of n keys, checking
+// that there's one for each key
+func mgetValues(reply *redis.Reply, n int) ([]*redis.Reply, error) {
+	if reply.Err != nil {
+		return nil, reply.Err
+	} else if reply.Type != redis.MultiReply || len(reply.Elems) != n {
+		return nil, fmt.Errorf("MGET of %d keys returned %d values", n, len(reply.Elems))
+	}
+	return reply.Elems, nil
+}
+
+// slotGroup is the keys out of a list of arguments which share a slot
+type slotGroup struct {
+	slot uint16
+
+	// Indexes of the keys in the arguments
+	idxs []int
+}
+
+// groupBySlot groups the keys in args, every step'th of which is a key, by
+// their slot, in the order the slots first appear
+func groupBySlot(args []string, step int) []slotGroup {
+	var groups []slotGroup
+	group := map[uint16]int{}
+	for i := 0; i < len(args); i += step {
+		slot := Slot(args[i])
+		g, ok := group[slot]
+		if !ok {
+			g = len(groups)
+			group[slot] = g
+			groups = append(groups, slotGroup{slot: slot})
+		}
+		groups[g].idxs = append(groups[g].idxs, i)
+	}
+	return groups
+}
+
+// args returns the group's share of the arguments it was made from: each of
+// its keys followed by the step-1 arguments after it
+func (g slotGroup) args(args []string, step int) []interface{} {
+	part := make([]interface{}, 0, len(g.idxs)*step)
+	for _, i := range g.idxs {
+		for j := 0; j < step; j++ {
+			part = append(part, args[i+j])
+		}
+	}
+	return part
+}
+
+// MGet retrieves the values of the given keys, which may be spread across any
+// number of slots. Since MGET can only be performed on keys sharing a slot, one
+// is performed for each slot, in the order the slots first appear in keys.
+// Each goes through Cmd, so is redirected (and only it is retried) on MOVED or
+// ASK like any other command.
+//
+// The result always has one entry per key in the same order as the keys were
+// given, regardless of how the keys were split up or which parts failed, so
+// keys and values can't be misaligned.
+func (c *Cluster) MGet(keys ...string) *MGetResult {
+	r := &MGetResult{Keys: make([]KeyResult, len(keys))}
+	for i, key := range keys {
+		r.Keys[i].Key = key
+	}
+	for _, g := range groupBySlot(keys, 1) {
+		r.set(g.idxs, c.Cmd("MGET", g.args(keys, 1)...))
+	}
+	return r
+}
@@ -0,0 +1,215 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fzzy/radix/extra/pubsub"
+	"github.com/fzzy/radix/redis"
+)
+
+// ShardedSub subscribes to shard channels (redis 7's SSUBSCRIBE) across a
+// cluster. Each channel is subscribed to on the node which owns its slot, using
+// a pubsub.Subscriber per node, and all messages are delivered on a single Go
+// channel. Messages can be published to shard channels using Cmd with
+// SPUBLISH.
+//
+// If a node replies to a subscription with MOVED the channel is subscribed to
+// on the node it was moved to instead. If a node later unsubscribes from a
+// channel by itself, because its slot was migrated, the topology is reloaded
+// and the channel is subscribed to again on its new node.
+type ShardedSub struct {
+	// Every message received from any node is sent on this channel, along
+	// with the ReconnectReply and ErrorReply values sent by each node's
+	// pubsub.Subscriber. It is closed once the ShardedSub has been closed. As
+	// with pubsub.Subscriber, it must not be read from by the routine calling
+	// SSubscribe or SUnsubscribe.
+	Ch chan *pubsub.SubReply
+
+	// held while the cluster or any of the maps are used
+	lock     sync.Mutex
+	cluster  *Cluster
+	subs     map[string]*pubsub.Subscriber
+	channels map[string]string // channel -> addr of node it's subscribed on
+	closed   bool
+
+	wg sync.WaitGroup
+}
+
+// NewShardedSub connects to the cluster which the node at the given address
+// belongs to, as NewCluster does, and returns a ShardedSub which isn't yet
+// subscribed to anything. The ShardedSub uses a Cluster of its own, just for
+// knowing the topology.
+func NewShardedSub(addr string) (*ShardedSub, error) {
+	c, err := NewCluster(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &ShardedSub{
+		Ch:       make(chan *pubsub.SubReply),
+		cluster:  c,
+		subs:     map[string]*pubsub.Subscriber{},
+		channels: map[string]string{},
+	}, nil
+}
+
+// SSubscribe subscribes to the given shard channels, each on the node which
+// owns its slot
+func (s *ShardedSub) SSubscribe(channels ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, ch := range channels {
+		if err := s.subscribe(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribe must be called with lock held
+func (s *ShardedSub) subscribe(ch string) error {
+	if s.closed {
+		return pubsub.ErrSubscriberClosed
+	}
+	if _, ok := s.channels[ch]; ok {
+		return nil
+	}
+
+	slot := Slot(ch)
+	addr := s.cluster.mapping[slot]
+	reset := false
+	for {
+		if addr == "" {
+			if reset {
+				return fmt.Errorf("no node known for slot %d", slot)
+			}
+			if err := s.cluster.Reset(); err != nil {
+				return err
+			}
+			reset = true
+			addr = s.cluster.mapping[slot]
+			continue
+		}
+
+		sub, err := s.subFor(addr)
+		if err != nil {
+			return err
+		}
+		err = sub.SSubscribe(ch)
+		if err == nil {
+			s.channels[ch] = addr
+			return nil
+		}
+
+		msg := err.Error()
+		if _, ok := err.(*redis.CmdError); !ok || !strings.HasPrefix(msg, "MOVED ") {
+			return err
+		} else if reset {
+			return errors.New("Cluster doesn't make sense")
+		}
+		// Each subsequent MOVED means the topology is changing under our feet,
+		// so it's reloaded entirely
+		_, movedTo := redirectInfo(msg)
+		s.cluster.mapping[slot] = movedTo
+		if movedTo == addr {
+			if err := s.cluster.Reset(); err != nil {
+				return err
+			}
+			reset = true
+			addr = s.cluster.mapping[slot]
+		} else {
+			addr = movedTo
+		}
+	}
+}
+
+// subFor returns the Subscriber for the node at the given address, creating it
+// if need be. It must be called with lock held.
+func (s *ShardedSub) subFor(addr string) (*pubsub.Subscriber, error) {
+	if sub, ok := s.subs[addr]; ok {
+		return sub, nil
+	}
+	sub, err := pubsub.NewSubscriber("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.subs[addr] = sub
+	s.wg.Add(1)
+	go s.forward(addr, sub)
+	return sub, nil
+}
+
+// forward sends everything received on the given node's Subscriber on Ch, and
+// re-subscribes to channels which the node unsubscribed from by itself
+func (s *ShardedSub) forward(addr string, sub *pubsub.Subscriber) {
+	defer s.wg.Done()
+	for sr := range sub.Ch {
+		if sr.Type == pubsub.UnsubscribeReply {
+			// This is done in a separate routine, since subscribing on this
+			// same node requires its Subscriber to not be blocked on us
+			go s.resubscribe(addr, sr.Channel)
+			continue
+		}
+		s.Ch <- sr
+	}
+}
+
+func (s *ShardedSub) resubscribe(addr, ch string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed || s.channels[ch] != addr {
+		return
+	}
+	delete(s.channels, ch)
+	var err error
+	if err = s.cluster.Reset(); err == nil {
+		err = s.subscribe(ch)
+	}
+	if err != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.Ch <- &pubsub.SubReply{Type: pubsub.ErrorReply, Channel: ch, Shard: true, Err: err}
+		}()
+	}
+}
+
+// SUnsubscribe unsubscribes from the given shard channels
+func (s *ShardedSub) SUnsubscribe(channels ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, ch := range channels {
+		addr, ok := s.channels[ch]
+		if !ok {
+			continue
+		}
+		if err := s.subs[addr].SUnsubscribe(ch); err != nil {
+			return err
+		}
+		delete(s.channels, ch)
+	}
+	return nil
+}
+
+// Close closes all connections. Ch is closed once everything that was
+// received has been read off of it.
+func (s *ShardedSub) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	for _, sub := range s.subs {
+		sub.Close()
+	}
+	s.cluster.Close()
+	s.lock.Unlock()
+
+	go func() {
+		s.wg.Wait()
+		close(s.Ch)
+	}()
+}
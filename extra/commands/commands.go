@@ -0,0 +1,76 @@
+// The commands package provides typed wrappers around common redis commands,
+// for those who would rather have the compiler check their arguments and
+// replies than use the stringly-typed Cmd:
+//
+//	ok, err := commands.Set(conn, "foo", "bar", commands.SetOpts{EX: time.Minute, NX: true})
+//	n, err := commands.ZAdd(conn, "scores", commands.ZAddOpts{}, redis.ZMember{Member: "a", Score: 1})
+//	id, err := commands.XAdd(conn, "events", commands.XAddOpts{MaxLen: 1000}, "*",
+//		map[string]string{"type": "login"})
+//
+// Each wrapper takes a redis.Cmder to perform the command on, such as a Client,
+// Pool or Cluster, followed by the command's arguments in the order redis
+// does, with its options (if any) gathered into an Opts struct whose zero
+// value leaves them all out, and returns the reply converted to the matching
+// Go type. Errors,
+// including redis.ErrNil for nil replies, are returned as the Reply methods
+// return them.
+//
+// The wrappers are generated from a description of each command by gen.go,
+// run with go generate. Commands not covered here can still be performed with
+// Cmd.
+package commands
+
+//go:generate go run gen.go
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/fzzy/radix/redis"
+)
+
+func appendStrings(args []interface{}, ss []string) []interface{} {
+	for _, s := range ss {
+		args = append(args, s)
+	}
+	return args
+}
+
+// appendMap appends the field value pairs of m, sorted by field so that the
+// command written is always the same
+func appendMap(args []interface{}, m map[string]string) []interface{} {
+	fields := make([]string, 0, len(m))
+	for f := range m {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		args = append(args, f, m[f])
+	}
+	return args
+}
+
+func appendZMembers(args []interface{}, ms []redis.ZMember) []interface{} {
+	for _, m := range ms {
+		args = append(args, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+	}
+	return args
+}
+
+// replyOK returns true for an OK reply and false for a nil one, as given by
+// commands which may or may not do anything depending on their options
+func replyOK(r *redis.Reply) (bool, error) {
+	if r.Err != nil {
+		return false, r.Err
+	} else if r.Type == redis.NilReply {
+		return false, nil
+	}
+	s, err := r.Str()
+	if err != nil {
+		return false, err
+	} else if s != "OK" {
+		return false, errors.New("reply is not OK")
+	}
+	return true, nil
+}
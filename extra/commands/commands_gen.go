@@ -0,0 +1,265 @@
+// Code generated by gen.go; DO NOT EDIT.
+
+package commands
+
+import (
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Get performs GET, which returns the value of key, or redis.ErrNil if it
+// doesn't exist
+func Get(c redis.Cmder, key string) (string, error) {
+	args := []interface{}{key}
+	return c.Cmd("GET", args...).Str()
+}
+
+// SetOpts are the options of SET, see Set
+type SetOpts struct {
+	EX      time.Duration // Expire after this long, in whole seconds
+	PX      time.Duration // Expire after this long, in milliseconds
+	NX      bool          // Only set the key if it doesn't already exist
+	XX      bool          // Only set the key if it already exists
+	KeepTTL bool          // Keep the key's existing expiration
+}
+
+func (o SetOpts) appendArgs(args []interface{}) []interface{} {
+	if o.EX > 0 {
+		args = append(args, "EX", int64(o.EX/time.Second))
+	}
+	if o.PX > 0 {
+		args = append(args, "PX", int64(o.PX/time.Millisecond))
+	}
+	if o.NX {
+		args = append(args, "NX")
+	}
+	if o.XX {
+		args = append(args, "XX")
+	}
+	if o.KeepTTL {
+		args = append(args, "KEEPTTL")
+	}
+	return args
+}
+
+// Set performs SET, which sets key to value, returning whether it was set (it
+// isn't if NX or XX aren't satisfied)
+func Set(c redis.Cmder, key, value string, opts SetOpts) (bool, error) {
+	args := []interface{}{key, value}
+	args = opts.appendArgs(args)
+	return replyOK(c.Cmd("SET", args...))
+}
+
+// Del performs DEL, which deletes the given keys, returning the number which
+// existed
+func Del(c redis.Cmder, keys ...string) (int64, error) {
+	args := []interface{}{}
+	args = appendStrings(args, keys)
+	return c.Cmd("DEL", args...).Int64()
+}
+
+// Exists performs EXISTS, which returns the number of the given keys which
+// exist
+func Exists(c redis.Cmder, keys ...string) (int64, error) {
+	args := []interface{}{}
+	args = appendStrings(args, keys)
+	return c.Cmd("EXISTS", args...).Int64()
+}
+
+// Expire performs PEXPIRE, which sets key to expire after ttl, with millisecond
+// precision, returning false if it doesn't exist
+func Expire(c redis.Cmder, key string, ttl time.Duration) (bool, error) {
+	args := []interface{}{key}
+	args = append(args, int64(ttl/time.Millisecond))
+	return c.Cmd("PEXPIRE", args...).Bool()
+}
+
+// Incr performs INCR, which increments the integer at key by one, returning its
+// new value
+func Incr(c redis.Cmder, key string) (int64, error) {
+	args := []interface{}{key}
+	return c.Cmd("INCR", args...).Int64()
+}
+
+// IncrBy performs INCRBY, which increments the integer at key by increment,
+// returning its new value
+func IncrBy(c redis.Cmder, key string, increment int64) (int64, error) {
+	args := []interface{}{key, increment}
+	return c.Cmd("INCRBY", args...).Int64()
+}
+
+// IncrByFloat performs INCRBYFLOAT, which increments the float at key by
+// increment, returning its new value
+func IncrByFloat(c redis.Cmder, key string, increment float64) (float64, error) {
+	args := []interface{}{key, increment}
+	return c.Cmd("INCRBYFLOAT", args...).Float64()
+}
+
+// HGet performs HGET, which returns the value of field in the hash at key, or
+// redis.ErrNil if either doesn't exist
+func HGet(c redis.Cmder, key, field string) (string, error) {
+	args := []interface{}{key, field}
+	return c.Cmd("HGET", args...).Str()
+}
+
+// HSet performs HSET, which sets the given fields of the hash at key, returning
+// the number which were added rather than updated
+func HSet(c redis.Cmder, key string, fields map[string]string) (int64, error) {
+	args := []interface{}{key}
+	args = appendMap(args, fields)
+	return c.Cmd("HSET", args...).Int64()
+}
+
+// HGetAll performs HGETALL, which returns all of the fields of the hash at key
+func HGetAll(c redis.Cmder, key string) (map[string]string, error) {
+	args := []interface{}{key}
+	return c.Cmd("HGETALL", args...).Hash()
+}
+
+// HDel performs HDEL, which deletes the given fields of the hash at key,
+// returning the number which existed
+func HDel(c redis.Cmder, key string, fields ...string) (int64, error) {
+	args := []interface{}{key}
+	args = appendStrings(args, fields)
+	return c.Cmd("HDEL", args...).Int64()
+}
+
+// LPush performs LPUSH, which pushes the given elements onto the head of the
+// list at key, returning its new length
+func LPush(c redis.Cmder, key string, elements ...string) (int64, error) {
+	args := []interface{}{key}
+	args = appendStrings(args, elements)
+	return c.Cmd("LPUSH", args...).Int64()
+}
+
+// RPush performs RPUSH, which pushes the given elements onto the tail of the
+// list at key, returning its new length
+func RPush(c redis.Cmder, key string, elements ...string) (int64, error) {
+	args := []interface{}{key}
+	args = appendStrings(args, elements)
+	return c.Cmd("RPUSH", args...).Int64()
+}
+
+// LRange performs LRANGE, which returns the elements of the list at key from
+// start to stop inclusive, counting from the tail if negative
+func LRange(c redis.Cmder, key string, start, stop int64) ([]string, error) {
+	args := []interface{}{key, start, stop}
+	return c.Cmd("LRANGE", args...).List()
+}
+
+// SAdd performs SADD, which adds the given members to the set at key, returning
+// the number which weren't already in it
+func SAdd(c redis.Cmder, key string, members ...string) (int64, error) {
+	args := []interface{}{key}
+	args = appendStrings(args, members)
+	return c.Cmd("SADD", args...).Int64()
+}
+
+// SRem performs SREM, which removes the given members from the set at key,
+// returning the number which were in it
+func SRem(c redis.Cmder, key string, members ...string) (int64, error) {
+	args := []interface{}{key}
+	args = appendStrings(args, members)
+	return c.Cmd("SREM", args...).Int64()
+}
+
+// SMembers performs SMEMBERS, which returns the members of the set at key, in
+// no particular order
+func SMembers(c redis.Cmder, key string) ([]string, error) {
+	args := []interface{}{key}
+	return c.Cmd("SMEMBERS", args...).List()
+}
+
+// ZAddOpts are the options of ZADD, see ZAdd
+type ZAddOpts struct {
+	NX bool // Only add new members
+	XX bool // Only update existing members
+	GT bool // Only update scores which would increase
+	LT bool // Only update scores which would decrease
+	CH bool // Count changed members as well as added ones
+}
+
+func (o ZAddOpts) appendArgs(args []interface{}) []interface{} {
+	if o.NX {
+		args = append(args, "NX")
+	}
+	if o.XX {
+		args = append(args, "XX")
+	}
+	if o.GT {
+		args = append(args, "GT")
+	}
+	if o.LT {
+		args = append(args, "LT")
+	}
+	if o.CH {
+		args = append(args, "CH")
+	}
+	return args
+}
+
+// ZAdd performs ZADD, which adds the given members to the sorted set at key, or
+// updates their scores, returning the number added (or changed, with CH)
+func ZAdd(c redis.Cmder, key string, opts ZAddOpts, members ...redis.ZMember) (int64, error) {
+	args := []interface{}{key}
+	args = opts.appendArgs(args)
+	args = appendZMembers(args, members)
+	return c.Cmd("ZADD", args...).Int64()
+}
+
+// ZScore performs ZSCORE, which returns the score of member in the sorted set
+// at key, or redis.ErrNil if either doesn't exist
+func ZScore(c redis.Cmder, key, member string) (float64, error) {
+	args := []interface{}{key, member}
+	return c.Cmd("ZSCORE", args...).Float64()
+}
+
+// ZRem performs ZREM, which removes the given members from the sorted set at
+// key, returning the number which were in it
+func ZRem(c redis.Cmder, key string, members ...string) (int64, error) {
+	args := []interface{}{key}
+	args = appendStrings(args, members)
+	return c.Cmd("ZREM", args...).Int64()
+}
+
+// ZRangeWithScores performs ZRANGE, which returns the members of the sorted set
+// at key, along with their scores, by rank from start to stop inclusive
+func ZRangeWithScores(c redis.Cmder, key string, start, stop int64) ([]redis.ZMember, error) {
+	args := []interface{}{key, start, stop}
+	args = append(args, "WITHSCORES")
+	return c.Cmd("ZRANGE", args...).ZMembers()
+}
+
+// XAddOpts are the options of XADD, see XAdd
+type XAddOpts struct {
+	NoMkStream bool  // Don't create the stream if it doesn't exist
+	MaxLen     int64 // Trim the stream to roughly this many entries
+}
+
+func (o XAddOpts) appendArgs(args []interface{}) []interface{} {
+	if o.NoMkStream {
+		args = append(args, "NOMKSTREAM")
+	}
+	if o.MaxLen > 0 {
+		args = append(args, "MAXLEN", "~", o.MaxLen)
+	}
+	return args
+}
+
+// XAdd performs XADD, which appends an entry with the given fields to the
+// stream at key, with the given ID ("*" to have one generated), returning its
+// ID. The fields are written sorted, so are stored in that order
+func XAdd(c redis.Cmder, key string, opts XAddOpts, id string, fields map[string]string) (string, error) {
+	args := []interface{}{key}
+	args = opts.appendArgs(args)
+	args = append(args, id)
+	args = appendMap(args, fields)
+	return c.Cmd("XADD", args...).Str()
+}
+
+// XLen performs XLEN, which returns the number of entries in the stream at key
+func XLen(c redis.Cmder, key string) (int64, error) {
+	args := []interface{}{key}
+	return c.Cmd("XLEN", args...).Int64()
+}
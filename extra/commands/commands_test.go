@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"github.com/fzzy/radix/extra/redistest"
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func TestCommands(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c, err := redis.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s.Handle("SET", redistest.Status("OK"), redistest.Reply(nil))
+	s.Handle("GET", redistest.Reply(nil))
+	s.Handle("ZADD", redistest.Reply(2))
+	s.Handle("ZRANGE", redistest.Reply([]string{"a", "1", "b", "2.5"}))
+	s.Handle("XADD", redistest.Reply("1-0"))
+	s.Handle("HSET", redistest.Reply(2))
+	s.Handle("DEL", redistest.Reply(1))
+
+	ok, err := Set(c, "foo", "bar", SetOpts{EX: time.Minute, NX: true})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = Set(c, "foo", "bar", SetOpts{PX: 1500 * time.Millisecond, XX: true})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = Get(c, "foo")
+	assert.Equal(t, redis.ErrNil, err)
+
+	n, err := ZAdd(c, "z", ZAddOpts{GT: true, CH: true},
+		redis.ZMember{Member: "a", Score: 1}, redis.ZMember{Member: "b", Score: 2.5})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+
+	ms, err := ZRangeWithScores(c, "z", 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []redis.ZMember{{Member: "a", Score: 1}, {Member: "b", Score: 2.5}}, ms)
+
+	id, err := XAdd(c, "s", XAddOpts{MaxLen: 100}, "*", map[string]string{"b": "2", "a": "1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1-0", id)
+
+	n, err = HSet(c, "h", map[string]string{"b": "2", "a": "1"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+
+	n, err = Del(c, "foo", "z")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	assert.Nil(t, s.ExpectCmds(
+		"SET foo bar EX 60 NX",
+		"SET foo bar PX 1500 XX",
+		"GET foo",
+		"ZADD z GT CH 1 a 2.5 b",
+		"ZRANGE z 0 -1 WITHSCORES",
+		"XADD s MAXLEN ~ 100 * a 1 b 2",
+		"HSET h a 1 b 2",
+		"DEL foo z",
+	))
+}
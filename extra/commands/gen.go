@@ -0,0 +1,413 @@
+//go:build ignore
+// +build ignore
+
+// gen.go generates commands_gen.go from the spec below, which describes each
+// command's arguments, options and reply as given by redis' command reference.
+// To add a command, add it to the spec and run go generate.
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// arg is a positional argument. Its kind determines its Go type and how it's
+// written:
+//
+//	string   string
+//	int      int64
+//	float    float64
+//	millis   time.Duration, written as milliseconds
+//	strings  ...string, written one after the other
+//	map      map[string]string, written as field value pairs sorted by field
+//	zmembers ...redis.ZMember, written as score member pairs
+type arg struct {
+	Name, Kind string
+}
+
+// opt is a field of a command's Opts struct, written only if set. Its kind
+// determines its type:
+//
+//	flag    bool, written as Token
+//	seconds time.Duration, written as Token followed by whole seconds
+//	millis  time.Duration, written as Token followed by milliseconds
+//	int     int64, written as Token followed by the value
+//
+// Token may be more than one word, each of which is written as an argument.
+type opt struct {
+	Field, Kind, Token, Doc string
+}
+
+// command describes a single wrapper. Opts, if any, are written after the
+// first OptsAfter Args, and Suffix after everything else. Reply is one of:
+//
+//	str      (string, error)
+//	int      (int64, error)
+//	float    (float64, error)
+//	bool     (bool, error), from an integer reply
+//	ok       (bool, error), true for OK and false for nil
+//	list     ([]string, error)
+//	hash     (map[string]string, error)
+//	zmembers ([]redis.ZMember, error), from a WITHSCORES reply
+type command struct {
+	Name, Cmd, Doc string
+	Args           []arg
+	Opts           []opt
+	OptsAfter      int
+	Suffix         []string
+	Reply          string
+}
+
+var spec = []command{
+	// Strings
+	{
+		Name: "Get", Cmd: "GET", Reply: "str",
+		Doc:  "returns the value of key, or redis.ErrNil if it doesn't exist",
+		Args: []arg{{"key", "string"}},
+	},
+	{
+		Name: "Set", Cmd: "SET", Reply: "ok", OptsAfter: 2,
+		Doc: "sets key to value, returning whether it was set (it isn't if NX " +
+			"or XX aren't satisfied)",
+		Args: []arg{{"key", "string"}, {"value", "string"}},
+		Opts: []opt{
+			{"EX", "seconds", "EX", "Expire after this long, in whole seconds"},
+			{"PX", "millis", "PX", "Expire after this long, in milliseconds"},
+			{"NX", "flag", "NX", "Only set the key if it doesn't already exist"},
+			{"XX", "flag", "XX", "Only set the key if it already exists"},
+			{"KeepTTL", "flag", "KEEPTTL", "Keep the key's existing expiration"},
+		},
+	},
+	{
+		Name: "Del", Cmd: "DEL", Reply: "int",
+		Doc:  "deletes the given keys, returning the number which existed",
+		Args: []arg{{"keys", "strings"}},
+	},
+	{
+		Name: "Exists", Cmd: "EXISTS", Reply: "int",
+		Doc:  "returns the number of the given keys which exist",
+		Args: []arg{{"keys", "strings"}},
+	},
+	{
+		Name: "Expire", Cmd: "PEXPIRE", Reply: "bool",
+		Doc: "sets key to expire after ttl, with millisecond precision, " +
+			"returning false if it doesn't exist",
+		Args: []arg{{"key", "string"}, {"ttl", "millis"}},
+	},
+	{
+		Name: "Incr", Cmd: "INCR", Reply: "int",
+		Doc:  "increments the integer at key by one, returning its new value",
+		Args: []arg{{"key", "string"}},
+	},
+	{
+		Name: "IncrBy", Cmd: "INCRBY", Reply: "int",
+		Doc:  "increments the integer at key by increment, returning its new value",
+		Args: []arg{{"key", "string"}, {"increment", "int"}},
+	},
+	{
+		Name: "IncrByFloat", Cmd: "INCRBYFLOAT", Reply: "float",
+		Doc:  "increments the float at key by increment, returning its new value",
+		Args: []arg{{"key", "string"}, {"increment", "float"}},
+	},
+
+	// Hashes
+	{
+		Name: "HGet", Cmd: "HGET", Reply: "str",
+		Doc: "returns the value of field in the hash at key, or redis.ErrNil if " +
+			"either doesn't exist",
+		Args: []arg{{"key", "string"}, {"field", "string"}},
+	},
+	{
+		Name: "HSet", Cmd: "HSET", Reply: "int",
+		Doc: "sets the given fields of the hash at key, returning the number " +
+			"which were added rather than updated",
+		Args: []arg{{"key", "string"}, {"fields", "map"}},
+	},
+	{
+		Name: "HGetAll", Cmd: "HGETALL", Reply: "hash",
+		Doc:  "returns all of the fields of the hash at key",
+		Args: []arg{{"key", "string"}},
+	},
+	{
+		Name: "HDel", Cmd: "HDEL", Reply: "int",
+		Doc: "deletes the given fields of the hash at key, returning the number " +
+			"which existed",
+		Args: []arg{{"key", "string"}, {"fields", "strings"}},
+	},
+
+	// Lists
+	{
+		Name: "LPush", Cmd: "LPUSH", Reply: "int",
+		Doc: "pushes the given elements onto the head of the list at key, " +
+			"returning its new length",
+		Args: []arg{{"key", "string"}, {"elements", "strings"}},
+	},
+	{
+		Name: "RPush", Cmd: "RPUSH", Reply: "int",
+		Doc: "pushes the given elements onto the tail of the list at key, " +
+			"returning its new length",
+		Args: []arg{{"key", "string"}, {"elements", "strings"}},
+	},
+	{
+		Name: "LRange", Cmd: "LRANGE", Reply: "list",
+		Doc: "returns the elements of the list at key from start to stop " +
+			"inclusive, counting from the tail if negative",
+		Args: []arg{{"key", "string"}, {"start", "int"}, {"stop", "int"}},
+	},
+
+	// Sets
+	{
+		Name: "SAdd", Cmd: "SADD", Reply: "int",
+		Doc: "adds the given members to the set at key, returning the number " +
+			"which weren't already in it",
+		Args: []arg{{"key", "string"}, {"members", "strings"}},
+	},
+	{
+		Name: "SRem", Cmd: "SREM", Reply: "int",
+		Doc: "removes the given members from the set at key, returning the " +
+			"number which were in it",
+		Args: []arg{{"key", "string"}, {"members", "strings"}},
+	},
+	{
+		Name: "SMembers", Cmd: "SMEMBERS", Reply: "list",
+		Doc:  "returns the members of the set at key, in no particular order",
+		Args: []arg{{"key", "string"}},
+	},
+
+	// Sorted sets
+	{
+		Name: "ZAdd", Cmd: "ZADD", Reply: "int", OptsAfter: 1,
+		Doc: "adds the given members to the sorted set at key, or updates their " +
+			"scores, returning the number added (or changed, with CH)",
+		Args: []arg{{"key", "string"}, {"members", "zmembers"}},
+		Opts: []opt{
+			{"NX", "flag", "NX", "Only add new members"},
+			{"XX", "flag", "XX", "Only update existing members"},
+			{"GT", "flag", "GT", "Only update scores which would increase"},
+			{"LT", "flag", "LT", "Only update scores which would decrease"},
+			{"CH", "flag", "CH", "Count changed members as well as added ones"},
+		},
+	},
+	{
+		Name: "ZScore", Cmd: "ZSCORE", Reply: "float",
+		Doc: "returns the score of member in the sorted set at key, or " +
+			"redis.ErrNil if either doesn't exist",
+		Args: []arg{{"key", "string"}, {"member", "string"}},
+	},
+	{
+		Name: "ZRem", Cmd: "ZREM", Reply: "int",
+		Doc: "removes the given members from the sorted set at key, returning " +
+			"the number which were in it",
+		Args: []arg{{"key", "string"}, {"members", "strings"}},
+	},
+	{
+		Name: "ZRangeWithScores", Cmd: "ZRANGE", Reply: "zmembers",
+		Suffix: []string{"WITHSCORES"},
+		Doc: "returns the members of the sorted set at key, along with their " +
+			"scores, by rank from start to stop inclusive",
+		Args: []arg{{"key", "string"}, {"start", "int"}, {"stop", "int"}},
+	},
+
+	// Streams
+	{
+		Name: "XAdd", Cmd: "XADD", Reply: "str", OptsAfter: 1,
+		Doc: "appends an entry with the given fields to the stream at key, with " +
+			"the given ID (\"*\" to have one generated), returning its ID. The " +
+			"fields are written sorted, so are stored in that order",
+		Args: []arg{{"key", "string"}, {"id", "string"}, {"fields", "map"}},
+		Opts: []opt{
+			{"NoMkStream", "flag", "NOMKSTREAM", "Don't create the stream if it doesn't exist"},
+			{"MaxLen", "int", "MAXLEN ~", "Trim the stream to roughly this many entries"},
+		},
+	},
+	{
+		Name: "XLen", Cmd: "XLEN", Reply: "int",
+		Doc:  "returns the number of entries in the stream at key",
+		Args: []arg{{"key", "string"}},
+	},
+}
+
+var argTypes = map[string]string{
+	"string":   "string",
+	"int":      "int64",
+	"float":    "float64",
+	"millis":   "time.Duration",
+	"strings":  "...string",
+	"map":      "map[string]string",
+	"zmembers": "...redis.ZMember",
+}
+
+var optTypes = map[string]string{
+	"flag":    "bool",
+	"seconds": "time.Duration",
+	"millis":  "time.Duration",
+	"int":     "int64",
+}
+
+var replies = map[string]struct{ typ, method string }{
+	"str":      {"string", ".Str()"},
+	"int":      {"int64", ".Int64()"},
+	"float":    {"float64", ".Float64()"},
+	"bool":     {"bool", ".Bool()"},
+	"list":     {"[]string", ".List()"},
+	"hash":     {"map[string]string", ".Hash()"},
+	"ok":       {"bool", ""},
+	"zmembers": {"[]redis.ZMember", ".ZMembers()"},
+}
+
+// argCode returns the code which appends the argument to args
+func argCode(a arg) string {
+	switch a.Kind {
+	case "millis":
+		return "args = append(args, int64(" + a.Name + "/time.Millisecond))"
+	case "strings":
+		return "args = appendStrings(args, " + a.Name + ")"
+	case "map":
+		return "args = appendMap(args, " + a.Name + ")"
+	case "zmembers":
+		return "args = appendZMembers(args, " + a.Name + ")"
+	}
+	return "args = append(args, " + a.Name + ")"
+}
+
+// optCode returns the code which appends the option to args
+func optCode(o opt) string {
+	tokens := `"` + strings.Join(strings.Fields(o.Token), `", "`) + `"`
+	f := "o." + o.Field
+	switch o.Kind {
+	case "flag":
+		return "if " + f + " {\nargs = append(args, " + tokens + ")\n}"
+	case "seconds":
+		return "if " + f + " > 0 {\nargs = append(args, " + tokens + ", int64(" + f + "/time.Second))\n}"
+	case "millis":
+		return "if " + f + " > 0 {\nargs = append(args, " + tokens + ", int64(" + f + "/time.Millisecond))\n}"
+	}
+	return "if " + f + " > 0 {\nargs = append(args, " + tokens + ", " + f + ")\n}"
+}
+
+// params returns the command's parameter list, following c redis.Cmder.
+// Consecutive parameters of the same type share it, as gofmt would have it.
+func params(c command) string {
+	type param struct{ name, typ string }
+	var ps []param
+	for i, a := range c.Args {
+		if i == c.OptsAfter && len(c.Opts) > 0 {
+			ps = append(ps, param{"opts", c.Name + "Opts"})
+		}
+		ps = append(ps, param{a.Name, argTypes[a.Kind]})
+	}
+	if c.OptsAfter >= len(c.Args) && len(c.Opts) > 0 {
+		ps = append(ps, param{"opts", c.Name + "Opts"})
+	}
+
+	var strs []string
+	for i, p := range ps {
+		if i+1 < len(ps) && ps[i+1].typ == p.typ {
+			strs = append(strs, p.name)
+		} else {
+			strs = append(strs, p.name+" "+p.typ)
+		}
+	}
+	return strings.Join(strs, ", ")
+}
+
+// body returns the code which builds the command's arguments. Leading
+// arguments which are written as-is are given in the literal args starts with.
+func body(c command) string {
+	var initial, lines []string
+	for i, a := range c.Args {
+		if i == c.OptsAfter && len(c.Opts) > 0 {
+			lines = append(lines, "args = opts.appendArgs(args)")
+		}
+		simple := a.Kind == "string" || a.Kind == "int" || a.Kind == "float"
+		if simple && len(lines) == 0 {
+			initial = append(initial, a.Name)
+		} else {
+			lines = append(lines, argCode(a))
+		}
+	}
+	if c.OptsAfter >= len(c.Args) && len(c.Opts) > 0 {
+		lines = append(lines, "args = opts.appendArgs(args)")
+	}
+	if len(c.Suffix) > 0 {
+		lines = append(lines, `args = append(args, "`+strings.Join(c.Suffix, `", "`)+`")`)
+	}
+	lines = append([]string{"args := []interface{}{" + strings.Join(initial, ", ") + "}"}, lines...)
+	return strings.Join(lines, "\n")
+}
+
+// comment returns s as a comment wrapped to 80 columns
+func comment(s string) string {
+	var lines []string
+	line := "//"
+	for _, w := range strings.Fields(s) {
+		if len(line)+1+len(w) > 80 {
+			lines = append(lines, line)
+			line = "//"
+		}
+		line += " " + w
+	}
+	return strings.Join(append(lines, line), "\n")
+}
+
+func ret(c command) string {
+	call := `c.Cmd("` + c.Cmd + `", args...)`
+	switch c.Reply {
+	case "ok":
+		return "return replyOK(" + call + ")"
+	}
+	return "return " + call + replies[c.Reply].method
+}
+
+var tmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"params":    params,
+	"body":      body,
+	"ret":       ret,
+	"optCode":   optCode,
+	"comment":   comment,
+	"optType":   func(o opt) string { return optTypes[o.Kind] },
+	"replyType": func(c command) string { return replies[c.Reply].typ },
+}).Parse(`// Code generated by gen.go; DO NOT EDIT.
+
+package commands
+
+import (
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+{{range .}}{{if .Opts}}
+// {{.Name}}Opts are the options of {{.Cmd}}, see {{.Name}}
+type {{.Name}}Opts struct {
+{{range .Opts}}	{{.Field}} {{optType .}} // {{.Doc}}
+{{end}}}
+
+func (o {{.Name}}Opts) appendArgs(args []interface{}) []interface{} {
+{{range .Opts}}{{optCode .}}
+{{end}}return args
+}
+{{end}}
+{{comment (printf "%s performs %s, which %s" .Name .Cmd .Doc)}}
+func {{.Name}}(c redis.Cmder, {{params .}}) ({{replyType .}}, error) {
+{{body .}}
+{{ret .}}
+}
+{{end}}`))
+
+func main() {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, spec); err != nil {
+		log.Fatal(err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("%s\n%s", err, buf.Bytes())
+	}
+	if err := os.WriteFile("commands_gen.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,250 @@
+// The failover package implements a client for simple primary/standby setups
+// where failover is managed outside of redis, without sentinel or cluster: it's
+// given an ordered list of addresses, and connects to the first of them which
+// is reachable and a writable master.
+//
+//	c, err := failover.Dial("tcp", []string{"10.0.0.1:6379", "10.0.0.2:6379"}, failover.Opts{})
+//	if err != nil {
+//		// handle error
+//	}
+//	defer c.Close()
+//
+//	r := c.Cmd("SET", "foo", "bar")
+//
+// Whenever a command encounters a network error, or a READONLY error because
+// the master it's connected to has been demoted to a replica, the connection
+// is dropped and the next call goes through the list again from the start. As
+// with a PersistentClient, the reply of the command which hit the error is
+// still returned as-is. Like redis.Client, a Client is not safe to use from
+// multiple routines at once.
+package failover
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults for Opts fields which aren't set
+const (
+	DefaultPasses  = 3
+	DefaultBackoff = 500 * time.Millisecond
+)
+
+// ErrNoAddrs is returned by Dial when it's given no addresses
+var ErrNoAddrs = errors.New("failover: no addresses given")
+
+// NotMasterError is the error for an address which was reachable but isn't a
+// master, see ConnectError
+type NotMasterError struct {
+	Role string
+}
+
+func (e *NotMasterError) Error() string {
+	return "server is a " + e.Role + ", not a master"
+}
+
+// ConnectError is returned when none of the addresses could be connected to,
+// and holds the error from the last attempt at each of them
+type ConnectError struct {
+	Addrs []string
+	Errs  []error
+}
+
+func (e *ConnectError) Error() string {
+	msgs := make([]string, len(e.Addrs))
+	for i := range e.Addrs {
+		msgs[i] = fmt.Sprintf("%s: %s", e.Addrs[i], e.Errs[i])
+	}
+	return "failover: no writable master: " + strings.Join(msgs, ", ")
+}
+
+// Opts are the options which can be given to Dial
+type Opts struct {
+	// The options every connection is made with
+	DialOpts redis.DialOpts
+
+	// Number of times the list of addresses is gone through when connecting
+	// before giving up, and the time waited between each pass. While a
+	// failover is in progress there may briefly be no master at all. Default
+	// to DefaultPasses and DefaultBackoff.
+	Passes  int
+	Backoff time.Duration
+
+	// If set, called with the address of every master connected to after the
+	// first, i.e. on every failover (or reconnect to the same address)
+	OnFailover func(addr string)
+}
+
+// Client is a connection to the first writable master out of a list of
+// addresses, see the package docs. It implements redis.Conn and redis.Doer.
+type Client struct {
+	network string
+	addrs   []string
+	opts    Opts
+
+	client *redis.Client
+	addr   string
+	broken bool
+}
+
+// Dial connects to the first of the addresses which is reachable and a
+// writable master, returning a *ConnectError if there's none
+func Dial(network string, addrs []string, opts Opts) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, ErrNoAddrs
+	}
+	if opts.Passes <= 0 {
+		opts.Passes = DefaultPasses
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	c := &Client{
+		network: network,
+		addrs:   append([]string(nil), addrs...),
+		opts:    opts,
+	}
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Addr returns the address of the master currently connected to
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Client returns the redis.Client currently being used. This will change on
+// failover, so don't hold onto it.
+func (c *Client) Client() *redis.Client {
+	return c.client
+}
+
+// Close closes the current connection. The Client should not be used after
+// this.
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+// Cmd calls the given command on the current master, first connecting to a
+// master again if a previous command encountered a network or READONLY error
+func (c *Client) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	if err := c.ensureConn(); err != nil {
+		return &redis.Reply{Type: redis.ErrorReply, Err: err}
+	}
+	return c.checkReply(c.client.Cmd(cmd, args...))
+}
+
+// Append adds the given call to the pipeline queue of the current connection.
+// Use GetReply to read the reply. If the Client fails over any calls which
+// have not yet been sent are lost.
+func (c *Client) Append(cmd string, args ...interface{}) {
+	// If connecting fails the old, closed, client is kept around and the
+	// error will surface when GetReply is called
+	c.ensureConn()
+	c.client.Append(cmd, args...)
+}
+
+// GetReply returns the reply for the next request in the pipeline queue, see
+// redis.Client's GetReply
+func (c *Client) GetReply() *redis.Reply {
+	return c.checkReply(c.client.GetReply())
+}
+
+// Do runs the Action using the Client, see redis.Action
+func (c *Client) Do(a redis.Action) error {
+	return a.Run(c)
+}
+
+// checkReply marks the connection as broken if the reply says it's no longer
+// to a usable master
+func (c *Client) checkReply(r *redis.Reply) *redis.Reply {
+	if redis.IsNetworkErr(r.Err) || isReadOnly(r.Err) {
+		c.broken = true
+	}
+	return r
+}
+
+func isReadOnly(err error) bool {
+	_, ok := err.(*redis.CmdError)
+	return ok && strings.HasPrefix(err.Error(), "READONLY ")
+}
+
+func (c *Client) ensureConn() error {
+	if !c.broken {
+		return nil
+	}
+	c.client.Close()
+	if err := c.connect(); err != nil {
+		return err
+	}
+	if c.opts.OnFailover != nil {
+		c.opts.OnFailover(c.addr)
+	}
+	return nil
+}
+
+// connect goes through the addresses in order, up to Passes times, and
+// connects to the first one which is a master
+func (c *Client) connect() error {
+	errs := make([]error, len(c.addrs))
+	for pass := 0; pass < c.opts.Passes; pass++ {
+		if pass > 0 {
+			time.Sleep(c.opts.Backoff)
+		}
+		for i, addr := range c.addrs {
+			client, err := c.dial(addr)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			c.client, c.addr, c.broken = client, addr, false
+			return nil
+		}
+	}
+	return &ConnectError{Addrs: c.addrs, Errs: errs}
+}
+
+// dial connects to the address and checks that it's a master, closing the
+// connection if it's not
+func (c *Client) dial(addr string) (*redis.Client, error) {
+	client, err := redis.DialWithOpts(c.network, addr, c.opts.DialOpts)
+	if err != nil {
+		return nil, err
+	}
+	role, err := serverRole(client)
+	if err == nil && role != "master" {
+		err = &NotMasterError{Role: role}
+	}
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// serverRole returns the role of the server the connection is to using ROLE,
+// falling back to INFO for servers older than redis 2.8.12 or which don't
+// allow ROLE
+func serverRole(client *redis.Client) (string, error) {
+	r := client.Cmd("ROLE")
+	if r.Err == nil && len(r.Elems) > 0 {
+		role, err := r.Elems[0].Str()
+		if err == nil && role == "slave" {
+			role = "replica"
+		}
+		return role, err
+	} else if redis.IsNetworkErr(r.Err) {
+		return "", r.Err
+	}
+	si, err := client.DetectServer()
+	if err != nil {
+		return "", err
+	}
+	return si.Role, nil
+}
@@ -0,0 +1,73 @@
+package failover
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "testing"
+
+	"github.com/fzzy/radix/extra/redistest"
+)
+
+func TestFailover(t *T) {
+	replica := redistest.Reply([]interface{}{"slave", "127.0.0.1", 6379, "connected", 1})
+	master := redistest.Reply([]interface{}{"master", 0, []interface{}{}})
+
+	s1, err := redistest.NewServer()
+	assert.Nil(t, err)
+	defer s1.Close()
+	s1.Handle("ROLE", replica, master)
+	s2, err := redistest.NewServer()
+	assert.Nil(t, err)
+	defer s2.Close()
+	s2.Handle("ROLE", master)
+	s2.Handle("SET", redistest.Error("READONLY You can't write against a read only replica."))
+
+	// The first address is a replica, so the second is used
+	var failovers []string
+	c, err := Dial("tcp", []string{s1.Addr, s2.Addr}, Opts{
+		OnFailover: func(addr string) { failovers = append(failovers, addr) },
+	})
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Equal(t, s2.Addr, c.Addr())
+
+	// The second is demoted, and by the next command the first has been
+	// promoted
+	assert.NotNil(t, c.Cmd("SET", "foo", "bar").Err)
+	assert.Equal(t, s2.Addr, c.Addr())
+	s, err := c.Cmd("PING").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", s)
+	assert.Equal(t, s1.Addr, c.Addr())
+	assert.Equal(t, []string{s1.Addr}, failovers)
+
+	// A connection lost is reconnected to the first address again
+	s1.DropConns()
+	c.Cmd("PING")
+	assert.Nil(t, c.Cmd("PING").Err)
+	assert.Equal(t, s1.Addr, c.Addr())
+	assert.Equal(t, []string{s1.Addr, s1.Addr}, failovers)
+}
+
+func TestDialNoMaster(t *T) {
+	s, err := redistest.NewServer()
+	assert.Nil(t, err)
+	defer s.Close()
+	s.Handle("ROLE", redistest.Reply([]interface{}{"slave", "127.0.0.1", 6379, "connected", 1}))
+
+	_, err = Dial("tcp", nil, Opts{})
+	assert.Equal(t, ErrNoAddrs, err)
+
+	_, err = Dial("tcp", []string{s.Addr}, Opts{Passes: 2, Backoff: 1})
+	cerr, ok := err.(*ConnectError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{s.Addr}, cerr.Addrs)
+	assert.Equal(t, &NotMasterError{Role: "replica"}, cerr.Errs[0])
+	assert.Equal(t, 2, s.Accepted())
+
+	// Servers without ROLE are asked using INFO
+	s.Handle("ROLE", redistest.Error("ERR unknown command 'ROLE'"))
+	s.Handle("INFO", redistest.Reply("# Server\r\nredis_version:2.8.0\r\n# Replication\r\nrole:master\r\n"))
+	c, err := Dial("tcp", []string{s.Addr}, Opts{})
+	assert.Nil(t, err)
+	c.Close()
+}
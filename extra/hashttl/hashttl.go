@@ -0,0 +1,167 @@
+// The hashttl package provides typed wrappers around the hash field expiration
+// commands added in redis 7.4 (HEXPIRE, HPERSIST, HTTL and friends). Each of
+// these commands replies with one result per field, in the order the fields
+// were given, which these wrappers turn into maps keyed by field so they can't
+// be misaligned.
+package hashttl
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Condition restricts which fields an expiration is set on
+type Condition string
+
+const (
+	Always Condition = ""   // Always set the expiration
+	NX     Condition = "NX" // Only if the field has no expiration
+	XX     Condition = "XX" // Only if the field already has an expiration
+	GT     Condition = "GT" // Only if the new expiration is later
+	LT     Condition = "LT" // Only if the new expiration is earlier
+)
+
+// ExpireStatus is the result of setting the expiration of a single field
+type ExpireStatus int
+
+const (
+	ExpireNoField ExpireStatus = -2 // The field (or the whole hash) doesn't exist
+	ExpireNotSet  ExpireStatus = 0  // The Condition wasn't met
+	ExpireSet     ExpireStatus = 1  // The expiration was set
+	ExpireDeleted ExpireStatus = 2  // The field was deleted, the expiration being in the past
+)
+
+// PersistStatus is the result of removing the expiration of a single field
+type PersistStatus int
+
+const (
+	PersistNoField PersistStatus = -2 // The field (or the whole hash) doesn't exist
+	PersistNoTTL   PersistStatus = -1 // The field had no expiration
+	PersistRemoved PersistStatus = 1  // The expiration was removed
+)
+
+// NoTTL is the TTL returned for fields which exist but have no expiration
+const NoTTL time.Duration = -1
+
+// Expire sets the given fields of the hash at key to expire after ttl (with
+// millisecond precision), using HPEXPIRE
+func Expire(c redis.Cmder, key string, ttl time.Duration, cond Condition,
+	fields ...string) (map[string]ExpireStatus, error) {
+	return expire(c, "HPEXPIRE", key, int64(ttl/time.Millisecond), cond, fields)
+}
+
+// ExpireAt sets the given fields of the hash at key to expire at t (with
+// millisecond precision), using HPEXPIREAT
+func ExpireAt(c redis.Cmder, key string, t time.Time, cond Condition,
+	fields ...string) (map[string]ExpireStatus, error) {
+	ms := t.UnixNano() / int64(time.Millisecond)
+	return expire(c, "HPEXPIREAT", key, ms, cond, fields)
+}
+
+func expire(c redis.Cmder, cmd, key string, ms int64, cond Condition,
+	fields []string) (map[string]ExpireStatus, error) {
+	args := []interface{}{key, ms}
+	if cond != Always {
+		args = append(args, string(cond))
+	}
+	is, err := fieldInts(c, cmd, args, fields)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]ExpireStatus, len(fields))
+	for i, f := range fields {
+		m[f] = ExpireStatus(is[i])
+	}
+	return m, nil
+}
+
+// Persist removes the expiration from the given fields of the hash at key
+func Persist(c redis.Cmder, key string, fields ...string) (map[string]PersistStatus, error) {
+	is, err := fieldInts(c, "HPERSIST", []interface{}{key}, fields)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]PersistStatus, len(fields))
+	for i, f := range fields {
+		m[f] = PersistStatus(is[i])
+	}
+	return m, nil
+}
+
+// TTL returns the remaining time to live (with millisecond precision) of the
+// given fields of the hash at key. Fields which exist but have no expiration
+// are given NoTTL, and fields which don't exist are left out of the map.
+func TTL(c redis.Cmder, key string, fields ...string) (map[string]time.Duration, error) {
+	is, err := fieldInts(c, "HPTTL", []interface{}{key}, fields)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]time.Duration, len(fields))
+	for i, f := range fields {
+		switch {
+		case is[i] == -2:
+		case is[i] == -1:
+			m[f] = NoTTL
+		default:
+			m[f] = time.Duration(is[i]) * time.Millisecond
+		}
+	}
+	return m, nil
+}
+
+// ExpireTime returns the time at which the given fields of the hash at key will
+// expire. Fields which exist but have no expiration are given the zero Time,
+// and fields which don't exist are left out of the map.
+func ExpireTime(c redis.Cmder, key string, fields ...string) (map[string]time.Time, error) {
+	is, err := fieldInts(c, "HPEXPIRETIME", []interface{}{key}, fields)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]time.Time, len(fields))
+	for i, f := range fields {
+		switch {
+		case is[i] == -2:
+		case is[i] == -1:
+			m[f] = time.Time{}
+		default:
+			ms := time.Duration(is[i]) * time.Millisecond
+			m[f] = time.Unix(0, 0).Add(ms)
+		}
+	}
+	return m, nil
+}
+
+// fieldInts performs the given command with the given arguments followed by
+// FIELDS and the fields, and returns its per-field results
+func fieldInts(c redis.Cmder, cmd string, args []interface{},
+	fields []string) ([]int64, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("no fields given")
+	}
+	args = append(args, "FIELDS", len(fields))
+	for _, f := range fields {
+		args = append(args, f)
+	}
+
+	r := c.Cmd(cmd, args...)
+	if r.Err != nil {
+		return nil, r.Err
+	} else if r.Type == redis.NilReply {
+		return nil, redis.ErrNil
+	} else if r.Type != redis.MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	} else if len(r.Elems) != len(fields) {
+		return nil, errors.New("reply has a different number of elements than fields given")
+	}
+
+	is := make([]int64, len(fields))
+	for i := range r.Elems {
+		var err error
+		if is[i], err = r.Elems[i].Int64(); err != nil {
+			return nil, err
+		}
+	}
+	return is, nil
+}
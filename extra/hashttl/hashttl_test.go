@@ -0,0 +1,50 @@
+package hashttl
+
+import (
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func TestFieldExpiration(t *T) {
+	c, err := redis.DialTimeout("tcp", "127.0.0.1:6379", 10*time.Second)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	key := "hashttl-test"
+	c.Cmd("DEL", key)
+	defer c.Cmd("DEL", key)
+	assert.Nil(t, c.Cmd("HMSET", key, "a", 1, "b", 2).Err)
+
+	st, err := Expire(c, key, time.Minute, Always, "a", "nope")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]ExpireStatus{"a": ExpireSet, "nope": ExpireNoField}, st)
+
+	// a already has an expiration
+	st, err = Expire(c, key, time.Hour, NX, "a", "b")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]ExpireStatus{"a": ExpireNotSet, "b": ExpireSet}, st)
+
+	ttls, err := TTL(c, key, "a", "b", "nope")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ttls))
+	assert.True(t, ttls["a"] > 0 && ttls["a"] <= time.Minute)
+	assert.True(t, ttls["b"] > time.Minute && ttls["b"] <= time.Hour)
+
+	ps, err := Persist(c, key, "b", "nope")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]PersistStatus{"b": PersistRemoved, "nope": PersistNoField}, ps)
+
+	ts, err := ExpireTime(c, key, "a", "b")
+	assert.Nil(t, err)
+	assert.True(t, ts["a"].After(time.Now()))
+	assert.True(t, ts["b"].IsZero())
+
+	st, err = ExpireAt(c, key, time.Now().Add(-time.Second), Always, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]ExpireStatus{"a": ExpireDeleted}, st)
+
+	_, err = TTL(c, key)
+	assert.NotNil(t, err)
+}
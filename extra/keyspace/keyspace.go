@@ -0,0 +1,174 @@
+// The keyspace package delivers redis' keyspace notifications as typed events,
+// e.g. for invalidating a local cache whenever a key is modified. Redis only
+// publishes notifications if its notify-keyspace-events config option is set,
+// which Opts.NotifyEvents can take care of.
+//
+// Notifications are published over pub/sub, so they're delivered at most once:
+// any which are published while the connection is down are missed. A Watcher
+// re-dials automatically, and sends an Event with Gap set once it has, so that
+// whatever depends on the notifications can start over.
+package keyspace
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fzzy/radix/extra/pubsub"
+	"github.com/fzzy/radix/redis"
+)
+
+// AllDBs can be used as Opts.DB to watch every database
+const AllDBs = -1
+
+// Opts describe which notifications a Watcher receives
+type Opts struct {
+	// Database to watch, or AllDBs
+	DB int
+
+	// Pattern of keys to watch, using the keyspace channels
+	// (__keyspace@<db>__:<key>). Defaults to "*" if Ops isn't set.
+	KeyPattern string
+
+	// Operations (e.g. "del", "expired") to watch, using the keyevent
+	// channels (__keyevent@<db>__:<op>). These receive notifications for all
+	// keys, and so should not be combined with KeyPattern, or events will be
+	// delivered twice.
+	Ops []string
+
+	// If set, the notify-keyspace-events config option is set to this (e.g.
+	// "KEA" for everything) using CONFIG SET before subscribing
+	NotifyEvents string
+}
+
+// Event is a single keyspace notification
+type Event struct {
+	DB  int
+	Key string
+	Op  string // e.g. "set", "del", "expired"
+
+	// If set, the connection was lost and has been re-established, and this
+	// isn't an actual notification. Any that were published in the meantime
+	// were missed.
+	Gap bool
+
+	// Set if the Watcher encountered an error, in which case this isn't an
+	// actual notification either
+	Err error
+}
+
+// Watcher subscribes to keyspace notifications and delivers them as Events
+type Watcher struct {
+	// All events are sent on this channel, which is closed once the Watcher
+	// has been closed
+	Ch chan *Event
+
+	sub *pubsub.Subscriber
+}
+
+// New connects to the given redis instance and returns a Watcher which is
+// subscribed to the notifications described by opts
+func New(network, addr string, opts Opts) (*Watcher, error) {
+	if opts.NotifyEvents != "" {
+		if err := setNotifyEvents(network, addr, opts.NotifyEvents); err != nil {
+			return nil, err
+		}
+	}
+
+	db := "*"
+	if opts.DB != AllDBs {
+		db = strconv.Itoa(opts.DB)
+	}
+	var patterns []interface{}
+	if opts.KeyPattern != "" || len(opts.Ops) == 0 {
+		pattern := opts.KeyPattern
+		if pattern == "" {
+			pattern = "*"
+		}
+		patterns = append(patterns, "__keyspace@"+db+"__:"+pattern)
+	}
+	for _, op := range opts.Ops {
+		patterns = append(patterns, "__keyevent@"+db+"__:"+op)
+	}
+
+	sub, err := pubsub.NewSubscriber(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{Ch: make(chan *Event), sub: sub}
+	go w.spin()
+	if err := sub.PSubscribe(patterns...); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func setNotifyEvents(network, addr, events string) error {
+	c, err := redis.Dial(network, addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Cmd("CONFIG", "SET", "notify-keyspace-events", events).Err
+}
+
+func (w *Watcher) spin() {
+	defer close(w.Ch)
+	for sr := range w.sub.Ch {
+		var e *Event
+		switch sr.Type {
+		case pubsub.MessageReply:
+			var err error
+			if e, err = ParseEvent(sr.Channel, sr.Message); err != nil {
+				e = &Event{Err: err}
+			}
+		case pubsub.ReconnectReply:
+			e = &Event{Gap: true}
+		case pubsub.ErrorReply:
+			e = &Event{Err: sr.Err}
+		default:
+			continue
+		}
+		w.Ch <- e
+	}
+}
+
+// ParseEvent parses a keyspace notification from the channel it was published
+// on and its message
+func ParseEvent(channel, message string) (*Event, error) {
+	var keyspace bool
+	switch {
+	case strings.HasPrefix(channel, "__keyspace@"):
+		keyspace = true
+		channel = channel[len("__keyspace@"):]
+	case strings.HasPrefix(channel, "__keyevent@"):
+		channel = channel[len("__keyevent@"):]
+	default:
+		return nil, fmt.Errorf("not a keyspace notification channel: %q", channel)
+	}
+
+	i := strings.Index(channel, "__:")
+	if i < 0 {
+		return nil, errors.New("malformed keyspace notification channel")
+	}
+	db, err := strconv.Atoi(channel[:i])
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Event{DB: db}
+	if keyspace {
+		e.Key, e.Op = channel[i+3:], message
+	} else {
+		e.Key, e.Op = message, channel[i+3:]
+	}
+	return e, nil
+}
+
+// Close closes the Watcher's connection. Ch is closed once any event which was
+// being sent has been read.
+func (w *Watcher) Close() {
+	w.sub.Close()
+}
@@ -0,0 +1,49 @@
+package keyspace
+
+import (
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func TestParseEvent(t *T) {
+	e, err := ParseEvent("__keyspace@3__:foo:bar", "set")
+	assert.Nil(t, err)
+	assert.Equal(t, &Event{DB: 3, Key: "foo:bar", Op: "set"}, e)
+
+	e, err = ParseEvent("__keyevent@0__:expired", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, &Event{DB: 0, Key: "foo", Op: "expired"}, e)
+
+	_, err = ParseEvent("foo", "set")
+	assert.NotNil(t, err)
+	_, err = ParseEvent("__keyspace@x__:foo", "set")
+	assert.NotNil(t, err)
+}
+
+func TestWatcher(t *T) {
+	w, err := New("tcp", "127.0.0.1:6379", Opts{
+		KeyPattern:   "keyspace-test:*",
+		NotifyEvents: "KEA",
+	})
+	assert.Nil(t, err)
+	defer w.Close()
+
+	c, err := redis.DialTimeout("tcp", "127.0.0.1:6379", 10*time.Second)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	key := "keyspace-test:a"
+	assert.Nil(t, c.Cmd("SET", key, "1").Err)
+	assert.Nil(t, c.Cmd("DEL", key).Err)
+
+	for _, op := range []string{"set", "del"} {
+		select {
+		case e := <-w.Ch:
+			assert.Equal(t, &Event{DB: 0, Key: key, Op: op}, e)
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event received", op)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// The lock package implements a distributed lock on top of redis. A lock is a
+// key set with SET NX to a random token and a TTL, so that a lock whose holder
+// goes away is eventually released. Only the holder of the token can extend or
+// release the lock, which is done atomically using lua scripts.
+//
+// A Locker can be given multiple independent redis instances (not replicas of
+// one another), in which case the lock is only acquired if it's acquired on a
+// majority of them, following the Redlock algorithm described at
+// http://redis.io/topics/distlock. With a single instance it behaves like a
+// plain SET NX lock.
+//
+// A Lock is only valid until its Until time. Anything done while holding it
+// which takes longer than that should Refresh it along the way.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults used for the Opts fields which aren't set
+const (
+	DefaultTTL              = 10 * time.Second
+	DefaultRetryDelay       = 100 * time.Millisecond
+	DefaultClockDriftFactor = 0.01
+)
+
+var (
+	// ErrNotAcquired is returned by TryLock when the lock is held by someone
+	// else
+	ErrNotAcquired = errors.New("lock not acquired")
+
+	// ErrNotHeld is returned by Refresh and Unlock when the lock is no longer
+	// held, e.g. because it expired and was then acquired by someone else
+	ErrNotHeld = errors.New("lock not held")
+)
+
+// Opts describe the timings of a Locker
+type Opts struct {
+	// How long a lock lasts unless refreshed. Defaults to DefaultTTL.
+	TTL time.Duration
+
+	// How long Lock waits between attempts. A random amount of up to as much
+	// again is added to each wait, so that competing callers don't stay in
+	// lock-step. Defaults to DefaultRetryDelay.
+	RetryDelay time.Duration
+
+	// The fraction of TTL which is assumed to be lost to clock drift between
+	// instances when working out how long a lock is valid for. Defaults to
+	// DefaultClockDriftFactor.
+	ClockDriftFactor float64
+}
+
+// Locker acquires the lock on a single key. It's safe to use from multiple
+// routines at once as long as its instances are, e.g. if they're pools.
+type Locker struct {
+	key       string
+	opts      Opts
+	instances []redis.Cmder
+}
+
+// Lock is a held lock, as returned by a Locker
+type Lock struct {
+	// The time the lock is valid until, unless refreshed
+	Until time.Time
+
+	locker *Locker
+	token  string
+}
+
+var unlockScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+var refreshScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+// NewLocker returns a Locker for the lock on the given key, which is kept on
+// the given instances. At least one instance must be given.
+func NewLocker(key string, opts Opts, instances ...redis.Cmder) *Locker {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultRetryDelay
+	}
+	if opts.ClockDriftFactor <= 0 {
+		opts.ClockDriftFactor = DefaultClockDriftFactor
+	}
+	return &Locker{key: key, opts: opts, instances: instances}
+}
+
+func (l *Locker) quorum() int {
+	return len(l.instances)/2 + 1
+}
+
+// TryLock makes a single attempt at acquiring the lock, returning
+// ErrNotAcquired if it's held by someone else. Only if there were so many
+// errors that a quorum of instances couldn't have been reached, even had the
+// lock been free on all the rest, is the first such error returned instead.
+func (l *Locker) TryLock() (*Lock, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	lk := &Lock{locker: l, token: hex.EncodeToString(b)}
+
+	ttl := int64(l.opts.TTL / time.Millisecond)
+	var firstErr error
+	var errs int
+	ok := l.each(func(c redis.Cmder) bool {
+		r := c.Cmd("SET", l.key, lk.token, "NX", "PX", ttl)
+		if r.Err != nil {
+			if firstErr == nil {
+				firstErr = r.Err
+			}
+			errs++
+		}
+		return r.Err == nil && r.Type != redis.NilReply
+	}, lk)
+	if ok {
+		return lk, nil
+	}
+
+	// Whatever was acquired is released, so that a retry isn't blocked by our
+	// own partial lock
+	l.each(func(c redis.Cmder) bool {
+		unlockScript.Cmd(c, l.key, lk.token)
+		return true
+	}, nil)
+	if len(l.instances)-errs < l.quorum() {
+		return nil, firstErr
+	}
+	return nil, ErrNotAcquired
+}
+
+// each calls fn on every instance, returning whether it succeeded on a
+// quorum of them in time for the lock to still be valid. If so, and lk isn't
+// nil, its Until is set.
+func (l *Locker) each(fn func(redis.Cmder) bool, lk *Lock) bool {
+	start := time.Now()
+	n := 0
+	for _, c := range l.instances {
+		if fn(c) {
+			n++
+		}
+	}
+	// Redlock adds a couple of milliseconds on top of the drift, for the
+	// resolution of redis' expiry
+	drift := time.Duration(float64(l.opts.TTL)*l.opts.ClockDriftFactor) + 2*time.Millisecond
+	until := start.Add(l.opts.TTL - drift)
+	if n < l.quorum() || !time.Now().Before(until) {
+		return false
+	}
+	if lk != nil {
+		lk.Until = until
+	}
+	return true
+}
+
+// Lock attempts to acquire the lock until it succeeds or the context is
+// cancelled, waiting RetryDelay (plus some jitter) between attempts. Errors
+// other than ErrNotAcquired are returned straight away.
+func (l *Locker) Lock(ctx context.Context) (*Lock, error) {
+	for {
+		lk, err := l.TryLock()
+		if err != ErrNotAcquired {
+			return lk, err
+		}
+
+		b := make([]byte, 1)
+		rand.Read(b)
+		delay := l.opts.RetryDelay + l.opts.RetryDelay*time.Duration(b[0])/255
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Refresh extends the lock so that it lasts for another TTL. ErrNotHeld is
+// returned if it couldn't be extended on enough instances, in which case the
+// lock may no longer be held and whatever it protects should be abandoned.
+func (lk *Lock) Refresh() error {
+	l := lk.locker
+	ttl := int64(l.opts.TTL / time.Millisecond)
+	ok := l.each(func(c redis.Cmder) bool {
+		n, err := refreshScript.Cmd(c, l.key, lk.token, ttl).Int()
+		return err == nil && n == 1
+	}, lk)
+	if !ok {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// Unlock releases the lock on every instance it's held on. ErrNotHeld is
+// returned if it wasn't held on any of them anymore.
+func (lk *Lock) Unlock() error {
+	l := lk.locker
+	var firstErr error
+	released := 0
+	for _, c := range l.instances {
+		n, err := unlockScript.Cmd(c, l.key, lk.token).Int()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		released += n
+	}
+	if released == 0 {
+		if firstErr != nil {
+			return firstErr
+		}
+		return ErrNotHeld
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func dialDB(t *T, db int) *redis.Client {
+	c, err := redis.DialTimeout("tcp", "127.0.0.1:6379", 10*time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, c.Cmd("SELECT", db).Err)
+	return c
+}
+
+func TestLock(t *T) {
+	c := dialDB(t, 0)
+	defer c.Close()
+	key := "lock-test"
+	c.Cmd("DEL", key)
+
+	l := NewLocker(key, Opts{TTL: time.Second}, c)
+	lk, err := l.TryLock()
+	assert.Nil(t, err)
+	assert.True(t, lk.Until.After(time.Now()))
+
+	_, err = l.TryLock()
+	assert.Equal(t, ErrNotAcquired, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = l.Lock(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.Nil(t, lk.Refresh())
+	assert.Nil(t, lk.Unlock())
+	assert.Equal(t, ErrNotHeld, lk.Unlock())
+	assert.Equal(t, ErrNotHeld, lk.Refresh())
+
+	lk, err = l.Lock(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, lk.Unlock())
+}
+
+func TestLockQuorum(t *T) {
+	// Separate databases stand in for separate instances
+	var cs []redis.Cmder
+	for db := 1; db <= 3; db++ {
+		c := dialDB(t, db)
+		defer c.Close()
+		c.Cmd("DEL", "lock-test")
+		defer c.Cmd("DEL", "lock-test")
+		cs = append(cs, c)
+	}
+
+	// Held by someone else on one instance isn't enough to stop us
+	assert.Nil(t, cs[0].Cmd("SET", "lock-test", "other").Err)
+	l := NewLocker("lock-test", Opts{}, cs...)
+	lk, err := l.TryLock()
+	assert.Nil(t, err)
+	assert.Nil(t, lk.Unlock())
+
+	// but on two it is, and our partial lock is released
+	assert.Nil(t, cs[1].Cmd("SET", "lock-test", "other").Err)
+	_, err = l.TryLock()
+	assert.Equal(t, ErrNotAcquired, err)
+	n, _ := cs[2].Cmd("EXISTS", "lock-test").Int()
+	assert.Equal(t, 0, n)
+}
+
+type cmderFunc func(cmd string, args ...interface{}) *redis.Reply
+
+func (f cmderFunc) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	return f(cmd, args...)
+}
+
+func TestLockInstanceDown(t *T) {
+	errDown := errors.New("instance down")
+	down := cmderFunc(func(string, ...interface{}) *redis.Reply { return redis.NewReply(errDown) })
+	held := cmderFunc(func(cmd string, _ ...interface{}) *redis.Reply {
+		if cmd == "SET" {
+			return redis.NewReply(nil)
+		}
+		return redis.NewReply(0)
+	})
+	free := cmderFunc(func(string, ...interface{}) *redis.Reply { return redis.NewReply("OK") })
+
+	// With one instance down the lock is only contended, so Lock keeps trying
+	l := NewLocker("lock-test", Opts{RetryDelay: time.Millisecond}, down, held, free)
+	_, err := l.TryLock()
+	assert.Equal(t, ErrNotAcquired, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.Lock(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// With two down a quorum is out of reach whatever the last one says
+	l = NewLocker("lock-test", Opts{}, down, down, free)
+	_, err = l.TryLock()
+	assert.Equal(t, errDown, err)
+}
@@ -0,0 +1,257 @@
+// The migrate package copies or moves keys between two redis instances which
+// aren't part of the same cluster, e.g. when moving to a new server, using
+// DUMP and RESTORE. Unlike the MIGRATE command it doesn't need the source
+// server to be able to reach the destination, since everything goes through
+// the client, and it paces itself so as not to overwhelm either server.
+//
+//	res, err := migrate.Scan(src, dst, "user:*", migrate.Opts{
+//		Replace:       true,
+//		KeysPerSecond: 5000,
+//	})
+//	if err != nil {
+//		// handle error
+//	}
+//	log.Printf("copied %d keys, %d failed", res.Copied, len(res.Failed))
+package migrate
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// DefaultBatchSize is the number of keys migrated in each batch when Opts
+// doesn't give one
+const DefaultBatchSize = 100
+
+// Opts describe how keys are migrated
+type Opts struct {
+	// If set, keys which already exist on the destination are replaced.
+	// Otherwise they're left alone and recorded in Result.Failed with a
+	// BUSYKEY error.
+	Replace bool
+
+	// If set, keys are deleted from the source once they've been restored on
+	// the destination, so that they're moved rather than copied
+	Move bool
+
+	// Number of keys whose DUMPs (and then RESTOREs) are pipelined together.
+	// Defaults to DefaultBatchSize.
+	BatchSize int
+
+	// If set, the migration is slowed down so that no more than this many keys
+	// are migrated per second
+	KeysPerSecond int
+}
+
+// Result describes the outcome of a migration
+type Result struct {
+	// Number of keys restored on the destination
+	Copied int
+
+	// Number of keys which disappeared from the source before they could be
+	// dumped, e.g. because they expired
+	Missing int
+
+	// Keys which couldn't be migrated, along with the reason why. These are
+	// errors returned by the server, such as BUSYKEY or a RESTORE payload
+	// version mismatch between different versions of redis.
+	Failed map[string]error
+}
+
+// Keys copies (or moves, see Opts) the given keys from src to dst, preserving
+// their TTLs. Keys are migrated in pipelined batches, one after the other.
+//
+// Errors which affect individual keys are recorded in the Result, the
+// returned error is for those which stop the migration altogether, e.g. a
+// network error, in which case the Result describes the keys migrated so far.
+func Keys(src, dst redis.Conn, keys []string, opts Opts) (*Result, error) {
+	m := newMigrator(src, dst, opts)
+	for len(keys) > 0 {
+		n := m.batchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		if err := m.batch(keys[:n]); err != nil {
+			return m.res, err
+		}
+		keys = keys[n:]
+	}
+	return m.res, nil
+}
+
+// Scan copies (or moves, see Opts) the keys on src matching the given pattern
+// (as taken by SCAN's MATCH, e.g. "user:*") to dst, as Keys does. Since keys
+// are found using SCAN, keys which are created or deleted while the migration
+// is under way may or may not be migrated.
+func Scan(src, dst redis.Conn, pattern string, opts Opts) (*Result, error) {
+	m := newMigrator(src, dst, opts)
+	s := redis.NewScanner(src, redis.ScanOpts{Pattern: pattern, Count: m.batchSize})
+	keys := make([]string, 0, m.batchSize)
+	for s.Next() {
+		if keys = append(keys, s.Value()); len(keys) < m.batchSize {
+			continue
+		}
+		if err := m.batch(keys); err != nil {
+			return m.res, err
+		}
+		keys = keys[:0]
+	}
+	if err := s.Err(); err != nil {
+		return m.res, err
+	}
+	if len(keys) > 0 {
+		if err := m.batch(keys); err != nil {
+			return m.res, err
+		}
+	}
+	return m.res, nil
+}
+
+type migrator struct {
+	src, dst  redis.Conn
+	opts      Opts
+	batchSize int
+	res       *Result
+
+	// For pacing, the number of keys migrated since start
+	start time.Time
+	done  int
+}
+
+func newMigrator(src, dst redis.Conn, opts Opts) *migrator {
+	m := &migrator{
+		src:       src,
+		dst:       dst,
+		opts:      opts,
+		batchSize: opts.BatchSize,
+		res:       &Result{Failed: map[string]error{}},
+		start:     time.Now(),
+	}
+	if m.batchSize <= 0 {
+		m.batchSize = DefaultBatchSize
+	}
+	return m
+}
+
+// dumped is a key which has been DUMPed, along with its TTL
+type dumped struct {
+	key     string
+	payload []byte
+	ttl     int64 // in milliseconds, 0 for none
+}
+
+func (m *migrator) batch(keys []string) error {
+	m.pace()
+	m.done += len(keys)
+
+	// DUMP each key, along with its remaining TTL
+	for _, key := range keys {
+		m.src.Append("DUMP", key)
+		m.src.Append("PTTL", key)
+	}
+	ds := make([]dumped, 0, len(keys))
+	var netErr error
+	for _, key := range keys {
+		// Every reply is read, even after a network error, so that the
+		// pipeline is left empty
+		dr, tr := m.src.GetReply(), m.src.GetReply()
+		if netErr != nil {
+			continue
+		} else if netErr = firstNetworkErr(dr.Err, tr.Err); netErr != nil {
+			continue
+		}
+
+		if dr.Type == redis.NilReply {
+			m.res.Missing++
+			continue
+		}
+		d := dumped{key: key}
+		var err error
+		if d.payload, err = dr.Bytes(); err == nil {
+			d.ttl, err = tr.Int64()
+		}
+		if err != nil {
+			m.res.Failed[key] = err
+			continue
+		}
+		switch {
+		case d.ttl == -2: // expired in between the DUMP and PTTL
+			m.res.Missing++
+			continue
+		case d.ttl < 0:
+			d.ttl = 0
+		}
+		ds = append(ds, d)
+	}
+	if netErr != nil {
+		return netErr
+	}
+
+	// RESTORE them
+	for _, d := range ds {
+		if m.opts.Replace {
+			m.dst.Append("RESTORE", d.key, d.ttl, d.payload, "REPLACE")
+		} else {
+			m.dst.Append("RESTORE", d.key, d.ttl, d.payload)
+		}
+	}
+	restored := make([]string, 0, len(ds))
+	for _, d := range ds {
+		r := m.dst.GetReply()
+		if netErr != nil {
+			continue
+		} else if netErr = firstNetworkErr(r.Err); netErr != nil {
+			continue
+		} else if r.Err != nil {
+			m.res.Failed[d.key] = r.Err
+			continue
+		}
+		m.res.Copied++
+		restored = append(restored, d.key)
+	}
+	if netErr != nil {
+		return netErr
+	}
+
+	if m.opts.Move && len(restored) > 0 {
+		if r := m.src.Cmd("DEL", restored); r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
+
+// firstNetworkErr returns the first of the given errors which is a network
+// error (see redis.IsNetworkErr), rather than one which only affects a single
+// key
+func firstNetworkErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil && redis.IsNetworkErr(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pace waits as long as is needed for the number of keys migrated so far to
+// be within KeysPerSecond
+func (m *migrator) pace() {
+	if m.opts.KeysPerSecond <= 0 {
+		return
+	}
+	due := m.start.Add(time.Duration(m.done) * time.Second / time.Duration(m.opts.KeysPerSecond))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// IsBusyKey returns whether the given error, e.g. from Result.Failed, is due
+// to the key already existing on the destination without Opts.Replace set
+func IsBusyKey(err error) bool {
+	if _, ok := err.(*redis.CmdError); !ok {
+		return false
+	}
+	return strings.HasPrefix(err.Error(), "BUSYKEY ")
+}
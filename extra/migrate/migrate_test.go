@@ -0,0 +1,101 @@
+package migrate
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+
+	"github.com/fzzy/radix/extra/redistest"
+	"github.com/fzzy/radix/redis"
+)
+
+func servers(t *T) (*redistest.Server, *redistest.Server, *redis.Client, *redis.Client) {
+	src, err := redistest.NewServer()
+	assert.Nil(t, err)
+	dst, err := redistest.NewServer()
+	assert.Nil(t, err)
+	srcc, err := redis.Dial("tcp", src.Addr)
+	assert.Nil(t, err)
+	dstc, err := redis.Dial("tcp", dst.Addr)
+	assert.Nil(t, err)
+	return src, dst, srcc, dstc
+}
+
+func TestMigrate(t *T) {
+	src, dst, srcc, dstc := servers(t)
+	defer src.Close()
+	defer dst.Close()
+	defer srcc.Close()
+	defer dstc.Close()
+
+	src.Handle("DUMP",
+		redistest.Reply("d1"), redistest.Reply(nil), redistest.Reply("d3"), redistest.Reply("d4"),
+	)
+	src.Handle("PTTL", redistest.Reply(-1), redistest.Reply(-2), redistest.Reply(5000), redistest.Reply(-1))
+	dst.Handle("RESTORE",
+		redistest.Status("OK"),
+		redistest.Error("BUSYKEY Target key name already exists."),
+		redistest.Status("OK"),
+	)
+	src.Handle("DEL", redistest.Reply(2))
+
+	res, err := Keys(srcc, dstc, []string{"a", "b", "c", "d"}, Opts{Move: true, BatchSize: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, res.Copied)
+	assert.Equal(t, 1, res.Missing)
+	assert.Equal(t, 1, len(res.Failed))
+	assert.True(t, IsBusyKey(res.Failed["c"]))
+	assert.Nil(t, src.ExpectCmds(
+		"DUMP a", "PTTL a", "DUMP b", "PTTL b", "DUMP c", "PTTL c",
+		"DEL a",
+		"DUMP d", "PTTL d",
+		"DEL d",
+	))
+	assert.Nil(t, dst.ExpectCmds("RESTORE a 0 d1", "RESTORE c 5000 d3", "RESTORE d 0 d4"))
+}
+
+func TestMigrateScan(t *T) {
+	src, dst, srcc, dstc := servers(t)
+	defer src.Close()
+	defer dst.Close()
+	defer srcc.Close()
+	defer dstc.Close()
+
+	src.Handle("SCAN",
+		redistest.Reply([]interface{}{"7", []string{"a", "b"}}),
+		redistest.Reply([]interface{}{"0", []string{"c"}}),
+	)
+	src.Handle("DUMP", redistest.Reply("d"))
+	src.Handle("PTTL", redistest.Reply(-1))
+	dst.Handle("RESTORE", redistest.Status("OK"))
+
+	res, err := Scan(srcc, dstc, "user:*", Opts{Replace: true, BatchSize: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, res.Copied)
+	assert.Nil(t, src.ExpectCmds(
+		"SCAN 0 MATCH user:* COUNT 2",
+		"DUMP a", "PTTL a", "DUMP b", "PTTL b",
+		"SCAN 7 MATCH user:* COUNT 2",
+		"DUMP c", "PTTL c",
+	))
+	assert.Nil(t, dst.ExpectCmds("RESTORE a 0 d REPLACE", "RESTORE b 0 d REPLACE", "RESTORE c 0 d REPLACE"))
+}
+
+func TestMigratePace(t *T) {
+	src, dst, srcc, dstc := servers(t)
+	defer src.Close()
+	defer dst.Close()
+	defer srcc.Close()
+	defer dstc.Close()
+
+	src.Handle("DUMP", redistest.Reply("d"))
+	src.Handle("PTTL", redistest.Reply(-1))
+	dst.Handle("RESTORE", redistest.Status("OK"))
+
+	// 4 keys in batches of 2 at 20 keys/s: the second batch waits 100ms
+	start := time.Now()
+	res, err := Keys(srcc, dstc, []string{"a", "b", "c", "d"}, Opts{BatchSize: 2, KeysPerSecond: 20})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, res.Copied)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
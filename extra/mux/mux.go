@@ -0,0 +1,243 @@
+// The mux package implements a client which shares a single connection between
+// many routines. Commands issued concurrently are queued up and written to the
+// connection together as a pipeline, and their replies are handed back to
+// each caller as they're read. For workloads made up of many small commands
+// this means far fewer syscalls and connections than a pool would use.
+//
+// Commands can be given a Priority, so that latency-critical commands jump
+// ahead of queued up bulk traffic while still sharing the one connection.
+//
+// Since the connection is shared, commands which change its state or block it
+// must not be used through a Mux. These include SELECT, MULTI/EXEC, WATCH,
+// SUBSCRIBE and blocking commands like BLPOP.
+package mux
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults for Opts fields which aren't set
+const (
+	DefaultMaxBatch  = 128
+	DefaultQueueSize = 1024
+	DefaultMinNormal = 16
+)
+
+// ErrClosed is the error of the Reply returned by Mux.Cmd once Close has been
+// called
+var ErrClosed = errors.New("mux is closed")
+
+// Opts are the options which can be given to New
+type Opts struct {
+	// Read/write timeout for the connection
+	Timeout time.Duration
+
+	// Maximum number of commands written in a single pipeline
+	MaxBatch int
+
+	// Maximum number of commands which can be waiting for their turn to be
+	// written, per Priority. Calls to Cmd block while the queue is full.
+	QueueSize int
+
+	// Minimum number of Normal priority commands written in each pipeline
+	// while any are waiting, so that a steady stream of High priority
+	// commands can't starve them. It's capped at half of MaxBatch.
+	MinNormal int
+}
+
+// Priority is the class of a command, see CmdPriority
+type Priority int
+
+const (
+	// Normal priority commands are written in the order they're issued
+	Normal Priority = iota
+
+	// High priority commands are written ahead of any Normal priority ones
+	// which are waiting, as long as each pipeline still has room for MinNormal
+	// of them
+	High
+)
+
+type muxCmd struct {
+	cmd     string
+	args    []interface{}
+	replyCh chan *redis.Reply
+}
+
+// Mux is a client which multiplexes commands from many routines over a single
+// connection. It is safe to use from multiple routines at once. If the
+// connection is lost the commands in flight are given the error, and a new
+// connection is made for the next batch.
+type Mux struct {
+	network, addr string
+	opts          Opts
+	conn          *redis.Client
+	queue         chan *muxCmd
+	hiQueue       chan *muxCmd
+
+	// read-locked while queueing, so that Close can't close the queue out from
+	// under a Cmd call
+	lock   sync.RWMutex
+	closed bool
+	doneCh chan struct{}
+}
+
+// New connects to the given redis instance and returns a Mux for it
+func New(network, addr string, opts Opts) (*Mux, error) {
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = DefaultMaxBatch
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.MinNormal <= 0 {
+		opts.MinNormal = DefaultMinNormal
+	}
+	if opts.MinNormal > opts.MaxBatch/2 {
+		opts.MinNormal = opts.MaxBatch / 2
+	}
+	conn, err := redis.DialTimeout(network, addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mux{
+		network: network,
+		addr:    addr,
+		opts:    opts,
+		conn:    conn,
+		queue:   make(chan *muxCmd, opts.QueueSize),
+		hiQueue: make(chan *muxCmd, opts.QueueSize),
+		doneCh:  make(chan struct{}),
+	}
+	go m.spin()
+	return m, nil
+}
+
+// Cmd performs the given command with Normal priority, batched together with
+// whichever other commands are issued around the same time
+func (m *Mux) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	return m.CmdPriority(Normal, cmd, args...)
+}
+
+// CmdPriority is like Cmd, but performs the command with the given Priority.
+// Commands of different priorities may be written in a different order than
+// they were issued in, even by the same routine.
+func (m *Mux) CmdPriority(p Priority, cmd string, args ...interface{}) *redis.Reply {
+	c := &muxCmd{cmd, args, make(chan *redis.Reply, 1)}
+	m.lock.RLock()
+	if m.closed {
+		m.lock.RUnlock()
+		return &redis.Reply{Type: redis.ErrorReply, Err: ErrClosed}
+	}
+	if p == High {
+		m.hiQueue <- c
+	} else {
+		m.queue <- c
+	}
+	m.lock.RUnlock()
+	return <-c.replyCh
+}
+
+func (m *Mux) spin() {
+	defer close(m.doneCh)
+	queue, hiQueue := m.queue, m.hiQueue
+	batch := make([]*muxCmd, 0, m.opts.MaxBatch)
+	for {
+		batch = m.nextBatch(batch[:0], &queue, &hiQueue)
+		if len(batch) == 0 {
+			break
+		}
+		m.do(batch)
+	}
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
+
+// nextBatch blocks until at least one command is queued, and then takes
+// whatever else has queued up while the last batch was in flight, High
+// priority commands first. Each queue is set to nil once it's been closed and
+// drained, and an empty batch is returned once both have been.
+func (m *Mux) nextBatch(batch []*muxCmd, queue, hiQueue *chan *muxCmd) []*muxCmd {
+	batch = fill(batch, hiQueue, m.opts.MaxBatch-m.opts.MinNormal)
+	for len(batch) == 0 && (*queue != nil || *hiQueue != nil) {
+		select {
+		case c, ok := <-*hiQueue:
+			if !ok {
+				*hiQueue = nil
+			} else {
+				batch = append(batch, c)
+			}
+		case c, ok := <-*queue:
+			if !ok {
+				*queue = nil
+			} else {
+				batch = append(batch, c)
+			}
+		}
+	}
+	batch = fill(batch, hiQueue, m.opts.MaxBatch-m.opts.MinNormal)
+	batch = fill(batch, queue, m.opts.MaxBatch)
+	return fill(batch, hiQueue, m.opts.MaxBatch)
+}
+
+// fill takes commands off of the queue, without blocking, until the batch has
+// n of them
+func fill(batch []*muxCmd, queue *chan *muxCmd, n int) []*muxCmd {
+	for *queue != nil && len(batch) < n {
+		select {
+		case c, ok := <-*queue:
+			if !ok {
+				*queue = nil
+			} else {
+				batch = append(batch, c)
+			}
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (m *Mux) do(batch []*muxCmd) {
+	if m.conn == nil {
+		conn, err := redis.DialTimeout(m.network, m.addr, m.opts.Timeout)
+		if err != nil {
+			for _, c := range batch {
+				c.replyCh <- &redis.Reply{Type: redis.ErrorReply, Err: err}
+			}
+			return
+		}
+		m.conn = conn
+	}
+
+	p := m.conn.Pipeline()
+	for _, c := range batch {
+		p.Queue(c.cmd, c.args...)
+	}
+	if err := p.Flush(); err != nil {
+		// The connection has been closed by the Pipeline
+		m.conn = nil
+	}
+	for i, c := range batch {
+		c.replyCh <- p.Reply(i)
+	}
+}
+
+// Close stops accepting new commands, waits for all commands already queued to
+// be performed, and closes the connection. It may be called more than once.
+func (m *Mux) Close() {
+	m.lock.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+		close(m.hiQueue)
+	}
+	m.lock.Unlock()
+	<-m.doneCh
+}
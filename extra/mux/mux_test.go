@@ -0,0 +1,75 @@
+package mux
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	. "testing"
+)
+
+func TestMux(t *T) {
+	m, err := New("tcp", "localhost:6379", Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := strconv.Itoa(i)
+			if v, err := m.Cmd("ECHO", s).Str(); err != nil {
+				t.Error(err)
+			} else if v != s {
+				t.Errorf("got reply %q for command %q", v, s)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// The connection is replaced if it's lost
+	m.conn.Conn.Close()
+	if r := m.Cmd("ECHO", "foo"); r.Err == nil {
+		t.Fatal("expected error on broken connection")
+	}
+	if v, err := m.Cmd("ECHO", "foo").Str(); err != nil {
+		t.Fatal(err)
+	} else if v != "foo" {
+		t.Fatalf("unexpected ECHO reply: %q", v)
+	}
+
+	m.Close()
+	if r := m.Cmd("ECHO", "foo"); r.Err != ErrClosed {
+		t.Fatalf("unexpected error after Close: %v", r.Err)
+	}
+}
+
+func TestNextBatch(t *T) {
+	m := &Mux{opts: Opts{MaxBatch: 4, MinNormal: 1}}
+	queue, hiQueue := make(chan *muxCmd, 10), make(chan *muxCmd, 10)
+	for i := 0; i < 3; i++ {
+		queue <- &muxCmd{cmd: "n" + strconv.Itoa(i)}
+		hiQueue <- &muxCmd{cmd: "h" + strconv.Itoa(i)}
+	}
+	close(queue)
+	close(hiQueue)
+
+	var cmds []string
+	for {
+		batch := m.nextBatch(nil, &queue, &hiQueue)
+		if len(batch) == 0 {
+			break
+		}
+		for _, c := range batch {
+			cmds = append(cmds, c.cmd)
+		}
+		cmds = append(cmds, "|")
+	}
+
+	// High priority commands come first, but there's always room for at least
+	// one Normal one
+	if got := strings.Join(cmds, " "); got != "h0 h1 h2 n0 | n1 n2 |" {
+		t.Fatalf("unexpected batches: %s", got)
+	}
+}
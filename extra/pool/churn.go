@@ -0,0 +1,160 @@
+package pool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults used by ChurnDetector for the fields which aren't set
+const (
+	DefaultChurnFactor = 2.0
+	DefaultChurnWindow = time.Minute
+)
+
+// Number of buckets the window is split into, and so the granularity with
+// which events drop out of it
+const churnBuckets = 12
+
+// Churn is the number of connections created and closed within a
+// ChurnDetector's window
+type Churn struct {
+	Creates, Closes int
+
+	// The window and threshold the counts were taken against
+	Window    time.Duration
+	Threshold int
+}
+
+// ChurnDetector flags abnormal connection churn, i.e. connections being
+// created and closed at a rate which is high relative to the size of the pool.
+// A healthy pool reuses its connections, so churn is usually the first sign of
+// a pool which is too small for its load (excess connections are closed as
+// they're Put back, only to be dialed again) or of a flaky network. It's fed
+// by the ConnCreated and ConnClosed callbacks of the Trace returned by its
+// Trace method:
+//
+//	d := &pool.ChurnDetector{Size: 10, OnChurn: func(c pool.Churn) {
+//		log.Printf("connection churn: %d created, %d closed in %s", c.Creates, c.Closes, c.Window)
+//	}}
+//	p, err := pool.NewPoolTrace("tcp", "localhost:6379", 10, d.Trace(nil))
+//
+// Its methods are safe to use from multiple routines at once.
+type ChurnDetector struct {
+	// The size of the pool being watched
+	Size int
+
+	// Churn is abnormal once the number of connections created plus closed
+	// within Window is more than Factor times Size. Default to
+	// DefaultChurnFactor and DefaultChurnWindow.
+	Factor float64
+	Window time.Duration
+
+	// Called when churn goes above the threshold. It's not called again until
+	// churn has dropped back below it. It's called from whichever routine
+	// created or closed the connection, so it shouldn't block.
+	OnChurn func(Churn)
+
+	lock    sync.Mutex
+	buckets [churnBuckets]churnBucket
+	flagged bool
+
+	// Overridden by tests
+	now func() time.Time
+}
+
+type churnBucket struct {
+	start           time.Time
+	creates, closes int
+}
+
+// Trace returns a Trace which feeds the ChurnDetector, to be set on the pool
+// being watched (see NewPoolTrace). Callbacks of next, if given, are called as
+// well, so that the ChurnDetector can be added alongside an existing Trace.
+func (d *ChurnDetector) Trace(next *redis.Trace) *redis.Trace {
+	if next == nil {
+		next = &redis.Trace{}
+	}
+	t := *next
+	t.ConnCreated = func(tc redis.TraceConn) {
+		if tc.Err == nil {
+			d.add(1, 0)
+		}
+		if next.ConnCreated != nil {
+			next.ConnCreated(tc)
+		}
+	}
+	t.ConnClosed = func(tc redis.TraceConn) {
+		d.add(0, 1)
+		if next.ConnClosed != nil {
+			next.ConnClosed(tc)
+		}
+	}
+	return &t
+}
+
+func (d *ChurnDetector) window() time.Duration {
+	if d.Window <= 0 {
+		return DefaultChurnWindow
+	}
+	return d.Window
+}
+
+func (d *ChurnDetector) threshold() int {
+	f := d.Factor
+	if f <= 0 {
+		f = DefaultChurnFactor
+	}
+	return int(f * float64(d.Size))
+}
+
+func (d *ChurnDetector) time() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
+
+// churn returns the current Churn. It must be called with lock held.
+func (d *ChurnDetector) churn(now time.Time) Churn {
+	c := Churn{Window: d.window(), Threshold: d.threshold()}
+	for _, b := range d.buckets {
+		if now.Sub(b.start) < c.Window {
+			c.Creates += b.creates
+			c.Closes += b.closes
+		}
+	}
+	return c
+}
+
+func (d *ChurnDetector) add(creates, closes int) {
+	d.lock.Lock()
+	now := d.time()
+	width := d.window() / churnBuckets
+	start := now.Truncate(width)
+	b := &d.buckets[(start.UnixNano()/int64(width))%churnBuckets]
+	if !b.start.Equal(start) {
+		*b = churnBucket{start: start}
+	}
+	b.creates += creates
+	b.closes += closes
+
+	c := d.churn(now)
+	over := c.Creates+c.Closes > c.Threshold
+	fire := over && !d.flagged
+	d.flagged = over
+	d.lock.Unlock()
+
+	if fire && d.OnChurn != nil {
+		d.OnChurn(c)
+	}
+}
+
+// Churn returns the number of connections created and closed within the
+// window up to now
+func (d *ChurnDetector) Churn() Churn {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.churn(d.time())
+}
@@ -0,0 +1,48 @@
+package pool
+
+import (
+	"github.com/fzzy/radix/redis"
+)
+
+// DB is a handle on a Pool whose connections all have a particular database
+// selected. It shares connections with the Pool and all other DBs derived from
+// it, each connection is SELECTed into the right database as it's retrieved.
+type DB struct {
+	pool *Pool
+	db   int
+}
+
+// ForDB returns a DB through which all commands are performed against the
+// given database
+func (p *Pool) ForDB(db int) *DB {
+	return &DB{pool: p, db: db}
+}
+
+// Get retrieves a connection from the Pool, as Pool.Get does, but with the
+// DB's database selected. Commands which change the selected database should
+// not be performed on it.
+func (d *DB) Get() (*redis.Client, error) {
+	return d.pool.getDB(d.db)
+}
+
+// Put returns a connection retrieved with Get back to the Pool, see Pool.Put
+func (d *DB) Put(conn *redis.Client) {
+	d.pool.Put(conn)
+}
+
+// CarefullyPut returns a connection retrieved with Get back to the Pool, see
+// Pool.CarefullyPut
+func (d *DB) CarefullyPut(conn *redis.Client, potentialErr *error) {
+	d.pool.CarefullyPut(conn, potentialErr)
+}
+
+// Cmd is like Pool.Cmd, but performs the command against the DB's database
+func (d *DB) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	return d.pool.cmd(d.pool.RetryPolicy, d.db, cmd, args)
+}
+
+// CmdNoRetry is like Pool.CmdNoRetry, but performs the command against the
+// DB's database
+func (d *DB) CmdNoRetry(cmd string, args ...interface{}) *redis.Reply {
+	return d.pool.cmd(nil, d.db, cmd, args)
+}
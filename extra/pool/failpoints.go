@@ -0,0 +1,68 @@
+//go:build radix_failpoints
+// +build radix_failpoints
+
+package pool
+
+import (
+	"sync"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Failpoints are hooks into the Pool's internals, for testing its concurrency
+// logic (or code extending it) deterministically: they can block to force a
+// particular interleaving, fail to simulate an unreachable server, or count
+// calls to check for leaked connections. They only exist when building with
+// the radix_failpoints tag, e.g. `go test -tags radix_failpoints`, otherwise
+// the calls to them compile away to nothing. Any of them may be nil.
+type Failpoints struct {
+	// Called before the Pool dials a new connection. If it returns an error
+	// the dial isn't made, and the error is returned as the dial's.
+	BeforeDial func(p *Pool) error
+
+	// Called before the Pool closes a connection rather than keeping it,
+	// because the Pool is full or closed, or while it's being emptied
+	BeforeDiscard func(p *Pool, conn *redis.Client)
+
+	// Called each time a WriteBehind's flush interval elapses, before it
+	// flushes
+	WriteBehindTick func(wb *WriteBehind)
+}
+
+var (
+	failpointsLock sync.RWMutex
+	failpoints     Failpoints
+)
+
+// SetFailpoints replaces the Failpoints used by every Pool. Pass the zero
+// Failpoints to remove them.
+func SetFailpoints(fp Failpoints) {
+	failpointsLock.Lock()
+	defer failpointsLock.Unlock()
+	failpoints = fp
+}
+
+func getFailpoints() Failpoints {
+	failpointsLock.RLock()
+	defer failpointsLock.RUnlock()
+	return failpoints
+}
+
+func failBeforeDial(p *Pool) error {
+	if fn := getFailpoints().BeforeDial; fn != nil {
+		return fn(p)
+	}
+	return nil
+}
+
+func failBeforeDiscard(p *Pool, conn *redis.Client) {
+	if fn := getFailpoints().BeforeDiscard; fn != nil {
+		fn(p, conn)
+	}
+}
+
+func failWriteBehindTick(wb *WriteBehind) {
+	if fn := getFailpoints().WriteBehindTick; fn != nil {
+		fn(wb)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !radix_failpoints
+// +build !radix_failpoints
+
+package pool
+
+import "github.com/fzzy/radix/redis"
+
+// Without the radix_failpoints tag there are no Failpoints, see failpoints.go
+
+func failBeforeDial(p *Pool) error { return nil }
+
+func failBeforeDiscard(p *Pool, conn *redis.Client) {}
+
+func failWriteBehindTick(wb *WriteBehind) {}
@@ -0,0 +1,123 @@
+//go:build radix_failpoints
+// +build radix_failpoints
+
+package pool
+
+import (
+	"errors"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/fzzy/radix/extra/redistest"
+	"github.com/fzzy/radix/redis"
+)
+
+func TestFailpoints(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle("INCR", redistest.Reply(1))
+
+	var discards, ticks int32
+	errDial := errors.New("dial failpoint")
+	SetFailpoints(Failpoints{
+		BeforeDial: func(p *Pool) error {
+			if p.Stats().Dials > 0 {
+				return errDial
+			}
+			return nil
+		},
+		BeforeDiscard:   func(*Pool, *redis.Client) { atomic.AddInt32(&discards, 1) },
+		WriteBehindTick: func(*WriteBehind) { atomic.AddInt32(&ticks, 1) },
+	})
+	defer SetFailpoints(Failpoints{})
+
+	pool, err := NewPool("tcp", s.Addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// The pool's one connection is taken, so the next Get has to dial
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Get(); err != errDial {
+		t.Fatalf("expected dial failpoint error, got %v", err)
+	}
+
+	pool.Put(conn)
+	pool.Put(conn)
+	if n := atomic.LoadInt32(&discards); n != 1 {
+		t.Fatalf("expected 1 discard after Put to full pool, got %d", n)
+	}
+	pool.Empty()
+	if n := atomic.LoadInt32(&discards); n != 2 {
+		t.Fatalf("expected 2 discards after Empty, got %d", n)
+	}
+
+	wb := pool.NewWriteBehind(WriteBehindOpts{FlushInterval: time.Millisecond})
+	time.Sleep(50 * time.Millisecond)
+	wb.Close()
+	if atomic.LoadInt32(&ticks) == 0 {
+		t.Fatal("write behind never ticked")
+	}
+}
+
+func TestMaxConcurrentDials(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var inFlight, peak int32
+	SetFailpoints(Failpoints{
+		BeforeDial: func(*Pool) error {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				m := atomic.LoadInt32(&peak)
+				if n <= m || atomic.CompareAndSwapInt32(&peak, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+			return nil
+		},
+	})
+	defer SetFailpoints(Failpoints{})
+
+	pool, err := NewPool("tcp", s.Addr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pool.MaxConcurrentDials = 2
+
+	conns := make(chan *redis.Client, 10)
+	for i := 0; i < cap(conns); i++ {
+		go func() {
+			conn, err := pool.Get()
+			if err != nil {
+				t.Error(err)
+			}
+			conns <- conn
+		}()
+	}
+	for i := 0; i < cap(conns); i++ {
+		if conn := <-conns; conn != nil {
+			conn.Close()
+		}
+	}
+	if n := atomic.LoadInt32(&peak); n != 2 {
+		t.Fatalf("expected at most 2 dials at once, got %d", n)
+	}
+	if st := pool.Stats(); st.Dials != 10 || st.DialWaits == 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
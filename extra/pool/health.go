@@ -0,0 +1,26 @@
+package pool
+
+import (
+	"context"
+
+	"github.com/fzzy/radix/extra/admin"
+	"github.com/fzzy/radix/redis"
+)
+
+// Diagnose checks the health of the redis instance the pool connects to using
+// one of its connections, see admin.DiagnoseNode. The report always has a
+// single node.
+func (p *Pool) Diagnose(ctx context.Context) *admin.HealthReport {
+	conn, err := p.Get()
+	if err != nil {
+		return &admin.HealthReport{Nodes: []admin.NodeHealth{{Addr: p.Addr, Err: err}}}
+	}
+	h := admin.DiagnoseNode(ctx, conn, p.Addr)
+	if redis.IsNetworkErr(h.Err) || ctx.Err() != nil {
+		// The connection may have been closed if ctx was cancelled
+		conn.Close()
+	} else {
+		p.Put(conn)
+	}
+	return &admin.HealthReport{Nodes: []admin.NodeHealth{h}}
+}
@@ -0,0 +1,211 @@
+package pool
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// DefaultManagerSize is the size of the Pools a Manager creates when its
+// Defaults don't give one
+const DefaultManagerSize = 10
+
+// ManagerDefaults are the settings a Manager creates its Pools with. Those
+// which are fields of Pool can still be changed on individual Pools afterwards.
+type ManagerDefaults struct {
+	// Network to connect over, defaults to "tcp"
+	Network string
+
+	// Size of each Pool, defaults to DefaultManagerSize
+	Size int
+
+	RetryPolicy *redis.RetryPolicy
+	Trace       *redis.Trace
+	Logger      redis.CmdLogger
+	ArgCache    *redis.ArgCache
+
+	// See Pool's MaxConcurrentDials
+	MaxConcurrentDials int
+}
+
+// Manager owns a set of Pools, each for a different redis instance and known
+// by a logical name such as "cache", "queue" or "sessions", so that an
+// application has one place to create, find, monitor and close them all:
+//
+//	m := pool.NewManager(pool.ManagerDefaults{Size: 20})
+//	if _, err := m.Add("cache", "10.0.0.1:6379"); err != nil {
+//		// handle error
+//	}
+//	if _, err := m.Add("sessions", "10.0.0.2:6379"); err != nil {
+//		// handle error
+//	}
+//	defer m.Close()
+//
+//	p, err := m.Get("cache")
+//
+// Its methods are safe to use from multiple routines at once.
+type Manager struct {
+	defaults ManagerDefaults
+
+	lock   sync.RWMutex
+	pools  map[string]*Pool
+	closed bool
+}
+
+// NewManager returns a Manager with no Pools, which creates them with the given
+// defaults
+func NewManager(defaults ManagerDefaults) *Manager {
+	if defaults.Network == "" {
+		defaults.Network = "tcp"
+	}
+	if defaults.Size <= 0 {
+		defaults.Size = DefaultManagerSize
+	}
+	return &Manager{
+		defaults: defaults,
+		pools:    map[string]*Pool{},
+	}
+}
+
+// Add creates a Pool connecting to the given address using the Manager's
+// defaults, and adds it under the given name. It's an error for there to
+// already be a Pool with the name.
+func (m *Manager) Add(name, addr string) (*Pool, error) {
+	if err := m.check(name); err != nil {
+		return nil, err
+	}
+	d := m.defaults
+	p, err := NewPoolTrace(d.Network, addr, d.Size, d.Trace)
+	if err != nil {
+		return nil, err
+	}
+	p.RetryPolicy = d.RetryPolicy
+	p.Logger = d.Logger
+	p.ArgCache = d.ArgCache
+	p.MaxConcurrentDials = d.MaxConcurrentDials
+	if err := m.AddPool(name, p); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// AddPool adds an existing Pool under the given name, after which the Manager
+// owns it and closes it along with the rest. It's an error for there to
+// already be a Pool with the name.
+func (m *Manager) AddPool(name string, p *Pool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.checkLocked(name); err != nil {
+		return err
+	}
+	m.pools[name] = p
+	return nil
+}
+
+func (m *Manager) check(name string) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.checkLocked(name)
+}
+
+// checkLocked returns an error if a Pool can't be added under the given name.
+// It must be called with lock held.
+func (m *Manager) checkLocked(name string) error {
+	if m.closed {
+		return ErrPoolClosed
+	} else if _, ok := m.pools[name]; ok {
+		return fmt.Errorf("pool %q already exists", name)
+	}
+	return nil
+}
+
+// Get returns the Pool with the given name. If the Manager has been closed
+// ErrPoolClosed is returned.
+func (m *Manager) Get(name string) (*Pool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.closed {
+		return nil, ErrPoolClosed
+	}
+	p, ok := m.pools[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown pool %q", name)
+	}
+	return p, nil
+}
+
+// Names returns the names of all the Manager's Pools, sorted
+func (m *Manager) Names() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	names := make([]string, 0, len(m.pools))
+	for name := range m.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Remove removes the Pool with the given name from the Manager and closes it.
+// It's a no-op if there's no such Pool.
+func (m *Manager) Remove(name string) {
+	m.lock.Lock()
+	p := m.pools[name]
+	delete(m.pools, name)
+	m.lock.Unlock()
+	if p != nil {
+		p.Close()
+	}
+}
+
+// Stats returns a snapshot of the counters of each of the Manager's Pools,
+// keyed by name, along with their totals
+func (m *Manager) Stats() (map[string]PoolStats, PoolStats) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	stats := make(map[string]PoolStats, len(m.pools))
+	var total PoolStats
+	for name, p := range m.pools {
+		s := p.Stats()
+		stats[name] = s
+		total.Gets += s.Gets
+		total.Dials += s.Dials
+		total.Puts += s.Puts
+		total.Closes += s.Closes
+		total.Discards += s.Discards
+		total.Resets += s.Resets
+		total.DialWaits += s.DialWaits
+		total.Cmds += s.Cmds
+		total.Errs += s.Errs
+	}
+	return stats, total
+}
+
+// Metrics returns a snapshot of the Metrics of each of the Manager's Pools,
+// keyed by name
+func (m *Manager) Metrics() map[string]redis.Metrics {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	metrics := make(map[string]redis.Metrics, len(m.pools))
+	for name, p := range m.pools {
+		metrics[name] = p.Metrics()
+	}
+	return metrics
+}
+
+// Close closes all of the Manager's Pools. Afterwards Get returns ErrPoolClosed,
+// as do Add and AddPool (in which case the Pool given to AddPool is left
+// alone). Calling Close more than once is a no-op.
+func (m *Manager) Close() {
+	m.lock.Lock()
+	pools := m.pools
+	m.pools = map[string]*Pool{}
+	m.closed = true
+	m.lock.Unlock()
+	for _, p := range pools {
+		p.Close()
+	}
+}
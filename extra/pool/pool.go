@@ -3,38 +3,160 @@
 package pool
 
 import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/fzzy/radix/redis"
 )
 
+// ErrPoolClosed is returned when retrieving a connection from a Pool which has
+// been closed
+var ErrPoolClosed = errors.New("pool is closed")
+
 // A simple connection pool. It will create a small pool of initial connections,
 // and if more connections are needed they will be created on demand. If a
 // connection is returned and the pool is full it will be closed.
+//
+// A Pool implements redis.Cmder and redis.Doer, so code which only performs
+// commands through it can take those interfaces and be given a mock in tests.
+// Its connections are always *redis.Client rather than redis.Conn, see
+// redis.Conn for why.
 type Pool struct {
 	Network string
 	Addr    string
 	Pool    chan *redis.Client
+
+	// If set, commands sent using Cmd which fail due to a network error will be
+	// retried on a different connection according to this policy
+	RetryPolicy *redis.RetryPolicy
+
+	// If set, every connection the pool makes is made with redis.DialTrace
+	// using this Trace. See NewPoolTrace for tracing the connections made by
+	// the constructor too.
+	Trace *redis.Trace
+
+	// The options every connection the pool makes is made with, other than
+	// their Trace, which is the Pool's. If ClientName is set each connection
+	// is named after it followed by a sequence number, e.g. "myapp-pool-3",
+	// so that they can be told apart in CLIENT LIST. DB is the database Get,
+	// Cmd and With use, and that new connections start out with. See
+	// NewPoolWithOpts for applying them to the connections made by the
+	// constructor too.
+	DialOpts redis.DialOpts
+
+	// Every connection retrieved from the pool has its Logger set to this, so
+	// it can be set at any time
+	Logger redis.CmdLogger
+
+	// Every connection retrieved from the pool has its ArgCache set to this,
+	// so that they all share it. It can be set at any time.
+	ArgCache *redis.ArgCache
+
+	// Every connection retrieved from the pool has its Latency set to this, so
+	// that the latencies of every command performed through the pool are
+	// recorded in it. It can be set at any time.
+	Latency *redis.LatencyTracker
+
+	// If set, at most this many connections are dialed by Get at once. When
+	// the pool is empty and that many dials are already in flight, further
+	// Gets wait for either a connection to be put back or a dial to finish
+	// before dialing themselves, so that a burst of traffic against an empty
+	// pool doesn't overwhelm redis (or run out of local ports) with hundreds of
+	// simultaneous connections. It must be set before the Pool is used.
+	MaxConcurrentDials int
+
+	dialSemOnce sync.Once
+	dialSem     chan struct{}
+
+	commandsLock sync.Mutex
+	commands     map[string]*redis.CommandInfo
+
+	stats    poolStats
+	counters redis.Counters
+	dialSeq  uint64
+
+	// closeLock is held for reading while connections are put back, so that
+	// none can be put back after Close has emptied the pool
+	closeLock sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
 }
 
+var (
+	_ redis.Cmder = (*Pool)(nil)
+	_ redis.Doer  = (*Pool)(nil)
+)
+
 // Creates a new Pool whose connections are all created using
 // redis.Dial(network, addr). The size indicates the maximum number of idle
 // connections to have waiting to be used at any given moment
 func NewPool(network, addr string, size int) (*Pool, error) {
-	var err error
-	pool := make([]*redis.Client, size)
-	for i := range pool {
-		if pool[i], err = redis.Dial(network, addr); err != nil {
+	return NewPoolTrace(network, addr, size, nil)
+}
+
+// NewPoolTrace is like NewPool, but sets the Pool's Trace before any
+// connections are made
+func NewPoolTrace(network, addr string, size int, trace *redis.Trace) (*Pool, error) {
+	return NewPoolWithOpts(network, addr, size, redis.DialOpts{Trace: trace})
+}
+
+// NewPoolWithOpts is like NewPool, but sets the Pool's DialOpts (and Trace,
+// from the options' Trace) before any connections are made
+func NewPoolWithOpts(network, addr string, size int, opts redis.DialOpts) (*Pool, error) {
+	p := &Pool{
+		Network:  network,
+		Addr:     addr,
+		Pool:     make(chan *redis.Client, size),
+		Trace:    opts.Trace,
+		DialOpts: opts,
+	}
+	for i := 0; i < size; i++ {
+		conn, err := p.dial()
+		if err != nil {
 			return nil, err
 		}
+		p.Pool <- conn
 	}
-	p := Pool{
-		Network: network,
-		Addr:    addr,
-		Pool:    make(chan *redis.Client, len(pool)),
+	return p, nil
+}
+
+// dial makes a new connection which shares the Pool's counters
+func (p *Pool) dial() (*redis.Client, error) {
+	if err := failBeforeDial(p); err != nil {
+		p.counters.AddDial(err)
+		return nil, err
 	}
-	for i := range pool {
-		p.Pool <- pool[i]
+	conn, err := redis.DialWithOpts(p.Network, p.Addr, p.dialOpts())
+	p.counters.AddDial(err)
+	if err != nil {
+		return nil, err
 	}
-	return &p, nil
+	conn.Counters = &p.counters
+	conn.Logger = p.Logger
+	conn.ArgCache = p.ArgCache
+	conn.Latency = p.Latency
+	return conn, nil
+}
+
+// dialOpts returns the options for the next connection the Pool makes
+func (p *Pool) dialOpts() redis.DialOpts {
+	opts := p.DialOpts
+	opts.Trace = p.Trace
+	if opts.ClientName != "" {
+		n := atomic.AddUint64(&p.dialSeq, 1)
+		opts.ClientName += "-" + strconv.FormatUint(n, 10)
+	}
+	return opts
+}
+
+// Metrics returns a snapshot of the counters of every connection the Pool has
+// made. Unlike Stats, it includes the connections' traffic, errors by class
+// and latency.
+func (p *Pool) Metrics() redis.Metrics {
+	return p.counters.Metrics()
 }
 
 // Calls NewPool, but if there is an error it return a pool of the same size but
@@ -54,33 +176,134 @@ func NewOrEmptyPool(network, addr string, size int) *Pool {
 }
 
 // Retrieves an available redis client. If there are none available it will
-// create a new one on the fly
+// create a new one on the fly. The client will have the database given by
+// DialOpts (normally 0) selected, see ForDB for using other databases. If the
+// pool has been closed ErrPoolClosed is returned.
 func (p *Pool) Get() (*redis.Client, error) {
+	return p.getDB(p.DialOpts.DB)
+}
+
+// getDB retrieves a client as Get does, and SELECTs the given database on it
+// if it doesn't have it selected already
+func (p *Pool) getDB(db int) (*redis.Client, error) {
+	conn, err := p.get()
+	if err != nil || conn.DB() == db {
+		return conn, err
+	}
+	if err = conn.Cmd("SELECT", db).Err; err != nil {
+		if !redis.IsNetworkErr(err) {
+			p.Put(conn)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// GetDedicated returns a new connection, made with the Pool's settings, which
+// isn't shared with anyone: it's never retrieved from the pool nor should it
+// be put back into it, and should instead be closed once done with. Use this
+// for subscriptions and long blocking commands (e.g. BLPOP), which would hold
+// up a pooled connection for everyone else.
+func (p *Pool) GetDedicated() (*redis.Client, error) {
+	if p.isClosed() {
+		return nil, ErrPoolClosed
+	}
+	return p.dial()
+}
+
+func (p *Pool) get() (*redis.Client, error) {
+	p.stats.gets.incr()
+	if p.isClosed() {
+		return nil, ErrPoolClosed
+	}
 	select {
 	case conn := <-p.Pool:
-		return conn, nil
+		return p.pooled(conn), nil
 	default:
-		conn, err := redis.Dial(p.Network, p.Addr)
-		p.CarefullyPut(conn, &err)
-		return conn, err
 	}
+
+	if sem := p.dialLimit(); sem != nil {
+		select {
+		case sem <- struct{}{}:
+		default:
+			// Wait for either a connection or a free dial slot, whichever
+			// comes first
+			p.stats.dialWaits.incr()
+			select {
+			case conn := <-p.Pool:
+				return p.pooled(conn), nil
+			case sem <- struct{}{}:
+			}
+		}
+		defer func() { <-sem }()
+	}
+	p.stats.dials.incr()
+	return p.dial()
+}
+
+// pooled prepares a connection taken from the pool to be handed out, setting
+// the fields which may have changed since it was put back
+func (p *Pool) pooled(conn *redis.Client) *redis.Client {
+	conn.Logger = p.Logger
+	conn.ArgCache = p.ArgCache
+	conn.Latency = p.Latency
+	return conn
+}
+
+// dialLimit returns the semaphore limiting the number of dials Get makes at
+// once, or nil if MaxConcurrentDials isn't set
+func (p *Pool) dialLimit() chan struct{} {
+	p.dialSemOnce.Do(func() {
+		if p.MaxConcurrentDials > 0 {
+			p.dialSem = make(chan struct{}, p.MaxConcurrentDials)
+		}
+	})
+	return p.dialSem
 }
 
 // Returns a client back to the pool. If the pool is full the client is closed
 // instead. If the client is already closed (due to connection failure or
 // what-have-you) it should not be put back in the pool. The pool will create
-// more connections as needed.
+// more connections as needed. If the pool has been closed the client is closed
+// as well.
+//
+// State left over on the client which would trip up whoever retrieved it next
+// is reset first (see redis.Client.ResetState): unread pipelined replies are
+// dropped, an open MULTI is DISCARDed, WATCHed keys are UNWATCHed and the
+// database from DialOpts is SELECTed again. A client which is subscribed to
+// anything, or whose state couldn't be reset, is closed instead. See
+// GetDedicated for retrieving connections for subscriptions.
 func (p *Pool) Put(conn *redis.Client) {
-	select {
-	case p.Pool <- conn:
-	default:
+	p.stats.puts.incr()
+	if conn.NeedsReset(p.DialOpts.DB) {
+		if err := conn.ResetState(p.DialOpts.DB); err != nil {
+			p.stats.discards.incr()
+			failBeforeDiscard(p, conn)
+			conn.Close()
+			return
+		}
+		p.stats.resets.incr()
+	}
+	p.closeLock.RLock()
+	put := false
+	if !p.closed {
+		select {
+		case p.Pool <- conn:
+			put = true
+		default:
+		}
+	}
+	p.closeLock.RUnlock()
+	if !put {
+		p.stats.closes.incr()
+		failBeforeDiscard(p, conn)
 		conn.Close()
 	}
 }
 
 // A useful helper method which acts as a wrapper around Put. It will only
-// actually Put the conn back if potentialErr is not an error or is a
-// redis.CmdError. It would be used like the following:
+// actually Put the conn back if potentialErr is not an error or is not a
+// network error (see redis.IsNetworkErr). It would be used like the following:
 //
 //	func doSomeThings(p *Pool) error {
 //		conn, redisErr := p.Get()
@@ -103,27 +326,127 @@ func (p *Pool) Put(conn *redis.Client) {
 // we don't want to Put back a connection which is broken. This method takes
 // care of doing that check so we can still use the convenient defer
 func (p *Pool) CarefullyPut(conn *redis.Client, potentialErr *error) {
-	if potentialErr != nil && *potentialErr != nil {
-		// We don't care about command errors, they don't indicate anything
-		// about the connection integrity
-		if _, ok := (*potentialErr).(*redis.CmdError); !ok {
-			return
-		}
+	// We don't care about command errors and the like, they don't indicate
+	// anything about the connection integrity
+	if potentialErr != nil && redis.IsNetworkErr(*potentialErr) {
+		return
 	}
 	p.Put(conn)
 }
 
-// Removes and calls Close() on all the connections currently in the pool.
-// Assuming there are no other connections waiting to be Put back this method
-// effectively closes and cleans up the pool.
+// Cmd retrieves a connection from the pool, performs the given command on it,
+// and returns the connection to the pool (unless it encountered a network
+// error). If RetryPolicy is set the command may be performed multiple times.
+func (p *Pool) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	return p.cmd(p.RetryPolicy, p.DialOpts.DB, cmd, args)
+}
+
+// Do retrieves a connection from the pool, as Get does, runs the Action on it,
+// and returns the connection to the pool (unless the Action returned a network
+// error). All of the Action's commands are performed on that one connection.
+// Unlike with Cmd, RetryPolicy doesn't apply.
+func (p *Pool) Do(a redis.Action) error {
+	conn, err := p.Get()
+	if err != nil {
+		return err
+	}
+	defer p.CarefullyPut(conn, &err)
+	err = a.Run(conn)
+	return err
+}
+
+// CmdNoRetry is like Cmd, but the command will never be retried regardless of
+// RetryPolicy. Use this for commands which are not idempotent.
+func (p *Pool) CmdNoRetry(cmd string, args ...interface{}) *redis.Reply {
+	return p.cmd(nil, p.DialOpts.DB, cmd, args)
+}
+
+func (p *Pool) cmd(rp *redis.RetryPolicy, db int, cmd string, args []interface{}) *redis.Reply {
+	return p.do(rp, db, func(conn *redis.Client) *redis.Reply {
+		return conn.Cmd(cmd, args...)
+	})
+}
+
+// do calls fn with a connection with the given database selected, putting the
+// connection back afterwards, and does so again on a new connection if the
+// reply's error should be retried according to rp
+func (p *Pool) do(rp *redis.RetryPolicy, db int, fn func(*redis.Client) *redis.Reply) *redis.Reply {
+	for attempt := 1; ; attempt++ {
+		var r *redis.Reply
+		conn, err := p.getDB(db)
+		if err != nil {
+			r = &redis.Reply{Type: redis.ErrorReply, Err: err}
+		} else {
+			r = fn(conn)
+			p.CarefullyPut(conn, &r.Err)
+		}
+		p.stats.cmds.incr()
+		if r.Err != nil {
+			p.stats.errs.incr()
+		}
+		if r.Err == ErrPoolClosed || !rp.ShouldRetry(attempt, r.Err) {
+			return r
+		}
+		time.Sleep(rp.Backoff(attempt))
+	}
+}
+
+// Removes and calls Close() on all the connections currently in the pool. The
+// pool can still be used afterwards, and will create new connections as
+// needed. See Close for closing the pool for good. It is safe to call Empty at
+// the same time as any other method, including Empty and Close.
 func (p *Pool) Empty() {
 	var conn *redis.Client
 	for {
 		select {
 		case conn = <-p.Pool:
+			failBeforeDiscard(p, conn)
 			conn.Close()
 		default:
 			return
 		}
 	}
 }
+
+// Close empties the pool and marks it as closed, after which Get returns
+// ErrPoolClosed and connections which are Put back are closed rather than
+// pooled. Calling Close more than once, even at the same time, is a no-op.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() {
+		p.closeLock.Lock()
+		p.closed = true
+		p.closeLock.Unlock()
+		p.Empty()
+	})
+}
+
+func (p *Pool) isClosed() bool {
+	p.closeLock.RLock()
+	defer p.closeLock.RUnlock()
+	return p.closed
+}
+
+// ServerCommands returns information about all commands supported by the redis
+// server the pool connects to, keyed by lowercased command name. The first
+// call performs a COMMAND call on one of the pool's connections, subsequent
+// calls return the same cached map, which should not be modified.
+func (p *Pool) ServerCommands() (map[string]*redis.CommandInfo, error) {
+	p.commandsLock.Lock()
+	defer p.commandsLock.Unlock()
+	if p.commands != nil {
+		return p.commands, nil
+	}
+
+	conn, err := p.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.CarefullyPut(conn, &err)
+
+	var m map[string]*redis.CommandInfo
+	if m, err = conn.ServerCommands(); err != nil {
+		return nil, err
+	}
+	p.commands = m
+	return m, nil
+}
@@ -1,8 +1,12 @@
 package pool
 
 import (
+	"context"
+	"github.com/fzzy/radix/extra/redistest"
 	"github.com/fzzy/radix/redis"
+	"sync"
 	. "testing"
+	"time"
 )
 
 func TestPool(t *T) {
@@ -24,3 +28,702 @@ func TestPool(t *T) {
 
 	pool.Empty()
 }
+
+func TestCmd(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	pool.RetryPolicy = &redis.RetryPolicy{MaxAttempts: 3}
+
+	// Kill the pooled connection, the retry should pick up a fresh one
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Conn.Close()
+	pool.Put(conn)
+
+	if s, err := pool.Cmd("ECHO", "foo").Str(); err != nil {
+		t.Fatal(err)
+	} else if s != "foo" {
+		t.Fatalf("unexpected ECHO reply: %q", s)
+	}
+
+	if r := pool.CmdNoRetry("ECHO", "foo"); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+}
+
+func TestForDB(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+
+	db := pool.ForDB(1)
+	if err := db.Cmd("SET", "fordb", "foo").Err; err != nil {
+		t.Fatal(err)
+	}
+	defer db.Cmd("DEL", "fordb")
+
+	// The single connection is shared, and is switched back to database 0 for
+	// use through the Pool itself
+	if r := pool.Cmd("EXISTS", "fordb"); r.Err != nil {
+		t.Fatal(r.Err)
+	} else if n, _ := r.Int(); n != 0 {
+		t.Fatal("key set through ForDB(1) exists in database 0")
+	}
+
+	conn, err := db.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Put(conn)
+	if conn.DB() != 1 {
+		t.Fatalf("unexpected database selected: %d", conn.DB())
+	} else if s, _ := conn.Cmd("GET", "fordb").Str(); s != "foo" {
+		t.Fatalf("unexpected GET reply: %q", s)
+	}
+}
+
+func TestPopWorker(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+
+	list := "popWorkerTestList"
+	pool.Cmd("DEL", list)
+	w, err := pool.NewPopWorker(PopWorkerOpts{Workers: 2, Lists: []string{list}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := pool.Cmd("LPUSH", list, "a", "b", "c"); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		select {
+		case res := <-w.Ch:
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			} else if res.List != list {
+				t.Fatalf("unexpected list %q", res.List)
+			}
+			seen[res.Value] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("took too long to pop")
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("didn't see all values: %v", seen)
+	}
+
+	w.Close()
+	for res := range w.Ch {
+		t.Fatalf("unexpected result after close: %+v", res)
+	}
+}
+
+func TestPopWorkerErrorBackoff(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle("BRPOP", redistest.Error("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	pool, err := NewPool("tcp", s.Addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	w, err := pool.NewPopWorker(PopWorkerOpts{Lists: []string{"l"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-w.Ch
+	if _, ok := res.Err.(*redis.CmdError); !ok {
+		t.Fatalf("expected CmdError, got %+v", res)
+	}
+
+	// The worker should be waiting out Timeout before trying again, rather
+	// than hammering the server
+	time.Sleep(200 * time.Millisecond)
+	if n := len(s.Cmds()); n != 1 {
+		t.Fatalf("expected 1 BRPOP, got %d", n)
+	}
+
+	// Close should take effect without waiting for the backoff, or for
+	// anything to be read from Ch
+	w.Close()
+	select {
+	case <-w.Ch:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Ch wasn't closed")
+	}
+}
+
+func TestPopWorkerCloseUnread(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle("BRPOP", redistest.Reply([]string{"l", "a"}), redistest.Reply(nil).After(time.Second))
+	s.Handle("RPUSH", redistest.Reply(1))
+	pool, err := NewPool("tcp", s.Addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	w, err := pool.NewPopWorker(PopWorkerOpts{Lists: []string{"l"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Nothing is reading Ch, so the popped element must be put back rather
+	// than the worker blocking forever
+	w.Close()
+	select {
+	case res, ok := <-w.Ch:
+		if ok {
+			t.Fatalf("unexpected result after close: %+v", res)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Ch wasn't closed")
+	}
+	if err := s.ExpectCmds("BRPOP l 1", "RPUSH l a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPopWorkerTimeout(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle("BRPOP", redistest.Reply([]string{"l", "a"}).After(300*time.Millisecond))
+	pool, err := NewPoolWithOpts("tcp", s.Addr, 1, redis.DialOpts{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// The pool's timeout is shorter than BRPOP's, so the worker's reads must
+	// wait longer than it
+	w, err := pool.NewPopWorker(PopWorkerOpts{Lists: []string{"l"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if res := <-w.Ch; res.Err != nil || res.Value != "a" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+func TestWriteBehind(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+
+	key := "writeBehindTestKey"
+	pool.Cmd("DEL", key)
+	wb := pool.NewWriteBehind(WriteBehindOpts{QueueSize: 10, DropPolicy: Block})
+	for i := 0; i < 100; i++ {
+		if !wb.Cmd("INCR", key) {
+			t.Fatal("command dropped")
+		}
+	}
+
+	// Close should flush everything that's still queued
+	wb.Close()
+	if wb.Cmd("INCR", key) {
+		t.Fatal("command queued after close")
+	}
+	if i, err := pool.Cmd("GET", key).Int(); err != nil {
+		t.Fatal(err)
+	} else if i != 100 {
+		t.Fatalf("expected 100, got %d", i)
+	}
+}
+
+func TestStats(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+
+	pool.Cmd("ECHO", "foo")
+	pool.Cmd("NOTACOMMAND")
+
+	// The second connection has nowhere to go once the first is put back
+	c1, _ := pool.Get()
+	c2, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(c1)
+	pool.Put(c2)
+
+	s := pool.Stats()
+	if s != (PoolStats{Gets: 4, Dials: 1, Puts: 4, Closes: 1, Cmds: 2, Errs: 1}) {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestWith(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+	defer pool.Cmd("DEL", "poolWith:foo")
+
+	h := pool.With(redis.WithKeyPrefix("poolWith:"), redis.WithTimeout(time.Second))
+	if err := h.Cmd("SET", "foo", "bar").Err; err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := pool.Cmd("GET", "poolWith:foo").Str(); s != "bar" {
+		t.Fatalf("unexpected GET reply: %q", s)
+	}
+}
+
+func TestWithTimeoutReset(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	pool, err := NewPool("tcp", s.Addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if r := pool.With(redis.WithTimeout(50 * time.Millisecond)).Cmd("PING"); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	// The connection has no timeout of its own, so the handle's deadlines
+	// mustn't outlive its call
+	time.Sleep(100 * time.Millisecond)
+	if r := pool.Cmd("PING"); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+}
+
+func TestClose(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			pool.Close()
+			pool.Empty()
+			done <- true
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	if _, err := pool.Get(); err != ErrPoolClosed {
+		t.Fatalf("unexpected Get error: %v", err)
+	}
+	if err := pool.Cmd("PING").Err; err != ErrPoolClosed {
+		t.Fatalf("unexpected Cmd error: %v", err)
+	}
+
+	// Putting back after Close closes the connection instead of pooling it
+	pool.Put(conn)
+	if len(pool.Pool) != 0 {
+		t.Fatalf("connection was pooled after Close")
+	}
+	if err := conn.Cmd("PING").Err; err == nil {
+		t.Fatalf("connection wasn't closed")
+	}
+}
+
+func TestDiagnose(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+
+	r := pool.Diagnose(context.Background())
+	if !r.Healthy() || len(r.Nodes) != 1 {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+	if r.Nodes[0].Role != "master" {
+		t.Fatalf("unexpected role: %q", r.Nodes[0].Role)
+	}
+}
+
+func TestTrace(t *T) {
+	var lock sync.Mutex
+	var created, cmds int
+	trace := &redis.Trace{
+		ConnCreated: func(tc redis.TraceConn) {
+			lock.Lock()
+			defer lock.Unlock()
+			created++
+		},
+		CmdCompleted: func(tc redis.TraceCmd) {
+			lock.Lock()
+			defer lock.Unlock()
+			cmds++
+		},
+	}
+	pool, err := NewPoolTrace("tcp", "localhost:6379", 2, trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+
+	if err := pool.Cmd("PING").Err; err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if created != 2 || cmds < 1 {
+		t.Fatalf("unexpected trace counts: %d created, %d commands", created, cmds)
+	}
+}
+
+func TestMetrics(t *T) {
+	pool, err := NewPool("tcp", "localhost:6379", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Empty()
+
+	if err := pool.Cmd("PING").Err; err != nil {
+		t.Fatal(err)
+	}
+	pool.Cmd("NOTACOMMAND")
+	m := pool.Metrics()
+	if m.Dials != 2 || m.Cmds != 2 || m.ServerErrs != 1 || m.BytesIn == 0 || m.BytesOut == 0 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestChurnDetector(t *T) {
+	now := time.Now()
+	var fired []Churn
+	d := &ChurnDetector{
+		Size:    2,
+		OnChurn: func(c Churn) { fired = append(fired, c) },
+		now:     func() time.Time { return now },
+	}
+	var created, closed int
+	trace := d.Trace(&redis.Trace{
+		ConnCreated: func(redis.TraceConn) { created++ },
+		ConnClosed:  func(redis.TraceConn) { closed++ },
+	})
+
+	// Up to the threshold of 4 doesn't fire, and failed dials don't count
+	for i := 0; i < 2; i++ {
+		trace.ConnCreated(redis.TraceConn{})
+		trace.ConnClosed(redis.TraceConn{})
+	}
+	trace.ConnCreated(redis.TraceConn{Err: context.DeadlineExceeded})
+	if len(fired) != 0 || created != 3 || closed != 2 {
+		t.Fatalf("unexpected state: %v fired, %d created, %d closed", fired, created, closed)
+	}
+
+	// Going over it fires once
+	trace.ConnCreated(redis.TraceConn{})
+	trace.ConnClosed(redis.TraceConn{})
+	if len(fired) != 1 || fired[0] != (Churn{Creates: 3, Closes: 2, Window: time.Minute, Threshold: 4}) {
+		t.Fatalf("unexpected churn: %v", fired)
+	}
+
+	// Once the events drop out of the window it can fire again
+	now = now.Add(2 * time.Minute)
+	if c := d.Churn(); c.Creates != 0 || c.Closes != 0 {
+		t.Fatalf("unexpected churn: %+v", c)
+	}
+	trace.ConnCreated(redis.TraceConn{})
+	if len(fired) != 1 {
+		t.Fatalf("unexpected churn: %v", fired)
+	}
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 4; i++ {
+		trace.ConnClosed(redis.TraceConn{})
+	}
+	if len(fired) != 2 || fired[1].Creates != 1 || fired[1].Closes != 4 {
+		t.Fatalf("unexpected churn: %v", fired)
+	}
+}
+
+func TestManager(t *T) {
+	var servers []*redistest.Server
+	for i := 0; i < 2; i++ {
+		s, err := redistest.NewServer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		servers = append(servers, s)
+	}
+
+	m := NewManager(ManagerDefaults{Size: 2})
+	cache, err := m.Add("cache", servers[0].Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("sessions", servers[1].Addr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Add("cache", servers[1].Addr); err == nil {
+		t.Fatal("expected error adding duplicate pool")
+	}
+	if names := m.Names(); len(names) != 2 || names[0] != "cache" || names[1] != "sessions" {
+		t.Fatalf("unexpected names: %v", names)
+	}
+	if servers[0].Accepted() != 2 || servers[1].Accepted() != 2 {
+		t.Fatalf("unexpected connections: %d, %d", servers[0].Accepted(), servers[1].Accepted())
+	}
+
+	p, err := m.Get("cache")
+	if err != nil || p != cache {
+		t.Fatalf("unexpected Get: %v, %v", p, err)
+	}
+	if _, err := m.Get("queue"); err == nil {
+		t.Fatal("expected error getting unknown pool")
+	}
+
+	for _, name := range []string{"cache", "sessions", "sessions"} {
+		p, _ := m.Get(name)
+		if err := p.Cmd("PING").Err; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Waiting on MaxConcurrentDials takes a burst of concurrent Gets to set
+	// off, which is covered in TestMaxConcurrentDials
+	cache.stats.dialWaits.incr()
+	stats, total := m.Stats()
+	if stats["cache"].Cmds != 1 || stats["sessions"].Cmds != 2 || total.Cmds != 3 || total.DialWaits != 1 {
+		t.Fatalf("unexpected stats: %+v, %+v", stats, total)
+	}
+	if metrics := m.Metrics(); metrics["sessions"].Dials != 2 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+
+	m.Remove("sessions")
+	if _, err := m.Get("sessions"); err == nil {
+		t.Fatal("expected error getting removed pool")
+	}
+
+	m.Close()
+	if _, err := m.Get("cache"); err != ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cache.Cmd("PING").Err; err != ErrPoolClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestClientName(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle("CLIENT", redistest.Status("OK"))
+
+	pool, err := NewPoolWithOpts("tcp", s.Addr, 2, redis.DialOpts{ClientName: "myapp-pool"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := s.ExpectCmds("CLIENT SETNAME myapp-pool-1", "CLIENT SETNAME myapp-pool-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connections made on demand are named too
+	s.Reset()
+	conns := make([]*redis.Client, 3)
+	for i := range conns {
+		if conns[i], err = pool.Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.ExpectCmds("CLIENT SETNAME myapp-pool-3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failure to set the name fails the dial
+	s.Handle("CLIENT", redistest.Error("ERR nope"))
+	pool.Empty()
+	if _, err := pool.Get(); err == nil || err.Error() != "ERR nope" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestDialOptsDB(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle("SELECT", redistest.Status("OK"))
+
+	pool, err := NewPoolWithOpts("tcp", s.Addr, 1, redis.DialOpts{DB: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// The database is selected as the connection is made, and not again
+	pool.Cmd("PING")
+	pool.With().Cmd("PING")
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.DB() != 2 {
+		t.Fatalf("unexpected database selected: %d", conn.DB())
+	}
+	pool.Put(conn)
+	pool.ForDB(0).Cmd("PING")
+	pool.Cmd("PING")
+	if err := s.ExpectCmds("SELECT 2", "PING", "PING", "SELECT 0", "PING", "SELECT 2", "PING"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutReset(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, cmd := range []string{"MULTI", "DISCARD", "WATCH", "UNWATCH", "SELECT"} {
+		s.Handle(cmd, redistest.Status("OK"))
+	}
+	s.Handle("SUBSCRIBE", redistest.Reply([]interface{}{"subscribe", "foo", 1}))
+
+	pool, err := NewPool("tcp", s.Addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// Connections left in a transaction, with keys watched, with replies
+	// unread or with another database selected are reset and kept
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Cmd("WATCH", "foo")
+	conn.Cmd("MULTI")
+	conn.Append("GET", "foo")
+	pool.Put(conn)
+	conn, err = pool.ForDB(3).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Cmd("WATCH", "foo")
+	pool.Put(conn)
+	if len(pool.Pool) != 1 {
+		t.Fatal("reset connection not put back in the pool")
+	}
+	if err := s.ExpectCmds(
+		"WATCH foo", "MULTI", "DISCARD",
+		"SELECT 3", "WATCH foo", "UNWATCH", "SELECT 0",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribed connections can't be reset, and are closed
+	conn, err = pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Cmd("SUBSCRIBE", "foo").Err; err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(conn)
+	if len(pool.Pool) != 0 {
+		t.Fatal("connection left in pool after SUBSCRIBE")
+	}
+	if st := pool.Stats(); st.Resets != 2 || st.Discards != 1 || st.Closes != 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	// Dedicated connections are new, and never come from the pool
+	conn, err = pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(conn)
+	dedicated, err := pool.GetDedicated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dedicated.Close()
+	if dedicated == conn || len(pool.Pool) != 1 {
+		t.Fatal("dedicated connection came from the pool")
+	}
+}
+
+func TestDo(t *T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Handle("MULTI", redistest.Status("OK"))
+	s.Handle("INCR", redistest.Status("QUEUED"))
+	s.Handle("EXEC", redistest.Reply([]interface{}{1}))
+
+	pool, err := NewPool("tcp", s.Addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// All of an Action's commands are performed on one connection, so it can
+	// use MULTI/EXEC
+	var r *redis.Reply
+	err = pool.Do(redis.ActionFunc(func(c redis.Cmder) error {
+		if err := c.Cmd("MULTI").Err; err != nil {
+			return err
+		}
+		if err := c.Cmd("INCR", "foo").Err; err != nil {
+			return err
+		}
+		return redis.Cmd(&r, "EXEC").Run(c)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(r.Elems) != 1 || r.Elems[0].String() != "1" {
+		t.Fatalf("unexpected EXEC reply: %v", r)
+	} else if s.Accepted() != 1 || len(pool.Pool) != 1 {
+		t.Fatal("connection not reused")
+	}
+	if err := s.ExpectCmds("MULTI", "INCR foo", "EXEC"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,230 @@
+package pool
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// PopResult is a single element which was popped off one of the lists a
+// PopWorker is watching, or an error which one of its workers encountered
+type PopResult struct {
+	List  string // The list the element came off of
+	Value string
+	Err   error
+}
+
+// PopWorkerOpts describes the lists a PopWorker watches and how
+type PopWorkerOpts struct {
+	// Number of dedicated connections to make, each of which will be issuing
+	// blocking pops. Defaults to 1.
+	Workers int
+
+	// Lists to pop elements off of. They are checked in the given order, as
+	// with BRPOP.
+	Lists []string
+
+	// If set, BLMOVE is used instead of BRPOP to atomically move each element
+	// onto the head of this list as it's popped, for reliable queue patterns.
+	// Only a single list in Lists is allowed in this case.
+	Dest string
+
+	// How long each blocking call waits before starting over, rounded to the
+	// nearest second. This is also the most Close will take to take effect.
+	// Defaults to 1 second.
+	Timeout time.Duration
+}
+
+// PopWorker dedicates a set of connections, dialed separately from the Pool's
+// own and never returned to it, to performing blocking pops. Blocking commands
+// should never be issued on pooled connections, since they hold up the
+// connection for everyone else.
+type PopWorker struct {
+	// All popped elements are sent on this channel. It will be closed once
+	// Close has been called and all workers have exited.
+	Ch chan *PopResult
+
+	network, addr string
+	dialOpts      func() redis.DialOpts
+	opts          PopWorkerOpts
+	closeCh       chan struct{}
+	closeOnce     sync.Once
+	wg            sync.WaitGroup
+}
+
+// NewPopWorker dials the connections for and starts a PopWorker on the redis
+// instance the Pool connects to
+func (p *Pool) NewPopWorker(opts PopWorkerOpts) (*PopWorker, error) {
+	if len(opts.Lists) == 0 {
+		return nil, errors.New("no lists given")
+	} else if opts.Dest != "" && len(opts.Lists) != 1 {
+		return nil, errors.New("exactly one list is required when Dest is set")
+	}
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.Timeout < time.Second {
+		opts.Timeout = time.Second
+	}
+
+	w := &PopWorker{
+		Ch:       make(chan *PopResult),
+		network:  p.Network,
+		addr:     p.Addr,
+		dialOpts: p.dialOpts,
+		opts:     opts,
+		closeCh:  make(chan struct{}),
+	}
+	conns := make([]*redis.Client, opts.Workers)
+	for i := range conns {
+		var err error
+		if conns[i], err = w.dial(); err != nil {
+			for j := 0; j < i; j++ {
+				conns[j].Close()
+			}
+			return nil, err
+		}
+	}
+
+	w.wg.Add(len(conns))
+	for i := range conns {
+		go w.spin(conns[i])
+	}
+	go func() {
+		w.wg.Wait()
+		close(w.Ch)
+	}()
+	return w, nil
+}
+
+// popTimeoutMargin is how much longer than a blocking call's own timeout the
+// worker waits for its reply
+const popTimeoutMargin = time.Second
+
+// dial connects using the Pool's DialOpts, except that the timeout is raised
+// if need be so that reads outlast the blocking calls. Otherwise the read
+// would time out while the server is still waiting to pop, and an element it
+// popped just after would be lost along with the connection.
+func (w *PopWorker) dial() (*redis.Client, error) {
+	opts := w.dialOpts()
+	if opts.Timeout != 0 && opts.Timeout < w.opts.Timeout+popTimeoutMargin {
+		opts.Timeout = w.opts.Timeout + popTimeoutMargin
+	}
+	return redis.DialWithOpts(w.network, w.addr, opts)
+}
+
+func (w *PopWorker) closed() bool {
+	select {
+	case <-w.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *PopWorker) spin(conn *redis.Client) {
+	defer w.wg.Done()
+	timeout := int((w.opts.Timeout + time.Second/2) / time.Second)
+	for !w.closed() {
+		if conn == nil {
+			var err error
+			if conn, err = w.dial(); err != nil {
+				w.send(nil, &PopResult{Err: err})
+				w.sleep(w.opts.Timeout)
+				continue
+			}
+		}
+
+		var res *PopResult
+		if w.opts.Dest != "" {
+			res = w.move(conn, timeout)
+		} else {
+			res = w.pop(conn, timeout)
+		}
+		if res == nil {
+			continue
+		}
+
+		w.send(conn, res)
+		if redis.IsNetworkErr(res.Err) {
+			conn.Close()
+			conn = nil
+		} else if res.Err != nil {
+			// Errors sent by the server (e.g. WRONGTYPE) are likely to be
+			// sent again straight away, so back off rather than spin
+			w.sleep(w.opts.Timeout)
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// send delivers the result on Ch, unless Close is called while waiting for it
+// to be received. In that case an element popped by BRPOP is pushed back onto
+// the list it came off of, so that it isn't lost (one moved by BLMOVE is still
+// on Dest), and errors are dropped.
+func (w *PopWorker) send(conn *redis.Client, res *PopResult) {
+	select {
+	case w.Ch <- res:
+		return
+	case <-w.closeCh:
+	}
+	if res.Err == nil && w.opts.Dest == "" && conn != nil {
+		conn.Cmd("RPUSH", res.List, res.Value)
+	}
+}
+
+// sleep waits for the given duration, or until Close is called
+func (w *PopWorker) sleep(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-w.closeCh:
+	}
+}
+
+func (w *PopWorker) pop(conn *redis.Client, timeout int) *PopResult {
+	args := make([]interface{}, 0, len(w.opts.Lists)+1)
+	for _, l := range w.opts.Lists {
+		args = append(args, l)
+	}
+	args = append(args, timeout)
+
+	r := conn.Cmd("BRPOP", args...)
+	if r.Type == redis.NilReply {
+		return nil
+	}
+	l, err := r.List()
+	if err != nil {
+		return &PopResult{Err: err}
+	} else if len(l) != 2 {
+		return &PopResult{Err: errors.New("malformed BRPOP reply")}
+	}
+	return &PopResult{List: l[0], Value: l[1]}
+}
+
+func (w *PopWorker) move(conn *redis.Client, timeout int) *PopResult {
+	src := w.opts.Lists[0]
+	r := conn.Cmd("BLMOVE", src, w.opts.Dest, "RIGHT", "LEFT", timeout)
+	if r.Type == redis.NilReply {
+		return nil
+	}
+	v, err := r.Str()
+	if err != nil {
+		return &PopResult{Err: err}
+	}
+	return &PopResult{List: src, Value: v}
+}
+
+// Close signals all workers to stop. Any blocking calls in progress are allowed
+// to finish, and their results are still delivered on Ch if it's being read
+// from, so the caller should keep reading from Ch until it is closed. Elements
+// which were popped but not received are pushed back onto their list. Close
+// may be called more than once.
+func (w *PopWorker) Close() {
+	w.closeOnce.Do(func() { close(w.closeCh) })
+}
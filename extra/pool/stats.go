@@ -0,0 +1,76 @@
+package pool
+
+import (
+	"math/rand"
+	"sync/atomic"
+)
+
+// Number of shards each counter is split into. Every increment goes to a
+// randomly chosen shard, so routines on different cores rarely contend on the
+// same cache line, and shards are only summed up when Stats is called.
+const counterShards = 16
+
+// paddedUint64 takes up an entire cache line, so that neighbouring shards
+// don't falsely share one
+type paddedUint64 struct {
+	n uint64
+	_ [56]byte
+}
+
+type counter [counterShards]paddedUint64
+
+func (c *counter) incr() {
+	atomic.AddUint64(&c[rand.Uint32()%counterShards].n, 1)
+}
+
+func (c *counter) load() uint64 {
+	var n uint64
+	for i := range c {
+		n += atomic.LoadUint64(&c[i].n)
+	}
+	return n
+}
+
+// PoolStats is a snapshot of a Pool's counters, all of which start at zero
+// when the Pool is created
+type PoolStats struct {
+	Gets   uint64 // Connections retrieved from the pool, including Dials
+	Dials  uint64 // Connections created because none were available
+	Puts   uint64 // Connections returned to the pool, including Closes and Discards
+	Closes uint64 // Connections closed on Put because the pool was full
+
+	// Connections closed on Put because they were subscribed, or their state
+	// couldn't be reset (these are counted in Puts, but not Closes)
+	Discards uint64
+
+	// Connections whose state was reset on Put, see Pool.Put
+	Resets uint64
+
+	// Gets which had to wait because MaxConcurrentDials dials were already in
+	// flight
+	DialWaits uint64
+
+	Cmds uint64 // Commands performed using Cmd or CmdNoRetry, counting retries
+	Errs uint64 // Of those, the ones whose reply was an error
+}
+
+type poolStats struct {
+	gets, dials, puts, closes, discards, resets, dialWaits, cmds, errs counter
+}
+
+// Stats returns a snapshot of the Pool's counters. It is safe to call at any
+// time, incrementing the counters doesn't involve any locking so they are
+// always kept.
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Gets:      p.stats.gets.load(),
+		Dials:     p.stats.dials.load(),
+		Puts:      p.stats.puts.load(),
+		Closes:    p.stats.closes.load(),
+		Discards:  p.stats.discards.load(),
+		Resets:    p.stats.resets.load(),
+		DialWaits: p.stats.dialWaits.load(),
+		Cmds:      p.stats.cmds.load(),
+		Errs:      p.stats.errs.load(),
+	}
+}
@@ -0,0 +1,61 @@
+package pool
+
+import (
+	"strings"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Handle is a lightweight handle on a Pool, created by With, which shares the
+// Pool's connections but performs commands with some of its behavior
+// overridden. Like the Pool, it's safe to use from multiple routines at once.
+type Handle struct {
+	pool *Pool
+	db   int
+	opts redis.Options
+}
+
+// With returns a handle on the Pool with the given Options overridden, so
+// that, for example, a library can use a shorter timeout, a different
+// RetryPolicy or its own key prefix without constructing a Pool of its own.
+// Options which aren't overridden are the Pool's own: its RetryPolicy, and no
+// timeout or prefix.
+func (p *Pool) With(opts ...redis.Option) *Handle {
+	h := &Handle{pool: p, db: p.DialOpts.DB, opts: redis.Options{RetryPolicy: p.RetryPolicy}}
+	return h.With(opts...)
+}
+
+// With is like Pool.With, but performs all commands against the DB's database
+func (d *DB) With(opts ...redis.Option) *Handle {
+	h := &Handle{pool: d.pool, db: d.db, opts: redis.Options{RetryPolicy: d.pool.RetryPolicy}}
+	return h.With(opts...)
+}
+
+// With returns a new handle with the given Options overridden on top of this
+// handle's
+func (h *Handle) With(opts ...redis.Option) *Handle {
+	h2 := &Handle{pool: h.pool, db: h.db, opts: h.opts}
+	for _, o := range opts {
+		o(&h2.opts)
+	}
+	return h2
+}
+
+// Cmd is like Pool.Cmd, but uses the handle's Options. Key prefixing makes use
+// of the Pool's cached ServerCommands. When KEYS is performed using SCAN, all
+// the SCANs are performed on the same connection.
+func (h *Handle) Cmd(cmd string, args ...interface{}) *redis.Reply {
+	if h.opts.KeyPrefix != "" {
+		commands, err := h.pool.ServerCommands()
+		if err != nil {
+			return &redis.Reply{Type: redis.ErrorReply, Err: err}
+		}
+		args = redis.PrefixKeys(commands[strings.ToLower(cmd)], h.opts.KeyPrefix, args)
+	}
+	return h.pool.do(h.opts.RetryPolicy, h.db, func(conn *redis.Client) *redis.Reply {
+		return conn.With(
+			redis.WithTimeout(h.opts.Timeout),
+			redis.WithKeysGuard(h.opts.KeysGuard, h.opts.KeysLimit),
+		).Cmd(cmd, args...)
+	})
+}
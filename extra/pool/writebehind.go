@@ -0,0 +1,218 @@
+package pool
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// DropPolicy describes what a WriteBehind does with a command when its queue
+// is full
+type DropPolicy int
+
+const (
+	// DropNewest discards the command being queued
+	DropNewest DropPolicy = iota
+
+	// DropOldest discards the oldest command in the queue to make room
+	DropOldest
+
+	// Block waits for there to be room in the queue
+	Block
+)
+
+// Defaults for WriteBehindOpts fields which aren't set
+const (
+	DefaultWriteBehindQueueSize     = 10000
+	DefaultWriteBehindBatchSize     = 100
+	DefaultWriteBehindFlushInterval = 100 * time.Millisecond
+)
+
+// WriteBehindOpts are the options which can be given to NewWriteBehind
+type WriteBehindOpts struct {
+	// Maximum number of commands which can be waiting to be written
+	QueueSize int
+
+	// Maximum number of commands written in a single pipeline
+	BatchSize int
+
+	// Maximum amount of time a command waits in the queue before being written,
+	// if a full batch hasn't been collected by then
+	FlushInterval time.Duration
+
+	// What to do when the queue is full. Defaults to DropNewest.
+	DropPolicy DropPolicy
+
+	// If set, called with each command which has failed to be written or which
+	// was replied to with an error. It is called from a background routine.
+	ErrHandler func(cmd string, args []interface{}, err error)
+}
+
+type wbCmd struct {
+	cmd  string
+	args []interface{}
+}
+
+// WriteBehind queues commands in memory and writes them in the background,
+// pipelined in batches over a single pooled connection at a time. It trades
+// durability for latency: a queued command can be dropped if the queue is
+// full, and it will be lost if the process dies before it's written. It should
+// only be used for non-critical writes, such as analytics counters, whose
+// replies are not needed.
+type WriteBehind struct {
+	dropped uint64 // accessed atomically, kept first for alignment
+
+	pool  *Pool
+	opts  WriteBehindOpts
+	queue chan *wbCmd
+
+	// read-locked while queueing, so that Close can't close the queue out from
+	// under a Cmd call
+	lock   sync.RWMutex
+	closed bool
+	doneCh chan struct{}
+}
+
+// NewWriteBehind starts a WriteBehind which writes using connections from this
+// Pool
+func (p *Pool) NewWriteBehind(opts WriteBehindOpts) *WriteBehind {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultWriteBehindQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultWriteBehindBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultWriteBehindFlushInterval
+	}
+	wb := &WriteBehind{
+		pool:   p,
+		opts:   opts,
+		queue:  make(chan *wbCmd, opts.QueueSize),
+		doneCh: make(chan struct{}),
+	}
+	go wb.spin()
+	return wb
+}
+
+// Cmd queues the given command to be written. It returns false if the command
+// was dropped instead, either due to the DropNewest policy or because the
+// WriteBehind has been closed.
+func (wb *WriteBehind) Cmd(cmd string, args ...interface{}) bool {
+	wb.lock.RLock()
+	defer wb.lock.RUnlock()
+	if wb.closed {
+		atomic.AddUint64(&wb.dropped, 1)
+		return false
+	}
+
+	c := &wbCmd{cmd, args}
+	switch wb.opts.DropPolicy {
+	case Block:
+		wb.queue <- c
+		return true
+	case DropOldest:
+		for {
+			select {
+			case wb.queue <- c:
+				return true
+			default:
+			}
+			select {
+			case <-wb.queue:
+				atomic.AddUint64(&wb.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case wb.queue <- c:
+			return true
+		default:
+			atomic.AddUint64(&wb.dropped, 1)
+			return false
+		}
+	}
+}
+
+// Dropped returns the total number of commands which have been dropped
+func (wb *WriteBehind) Dropped() uint64 {
+	return atomic.LoadUint64(&wb.dropped)
+}
+
+// Len returns the number of commands currently waiting to be written
+func (wb *WriteBehind) Len() int {
+	return len(wb.queue)
+}
+
+func (wb *WriteBehind) spin() {
+	defer close(wb.doneCh)
+	batch := make([]*wbCmd, 0, wb.opts.BatchSize)
+	ticker := time.NewTicker(wb.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case c, ok := <-wb.queue:
+			if !ok {
+				wb.flush(batch)
+				return
+			}
+			if batch = append(batch, c); len(batch) >= wb.opts.BatchSize {
+				wb.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			failWriteBehindTick(wb)
+			wb.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (wb *WriteBehind) flush(batch []*wbCmd) {
+	if len(batch) == 0 {
+		return
+	}
+
+	conn, err := wb.pool.Get()
+	if err != nil {
+		wb.handleErr(batch, err)
+		return
+	}
+	for _, c := range batch {
+		conn.Append(c.cmd, c.args...)
+	}
+	for i, c := range batch {
+		if err = conn.GetReply().Err; redis.IsNetworkErr(err) {
+			// The connection is gone, so are the rest of the replies
+			wb.handleErr(batch[i:], err)
+			return
+		} else if err != nil {
+			wb.handleErr([]*wbCmd{c}, err)
+		}
+	}
+	wb.pool.Put(conn)
+}
+
+func (wb *WriteBehind) handleErr(cmds []*wbCmd, err error) {
+	if wb.opts.ErrHandler == nil {
+		return
+	}
+	for _, c := range cmds {
+		wb.opts.ErrHandler(c.cmd, c.args, err)
+	}
+}
+
+// Close stops accepting new commands, writes all commands still in the queue,
+// and returns once that's done. It may be called more than once.
+func (wb *WriteBehind) Close() {
+	wb.lock.Lock()
+	if !wb.closed {
+		wb.closed = true
+		close(wb.queue)
+	}
+	wb.lock.Unlock()
+	<-wb.doneCh
+}
@@ -0,0 +1,190 @@
+package pubsub
+
+import (
+	"sync"
+)
+
+// DropPolicy describes what a Subscriber with a buffer does with a message
+// when its buffer is full
+type DropPolicy int
+
+const (
+	// DropOldest discards the oldest message in the buffer to make room
+	DropOldest DropPolicy = iota
+
+	// DropNewest discards the message which was just received
+	DropNewest
+
+	// Block stops reading from the connection until there's room in the
+	// buffer, as an unbuffered Subscriber does. If the consumer falls too far
+	// behind the server may disconnect the Subscriber, see redis'
+	// client-output-buffer-limit.
+	Block
+)
+
+// SubscriberOpts are the options which can be given to NewSubscriberWithOpts
+type SubscriberOpts struct {
+	// If set, messages are read off the connection as soon as they arrive and
+	// held in a buffer of up to this many messages until they're received from
+	// Ch, so that a consumer which is briefly slow doesn't hold up the
+	// connection. Other replies (errors, reconnects, etc...) are buffered along
+	// with them but are never dropped, nor do they count towards the size.
+	BufferSize int
+
+	// What to do with messages when the buffer is full. Defaults to
+	// DropOldest.
+	DropPolicy DropPolicy
+}
+
+// subBuffer is the queue between a buffered Subscriber's routine, which
+// pushes onto it, and the routine which delivers from it onto Ch. A reply stays
+// in the buffer until it's been delivered.
+type subBuffer struct {
+	size   int
+	policy DropPolicy
+
+	lock     sync.Mutex
+	cond     *sync.Cond // signalled on every push, removal and close
+	replies  []*SubReply
+	messages int  // number of MessageReplys in replies
+	sending  bool // whether replies[0] is being delivered
+	dropped  uint64
+	closed   bool
+}
+
+func newSubBuffer(size int, policy DropPolicy) *subBuffer {
+	b := &subBuffer{size: size, policy: policy}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+// push adds the reply to the buffer, applying the DropPolicy if it's a
+// message and the buffer is full. It returns false if the buffer was closed.
+func (b *subBuffer) push(sr *SubReply) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	isMsg := sr.Type == MessageReply
+	if isMsg && b.messages >= b.size {
+		switch b.policy {
+		case Block:
+			for !b.closed && b.messages >= b.size {
+				b.cond.Wait()
+			}
+		case DropNewest:
+			b.dropped++
+			return !b.closed
+		default:
+			if !b.dropOldest() {
+				// The only message is the one being delivered
+				b.dropped++
+				return !b.closed
+			}
+		}
+	}
+	if b.closed {
+		return false
+	}
+	b.replies = append(b.replies, sr)
+	if isMsg {
+		b.messages++
+	}
+	b.cond.Broadcast()
+	return true
+}
+
+// dropOldest removes the oldest message which isn't being delivered, returning
+// false if there's no such message. It must be called with lock held.
+func (b *subBuffer) dropOldest() bool {
+	for i, r := range b.replies {
+		if r.Type != MessageReply || (i == 0 && b.sending) {
+			continue
+		}
+		copy(b.replies[i:], b.replies[i+1:])
+		b.replies[len(b.replies)-1] = nil
+		b.replies = b.replies[:len(b.replies)-1]
+		b.messages--
+		b.dropped++
+		return true
+	}
+	return false
+}
+
+// next returns the oldest reply in the buffer, waiting for one if it's empty,
+// and marks it as being delivered. It returns false once the buffer has been
+// closed.
+func (b *subBuffer) next() (*SubReply, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for !b.closed && len(b.replies) == 0 {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return nil, false
+	}
+	b.sending = true
+	return b.replies[0], true
+}
+
+// delivered removes the reply returned by next from the buffer
+func (b *subBuffer) delivered() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.replies[0].Type == MessageReply {
+		b.messages--
+	}
+	b.replies[0] = nil
+	b.replies = b.replies[1:]
+	b.sending = false
+	b.cond.Broadcast()
+}
+
+func (b *subBuffer) close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+func (b *subBuffer) stats() (int, uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.messages, b.dropped
+}
+
+// deliver sends the buffered replies on Ch until the Subscriber is closed, and
+// then closes Ch
+func (s *Subscriber) deliver() {
+	defer close(s.Ch)
+	for {
+		sr, ok := s.buf.next()
+		if !ok {
+			return
+		}
+		select {
+		case s.Ch <- sr:
+			s.buf.delivered()
+		case <-s.closeCh:
+			return
+		}
+	}
+}
+
+// Buffered returns the number of messages currently waiting in the
+// Subscriber's buffer, which is always 0 for one without a buffer
+func (s *Subscriber) Buffered() int {
+	if s.buf == nil {
+		return 0
+	}
+	n, _ := s.buf.stats()
+	return n
+}
+
+// Dropped returns the total number of messages which have been dropped due to
+// the Subscriber's buffer being full, see SubscriberOpts
+func (s *Subscriber) Dropped() uint64 {
+	if s.buf == nil {
+		return 0
+	}
+	_, n := s.buf.stats()
+	return n
+}
@@ -0,0 +1,312 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// DefaultGroupTTL is the GroupOpts TTL used when none is given
+const DefaultGroupTTL = 10 * time.Second
+
+// PartitionChannel returns the name of the partition of the given channel
+// which messages with the given key are published to, when the channel is
+// split into the given number of partitions
+func PartitionChannel(channel string, partitions int, key string) string {
+	return fmt.Sprintf("%s:%d", channel, crc32.ChecksumIEEE([]byte(key))%uint32(partitions))
+}
+
+// PublishPartitioned publishes the message to the partition of the channel
+// which the key hashes to (see PartitionChannel), so that all messages with the
+// same key are handled by the same GroupConsumer
+func PublishPartitioned(c redis.Cmder, channel string, partitions int, key string, msg interface{}) *redis.Reply {
+	return c.Cmd("PUBLISH", PartitionChannel(channel, partitions, key), msg)
+}
+
+// GroupOpts describe the partitioned channel a GroupConsumer consumes from
+type GroupOpts struct {
+	// The channel and the number of partitions it's split into. All publishers
+	// and consumers must agree on these.
+	Channel    string
+	Partitions int
+
+	// Name of the group. Each partition is owned by exactly one consumer in
+	// the group at any time.
+	Group string
+
+	// Name of this consumer, which must be unique within the group. Defaults
+	// to a random string.
+	Consumer string
+
+	// How long a consumer's ownership of its partitions lasts without being
+	// renewed. Ownership is renewed every third of this. Defaults to
+	// DefaultGroupTTL.
+	TTL time.Duration
+}
+
+// GroupConsumer emulates consumer groups on top of pub/sub, for redis
+// instances which don't support streams. Messages are published to one of a
+// fixed number of partitions of a channel (see PublishPartitioned), and the
+// partitions are divided evenly between the live consumers in a group, each of
+// which only subscribes to the partitions it owns.
+//
+// Ownership is coordinated through keys with a TTL, which each consumer renews
+// periodically, so the partitions of a consumer which goes away are taken over
+// by the others once the TTL runs out. Since this is still pub/sub, messages
+// published to a partition while it has no owner, or while it's being handed
+// over, are lost.
+type GroupConsumer struct {
+	// All messages received on owned partitions are sent on this channel. If
+	// the subscription connection fails a single ErrorReply is sent and the
+	// consumer stops. The channel is closed once the consumer has stopped.
+	//
+	// Ownership isn't renewed while a message is waiting to be read off of Ch,
+	// so it should not be left unread for longer than TTL.
+	Ch chan *SubReply
+
+	opts    GroupOpts
+	conn    *redis.Client
+	sub     *SubClient
+	closeCh chan struct{}
+	doneCh  chan struct{}
+
+	ownedLock sync.Mutex
+	owned     map[int]bool
+}
+
+// Only extends or deletes an owner key if it's still ours
+var extendScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+var releaseScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+// NewGroupConsumer connects to the given redis instance and starts consuming
+// from the partitions of opts.Channel which it's able to claim for itself.
+// Two connections are used, one for subscribing and one for coordinating with
+// the rest of the group.
+func NewGroupConsumer(network, addr string, opts GroupOpts) (*GroupConsumer, error) {
+	if opts.Partitions <= 0 {
+		return nil, fmt.Errorf("invalid number of partitions: %d", opts.Partitions)
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultGroupTTL
+	}
+	if opts.Consumer == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		opts.Consumer = hex.EncodeToString(b)
+	}
+
+	conn, err := redis.DialTimeout(network, addr, opts.TTL)
+	if err != nil {
+		return nil, err
+	}
+	// Reads time out every heartbeat, which is when ownership is renewed
+	subConn, err := redis.DialTimeout(network, addr, opts.TTL/3)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	g := &GroupConsumer{
+		Ch:      make(chan *SubReply),
+		opts:    opts,
+		conn:    conn,
+		sub:     NewSubClient(subConn),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+		owned:   map[int]bool{},
+	}
+	if err := g.rebalance(); err != nil {
+		conn.Close()
+		subConn.Close()
+		return nil, err
+	}
+
+	go g.spin()
+	return g, nil
+}
+
+func (g *GroupConsumer) membersKey() string {
+	return g.opts.Group + ":" + g.opts.Channel + ":members"
+}
+
+func (g *GroupConsumer) ownerKey(i int) string {
+	return fmt.Sprintf("%s:%s:owner:%d", g.opts.Group, g.opts.Channel, i)
+}
+
+func (g *GroupConsumer) partition(i int) string {
+	return fmt.Sprintf("%s:%d", g.opts.Channel, i)
+}
+
+func (g *GroupConsumer) spin() {
+	defer close(g.doneCh)
+	defer close(g.Ch)
+	beat := time.Now()
+	for {
+		select {
+		case <-g.closeCh:
+			return
+		default:
+		}
+
+		sr := g.sub.Receive()
+		if sr.Err != nil && !sr.Timeout() {
+			select {
+			case g.Ch <- sr:
+			case <-g.closeCh:
+			}
+			return
+		} else if sr.Type == MessageReply {
+			select {
+			case g.Ch <- sr:
+			case <-g.closeCh:
+				return
+			}
+		}
+
+		if time.Since(beat) < g.opts.TTL/3 {
+			continue
+		}
+		beat = time.Now()
+		if err := g.rebalance(); err != nil {
+			select {
+			case g.Ch <- &SubReply{Type: ErrorReply, Err: err}:
+			case <-g.closeCh:
+			}
+			if redis.IsNetworkErr(err) {
+				return
+			}
+		}
+	}
+}
+
+// rebalance announces this consumer to the group, renews ownership of its
+// partitions, and claims or releases partitions so that it owns its fair share
+// of them, subscribing and unsubscribing accordingly
+func (g *GroupConsumer) rebalance() error {
+	now := time.Now()
+	ms := now.UnixNano() / int64(time.Millisecond)
+	ttlMs := int64(g.opts.TTL / time.Millisecond)
+	members := g.membersKey()
+
+	if err := g.conn.Cmd("ZADD", members, ms, g.opts.Consumer).Err; err != nil {
+		return err
+	}
+	if err := g.conn.Cmd("ZREMRANGEBYSCORE", members, "-inf", ms-ttlMs).Err; err != nil {
+		return err
+	}
+	if err := g.conn.Cmd("PEXPIRE", members, ttlMs).Err; err != nil {
+		return err
+	}
+	n, err := g.conn.Cmd("ZCARD", members).Int()
+	if err != nil {
+		return err
+	}
+	fair := (g.opts.Partitions + n - 1) / n
+
+	g.ownedLock.Lock()
+	defer g.ownedLock.Unlock()
+
+	var sub, unsub []interface{}
+	for i := range g.owned {
+		ok, err := extendScript.Cmd(g.conn, g.ownerKey(i), g.opts.Consumer, ttlMs).Bool()
+		if err != nil {
+			return err
+		} else if !ok {
+			delete(g.owned, i)
+			unsub = append(unsub, g.partition(i))
+		}
+	}
+
+	for i := range g.owned {
+		if len(g.owned) <= fair {
+			break
+		}
+		if err := releaseScript.Cmd(g.conn, g.ownerKey(i), g.opts.Consumer).Err; err != nil {
+			return err
+		}
+		delete(g.owned, i)
+		unsub = append(unsub, g.partition(i))
+	}
+
+	// Start looking from a different partition for each consumer, so they
+	// don't all race for the same ones
+	start := int(crc32.ChecksumIEEE([]byte(g.opts.Consumer)) % uint32(g.opts.Partitions))
+	for j := 0; j < g.opts.Partitions && len(g.owned) < fair; j++ {
+		i := (start + j) % g.opts.Partitions
+		if g.owned[i] {
+			continue
+		}
+		r := g.conn.Cmd("SET", g.ownerKey(i), g.opts.Consumer, "PX", ttlMs, "NX")
+		if r.Err != nil {
+			return r.Err
+		} else if r.Type == redis.NilReply {
+			continue
+		}
+		g.owned[i] = true
+		sub = append(sub, g.partition(i))
+	}
+
+	if len(unsub) > 0 {
+		if sr := g.sub.Unsubscribe(unsub...); sr.Err != nil {
+			return sr.Err
+		}
+	}
+	if len(sub) > 0 {
+		if sr := g.sub.Subscribe(sub...); sr.Err != nil {
+			return sr.Err
+		}
+	}
+	return nil
+}
+
+// Partitions returns the partitions (numbered from 0) this consumer currently
+// owns, in ascending order
+func (g *GroupConsumer) Partitions() []int {
+	g.ownedLock.Lock()
+	defer g.ownedLock.Unlock()
+	p := make([]int, 0, len(g.owned))
+	for i := range g.owned {
+		p = append(p, i)
+	}
+	sort.Ints(p)
+	return p
+}
+
+// Close stops the consumer, releasing its partitions and leaving the group so
+// that the other consumers can take over right away, and closes its
+// connections. Ch will be closed once it returns, which may take up to a third
+// of TTL.
+func (g *GroupConsumer) Close() {
+	close(g.closeCh)
+	<-g.doneCh
+
+	g.ownedLock.Lock()
+	for i := range g.owned {
+		releaseScript.Cmd(g.conn, g.ownerKey(i), g.opts.Consumer)
+	}
+	g.owned = map[int]bool{}
+	g.ownedLock.Unlock()
+
+	g.conn.Cmd("ZREM", g.membersKey(), g.opts.Consumer)
+	g.conn.Close()
+	g.sub.Client.Close()
+}
@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// ChannelStats holds counters for the messages received on a single channel or
+// pattern
+type ChannelStats struct {
+	Messages uint64 // Number of messages received
+	Bytes    uint64 // Total size of the bodies of those messages
+
+	// When the first and most recent messages were received
+	First, Last time.Time
+}
+
+// MessageRate returns the average number of messages received per second
+// between the given time (usually SubStats' Since) and the last message
+func (cs ChannelStats) MessageRate(since time.Time) float64 {
+	return rate(cs.Messages, since, cs.Last)
+}
+
+// ByteRate returns the average number of bytes received per second between
+// the given time (usually SubStats' Since) and the last message
+func (cs ChannelStats) ByteRate(since time.Time) float64 {
+	return rate(cs.Bytes, since, cs.Last)
+}
+
+func rate(n uint64, since, last time.Time) float64 {
+	d := last.Sub(since).Seconds()
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d
+}
+
+// SubStats is a snapshot of the per-channel and per-pattern message counters
+// of a SubClient
+type SubStats struct {
+	// When the counters were started (or last reset)
+	Since time.Time
+
+	// Keyed by the channel the message was published to. This includes
+	// messages received due to a pattern subscription.
+	Channels map[string]ChannelStats
+
+	// Keyed by the pattern which was matched, for messages received due to a
+	// pattern subscription only
+	Patterns map[string]ChannelStats
+}
+
+type subStats struct {
+	sync.Mutex
+	since              time.Time
+	channels, patterns map[string]*ChannelStats
+}
+
+func newSubStats() *subStats {
+	return &subStats{
+		since:    time.Now(),
+		channels: map[string]*ChannelStats{},
+		patterns: map[string]*ChannelStats{},
+	}
+}
+
+func incr(m map[string]*ChannelStats, name string, size int, now time.Time) {
+	cs, ok := m[name]
+	if !ok {
+		cs = &ChannelStats{First: now}
+		m[name] = cs
+	}
+	cs.Messages++
+	cs.Bytes += uint64(size)
+	cs.Last = now
+}
+
+func (s *subStats) record(pattern, channel string, size int) {
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+	incr(s.channels, channel, size, now)
+	if pattern != "" {
+		incr(s.patterns, pattern, size, now)
+	}
+}
+
+func copyStats(m map[string]*ChannelStats) map[string]ChannelStats {
+	c := make(map[string]ChannelStats, len(m))
+	for k, v := range m {
+		c[k] = *v
+	}
+	return c
+}
+
+// Stats returns a snapshot of the message counters for every channel and
+// pattern this SubClient has received messages on. Unlike the rest of
+// SubClient's methods, this is safe to call from other routines at the same
+// time as Receive.
+func (c *SubClient) Stats() SubStats {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+	return SubStats{
+		Since:    c.stats.since,
+		Channels: copyStats(c.stats.channels),
+		Patterns: copyStats(c.stats.patterns),
+	}
+}
+
+// ResetStats clears all message counters. It is safe to call from other
+// routines.
+func (c *SubClient) ResetStats() {
+	c.stats.Lock()
+	defer c.stats.Unlock()
+	c.stats.since = time.Now()
+	c.stats.channels = map[string]*ChannelStats{}
+	c.stats.patterns = map[string]*ChannelStats{}
+}
@@ -4,8 +4,9 @@ package pubsub
 
 import (
 	"container/list"
+	"context"
 	"errors"
-	"net"
+	"time"
 
 	"github.com/fzzy/radix/redis"
 )
@@ -17,36 +18,121 @@ const (
 	SubscribeReply
 	UnsubscribeReply
 	MessageReply
+
+	// Sent by a Subscriber after it has re-established a lost connection
+	ReconnectReply
+)
+
+// SubReplyKind classifies a SubReply more finely than its Type does, so that
+// everything a subscription can deliver, including the loss of its
+// connection, can be handled in a single switch on SubReply.Kind
+type SubReplyKind uint8
+
+const (
+	// An ErrorReply which isn't due to the connection being lost, e.g. a
+	// timeout or an error reply from the server
+	KindError SubReplyKind = iota
+
+	// A message published to a subscribed (shard) channel
+	KindMessage
+
+	// A message published to a channel matching a subscribed pattern
+	KindPMessage
+
+	// A SubscribeReply or UnsubscribeReply
+	KindSubscribed
+	KindUnsubscribed
+
+	// An ErrorReply due to the connection being lost. Any messages published
+	// until a Reconnected reply is received are missed.
+	KindDisconnected
+
+	// A ReconnectReply
+	KindReconnected
 )
 
+func (k SubReplyKind) String() string {
+	switch k {
+	case KindError:
+		return "error"
+	case KindMessage:
+		return "message"
+	case KindPMessage:
+		return "pmessage"
+	case KindSubscribed:
+		return "subscribed"
+	case KindUnsubscribed:
+		return "unsubscribed"
+	case KindDisconnected:
+		return "disconnected"
+	case KindReconnected:
+		return "reconnected"
+	}
+	return "unknown"
+}
+
 // SubClient wraps a Redis client to provide convenience methods for Pub/Sub functionality.
 type SubClient struct {
 	Client   *redis.Client
 	messages *list.List
+	stats    *subStats
 }
 
 // SubReply wraps a Redis reply and provides convienient access to Pub/Sub info.
 type SubReply struct {
-	Type     SubReplyType // SubReply type
-	Channel  string       // Channel reply is on (MessageReply)
+	Type SubReplyType // SubReply type
+
+	// Channel reply is on (MessageReply), or which was subscribed to or
+	// unsubscribed from (SubscribeReply or UnsubscribeReply)
+	Channel string
+
+	// Pattern which matched the channel (MessageReply received due to a
+	// PSUBSCRIBE), or which was subscribed to or unsubscribed from
+	// (SubscribeReply or UnsubscribeReply for PSUBSCRIBE or PUNSUBSCRIBE). Only
+	// one of Channel and Pattern is set on subscription replies.
+	Pattern string
+
+	// Whether Channel is a shard channel, i.e. the reply is due to SSUBSCRIBE
+	// or SUNSUBSCRIBE, or the message was published using SPUBLISH
+	Shard bool
+
 	SubCount int          // Count of subs active after this action (SubscribeReply or UnsubscribeReply)
 	Message  string       // Publish message (MessageReply)
+	Bytes    []byte       // Publish message as raw bytes (MessageReply)
 	Err      error        // SubReply error (ErrorReply)
-	Reply    *redis.Reply // Original Redis reply (MessageReply)
+	Reply    *redis.Reply // Original Redis reply
+}
+
+// Kind returns the SubReplyKind of the reply, which is derived from its Type,
+// Pattern and Err
+func (r *SubReply) Kind() SubReplyKind {
+	switch r.Type {
+	case MessageReply:
+		if r.Pattern != "" {
+			return KindPMessage
+		}
+		return KindMessage
+	case SubscribeReply:
+		return KindSubscribed
+	case UnsubscribeReply:
+		return KindUnsubscribed
+	case ReconnectReply:
+		return KindReconnected
+	}
+	if redis.IsNetworkErr(r.Err) && !r.Timeout() {
+		return KindDisconnected
+	}
+	return KindError
 }
 
 // Timeout determines if this SubReply is an error type
 // due to a timeout reading from the network
 func (r *SubReply) Timeout() bool {
-	if r.Err == nil {
-		return false
-	}
-	t, ok := r.Err.(*net.OpError)
-	return ok && t.Timeout()
+	return redis.IsTimeout(r.Err)
 }
 
 func NewSubClient(client *redis.Client) *SubClient {
-	return &SubClient{client, &list.List{}}
+	return &SubClient{Client: client, messages: &list.List{}, stats: newSubStats()}
 }
 
 // Subscribe makes a Redis "SUBSCRIBE" command on the provided channels
@@ -59,6 +145,18 @@ func (c *SubClient) PSubscribe(patterns ...interface{}) *SubReply {
 	return c.filterMessages("PSUBSCRIBE", patterns...)
 }
 
+// SSubscribe makes a Redis "SSUBSCRIBE" command on the provided shard channels
+// (redis 7 and up). In a cluster all of the channels must belong to slots
+// served by the node the client is connected to. If the server is known to be
+// too old (see redis.Client.Server) a *redis.UnsupportedError is returned
+// without anything being sent.
+func (c *SubClient) SSubscribe(channels ...interface{}) *SubReply {
+	if err := c.Client.RequireFeature(redis.FeatureShardedPubSub); err != nil {
+		return &SubReply{Type: ErrorReply, Err: err}
+	}
+	return c.filterMessages("SSUBSCRIBE", channels...)
+}
+
 // Unsubscribe makes a Redis "UNSUBSCRIBE" command on the provided channels
 func (c *SubClient) Unsubscribe(channels ...interface{}) *SubReply {
 	return c.filterMessages("UNSUBSCRIBE", channels...)
@@ -69,6 +167,12 @@ func (c *SubClient) PUnsubscribe(patterns ...interface{}) *SubReply {
 	return c.filterMessages("PUNSUBSCRIBE", patterns...)
 }
 
+// SUnsubscribe makes a Redis "SUNSUBSCRIBE" command on the provided shard
+// channels
+func (c *SubClient) SUnsubscribe(channels ...interface{}) *SubReply {
+	return c.filterMessages("SUNSUBSCRIBE", channels...)
+}
+
 // Receive returns the next publish reply on the Redis client.  It is possible
 // Receive will timeout, and the *SubReply will be an ErrorReply. You can use
 // the Timeout() method on SubReply to easily determine if that is the case. If
@@ -78,10 +182,40 @@ func (c *SubClient) Receive() *SubReply {
 	return c.receive(false)
 }
 
+// ReceiveTimeout is like Receive, but waits at most the given duration for a
+// publish reply, regardless of the underlying client's timeout. If none
+// arrives in time the returned SubReply's Timeout method returns true.
+func (c *SubClient) ReceiveTimeout(d time.Duration) *SubReply {
+	if sr := c.buffered(); sr != nil {
+		return sr
+	}
+	return c.parseReply(c.Client.ReadReplyTimeout(d))
+}
+
+// ReceiveContext is like Receive, but returns an ErrorReply with the context's
+// error as soon as the context is cancelled or its deadline passes. The
+// subscription is unaffected, so Receive can be called again afterwards.
+func (c *SubClient) ReceiveContext(ctx context.Context) *SubReply {
+	if sr := c.buffered(); sr != nil {
+		return sr
+	}
+	return c.parseReply(c.Client.ReadReplyContext(ctx))
+}
+
+// buffered returns the earliest publish reply which was received while
+// waiting on a subscription reply, or nil
+func (c *SubClient) buffered() *SubReply {
+	if c.messages.Len() == 0 {
+		return nil
+	}
+	return c.messages.Remove(c.messages.Front()).(*SubReply)
+}
+
 func (c *SubClient) receive(skipBuffer bool) *SubReply {
-	if c.messages.Len() > 0 && !skipBuffer {
-		v := c.messages.Remove(c.messages.Front())
-		return v.(*SubReply)
+	if !skipBuffer {
+		if sr := c.buffered(); sr != nil {
+			return sr
+		}
 	}
 	r := c.Client.ReadReply()
 	return c.parseReply(r)
@@ -108,7 +242,7 @@ func (c *SubClient) filterMessages(cmd string, names ...interface{}) *SubReply {
 func (c *SubClient) parseReply(reply *redis.Reply) *SubReply {
 	sr := &SubReply{Reply: reply}
 	switch reply.Type {
-	case redis.MultiReply:
+	case redis.MultiReply, redis.PushReply:
 		if len(reply.Elems) < 3 {
 			sr.Err = errors.New("reply is not formatted as a subscription reply")
 			return sr
@@ -130,7 +264,7 @@ func (c *SubClient) parseReply(reply *redis.Reply) *SubReply {
 
 	//first element
 	switch rtype {
-	case "subscribe", "psubscribe":
+	case "subscribe", "psubscribe", "ssubscribe":
 		sr.Type = SubscribeReply
 		count, err := reply.Elems[2].Int()
 		if err != nil {
@@ -138,8 +272,9 @@ func (c *SubClient) parseReply(reply *redis.Reply) *SubReply {
 			sr.Type = ErrorReply
 		} else {
 			sr.SubCount = count
+			setSubName(sr, rtype, reply.Elems[1])
 		}
-	case "unsubscribe", "punsubscribe":
+	case "unsubscribe", "punsubscribe", "sunsubscribe":
 		sr.Type = UnsubscribeReply
 		count, err := reply.Elems[2].Int()
 		if err != nil {
@@ -147,11 +282,12 @@ func (c *SubClient) parseReply(reply *redis.Reply) *SubReply {
 			sr.Type = ErrorReply
 		} else {
 			sr.SubCount = count
+			setSubName(sr, rtype, reply.Elems[1])
 		}
-	case "message", "pmessage":
+	case "message", "pmessage", "smessage":
 		var chanI, msgI int
 
-		if rtype == "message" {
+		if rtype != "pmessage" {
 			chanI, msgI = 1, 2
 		} else { // "pmessage"
 			chanI, msgI = 2, 3
@@ -165,16 +301,35 @@ func (c *SubClient) parseReply(reply *redis.Reply) *SubReply {
 			return sr
 		}
 		sr.Channel = channel
-		msg, err := reply.Elems[msgI].Str()
+		msg, err := reply.Elems[msgI].Bytes()
 		if err != nil {
 			sr.Err = errors.New("message reply does not have string value for body")
 			sr.Type = ErrorReply
 		} else {
-			sr.Message = msg
+			sr.Message = string(msg)
+			sr.Bytes = msg
 		}
+
+		if rtype == "pmessage" {
+			sr.Pattern, _ = reply.Elems[1].Str()
+		}
+		sr.Shard = rtype == "smessage"
+		c.stats.record(sr.Pattern, channel, len(msg))
 	default:
 		sr.Err = errors.New("suscription multireply has invalid type: " + rtype)
 		sr.Type = ErrorReply
 	}
 	return sr
 }
+
+// setSubName sets the Channel or Pattern of a subscription reply, which is
+// nil when unsubscribing from everything while not subscribed to anything
+func setSubName(sr *SubReply, rtype string, name *redis.Reply) {
+	s, _ := name.Str()
+	if rtype[0] == 'p' {
+		sr.Pattern = s
+	} else {
+		sr.Channel = s
+		sr.Shard = rtype == "ssubscribe" || rtype == "sunsubscribe"
+	}
+}
@@ -1,10 +1,14 @@
 package pubsub
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"testing"
 	"time"
 
+	"github.com/fzzy/radix/extra/redistest"
 	"github.com/fzzy/radix/redis"
 )
 
@@ -106,6 +110,10 @@ func TestPSubscribe(t *testing.T) {
 		t.Fatal(fmt.Sprintf("Unexpected subscription count, Expected: 0, Found: %d", sr.SubCount))
 	}
 
+	if sr.Pattern != pattern || sr.Channel != "" {
+		t.Fatalf("Unexpected pattern/channel on subscribe reply: %q/%q", sr.Pattern, sr.Channel)
+	}
+
 	r := pub.Cmd("PUBLISH", "patternThenHello", message)
 	if r.Err != nil {
 		t.Fatal(r.Err)
@@ -130,10 +138,14 @@ func TestPSubscribe(t *testing.T) {
 		t.Fatal("Did not receive a message reply")
 	}
 
-	if sr.Message != message {
+	if sr.Message != message || string(sr.Bytes) != message {
 		t.Fatal(fmt.Sprintf("Did not recieve expected message '%s', instead got: '%s'", message, sr.Message))
 	}
 
+	if sr.Pattern != pattern || sr.Channel != "patternThenHello" {
+		t.Fatalf("Unexpected pattern/channel on message: %q/%q", sr.Pattern, sr.Channel)
+	}
+
 	sr = sub.PUnsubscribe(pattern)
 	if sr.Err != nil {
 		t.Fatal(sr.Err)
@@ -147,3 +159,260 @@ func TestPSubscribe(t *testing.T) {
 		t.Fatal(fmt.Sprintf("Unexpected subscription count, Expected: 0, Found: %d", sr.SubCount))
 	}
 }
+
+func TestStats(t *testing.T) {
+	pub, err := redis.DialTimeout("tcp", "localhost:6379", time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := redis.DialTimeout("tcp", "localhost:6379", time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := NewSubClient(client)
+
+	if sr := sub.PSubscribe("statsTest*"); sr.Err != nil {
+		t.Fatal(sr.Err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if r := pub.Cmd("PUBLISH", "statsTestChannel", "hello"); r.Err != nil {
+			t.Fatal(r.Err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if sr := sub.Receive(); sr.Err != nil {
+			t.Fatal(sr.Err)
+		}
+	}
+
+	stats := sub.Stats()
+	cs := stats.Channels["statsTestChannel"]
+	if cs.Messages != 3 || cs.Bytes != 15 {
+		t.Fatalf("unexpected channel stats: %+v", cs)
+	}
+	ps := stats.Patterns["statsTest*"]
+	if ps.Messages != 3 || ps.Bytes != 15 {
+		t.Fatalf("unexpected pattern stats: %+v", ps)
+	}
+
+	sub.ResetStats()
+	if l := len(sub.Stats().Channels); l != 0 {
+		t.Fatalf("stats not reset, have %d channels", l)
+	}
+}
+
+func TestGroupConsumer(t *testing.T) {
+	pub, err := redis.DialTimeout("tcp", "localhost:6379", time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := GroupOpts{
+		Channel:    "groupTestChannel",
+		Partitions: 4,
+		Group:      "groupTest",
+		TTL:        3 * time.Second,
+	}
+	g, err := NewGroupConsumer("tcp", "localhost:6379", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	// The only consumer in the group gets every partition
+	if p := g.Partitions(); len(p) != 4 {
+		t.Fatalf("unexpected partitions owned: %v", p)
+	}
+
+	if r := PublishPartitioned(pub, opts.Channel, opts.Partitions, "someKey", "hello"); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+
+	select {
+	case sr := <-g.Ch:
+		if sr.Err != nil {
+			t.Fatal(sr.Err)
+		}
+		if sr.Channel != PartitionChannel(opts.Channel, opts.Partitions, "someKey") {
+			t.Fatalf("message on unexpected channel %q", sr.Channel)
+		}
+		if sr.Message != "hello" {
+			t.Fatalf("unexpected message %q", sr.Message)
+		}
+	case <-time.After(time.Duration(10) * time.Second):
+		t.Fatal("Took too long to Receive message")
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	pub, err := redis.DialTimeout("tcp", "localhost:6379", time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSubscriber("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	channel := "subscriberTestChannel"
+	if err := s.Subscribe(channel); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(kind SubReplyKind) *SubReply {
+		select {
+		case sr := <-s.Ch:
+			if sr.Kind() != kind {
+				t.Fatalf("unexpected reply: %+v", sr)
+			}
+			return sr
+		case <-time.After(time.Duration(10) * time.Second):
+			t.Fatal("Took too long to Receive message")
+		}
+		return nil
+	}
+
+	pub.Cmd("PUBLISH", channel, "one")
+	if sr := receive(KindMessage); sr.Message != "one" {
+		t.Fatalf("unexpected message %q", sr.Message)
+	}
+
+	// Kill the connection, the subscription should be re-established
+	s.lock.Lock()
+	addr := s.conn.LocalAddr().String()
+	s.lock.Unlock()
+	if r := pub.Cmd("CLIENT", "KILL", "ADDR", addr); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	receive(KindDisconnected)
+	receive(KindReconnected)
+
+	pub.Cmd("PUBLISH", channel, "two")
+	if sr := receive(KindMessage); sr.Message != "two" {
+		t.Fatalf("unexpected message %q", sr.Message)
+	}
+
+	s.Close()
+	if _, ok := <-s.Ch; ok {
+		t.Fatal("Ch not closed")
+	}
+	if err := s.Subscribe(channel); err != ErrSubscriberClosed {
+		t.Fatalf("unexpected error after close: %v", err)
+	}
+}
+
+func TestSubscriberBuffer(t *testing.T) {
+	srv, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// The subscription is acknowledged along with four messages, which arrive
+	// before anything is read from Ch
+	raw := "*3\r\n$9\r\nsubscribe\r\n$3\r\nfoo\r\n:1\r\n"
+	for _, msg := range []string{"1", "2", "3", "4"} {
+		raw += "*3\r\n$7\r\nmessage\r\n$3\r\nfoo\r\n$1\r\n" + msg + "\r\n"
+	}
+	srv.Handle("SUBSCRIBE", redistest.Raw([]byte(raw)))
+
+	for _, c := range []struct {
+		policy DropPolicy
+		msgs   []string
+	}{
+		{DropOldest, []string{"3", "4"}},
+		{DropNewest, []string{"1", "2"}},
+	} {
+		s, err := NewSubscriberWithOpts("tcp", srv.Addr, SubscriberOpts{BufferSize: 2, DropPolicy: c.policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Subscribe("foo"); err != nil {
+			t.Fatal(err)
+		}
+		for start := time.Now(); s.Dropped() < 2; {
+			if time.Since(start) > time.Second {
+				t.Fatalf("policy %d: only %d messages dropped", c.policy, s.Dropped())
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if n := s.Buffered(); n != 2 {
+			t.Fatalf("policy %d: %d messages buffered", c.policy, n)
+		}
+		for _, msg := range c.msgs {
+			if sr := <-s.Ch; sr.Message != msg {
+				t.Fatalf("policy %d: got message %q, expected %q", c.policy, sr.Message, msg)
+			}
+		}
+		s.Close()
+		if _, ok := <-s.Ch; ok {
+			t.Fatal("Ch not closed")
+		}
+	}
+}
+
+func TestReceiveTimeout(t *testing.T) {
+	pub, err := redis.DialTimeout("tcp", "localhost:6379", time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := redis.Dial("tcp", "localhost:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := NewSubClient(client)
+	defer client.Close()
+
+	channel := "receiveTimeoutTestChannel"
+	if sr := sub.Subscribe(channel); sr.Err != nil {
+		t.Fatal(sr.Err)
+	}
+
+	if sr := sub.ReceiveTimeout(100 * time.Millisecond); !sr.Timeout() {
+		t.Fatalf("expected timeout, got: %+v", sr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	if sr := sub.ReceiveContext(ctx); sr.Err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got: %+v", sr)
+	}
+
+	// The subscription still works afterwards
+	if r := pub.Cmd("PUBLISH", channel, "hello"); r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	sr := sub.ReceiveContext(context.Background())
+	if sr.Err != nil {
+		t.Fatal(sr.Err)
+	} else if sr.Message != "hello" {
+		t.Fatalf("unexpected message %q", sr.Message)
+	}
+}
+
+func TestKind(t *testing.T) {
+	for _, c := range []struct {
+		sr   *SubReply
+		kind SubReplyKind
+	}{
+		{&SubReply{Type: MessageReply, Channel: "foo"}, KindMessage},
+		{&SubReply{Type: MessageReply, Channel: "foo", Pattern: "f*"}, KindPMessage},
+		{&SubReply{Type: SubscribeReply, Pattern: "f*"}, KindSubscribed},
+		{&SubReply{Type: UnsubscribeReply, Channel: "foo"}, KindUnsubscribed},
+		{&SubReply{Type: ReconnectReply}, KindReconnected},
+		{&SubReply{Type: ErrorReply, Err: io.EOF}, KindDisconnected},
+		{&SubReply{Type: ErrorReply, Err: &redis.CmdError{Err: errors.New("ERR")}}, KindError},
+	} {
+		if k := c.sr.Kind(); k != c.kind {
+			t.Errorf("expected %s for %+v, got %s", c.kind, c.sr, k)
+		}
+	}
+}
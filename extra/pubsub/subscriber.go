@@ -0,0 +1,427 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// ErrSubscriberClosed is returned by the methods of a Subscriber which has
+// been closed
+var ErrSubscriberClosed = errors.New("subscriber is closed")
+
+// subOp is a SUBSCRIBE family command waiting to be performed by a
+// Subscriber's routine
+type subOp struct {
+	cmd     string
+	names   []interface{}
+	errCh   chan error
+	tracked bool
+}
+
+// Subscriber is a subscription connection which survives connection failures.
+// Messages are delivered on a channel by a background routine, which re-dials
+// whenever the connection is lost and re-subscribes to every channel, pattern
+// and shard channel it was subscribed to. Unlike SubClient, a Subscriber is
+// safe to use from multiple routines at once.
+type Subscriber struct {
+	// Every message received is sent on this channel. When the connection is
+	// lost an ErrorReply with the error is sent (of KindDisconnected, for
+	// network errors), and after the re-dial a ReconnectReply, since any
+	// messages published while the connection was down have been missed. The
+	// channel is closed once the Subscriber is closed.
+	//
+	// The methods which change subscriptions wait on the routine which sends
+	// on Ch, so they must not be called from the routine reading from it,
+	// unless the Subscriber has a buffer which doesn't Block (see
+	// SubscriberOpts).
+	Ch chan *SubReply
+
+	// Maximum number of dial attempts made when re-connecting, and the
+	// exponential backoff between them. Once the attempts are exhausted an
+	// ErrorReply is sent on Ch and the Subscriber starts over. These should be
+	// set before the connection is first lost.
+	MaxDialAttempts int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+
+	network, addr string
+	closeCh       chan struct{}
+	buf           *subBuffer // nil if unbuffered
+
+	// conn is the current connection, which is interrupted whenever ops are
+	// added to pending while the background routine is receiving on it
+	lock      sync.Mutex
+	conn      net.Conn
+	pending   []*subOp
+	receiving bool
+	closed    bool
+
+	// only touched by the background routine
+	sub                        *SubClient
+	channels, patterns, shards map[string]bool
+}
+
+// NewSubscriber connects to the given redis instance and returns a Subscriber
+// which isn't yet subscribed to anything
+func NewSubscriber(network, addr string) (*Subscriber, error) {
+	return NewSubscriberWithOpts(network, addr, SubscriberOpts{})
+}
+
+// NewSubscriberWithOpts is like NewSubscriber, but takes options such as the
+// size of a buffer to hold messages in while the consumer of Ch is busy
+func NewSubscriberWithOpts(network, addr string, opts SubscriberOpts) (*Subscriber, error) {
+	client, err := redis.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Subscriber{
+		Ch:              make(chan *SubReply),
+		MaxDialAttempts: redis.DefaultMaxDialAttempts,
+		InitialBackoff:  redis.DefaultInitialBackoff,
+		MaxBackoff:      redis.DefaultMaxBackoff,
+		network:         network,
+		addr:            addr,
+		closeCh:         make(chan struct{}),
+		conn:            client.Conn,
+		sub:             NewSubClient(client),
+		channels:        map[string]bool{},
+		patterns:        map[string]bool{},
+		shards:          map[string]bool{},
+	}
+	if opts.BufferSize > 0 {
+		s.buf = newSubBuffer(opts.BufferSize, opts.DropPolicy)
+		go s.deliver()
+	}
+	go s.spin()
+	return s, nil
+}
+
+// Subscribe subscribes to the given channels, returning once the subscription
+// is in place. If the connection is currently down this blocks until it has
+// been re-established.
+func (s *Subscriber) Subscribe(channels ...interface{}) error {
+	return s.do("SUBSCRIBE", channels)
+}
+
+// PSubscribe subscribes to the given patterns, see Subscribe
+func (s *Subscriber) PSubscribe(patterns ...interface{}) error {
+	return s.do("PSUBSCRIBE", patterns)
+}
+
+// SSubscribe subscribes to the given shard channels, see Subscribe and
+// SubClient.SSubscribe. If the server unsubscribes from a shard channel by
+// itself, e.g. because its slot was migrated to another node of a cluster, the
+// UnsubscribeReply is sent on Ch and the channel is not re-subscribed to after
+// a re-dial.
+func (s *Subscriber) SSubscribe(channels ...interface{}) error {
+	return s.do("SSUBSCRIBE", channels)
+}
+
+// Unsubscribe unsubscribes from the given channels, see Subscribe
+func (s *Subscriber) Unsubscribe(channels ...interface{}) error {
+	return s.do("UNSUBSCRIBE", channels)
+}
+
+// PUnsubscribe unsubscribes from the given patterns, see Subscribe
+func (s *Subscriber) PUnsubscribe(patterns ...interface{}) error {
+	return s.do("PUNSUBSCRIBE", patterns)
+}
+
+// SUnsubscribe unsubscribes from the given shard channels, see Subscribe
+func (s *Subscriber) SUnsubscribe(channels ...interface{}) error {
+	return s.do("SUNSUBSCRIBE", channels)
+}
+
+func (s *Subscriber) do(cmd string, names []interface{}) error {
+	if len(names) == 0 {
+		return errors.New("no channels or patterns given")
+	}
+	op := &subOp{cmd: cmd, names: names, errCh: make(chan error, 1)}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrSubscriberClosed
+	}
+	s.pending = append(s.pending, op)
+	// Receive blocks without a timeout, setting a deadline in the past is how
+	// the background routine is woken up to perform the op
+	if s.receiving {
+		s.conn.SetReadDeadline(time.Now())
+	}
+	s.lock.Unlock()
+
+	select {
+	case err := <-op.errCh:
+		return err
+	case <-s.closeCh:
+		return ErrSubscriberClosed
+	}
+}
+
+// Close closes the connection and stops the background routine. Ch is closed
+// shortly afterwards.
+func (s *Subscriber) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.closeCh)
+	if s.buf != nil {
+		s.buf.close()
+	}
+	s.conn.SetReadDeadline(time.Now())
+}
+
+func (s *Subscriber) isClosed() bool {
+	select {
+	case <-s.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// send sends the reply on Ch, or adds it to the buffer if there is one,
+// returning false if the Subscriber was closed first
+func (s *Subscriber) send(sr *SubReply) bool {
+	if s.buf != nil {
+		return s.buf.push(sr)
+	}
+	select {
+	case s.Ch <- sr:
+		return true
+	case <-s.closeCh:
+		return false
+	}
+}
+
+func (s *Subscriber) spin() {
+	if s.buf == nil {
+		defer close(s.Ch)
+	}
+	defer func() { s.sub.Client.Close() }()
+
+	for {
+		var err error
+		s.lock.Lock()
+		if len(s.pending) > 0 {
+			s.lock.Unlock()
+			err = s.performOps()
+		} else {
+			s.receiving = true
+			s.lock.Unlock()
+			sr := s.sub.Receive()
+			s.lock.Lock()
+			s.receiving = false
+			s.lock.Unlock()
+			s.sub.Client.Conn.SetReadDeadline(time.Time{})
+
+			if s.isClosed() {
+				return
+			} else if sr.Timeout() {
+				// Woken up by do, the ops will be performed next time around
+				continue
+			} else if sr.Err != nil {
+				err = sr.Err
+			} else if sr.Type == UnsubscribeReply && sr.Shard {
+				// Only the server unsubscribes outside of performOps
+				delete(s.shards, sr.Channel)
+				if !s.send(sr) {
+					return
+				}
+			} else if sr.Type == MessageReply && !s.send(sr) {
+				return
+			}
+		}
+
+		if err != nil && !s.reconnect(err) {
+			return
+		}
+	}
+}
+
+// performOps performs all pending ops. If a network error is encountered it's
+// returned, and the ops will be acknowledged once the connection has been
+// re-established, since that replays all subscriptions.
+func (s *Subscriber) performOps() error {
+	s.lock.Lock()
+	s.trackPending()
+	ops := s.pending
+	s.lock.Unlock()
+
+	for i, op := range ops {
+		var sr *SubReply
+		switch op.cmd {
+		case "SUBSCRIBE":
+			sr = s.sub.Subscribe(op.names...)
+		case "PSUBSCRIBE":
+			sr = s.sub.PSubscribe(op.names...)
+		case "UNSUBSCRIBE":
+			sr = s.sub.Unsubscribe(op.names...)
+		case "PUNSUBSCRIBE":
+			sr = s.sub.PUnsubscribe(op.names...)
+		case "SSUBSCRIBE":
+			sr = s.sub.SSubscribe(op.names...)
+		case "SUNSUBSCRIBE":
+			sr = s.sub.SUnsubscribe(op.names...)
+		}
+		if redis.IsNetworkErr(sr.Err) {
+			return sr.Err
+		} else if sr.Err != nil && !strings.Contains(op.cmd, "UNSUB") {
+			// e.g. a MOVED error for a shard channel, which shouldn't be
+			// replayed after a re-dial
+			m := s.opMap(op)
+			for _, name := range op.names {
+				delete(m, nameStr(name))
+			}
+		}
+		s.lock.Lock()
+		s.pending = s.pending[1:]
+		s.lock.Unlock()
+		ops[i].errCh <- sr.Err
+	}
+	return nil
+}
+
+// trackPending records the effect of every pending op on the channels and
+// patterns which are subscribed to, so that they can be replayed after a
+// re-dial. It must be called with lock held.
+func (s *Subscriber) trackPending() {
+	for _, op := range s.pending {
+		if op.tracked {
+			continue
+		}
+		op.tracked = true
+		m := s.opMap(op)
+		for _, name := range op.names {
+			if strings.Contains(op.cmd, "UNSUB") {
+				delete(m, nameStr(name))
+			} else {
+				m[nameStr(name)] = true
+			}
+		}
+	}
+}
+
+// opMap returns the set of names which the given op affects
+func (s *Subscriber) opMap(op *subOp) map[string]bool {
+	switch op.cmd {
+	case "PSUBSCRIBE", "PUNSUBSCRIBE":
+		return s.patterns
+	case "SSUBSCRIBE", "SUNSUBSCRIBE":
+		return s.shards
+	}
+	return s.channels
+}
+
+func nameStr(name interface{}) string {
+	switch n := name.(type) {
+	case string:
+		return n
+	case []byte:
+		return string(n)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// reconnect sends an ErrorReply with the error the connection was lost to,
+// re-dials until it succeeds in re-establishing all subscriptions, and then
+// sends a ReconnectReply. It returns false if the Subscriber was closed first.
+func (s *Subscriber) reconnect(err error) bool {
+	s.sub.Client.Close()
+	if !s.send(&SubReply{Type: ErrorReply, Err: err}) {
+		return false
+	}
+	for {
+		backoff := s.InitialBackoff
+		var err error
+		for i := 0; i == 0 || i < s.MaxDialAttempts; i++ {
+			if i > 0 {
+				select {
+				case <-time.After(backoff):
+				case <-s.closeCh:
+					return false
+				}
+				if backoff *= 2; backoff > s.MaxBackoff {
+					backoff = s.MaxBackoff
+				}
+			}
+			if err = s.redial(); err == nil {
+				break
+			}
+		}
+		if err == nil {
+			break
+		}
+		if !s.send(&SubReply{Type: ErrorReply, Err: err}) {
+			return false
+		}
+	}
+
+	// The pending ops were all applied by the replay
+	s.lock.Lock()
+	ops := s.pending
+	s.pending = nil
+	s.lock.Unlock()
+	for _, op := range ops {
+		op.errCh <- nil
+	}
+
+	return s.send(&SubReply{Type: ReconnectReply})
+}
+
+// redial dials a new connection and re-subscribes to everything on it
+func (s *Subscriber) redial() error {
+	client, err := redis.Dial(s.network, s.addr)
+	if err != nil {
+		return err
+	}
+	sub := NewSubClient(client)
+
+	s.lock.Lock()
+	s.trackPending()
+	s.lock.Unlock()
+
+	if err := replay(sub.Subscribe, s.channels); err != nil {
+		client.Close()
+		return err
+	}
+	if err := replay(sub.PSubscribe, s.patterns); err != nil {
+		client.Close()
+		return err
+	}
+	if err := replay(sub.SSubscribe, s.shards); err != nil {
+		client.Close()
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		client.Close()
+		return ErrSubscriberClosed
+	}
+	s.sub = sub
+	s.conn = client.Conn
+	return nil
+}
+
+func replay(fn func(...interface{}) *SubReply, m map[string]bool) error {
+	if len(m) == 0 {
+		return nil
+	}
+	names := make([]interface{}, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return fn(names...).Err
+}
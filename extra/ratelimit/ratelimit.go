@@ -0,0 +1,141 @@
+// The ratelimit package implements rate limiters whose state is kept in redis,
+// so that a limit can be shared by every instance of a program. Each check is
+// a single lua script call, which makes it atomic, and uses the server's clock,
+// so the instances' clocks don't need to agree.
+//
+// Two algorithms are available: a sliding window, which allows at most a
+// fixed number of calls within any window of time, and a token bucket, which
+// allows a steady rate of calls along with bursts of up to a given size.
+//
+// Both take a redis.Cmder, so they can be used with a *redis.Client,
+// *pool.Pool or *cluster.Cluster alike. Each key's state is kept under that key
+// alone, and expires once it's no longer relevant.
+package ratelimit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Limiter is implemented by SlidingWindow and TokenBucket
+type Limiter interface {
+	// Allow records a call under the given key if the limit allows it. If not,
+	// it returns false along with how long to wait before a call is next
+	// likely to be allowed.
+	Allow(key string) (bool, time.Duration, error)
+}
+
+// timePrelude sets now to the server's time in microseconds. Scripts must be
+// replicated by their effects rather than verbatim (the default from redis 5
+// on) in order to call TIME.
+const timePrelude = `
+	if redis.replicate_commands then
+		redis.replicate_commands()
+	end
+	local t = redis.call("TIME")
+	local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+`
+
+// KEYS[1] = key, ARGV = limit, window (us), unique member
+var slidingWindowScript = redis.NewScript(1, timePrelude+`
+	local limit = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+	if redis.call("ZCARD", KEYS[1]) < limit then
+		redis.call("ZADD", KEYS[1], now, ARGV[3])
+		redis.call("PEXPIRE", KEYS[1], math.ceil(window / 1000))
+		return {1, 0}
+	end
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	return {0, tonumber(oldest[2]) + window - now}
+`)
+
+// KEYS[1] = key, ARGV = rate (tokens/us), burst
+var tokenBucketScript = redis.NewScript(1, timePrelude+`
+	local rate = tonumber(ARGV[1])
+	local burst = tonumber(ARGV[2])
+	local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+	local tokens = tonumber(state[1]) or burst
+	local ts = tonumber(state[2]) or now
+	tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+	local allowed, retry = 0, 0
+	if tokens >= 1 then
+		tokens = tokens - 1
+		allowed = 1
+	else
+		retry = math.ceil((1 - tokens) / rate)
+	end
+	redis.call("HMSET", KEYS[1], "tokens", tokens, "ts", now)
+	redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate / 1000) + 1)
+	return {allowed, retry}
+`)
+
+// SlidingWindow allows at most a limited number of calls per key within any
+// window of time. Every allowed call is kept in a sorted set until it falls
+// out of the window, so memory use grows with the limit.
+type SlidingWindow struct {
+	c      redis.Cmder
+	limit  int
+	window time.Duration
+}
+
+// NewSlidingWindow returns a SlidingWindow which performs its calls using the
+// given Cmder
+func NewSlidingWindow(c redis.Cmder, limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{c: c, limit: limit, window: window}
+}
+
+// Allow implements the method for the Limiter interface
+func (s *SlidingWindow) Allow(key string) (bool, time.Duration, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return false, 0, err
+	}
+	r := slidingWindowScript.Cmd(s.c, key, s.limit, int64(s.window/time.Microsecond), hex.EncodeToString(b))
+	return parseResult(r)
+}
+
+// TokenBucket allows a steady rate of calls per key, along with bursts of up
+// to a given size once enough time has passed without any. Only a couple of
+// numbers are kept per key.
+type TokenBucket struct {
+	c     redis.Cmder
+	rate  float64
+	burst int
+}
+
+// NewTokenBucket returns a TokenBucket which performs its calls using the given
+// Cmder. The rate is in calls per second.
+func NewTokenBucket(c redis.Cmder, rate float64, burst int) *TokenBucket {
+	return &TokenBucket{c: c, rate: rate, burst: burst}
+}
+
+// Allow implements the method for the Limiter interface
+func (t *TokenBucket) Allow(key string) (bool, time.Duration, error) {
+	r := tokenBucketScript.Cmd(t.c, key, t.rate/1e6, t.burst)
+	return parseResult(r)
+}
+
+// parseResult parses the {allowed, retry after (us)} reply of either script
+func parseResult(r *redis.Reply) (bool, time.Duration, error) {
+	if r.Err != nil {
+		return false, 0, r.Err
+	}
+	if len(r.Elems) != 2 {
+		return false, 0, errors.New("unexpected rate limit script reply")
+	}
+	allowed, err := r.Elems[0].Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	retry, err := r.Elems[1].Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed == 1, time.Duration(retry) * time.Microsecond, nil
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func dial(t *T) *redis.Client {
+	c, err := redis.DialTimeout("tcp", "127.0.0.1:6379", 10*time.Second)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestSlidingWindow(t *T) {
+	c := dial(t)
+	defer c.Close()
+	key := "ratelimit-test:sliding"
+	c.Cmd("DEL", key)
+	defer c.Cmd("DEL", key)
+
+	l := NewSlidingWindow(c, 2, 200*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		ok, _, err := l.Allow(key)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	ok, retry, err := l.Allow(key)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.True(t, retry > 0 && retry <= 200*time.Millisecond)
+
+	time.Sleep(retry)
+	ok, _, err = l.Allow(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestTokenBucket(t *T) {
+	c := dial(t)
+	defer c.Close()
+	key := "ratelimit-test:bucket"
+	c.Cmd("DEL", key)
+	defer c.Cmd("DEL", key)
+
+	// A burst of 3, then one every 100ms
+	var l Limiter = NewTokenBucket(c, 10, 3)
+	for i := 0; i < 3; i++ {
+		ok, _, err := l.Allow(key)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	ok, retry, err := l.Allow(key)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.True(t, retry > 0 && retry <= 100*time.Millisecond)
+
+	time.Sleep(retry)
+	ok, _, err = l.Allow(key)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,234 @@
+// The redistest package implements an in-process server which speaks enough
+// RESP to stand in for redis in tests. Rather than storing any data it replies
+// to each command with whatever Response it's been given for it, which can
+// also be a fault such as a delay, garbage bytes or a dropped connection, and
+// it records every command it receives so that tests can check them
+// afterwards. This makes it possible to test things like reconnects and pool
+// behavior deterministically, without a real redis. It's built on the server
+// package, which can be used directly for fakes which need real behavior.
+//
+//	s, err := redistest.NewServer()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer s.Close()
+//
+//	s.Handle("GET", redistest.Reply("bar"), redistest.Drop())
+//	c, err := redis.Dial("tcp", s.Addr)
+//	...
+//
+// Commands which have no Response reply with an "ERR unknown command" error,
+// other than PING which replies with PONG. CLIENT SETINFO, which Clients send
+// as they connect if DialOpts.SetLibInfo is set, always replies with OK and
+// isn't recorded, so that only the commands a test performs itself are.
+package redistest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/extra/server"
+	"github.com/fzzy/radix/redis/resp"
+)
+
+// Response is what the Server does in reply to a command
+type Response struct {
+	raw   []byte
+	delay time.Duration
+	drop  bool
+}
+
+// Reply returns a Response which replies with the given value, encoded as
+// resp.WriteArbitrary does: strings and []byte as bulk strings, ints as
+// integers, nil as a nil bulk string, errors as errors and slices as arrays.
+func Reply(v interface{}) Response {
+	buf := new(bytes.Buffer)
+	if err := resp.WriteArbitrary(buf, v); err != nil {
+		panic(err)
+	}
+	return Response{raw: buf.Bytes()}
+}
+
+// Status returns a Response which replies with the given simple string, e.g.
+// "OK"
+func Status(s string) Response {
+	return Response{raw: []byte("+" + s + "\r\n")}
+}
+
+// Error returns a Response which replies with an error with the given message
+func Error(msg string) Response {
+	return Reply(errors.New(msg))
+}
+
+// Raw returns a Response which writes the given bytes as-is, e.g. garbage
+// which isn't valid RESP at all
+func Raw(b []byte) Response {
+	return Response{raw: b}
+}
+
+// Drop returns a Response which closes the connection instead of replying
+func Drop() Response {
+	return Response{drop: true}
+}
+
+// After returns a copy of the Response which waits for the given duration
+// before replying (or dropping the connection)
+func (r Response) After(d time.Duration) Response {
+	r.delay = d
+	return r
+}
+
+// Server is an in-process RESP server, see the package docs. Its methods are
+// safe to use from multiple routines at once.
+type Server struct {
+	// The address the server is listening on, to be passed to redis.Dial and
+	// friends along with "tcp"
+	Addr string
+
+	srv *server.Server
+
+	lock      sync.Mutex
+	responses map[string][]Response
+	cmds      [][]string
+	accepted  int
+}
+
+// NewServer starts a Server listening on a random port on localhost
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:      l.Addr().String(),
+		responses: map[string][]Response{},
+	}
+	s.srv = server.New(s.handle)
+	go s.srv.Serve(&countingListener{Listener: l, s: s})
+	return s, nil
+}
+
+// countingListener keeps count of the connections the Server has accepted
+type countingListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.s.lock.Lock()
+		l.s.accepted++
+		l.s.lock.Unlock()
+	}
+	return conn, err
+}
+
+// Handle sets the Responses to the given command (e.g. "GET"), replacing any
+// set previously. They're used one after the other as the command is
+// received, on any connection, with the last one being repeated from then on.
+func (s *Server) Handle(cmd string, rs ...Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responses[strings.ToUpper(cmd)] = rs
+}
+
+// Cmds returns every command the Server has received, in order, as the command
+// name followed by its arguments
+func (s *Server) Cmds() [][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([][]string(nil), s.cmds...)
+}
+
+// ExpectCmds returns an error describing the difference if the commands the
+// Server has received (see Cmds) aren't exactly the given ones, each given as
+// a single space separated string such as "SET foo bar"
+func (s *Server) ExpectCmds(cmds ...string) error {
+	got := s.Cmds()
+	for i := 0; i < len(got) || i < len(cmds); i++ {
+		var g, e string
+		if i < len(got) {
+			g = strings.Join(got[i], " ")
+		}
+		if i < len(cmds) {
+			e = cmds[i]
+		}
+		if i >= len(got) {
+			return fmt.Errorf("command %d: expected %q, got nothing", i, e)
+		} else if i >= len(cmds) {
+			return fmt.Errorf("command %d: expected nothing, got %q", i, g)
+		} else if g != e {
+			return fmt.Errorf("command %d: expected %q, got %q", i, e, g)
+		}
+	}
+	return nil
+}
+
+// Reset forgets the commands the Server has received so far
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cmds = nil
+}
+
+// Accepted returns the number of connections the Server has accepted, so that
+// tests can check whether a client reconnected
+func (s *Server) Accepted() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.accepted
+}
+
+// DropConns closes every open connection, as if the server had gone away
+// without going down for good
+func (s *Server) DropConns() {
+	s.srv.CloseConns()
+}
+
+// Close stops the Server, closing its listener and every open connection, and
+// waits for its routines to exit
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+func (s *Server) handle(c *server.Conn, cmd string, args []string) interface{} {
+	res := s.respond(append([]string{cmd}, args...))
+	if res.delay > 0 {
+		time.Sleep(res.delay)
+	}
+	if res.drop {
+		c.Close()
+	}
+	return server.Raw(res.raw)
+}
+
+// respond records the command and returns the Response to it
+func (s *Server) respond(cmd []string) Response {
+	if len(cmd) > 1 && strings.EqualFold(cmd[0], "CLIENT") && strings.EqualFold(cmd[1], "SETINFO") {
+		return Status("OK")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cmds = append(s.cmds, cmd)
+
+	name := strings.ToUpper(cmd[0])
+	rs := s.responses[name]
+	if len(rs) == 0 {
+		if name == "PING" {
+			return Status("PONG")
+		}
+		return Error(fmt.Sprintf("ERR unknown command '%s'", cmd[0]))
+	}
+	res := rs[0]
+	if len(rs) > 1 {
+		s.responses[name] = rs[1:]
+	}
+	return res
+}
@@ -0,0 +1,93 @@
+package redistest
+
+import (
+	"errors"
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func newServer(t *T) *Server {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServer(t *T) {
+	s := newServer(t)
+	defer s.Close()
+	s.Handle("GET", Reply("bar"), Reply(nil))
+	s.Handle("set", Status("OK"))
+	s.Handle("MGET", Reply([]interface{}{"a", nil, 1}))
+	s.Handle("INCR", Error("ERR value is not an integer"))
+
+	c, err := redis.Dial("tcp", s.Addr)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	v, err := c.Cmd("GET", "foo").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", v)
+	assert.Equal(t, redis.NilReply, c.Cmd("GET", "foo").Type)
+	assert.Equal(t, redis.NilReply, c.Cmd("GET", "foo").Type)
+	assert.Nil(t, c.Cmd("SET", "foo", 1).Err)
+	assert.Equal(t, 3, len(c.Cmd("MGET", "a", "b", "c").Elems))
+	assert.Equal(t, "ERR value is not an integer", c.Cmd("INCR", "foo").Err.Error())
+	assert.Nil(t, c.Cmd("PING").Err)
+	assert.NotNil(t, c.Cmd("NOPE").Err)
+
+	assert.Nil(t, s.ExpectCmds(
+		"GET foo", "GET foo", "GET foo", "SET foo 1", "MGET a b c", "INCR foo",
+		"PING", "NOPE",
+	))
+	assert.NotNil(t, s.ExpectCmds("GET foo"))
+	s.Reset()
+	assert.Nil(t, s.ExpectCmds())
+}
+
+func TestFaults(t *T) {
+	s := newServer(t)
+	defer s.Close()
+
+	c, err := redis.DialTimeout("tcp", s.Addr, 100*time.Millisecond)
+	assert.Nil(t, err)
+	s.Handle("GET", Reply("bar").After(time.Second))
+	assert.True(t, redis.IsTimeout(c.Cmd("GET", "foo").Err))
+	c.Close()
+
+	c, err = redis.Dial("tcp", s.Addr)
+	assert.Nil(t, err)
+	s.Handle("GET", Raw([]byte("?garbage\r\n")))
+	assert.True(t, redis.IsNetworkErr(c.Cmd("GET", "foo").Err))
+	c.Close()
+
+	c, err = redis.Dial("tcp", s.Addr)
+	assert.Nil(t, err)
+	s.Handle("GET", Drop())
+	assert.True(t, redis.IsNetworkErr(c.Cmd("GET", "foo").Err))
+	c.Close()
+	assert.Equal(t, 3, s.Accepted())
+}
+
+func TestReconnect(t *T) {
+	s := newServer(t)
+	defer s.Close()
+
+	p, err := redis.DialPersistent("tcp", s.Addr)
+	assert.Nil(t, err)
+	defer p.Close()
+	p.InitialBackoff = time.Millisecond
+
+	s.Handle("SELECT", Status("OK"))
+	assert.Nil(t, p.Cmd("SELECT", 2).Err)
+	s.DropConns()
+	assert.True(t, redis.IsNetworkErr(p.Cmd("PING").Err))
+	assert.Nil(t, p.Cmd("PING").Err)
+
+	assert.Equal(t, 2, s.Accepted())
+	assert.Nil(t, s.ExpectCmds("SELECT 2", "SELECT 2", "PING"))
+	assert.Equal(t, errors.New(`command 3: expected "PING", got nothing`), s.ExpectCmds("SELECT 2", "SELECT 2", "PING", "PING"))
+}
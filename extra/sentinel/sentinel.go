@@ -183,14 +183,14 @@ func (c *Client) spin() {
 
 		case sm := <-c.switchMasterCh:
 			if p, ok := c.masterPools[sm.name]; ok {
-				p.Empty()
+				p.Close()
 				p = pool.NewOrEmptyPool("tcp", sm.addr, c.poolSize)
 				c.masterPools[sm.name] = p
 			}
 
 		case <-c.closeCh:
 			for name := range c.masterPools {
-				c.masterPools[name].Empty()
+				c.masterPools[name].Close()
 			}
 			c.subClient.Client.Close()
 			close(c.getCh)
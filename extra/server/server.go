@@ -0,0 +1,244 @@
+// The server package is a minimal framework for building services which speak
+// the redis protocol, such as sidecars, shims in front of other datastores, or
+// fakes for testing. It takes care of accepting connections and reading and
+// writing RESP (using the resp package), leaving only the commands themselves
+// to a HandlerFunc:
+//
+//	s := server.New(func(c *server.Conn, cmd string, args []string) interface{} {
+//		switch strings.ToUpper(cmd) {
+//		case "PING":
+//			return resp.NewSimpleString("PONG")
+//		case "ECHO":
+//			if len(args) != 1 {
+//				return errors.New("ERR wrong number of arguments for 'echo' command")
+//			}
+//			return args[0]
+//		}
+//		return fmt.Errorf("ERR unknown command '%s'", cmd)
+//	})
+//	l, err := net.Listen("tcp", ":6380")
+//	if err != nil {
+//		// handle error
+//	}
+//	err = s.Serve(l)
+//
+// Commands on a single connection are handled one after the other, and their
+// replies are written in the same order. Pipelined commands have their replies
+// flushed together.
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fzzy/radix/redis/resp"
+)
+
+// ErrServerClosed is returned by Serve once the Server has been closed
+var ErrServerClosed = errors.New("server closed")
+
+// HandlerFunc handles a single command sent on the given connection, returning
+// its reply. The reply is written using resp.WriteArbitrary, so strings and
+// []byte are written as bulk strings, ints as integers, nil as a nil bulk
+// string, errors as errors (whose message should start with an error code such
+// as "ERR"), and slices and maps as arrays. Messages (e.g. from
+// resp.NewSimpleString) and Raw are written as-is.
+//
+// If the handler closes the connection nothing is written. The handler is
+// called from the connection's own routine, so handlers for different
+// connections may be called at once.
+type HandlerFunc func(c *Conn, cmd string, args []string) interface{}
+
+// Raw is a reply which is written exactly as given, without being encoded
+type Raw []byte
+
+// Conn is a connection to a Server
+type Conn struct {
+	// The underlying connection. Don't read from or write to it.
+	net.Conn
+
+	// Data can be used by the handler to keep per-connection state, e.g. the
+	// selected database. It's only ever accessed from the connection's own
+	// routine.
+	Data interface{}
+
+	closeOnce sync.Once
+	closed    int32
+
+	// held while writing to w
+	wlock sync.Mutex
+	w     *bufio.Writer
+}
+
+// Close closes the connection. It's safe to call more than once.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		atomic.StoreInt32(&c.closed, 1)
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// Push writes the given reply to the connection straight away, outside of the
+// usual command and reply cycle, as redis does with RESP3 push messages such
+// as client tracking invalidations. It's written as a HandlerFunc's reply is,
+// so a push is usually given as Raw. Push may be called from any routine.
+func (c *Conn) Push(reply interface{}) error {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	if err := c.write(reply); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// write must be called with wlock held
+func (c *Conn) write(reply interface{}) error {
+	if raw, ok := reply.(Raw); ok {
+		_, err := c.w.Write(raw)
+		return err
+	}
+	return resp.WriteArbitrary(c.w, reply)
+}
+
+// Server serves redis protocol connections using a HandlerFunc
+type Server struct {
+	handler HandlerFunc
+
+	lock      sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[*Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a Server which handles commands using the given HandlerFunc
+func New(handler HandlerFunc) *Server {
+	return &Server{
+		handler:   handler,
+		listeners: map[net.Listener]bool{},
+		conns:     map[*Conn]bool{},
+	}
+}
+
+// Serve accepts connections on the given listener, serving each in its own
+// routine, until the listener fails or the Server is closed (in which case
+// ErrServerClosed is returned). The listener is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.wg.Add(1)
+	s.lock.Unlock()
+	defer s.wg.Done()
+
+	defer l.Close()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			delete(s.listeners, l)
+			if s.closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c := &Conn{Conn: nc, w: bufio.NewWriter(nc)}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			nc.Close()
+			continue
+		}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.lock.Unlock()
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c *Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, c)
+		s.lock.Unlock()
+		c.Close()
+	}()
+
+	r := resp.NewReader(c.Conn)
+	for {
+		m, err := r.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var reply interface{}
+		if cmd, err := readCmd(m); err != nil {
+			reply = err
+		} else if reply = s.handler(c, cmd[0], cmd[1:]); atomic.LoadInt32(&c.closed) == 1 {
+			return
+		}
+		c.wlock.Lock()
+		err = c.write(reply)
+
+		// Only flush once there are no more pipelined commands waiting
+		if err == nil && r.Buffered() == 0 {
+			err = c.w.Flush()
+		}
+		c.wlock.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readCmd returns the command name and arguments held by an array of bulk
+// strings
+func readCmd(m *resp.Message) ([]string, error) {
+	ms, err := m.Array()
+	if err != nil || len(ms) == 0 {
+		return nil, errors.New("ERR Protocol error: expected a non-empty array")
+	}
+	cmd := make([]string, len(ms))
+	for i := range ms {
+		if cmd[i], err = ms[i].Str(); err != nil {
+			return nil, errors.New("ERR Protocol error: expected bulk strings")
+		}
+	}
+	return cmd, nil
+}
+
+// CloseConns closes every open connection, without stopping the Server from
+// accepting new ones
+func (s *Server) CloseConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Close stops the Server, closing its listeners and every open connection,
+// and waits for all of its routines (and so handlers) to return
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.lock.Unlock()
+	s.CloseConns()
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fzzy/radix/redis"
+	"github.com/fzzy/radix/redis/resp"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	. "testing"
+)
+
+func newServer(t *T) (*Server, string) {
+	s := New(func(c *Conn, cmd string, args []string) interface{} {
+		switch strings.ToUpper(cmd) {
+		case "PING":
+			return resp.NewSimpleString("PONG")
+		case "ECHO":
+			if len(args) != 1 {
+				return errors.New("ERR wrong number of arguments for 'echo' command")
+			}
+			return args[0]
+		case "INCR":
+			n, _ := c.Data.(int)
+			c.Data = n + 1
+			return n + 1
+		case "QUIT":
+			c.Close()
+			return nil
+		}
+		return fmt.Errorf("ERR unknown command '%s'", cmd)
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func TestServer(t *T) {
+	s, addr := newServer(t)
+	defer s.Close()
+
+	c, err := redis.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	r := c.Cmd("PING")
+	assert.Equal(t, redis.StatusReply, r.Type)
+	v, err := c.Cmd("ECHO", "foo").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", v)
+	assert.Equal(t, "ERR wrong number of arguments for 'echo' command", c.Cmd("ECHO").Err.Error())
+	assert.NotNil(t, c.Cmd("NOPE").Err)
+
+	// Each connection has its own Data
+	c2, err := redis.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer c2.Close()
+	for i := 1; i <= 3; i++ {
+		n, err := c.Cmd("INCR").Int()
+		assert.Nil(t, err)
+		assert.Equal(t, i, n)
+	}
+	n, err := c2.Cmd("INCR").Int()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	// Pipelined replies come back in order
+	p := c.Pipeline()
+	for i := 0; i < 100; i++ {
+		p.Queue("ECHO", i)
+	}
+	assert.Nil(t, p.Send())
+	for i := 0; i < 100; i++ {
+		n, err := p.ReadReply().Int()
+		assert.Nil(t, err)
+		assert.Equal(t, i, n)
+	}
+
+	assert.True(t, redis.IsNetworkErr(c2.Cmd("QUIT").Err))
+}
+
+func TestClose(t *T) {
+	s, addr := newServer(t)
+	c, err := redis.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Nil(t, c.Cmd("PING").Err)
+
+	s.Close()
+	assert.True(t, redis.IsNetworkErr(c.Cmd("PING").Err))
+	_, err = redis.Dial("tcp", addr)
+	assert.NotNil(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Equal(t, ErrServerClosed, s.Serve(l))
+}
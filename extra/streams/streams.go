@@ -0,0 +1,386 @@
+// The streams package implements consuming from a redis stream as part of a
+// consumer group. A GroupReader takes care of creating the group, reading new
+// entries with XREADGROUP, acknowledging them with XACK and claiming the
+// entries of consumers which have gone away with XAUTOCLAIM, which requires
+// redis 6.2 or later.
+package streams
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+)
+
+// Defaults used for the GroupOpts fields which aren't set
+const (
+	DefaultCount         = 10
+	DefaultBlock         = 2 * time.Second
+	DefaultClaimInterval = 30 * time.Second
+	DefaultMinIdle       = time.Minute
+)
+
+// GroupOpts describe the stream and consumer group a GroupReader reads from
+type GroupOpts struct {
+	// The stream and the name of the group. The group (and the stream, if need
+	// be) is created if it doesn't exist, starting at StartID, which defaults
+	// to "$" (only entries added from then on).
+	Stream  string
+	Group   string
+	StartID string
+
+	// Name of this consumer, which must be unique within the group. Defaults
+	// to a random string. Using a name which stays the same across restarts
+	// means entries which were read but not acknowledged before a restart are
+	// delivered again straight away, rather than once they're claimed.
+	Consumer string
+
+	// Maximum number of entries read per XREADGROUP or XAUTOCLAIM call.
+	// Defaults to DefaultCount.
+	Count int
+
+	// How long each XREADGROUP call blocks waiting for new entries. Close
+	// waits for the current call to return, so this shouldn't be too long.
+	// Defaults to DefaultBlock.
+	Block time.Duration
+
+	// How often entries which other consumers have left pending for longer
+	// than MinIdle are claimed. Defaults to DefaultClaimInterval and
+	// DefaultMinIdle. Claiming is disabled if ClaimInterval is negative.
+	ClaimInterval time.Duration
+	MinIdle       time.Duration
+}
+
+// Entry is a single stream entry read by a GroupReader
+type Entry struct {
+	ID     string
+	Fields map[string]string
+
+	// Set if the entry was claimed from another consumer, rather than read
+	// from the stream
+	Claimed bool
+
+	// Set if the GroupReader encountered an error, in which case this isn't an
+	// actual entry. The GroupReader keeps going regardless, after backing off.
+	Err error
+}
+
+// GroupReader reads entries from a stream as a member of a consumer group and
+// delivers them on a channel. Entries must be acknowledged using Ack once
+// they've been processed, otherwise they stay pending and are eventually
+// claimed by another consumer in the group.
+//
+// When started, a GroupReader first delivers any entries which are still
+// pending for its consumer from before, and then moves on to new ones.
+type GroupReader struct {
+	// All entries are sent on this channel, which is closed once the
+	// GroupReader has been closed
+	Ch chan *Entry
+
+	opts      GroupOpts
+	client    *redis.PersistentClient
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	doneCh    chan struct{}
+
+	// ackClient is used for Ack, since client spends most of its time blocked
+	ackLock   sync.Mutex
+	ackClient *redis.PersistentClient
+}
+
+// NewGroupReader connects to the given redis instance, creating the consumer
+// group if need be, and starts reading entries from the stream. Two
+// connections are used, one for reading and claiming and one for Ack.
+func NewGroupReader(network, addr string, opts GroupOpts) (*GroupReader, error) {
+	if opts.Stream == "" || opts.Group == "" {
+		return nil, errors.New("stream and group must be given")
+	}
+	if opts.StartID == "" {
+		opts.StartID = "$"
+	}
+	if opts.Consumer == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		opts.Consumer = hex.EncodeToString(b)
+	}
+	if opts.Count <= 0 {
+		opts.Count = DefaultCount
+	}
+	if opts.Block <= 0 {
+		opts.Block = DefaultBlock
+	}
+	if opts.ClaimInterval == 0 {
+		opts.ClaimInterval = DefaultClaimInterval
+	}
+	if opts.MinIdle <= 0 {
+		opts.MinIdle = DefaultMinIdle
+	}
+
+	client, err := redis.DialPersistent(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	ackClient, err := redis.DialPersistent(network, addr)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	r := &GroupReader{
+		Ch:        make(chan *Entry),
+		opts:      opts,
+		client:    client,
+		closeCh:   make(chan struct{}),
+		doneCh:    make(chan struct{}),
+		ackClient: ackClient,
+	}
+	if err := r.createGroup(); err != nil {
+		client.Close()
+		ackClient.Close()
+		return nil, err
+	}
+	go r.spin()
+	return r, nil
+}
+
+// createGroup creates the consumer group, doing nothing if it already exists
+func (r *GroupReader) createGroup() error {
+	err := r.client.Cmd("XGROUP", "CREATE", r.opts.Stream, r.opts.Group, r.opts.StartID, "MKSTREAM").Err
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// Ack acknowledges the entries with the given ids as processed, removing them
+// from the group's pending entries
+func (r *GroupReader) Ack(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids)+2)
+	args = append(args, r.opts.Stream, r.opts.Group)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	r.ackLock.Lock()
+	defer r.ackLock.Unlock()
+	return r.ackClient.Cmd("XACK", args...).Err
+}
+
+func (r *GroupReader) isClosed() bool {
+	select {
+	case <-r.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// send sends the entry on Ch, returning false if the GroupReader was closed
+// first
+func (r *GroupReader) send(e *Entry) bool {
+	select {
+	case r.Ch <- e:
+		return true
+	case <-r.closeCh:
+		return false
+	}
+}
+
+// fail sends the error on Ch and backs off, returning false if the GroupReader
+// was closed in the meantime
+func (r *GroupReader) fail(err error) bool {
+	if !r.send(&Entry{Err: err}) {
+		return false
+	}
+	select {
+	case <-time.After(redis.DefaultInitialBackoff):
+		return true
+	case <-r.closeCh:
+		return false
+	}
+}
+
+func (r *GroupReader) spin() {
+	defer close(r.doneCh)
+	defer close(r.Ch)
+	defer r.client.Close()
+
+	// Entries still pending for this consumer are read from the start of the
+	// group's history, and then new ones using ">"
+	id := "0"
+	lastClaim := time.Now()
+	for !r.isClosed() {
+		if r.opts.ClaimInterval > 0 && time.Since(lastClaim) >= r.opts.ClaimInterval {
+			lastClaim = time.Now()
+			if !r.claim() {
+				return
+			}
+			continue
+		}
+
+		args := []interface{}{"GROUP", r.opts.Group, r.opts.Consumer, "COUNT", r.opts.Count}
+		if id == ">" {
+			args = append(args, "BLOCK", int64(r.opts.Block/time.Millisecond))
+		}
+		args = append(args, "STREAMS", r.opts.Stream, id)
+		reply := r.client.Cmd("XREADGROUP", args...)
+		if reply.Err != nil && strings.HasPrefix(reply.Err.Error(), "NOGROUP") {
+			// The stream or group was deleted from under us
+			if err := r.createGroup(); err != nil && !r.fail(err) {
+				return
+			}
+			continue
+		} else if reply.Err != nil {
+			if !r.fail(reply.Err) {
+				return
+			}
+			continue
+		}
+
+		entries, err := parseReadReply(reply)
+		if err != nil {
+			if !r.fail(err) {
+				return
+			}
+			continue
+		}
+		if id != ">" {
+			if len(entries) == 0 {
+				id = ">"
+			} else {
+				id = entries[len(entries)-1].ID
+			}
+		}
+		if !r.deliver(entries) {
+			return
+		}
+	}
+}
+
+// claim claims all entries which other consumers have left pending for longer
+// than MinIdle, and delivers them. It returns false if the GroupReader was
+// closed in the meantime.
+func (r *GroupReader) claim() bool {
+	minIdle := int64(r.opts.MinIdle / time.Millisecond)
+	cursor := "0-0"
+	for {
+		reply := r.client.Cmd("XAUTOCLAIM", r.opts.Stream, r.opts.Group, r.opts.Consumer,
+			minIdle, cursor, "COUNT", r.opts.Count)
+		if reply.Err != nil {
+			return r.fail(reply.Err)
+		}
+		if reply.Type != redis.MultiReply || len(reply.Elems) < 2 {
+			return r.fail(errors.New("unexpected XAUTOCLAIM reply"))
+		}
+		var err error
+		if cursor, err = reply.Elems[0].Str(); err != nil {
+			return r.fail(err)
+		}
+		entries, err := parseEntries(reply.Elems[1])
+		if err != nil {
+			return r.fail(err)
+		}
+		// Since redis 7 the ids of deleted entries are returned separately,
+		// and they're removed from the pending entries already
+		for _, e := range entries {
+			e.Claimed = true
+		}
+		if !r.deliver(entries) {
+			return false
+		}
+		if cursor == "0-0" {
+			return true
+		}
+	}
+}
+
+// deliver sends the entries on Ch, and acknowledges the ones which have been
+// deleted from the stream (those without Fields), which would otherwise stay
+// pending forever
+func (r *GroupReader) deliver(entries []*Entry) bool {
+	var deleted []string
+	for _, e := range entries {
+		if e.Fields == nil {
+			deleted = append(deleted, e.ID)
+		}
+	}
+	if err := r.Ack(deleted...); err != nil && !r.send(&Entry{Err: err}) {
+		return false
+	}
+	for _, e := range entries {
+		if e.Fields != nil && !r.send(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseReadReply parses the entries of the single stream in an XREADGROUP
+// reply
+func parseReadReply(r *redis.Reply) ([]*Entry, error) {
+	switch r.Type {
+	case redis.NilReply:
+		// BLOCK timed out
+		return nil, nil
+	case redis.MapReply:
+		// stream, entries, as with RESP3
+		if len(r.Elems) != 2 {
+			return nil, errors.New("unexpected XREADGROUP reply")
+		}
+		return parseEntries(r.Elems[1])
+	case redis.MultiReply:
+		// [[stream, entries]]
+		if len(r.Elems) != 1 || len(r.Elems[0].Elems) != 2 {
+			return nil, errors.New("unexpected XREADGROUP reply")
+		}
+		return parseEntries(r.Elems[0].Elems[1])
+	}
+	return nil, fmt.Errorf("unexpected XREADGROUP reply type: %d", r.Type)
+}
+
+// parseEntries parses a list of [id, [field, value...]] entries. Entries which
+// have been deleted from the stream have a nil field list, and are returned
+// with nil Fields.
+func parseEntries(r *redis.Reply) ([]*Entry, error) {
+	entries := make([]*Entry, 0, len(r.Elems))
+	for _, er := range r.Elems {
+		if len(er.Elems) != 2 {
+			return nil, errors.New("unexpected stream entry")
+		}
+		id, err := er.Elems[0].Str()
+		if err != nil {
+			return nil, err
+		}
+		e := &Entry{ID: id}
+		if er.Elems[1].Type != redis.NilReply {
+			if e.Fields, err = er.Elems[1].Hash(); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Close stops the GroupReader, waiting for the current XREADGROUP call to
+// return, and closes its connections. Entries which were read but haven't been
+// acknowledged stay pending. It's safe to call more than once.
+func (r *GroupReader) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closeCh)
+		<-r.doneCh
+
+		r.ackLock.Lock()
+		r.ackClient.Close()
+		r.ackLock.Unlock()
+	})
+}
@@ -0,0 +1,70 @@
+package streams
+
+import (
+	"github.com/fzzy/radix/redis"
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func readEntry(t *T, r *GroupReader) *Entry {
+	select {
+	case e := <-r.Ch:
+		assert.Nil(t, e.Err)
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no entry received")
+	}
+	return nil
+}
+
+func TestGroupReader(t *T) {
+	c, err := redis.DialTimeout("tcp", "127.0.0.1:6379", 10*time.Second)
+	assert.Nil(t, err)
+	defer c.Close()
+
+	stream := "streams-test"
+	c.Cmd("DEL", stream)
+	defer c.Cmd("DEL", stream)
+
+	opts := GroupOpts{
+		Stream:   stream,
+		Group:    "g",
+		Consumer: "a",
+		Block:    100 * time.Millisecond,
+	}
+	r, err := NewGroupReader("tcp", "127.0.0.1:6379", opts)
+	assert.Nil(t, err)
+
+	id, err := c.Cmd("XADD", stream, "*", "foo", "bar").Str()
+	assert.Nil(t, err)
+	e := readEntry(t, r)
+	assert.Equal(t, id, e.ID)
+	assert.Equal(t, map[string]string{"foo": "bar"}, e.Fields)
+	assert.False(t, e.Claimed)
+
+	// Without an ack the entry is delivered again after a restart
+	r.Close()
+	r, err = NewGroupReader("tcp", "127.0.0.1:6379", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, id, readEntry(t, r).ID)
+	r.Close()
+	r.Close()
+
+	// and can be claimed by another consumer once it's idle
+	opts.Consumer = "b"
+	opts.ClaimInterval = 10 * time.Millisecond
+	opts.MinIdle = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	r, err = NewGroupReader("tcp", "127.0.0.1:6379", opts)
+	assert.Nil(t, err)
+	defer r.Close()
+	e = readEntry(t, r)
+	assert.Equal(t, id, e.ID)
+	assert.True(t, e.Claimed)
+
+	assert.Nil(t, r.Ack(e.ID))
+	pending, err := c.Cmd("XPENDING", stream, "g").Elems[0].Int()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, pending)
+}
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+// Action is a unit of work performed against redis, such as a single command
+// (see Cmd) or a script (see Script.Action). It's given to a Doer, which
+// decides what it's run against: a Client runs it on its own connection, a
+// Pool on one of its connections, and a Cluster on the nodes the keys of its
+// commands belong to.
+//
+// Actions may perform any number of commands. Other than with a Cluster, they
+// are all performed on the same connection, so an Action can use MULTI/EXEC
+// (unless a PersistentClient re-dials part way through).
+type Action interface {
+	Run(c Cmder) error
+}
+
+// Doer is implemented by anything which can perform an Action, namely
+// *Client, *PersistentClient, *pool.Pool and *cluster.Cluster. Libraries built
+// on radix can take a Doer to be usable with any of them:
+//
+//	func LoadUser(db redis.Doer, id string) (*User, error) {
+//		u := new(User)
+//		if err := db.Do(redis.Cmd(u, "HGETALL", "user:"+id)); err != nil {
+//			return nil, err
+//		}
+//		return u, nil
+//	}
+type Doer interface {
+	Do(a Action) error
+}
+
+var (
+	_ Doer = (*Client)(nil)
+	_ Doer = (*PersistentClient)(nil)
+)
+
+// Do runs the Action on the connection
+func (c *Client) Do(a Action) error {
+	return a.Run(c)
+}
+
+// ActionFunc adapts a function to the Action interface
+type ActionFunc func(c Cmder) error
+
+// Run calls the function
+func (f ActionFunc) Run(c Cmder) error {
+	return f(c)
+}
+
+// Cmd returns an Action which performs the given command, decoding its reply
+// into rcv. Arguments are flattened as they are by Client.Cmd.
+//
+// rcv may be nil, in which case the reply is discarded (though an error reply
+// is still returned as an error), a **Reply, which is set to the reply itself
+// (see Client.ReuseReplies for how long that's valid), or a pointer to one
+// of: string, []byte, bool, any int, uint or float type, []string, [][]byte,
+// map[string]string, []ZMember, []StreamEntry, map[string][]StreamEntry (for
+// XREAD), []GeoLocation, or a struct (decoded using Reply.Scan). A nil reply
+// results in ErrNil, as with the Reply methods.
+func Cmd(rcv interface{}, cmd string, args ...interface{}) Action {
+	return ActionFunc(func(c Cmder) error {
+		return decodeReply(c.Cmd(cmd, args...), rcv)
+	})
+}
+
+// FlatCmd is like Cmd, but takes the key separately from the rest of the
+// arguments, which are flattened into individual arguments. Since Cmd flattens
+// its arguments as well, the two only differ in how they read:
+//
+//	redis.FlatCmd(nil, "HSET", "user:1", map[string]string{"name": "bob"})
+func FlatCmd(rcv interface{}, cmd, key string, args ...interface{}) Action {
+	return Cmd(rcv, cmd, append([]interface{}{key}, args...)...)
+}
+
+// Action returns an Action which performs the script as Cmd does, decoding its
+// reply into rcv as the Cmd function does
+func (s *Script) Action(rcv interface{}, keysAndArgs ...interface{}) Action {
+	return ActionFunc(func(c Cmder) error {
+		return decodeReply(s.Cmd(c, keysAndArgs...), rcv)
+	})
+}
+
+// decodeReply decodes the reply into rcv, see Cmd
+func decodeReply(r *Reply, rcv interface{}) error {
+	if r.Type == ErrorReply {
+		return r.Err
+	}
+
+	var err error
+	switch rcv := rcv.(type) {
+	case nil:
+	case **Reply:
+		*rcv = r
+	case *string:
+		*rcv, err = r.Str()
+	case *[]byte:
+		var b []byte
+		if b, err = r.Bytes(); err == nil {
+			*rcv = append((*rcv)[:0], b...)
+		}
+	case *int64:
+		*rcv, err = r.Int64()
+	case *int:
+		*rcv, err = r.Int()
+	case *float64:
+		*rcv, err = r.Float64()
+	case *bool:
+		*rcv, err = r.Bool()
+	case *[]string:
+		*rcv, err = r.List()
+	case *[][]byte:
+		*rcv, err = r.ListBytes()
+	case *map[string]string:
+		*rcv, err = r.Hash()
+	case *[]ZMember:
+		*rcv, err = r.ZMembers()
+	case *[]StreamEntry:
+		*rcv, err = r.StreamEntries()
+	case *map[string][]StreamEntry:
+		*rcv, err = r.Streams()
+	case *[]GeoLocation:
+		*rcv, err = r.GeoLocations()
+	default:
+		err = decodeValue(r, rcv)
+	}
+	return err
+}
+
+// decodeValue decodes the reply into the value pointed to by rcv using
+// reflection, for the types decodeReply doesn't handle itself
+func decodeValue(r *Reply, rcv interface{}) error {
+	v := reflect.ValueOf(rcv)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("rcv must be a non-nil pointer")
+	}
+	if v.Elem().Kind() == reflect.Struct && v.Elem().Type() != typeOfTime {
+		return r.Scan(rcv)
+	}
+
+	if r.Type == NilReply {
+		return ErrNil
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		if r.Type != IntegerReply {
+			return err
+		}
+		b = []byte(strconv.FormatInt(r.int, 10))
+	}
+	return setField(v.Elem(), b)
+}
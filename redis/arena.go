@@ -0,0 +1,79 @@
+package redis
+
+// Replies and Elems held onto by a replyArena beyond this many are let go of on
+// reset, so that a single very large reply doesn't pin its memory for good
+const maxArenaReplies = 4096
+
+// replyArena hands out the Replies read by a Client with ReuseReplies set.
+// While active every Reply it hands out since the last reset is a new one,
+// and after a reset they're handed out again in the same order. A nil or
+// inactive replyArena allocates every Reply afresh.
+type replyArena struct {
+	active bool
+
+	replies []*Reply
+	n       int // replies handed out since the last reset
+
+	// Elems are sub-sliced out of this, a new one being allocated whenever it
+	// runs out
+	elems []*Reply
+	e     int
+}
+
+// reset makes the arena active, and makes every Reply handed out so far
+// available to be handed out again
+func (a *replyArena) reset() {
+	a.active = true
+	a.n, a.e = 0, 0
+	if len(a.replies) > maxArenaReplies {
+		a.replies = nil
+	}
+	if len(a.elems) > maxArenaReplies {
+		a.elems = nil
+	}
+}
+
+func (a *replyArena) newReply() *Reply {
+	if a == nil || !a.active {
+		return &Reply{}
+	}
+	if a.n == len(a.replies) {
+		a.replies = append(a.replies, &Reply{})
+	}
+	r := a.replies[a.n]
+	a.n++
+	*r = Reply{}
+	return r
+}
+
+func (a *replyArena) newElems(n int) []*Reply {
+	if a == nil || !a.active {
+		return make([]*Reply, n)
+	}
+	if a.e+n > len(a.elems) {
+		size := len(a.elems) * 2
+		if size < n {
+			size = n
+		}
+		a.elems = make([]*Reply, size)
+		a.e = 0
+	}
+	// The capacity is capped so that appending to Elems never overwrites
+	// those of another Reply
+	es := a.elems[a.e : a.e+n : a.e+n]
+	a.e += n
+	return es
+}
+
+// clone returns a deep copy of the Reply, which doesn't share any Replies
+// with it
+func (r *Reply) clone() *Reply {
+	cp := *r
+	if r.Elems != nil {
+		cp.Elems = make([]*Reply, len(r.Elems))
+		for i := range r.Elems {
+			cp.Elems[i] = r.Elems[i].clone()
+		}
+	}
+	return &cp
+}
@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"sync"
+
+	"github.com/fzzy/radix/redis/resp"
+)
+
+// Defaults used by NewArgCache when given zero values
+const (
+	DefaultArgCacheSize   = 1024
+	DefaultArgCacheMaxLen = 128
+)
+
+// ArgCache keeps the encoded form of command names and string arguments which
+// are written over and over, such as the same few hundred keys being
+// incremented, so that they aren't re-encoded (and re-allocated) every time.
+// Set it as the ArgCache of a Client, or of a pool.Pool to share it between
+// all of the pool's connections. It's safe to use from multiple routines at
+// once.
+//
+// Strings are cached the first time they're seen, as long as they're no longer
+// than the cache's maximum length, until the cache is full. Nothing is evicted
+// afterwards, so it suits workloads with a small, stable set of keys; ones
+// which go through many distinct keys fill it with whatever came first.
+type ArgCache struct {
+	size, maxLen int
+
+	lock sync.RWMutex
+	m    map[string]*resp.Message
+}
+
+// NewArgCache returns an ArgCache holding up to size strings of up to maxLen
+// bytes each. Zero values mean DefaultArgCacheSize and DefaultArgCacheMaxLen.
+func NewArgCache(size, maxLen int) *ArgCache {
+	if size <= 0 {
+		size = DefaultArgCacheSize
+	}
+	if maxLen <= 0 {
+		maxLen = DefaultArgCacheMaxLen
+	}
+	return &ArgCache{size: size, maxLen: maxLen, m: map[string]*resp.Message{}}
+}
+
+// Len returns the number of strings in the cache
+func (ac *ArgCache) Len() int {
+	ac.lock.RLock()
+	defer ac.lock.RUnlock()
+	return len(ac.m)
+}
+
+// get returns the encoded form of s, or nil if it isn't and can't be cached
+func (ac *ArgCache) get(s string) *resp.Message {
+	if len(s) > ac.maxLen {
+		return nil
+	}
+	ac.lock.RLock()
+	m, ok := ac.m[s]
+	full := len(ac.m) >= ac.size
+	ac.lock.RUnlock()
+	if ok || full {
+		return m
+	}
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	if m, ok = ac.m[s]; !ok && len(ac.m) < ac.size {
+		m = resp.NewBulkStr([]byte(s))
+		ac.m[s] = m
+	}
+	return m
+}
+
+// args returns the command and its arguments ready to be written, with any
+// which are cached replaced by their encoded form. A nil ArgCache returns them
+// as-is.
+func (ac *ArgCache) args(cmd string, args []interface{}) []interface{} {
+	a := make([]interface{}, 0, len(args)+1)
+	a = append(a, cmd)
+	a = append(a, args...)
+	if ac == nil {
+		return a
+	}
+	for i := range a {
+		if s, ok := a[i].(string); ok {
+			if m := ac.get(s); m != nil {
+				a[i] = m
+			}
+		}
+	}
+	return a
+}
@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// CancelPolicy describes what a Client does when the context given to
+// CmdContext is cancelled after the command has been written, but before its
+// reply has been read
+type CancelPolicy int
+
+const (
+	// CancelClose closes the connection immediately. This gives the lowest
+	// latency, at the cost of having to make a new connection.
+	CancelClose CancelPolicy = iota
+
+	// CancelDrain waits up to the Client's CancelDrainTimeout for the reply to
+	// arrive, discarding it so the connection can continue to be used. If it
+	// doesn't arrive in time the connection is closed.
+	CancelDrain
+)
+
+// DefaultCancelDrainTimeout is used when a Client's CancelPolicy is
+// CancelDrain but its CancelDrainTimeout isn't set
+const DefaultCancelDrainTimeout = 100 * time.Millisecond
+
+// CmdContext is like Cmd, but returns an ErrorReply with the context's error as
+// soon as the context is cancelled. If the command has not been written by then
+// it won't be, otherwise the Client's CancelPolicy decides what becomes of the
+// command's reply and the connection. Once closed, all further commands on the
+// connection will return network errors.
+func (c *Client) CmdContext(ctx context.Context, cmd string, args ...interface{}) *Reply {
+	if ctx.Done() == nil {
+		return c.Cmd(cmd, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return &Reply{Type: ErrorReply, Err: err}
+	}
+
+	req := &request{cmd: cmd, args: args}
+	if err := c.writeRequest(req); err != nil {
+		return &Reply{Type: ErrorReply, Err: err}
+	}
+
+	replyCh := make(chan *Reply, 1)
+	go func() {
+		replyCh <- c.readReplyFor(req)
+	}()
+
+	select {
+	case r := <-replyCh:
+		return r
+	case <-ctx.Done():
+	}
+
+	if c.CancelPolicy == CancelDrain {
+		timeout := c.CancelDrainTimeout
+		if timeout <= 0 {
+			timeout = DefaultCancelDrainTimeout
+		}
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case <-replyCh:
+			return &Reply{Type: ErrorReply, Err: ctx.Err()}
+		case <-t.C:
+		}
+	}
+
+	// Closing the connection unblocks the read, which we wait on so that the
+	// Client is never in use by two routines at once
+	c.Close()
+	<-replyCh
+	return &Reply{Type: ErrorReply, Err: ctx.Err()}
+}
+
+// ReadReplyContext is like ReadReply, but returns an ErrorReply with the
+// context's error as soon as the context is cancelled, or once its deadline
+// passes, rather than after the Client's own timeout. Unlike CmdContext the
+// connection is not closed: the read is interrupted by moving its deadline, as
+// if it had timed out, so that a subscription connection can continue to be
+// read from.
+func (c *Client) ReadReplyContext(ctx context.Context) *Reply {
+	if r := c.popPush(); r != nil {
+		return r
+	}
+	if ctx.Done() == nil {
+		return c.ReadReply()
+	}
+	if err := ctx.Err(); err != nil {
+		return &Reply{Type: ErrorReply, Err: err}
+	}
+
+	// The deadline must be in place before the routine is started, so that it
+	// can't be overwritten once the context has been cancelled
+	deadline, _ := ctx.Deadline()
+	c.Conn.SetReadDeadline(deadline)
+	doneCh, stoppedCh := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stoppedCh)
+		select {
+		case <-ctx.Done():
+			c.Conn.SetReadDeadline(time.Now())
+		case <-doneCh:
+		}
+	}()
+
+	r := c.parse()
+	close(doneCh)
+	<-stoppedCh
+	c.resetReadDeadline()
+	if r.Err != nil && ctx.Err() != nil {
+		return &Reply{Type: ErrorReply, Err: ctx.Err()}
+	}
+	return r
+}
@@ -3,7 +3,10 @@ package redis
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,38 +22,172 @@ const (
 var LoadingError error = errors.New("server is busy loading dataset in memory")
 var PipelineQueueEmptyError error = errors.New("pipeline queue empty")
 
+// ErrPipelineIndex is the error of the Reply returned by Pipeline.Reply for an
+// index which doesn't have a reply
+var ErrPipelineIndex error = errors.New("no reply at pipeline index")
+
+// ErrTxConflict is returned by Watch.Do when every attempt at the transaction
+// was aborted because a watched key was modified
+var ErrTxConflict error = errors.New("transaction aborted due to watched key being modified")
+
+// ErrOOM is returned when the server refuses a command because it has reached
+// its maxmemory limit. It is a *CmdError, since the connection is still fine.
+var ErrOOM error = &CmdError{errors.New("command not allowed when used memory > 'maxmemory'")}
+
+// ErrRequiresRESP3 is returned by features which only work over RESP3 when the
+// connection is using RESP2, e.g. because the server or a proxy in front of it
+// doesn't support RESP3. See HelloFallback.
+var ErrRequiresRESP3 error = errors.New("RESP3 is required but the connection is using RESP2")
+
 //* Client
 
 // Client describes a Redis client.
 type Client struct {
 	// The connection the client talks to redis over. Don't touch this unless
 	// you know what you're doing.
-	Conn      net.Conn
+	Conn net.Conn
+
+	// What CmdContext does when its context is cancelled while waiting on a
+	// reply, and how long it will wait in the case of CancelDrain. See
+	// CancelPolicy.
+	CancelPolicy       CancelPolicy
+	CancelDrainTimeout time.Duration
+
+	// If set, called as commands are performed on the connection and when it
+	// is closed, see Trace and DialTrace
+	Trace *Trace
+
+	// The counters reported by Metrics. DialTimeout sets a new Counters on
+	// every Client, but it may be replaced (e.g. by one shared with other
+	// Clients) at any time before the Client is used.
+	Counters *Counters
+
+	// If set, called once for every command performed on the connection, with
+	// its sensitive arguments redacted. See CmdLogger.
+	Logger CmdLogger
+
+	// If set, the latency of every command performed on the connection is
+	// recorded in it, by command name. See LatencyTracker.
+	Latency *LatencyTracker
+
+	// If set, the encoded forms of frequently written strings are taken from
+	// here rather than re-encoded each time. See ArgCache.
+	ArgCache *ArgCache
+
+	// If set, the Reply returned by Cmd, along with all of its Elems, is
+	// reused by the next call to Cmd rather than a new one being allocated
+	// for every command. The Reply is then only valid until the next command
+	// is performed on the Client, so it mustn't be kept around or handed to
+	// another routine (the values returned by its methods, e.g. Str, may be).
+	// Replies read by other means, e.g. GetReply or ReadReply, are never
+	// reused.
+	ReuseReplies bool
+
+	// If set, called with the name of the command whenever a reply of ErrOOM
+	// is received, e.g. to temporarily stop writing non-essential data. See
+	// DialOpts.OOMHandler for setting it on every connection a Pool makes.
+	OOMHandler func(cmd string)
+
 	timeout   time.Duration
 	reader    *bufio.Reader
+	writer    *bufio.Writer
 	pending   []*request
 	completed []*Reply
+	commands  map[string]*CommandInfo
+	db        int
+	closed    int32 // set once the close has been traced
+	arena     *replyArena
+
+	// See Subscribed, InTransaction and NeedsReset
+	subscribed, inTx, watching bool
+
+	// RESP3 state
+	proto       int
+	pushes      []*Reply
+	pushHandler func(*Reply)
+
+	// See Server
+	server *ServerInfo
+}
+
+// Cmder is implemented by anything which can perform a single command and
+// return its reply, such as *Client, *PersistentClient, *pool.Pool and
+// *cluster.Cluster
+type Cmder interface {
+	Cmd(cmd string, args ...interface{}) *Reply
 }
 
+// Conn is implemented by connections which can perform commands one at a time
+// or pipelined, namely *Client and *PersistentClient. Code which takes a Conn
+// (or just a Cmder, if it only calls Cmd) rather than a *Client can be given a
+// mock or recording implementation in tests instead of a live connection.
+//
+// pool.Pool and cluster.Cluster implement Cmder and Doer, but not Conn, and
+// still hand out and take back *Client rather than Conn: they depend on state
+// only a real connection has (its database, whether it's subscribed or in a
+// transaction, see NeedsReset), and pipelining across a pool would spread the
+// replies over different connections. Code using a Pool or Cluster should take
+// a Cmder or Doer to be mockable.
+type Conn interface {
+	Cmder
+	Append(cmd string, args ...interface{})
+	GetReply() *Reply
+	Close() error
+}
+
+var (
+	_ Conn = (*Client)(nil)
+	_ Conn = (*PersistentClient)(nil)
+)
+
 // request describes a client's request to the redis server
 type request struct {
-	cmd  string
-	args []interface{}
+	cmd   string
+	args  []interface{}
+	start time.Time // set as the request is written
 }
 
 // Dial connects to the given Redis server with the given timeout, which will be
 // used as the read/write timeout when communicating with redis
 func DialTimeout(network, addr string, timeout time.Duration) (*Client, error) {
+	return dialOpts(network, addr, DialOpts{Timeout: timeout})
+}
+
+func dialOpts(network, addr string, opts DialOpts) (*Client, error) {
 	// establish a connection
-	conn, err := net.Dial(network, addr)
+	conn, err := dialConn(network, addr, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	c := new(Client)
 	c.Conn = conn
-	c.timeout = timeout
-	c.reader = bufio.NewReaderSize(conn, bufSize)
+	c.Trace = opts.Trace
+	c.OOMHandler = opts.OOMHandler
+	c.Counters = &Counters{}
+	c.Counters.AddDial(nil)
+	c.timeout = opts.Timeout
+	c.reader = bufio.NewReaderSize(countingConn{c}, bufSize)
+	c.proto = 2
+	if err := c.setup(opts); err != nil {
+		return nil, err
+	}
+	if opts.Negotiate {
+		if err := c.negotiate(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	} else if opts.PreferRESP3 {
+		if r := c.HelloFallback(); IsNetworkErr(r.Err) {
+			c.Close()
+			return nil, r.Err
+		}
+	}
+	if opts.SetLibInfo {
+		if err := c.setLibInfo(); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
@@ -63,22 +200,142 @@ func Dial(network, addr string) (*Client, error) {
 
 // Close closes the connection.
 func (c *Client) Close() error {
-	return c.Conn.Close()
+	err := c.Conn.Close()
+	c.traceClosed()
+	return err
 }
 
 // Cmd calls the given Redis command.
 func (c *Client) Cmd(cmd string, args ...interface{}) *Reply {
-	err := c.writeRequest(&request{cmd, args})
+	req := &request{cmd: cmd, args: args}
+	err := c.writeRequest(req)
 	if err != nil {
 		return &Reply{Type: ErrorReply, Err: err}
 	}
-	return c.ReadReply()
+	if !c.ReuseReplies {
+		return c.readReplyFor(req)
+	}
+
+	if c.arena == nil {
+		c.arena = &replyArena{}
+	}
+	c.arena.reset()
+	defer func() { c.arena.active = false }()
+	return c.readReplyFor(req)
+}
+
+// CmdWriteTo calls the given Redis command and writes its reply to w, as
+// Reply.WriteTo does. If the reply is a bulk string its contents are copied
+// directly from the connection into w, without being held in memory, which
+// makes this suitable for fetching very large values.
+//
+// If writing to w fails the rest of the reply is discarded, so the connection
+// can still be used afterwards.
+func (c *Client) CmdWriteTo(w io.Writer, cmd string, args ...interface{}) (int64, error) {
+	req := &request{cmd: cmd, args: args}
+	if err := c.writeRequest(req); err != nil {
+		return 0, err
+	}
+	for {
+		c.setReadTimeout()
+		ew := &errWriter{w: w}
+		n, m, err := resp.CopyBulkStr(ew, c.reader)
+		if ew.err != nil {
+			return n, ew.err
+		} else if err != nil {
+			if !IsTimeout(err) {
+				c.Close()
+			}
+			c.finish(req, err)
+			return n, err
+		} else if m == nil {
+			c.finish(req, nil)
+			return n, nil
+		}
+
+		r, err := messageToReply(m)
+		if err != nil {
+			r = &Reply{Type: ErrorReply, Err: err}
+		}
+		if c.divertPush(req, r) {
+			continue
+		}
+		c.track(req, r)
+		return r.WriteTo(w)
+	}
+}
+
+// CmdReadInto calls the given Redis command and returns the value of its
+// reply, as Reply.Bytes does, in buf[:0] (or a larger slice, if buf doesn't
+// have the capacity for it). If the reply is a bulk string it's read directly
+// from the connection into buf, so that a hot path which decodes or hashes the
+// value straight away can reuse a single buffer and read replies without
+// allocating:
+//
+//	var buf []byte
+//	for _, key := range keys {
+//		var err error
+//		if buf, err = client.CmdReadInto(buf, "GET", key); err != nil {
+//			// handle err
+//		}
+//		h.Write(buf)
+//	}
+//
+// The returned slice is only valid until buf is next used.
+func (c *Client) CmdReadInto(buf []byte, cmd string, args ...interface{}) ([]byte, error) {
+	buf = buf[:0]
+	req := &request{cmd: cmd, args: args}
+	if err := c.writeRequest(req); err != nil {
+		return buf, err
+	}
+	for {
+		c.setReadTimeout()
+		b, m, err := resp.ReadBulkStrInto(c.reader, buf)
+		if err != nil {
+			if !IsTimeout(err) {
+				c.Close()
+			}
+			c.finish(req, err)
+			return buf, err
+		} else if m == nil {
+			c.finish(req, nil)
+			return b, nil
+		}
+
+		r, err := messageToReply(m)
+		if err != nil {
+			r = &Reply{Type: ErrorReply, Err: err}
+		}
+		if c.divertPush(req, r) {
+			continue
+		}
+		c.track(req, r)
+		v, err := r.Bytes()
+		return append(buf, v...), err
+	}
+}
+
+// errWriter keeps track of the first error its underlying io.Writer returns,
+// so that it can be told apart from errors reading off the connection
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) Write(b []byte) (int, error) {
+	n, err := ew.w.Write(b)
+	if err != nil && ew.err == nil {
+		ew.err = err
+	}
+	return n, err
 }
 
 // Append adds the given call to the pipeline queue.
-// Use GetReply() to read the reply.
+// Use GetReply() to read the reply. Nothing is written until then, when all of
+// the queued calls are written together, usually in a single write. See also
+// Pipeline, which makes it harder to leave replies unread.
 func (c *Client) Append(cmd string, args ...interface{}) {
-	c.pending = append(c.pending, &request{cmd, args})
+	c.pending = append(c.pending, &request{cmd: cmd, args: args})
 }
 
 // GetReply returns the reply for the next request in the pipeline queue.
@@ -96,21 +353,88 @@ func (c *Client) GetReply() *Reply {
 		return &Reply{Type: ErrorReply, Err: PipelineQueueEmptyError}
 	}
 
-	nreqs := len(c.pending)
-	err := c.writeRequest(c.pending...)
+	reqs := c.pending
+	err := c.writeRequest(reqs...)
 	c.pending = nil
 	if err != nil {
 		return &Reply{Type: ErrorReply, Err: err}
 	}
-	r := c.ReadReply()
-	c.completed = make([]*Reply, nreqs-1)
-	for i := 0; i < nreqs-1; i++ {
-		c.completed[i] = c.ReadReply()
+	r := c.readReplyFor(reqs[0])
+	c.completed = make([]*Reply, len(reqs)-1)
+	for i := range c.completed {
+		c.completed[i] = c.readReplyFor(reqs[i+1])
 	}
 
 	return r
 }
 
+// Hello sends a HELLO command for the given protocol version (2 or 3) along
+// with any extra arguments (e.g. AUTH username password). If it succeeds the
+// connection will use that protocol from then on, if it fails (e.g. the
+// server is older than redis 6) the connection continues to use RESP2.
+//
+// When using RESP3 the server may send push replies at any time, for example
+// invalidation messages for client-side caching. Those which arrive while
+// waiting on the reply to a command (other than the SUBSCRIBE family) are set
+// aside, see SetPushHandler.
+func (c *Client) Hello(protover int, args ...interface{}) *Reply {
+	return c.Cmd("HELLO", append([]interface{}{protover}, args...)...)
+}
+
+// HelloFallback attempts to switch the connection to RESP3 using HELLO 3, and
+// falls back to RESP2 if the server doesn't support it, so that the connection
+// is usable either way. Protocol returns whichever was negotiated.
+//
+// Any extra arguments (e.g. AUTH username password) are sent along with HELLO
+// 2 if the server replies to HELLO 3 with NOPROTO. Servers older than redis 6
+// don't have HELLO at all, in which case the connection already uses RESP2
+// and a status reply of OK is returned, unless there were extra arguments,
+// which can't be applied, in which case the error from HELLO 3 is returned.
+func (c *Client) HelloFallback(args ...interface{}) *Reply {
+	r := c.Hello(3, args...)
+	if _, ok := r.Err.(*CmdError); !ok {
+		return r
+	}
+	if strings.HasPrefix(r.Err.Error(), "NOPROTO") {
+		if len(args) == 0 {
+			return &Reply{Type: StatusReply, buf: []byte("OK")}
+		}
+		return c.Hello(2, args...)
+	} else if strings.Contains(strings.ToLower(r.Err.Error()), "unknown command") && len(args) == 0 {
+		return &Reply{Type: StatusReply, buf: []byte("OK")}
+	}
+	return r
+}
+
+// RequireRESP3 returns ErrRequiresRESP3 if the connection isn't using RESP3
+func (c *Client) RequireRESP3() error {
+	if c.proto != 3 {
+		return ErrRequiresRESP3
+	}
+	return nil
+}
+
+// Protocol returns the version of RESP the connection is currently using,
+// either 2 or 3
+func (c *Client) Protocol() int {
+	return c.proto
+}
+
+// DB returns the database the connection currently has selected, as tracked
+// from successful SELECT commands made through it. SELECTs made within a
+// MULTI/EXEC transaction or a script are not tracked.
+func (c *Client) DB() int {
+	return c.db
+}
+
+// SetPushHandler sets a function which will be called with every push reply
+// which arrives while waiting on the reply to a command. If no handler is set
+// those push replies are instead buffered and returned by subsequent calls to
+// ReadReply, before any new replies are read off the connection.
+func (c *Client) SetPushHandler(fn func(*Reply)) {
+	c.pushHandler = fn
+}
+
 //* Private methods
 
 func (c *Client) setReadTimeout() {
@@ -119,6 +443,15 @@ func (c *Client) setReadTimeout() {
 	}
 }
 
+// resetReadDeadline clears a read deadline set other than by setReadTimeout,
+// which would otherwise be left in place for the next read if the Client has no
+// timeout of its own
+func (c *Client) resetReadDeadline() {
+	if c.timeout == 0 {
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
 func (c *Client) setWriteTimeout() {
 	if c.timeout != 0 {
 		c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
@@ -132,7 +465,7 @@ func (c *Client) setWriteTimeout() {
 //
 //	r := conn.ReadReply()
 //	if r.Err != nil {
-//		if t, ok := r.Err.(*net.OpError); ok && t.Timeout() {
+//		if redis.IsTimeout(r.Err) {
 //			// Is timeout
 //		} else {
 //			// Not timeout
@@ -142,46 +475,179 @@ func (c *Client) setWriteTimeout() {
 // Note: this is a more low-level function, you really shouldn't have to
 // actually use it unless you're writing your own pub/sub code
 func (c *Client) ReadReply() *Reply {
+	if r := c.popPush(); r != nil {
+		return r
+	}
 	c.setReadTimeout()
 	return c.parse()
 }
 
+// ReadReplyTimeout is like ReadReply, but waits up to the given duration for a
+// reply instead of the Client's own timeout
+func (c *Client) ReadReplyTimeout(d time.Duration) *Reply {
+	if r := c.popPush(); r != nil {
+		return r
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(d))
+	r := c.parse()
+	c.resetReadDeadline()
+	return r
+}
+
+// popPush returns the earliest push reply which was set aside, or nil
+func (c *Client) popPush() *Reply {
+	if len(c.pushes) == 0 {
+		return nil
+	}
+	r := c.pushes[0]
+	c.pushes = c.pushes[1:]
+	return r
+}
+
+// readReplyFor reads the reply to the given request off of the connection,
+// setting aside any push replies which don't belong to it
+func (c *Client) readReplyFor(req *request) *Reply {
+	for {
+		c.setReadTimeout()
+		r := c.parse()
+		if c.divertPush(req, r) {
+			continue
+		}
+		c.track(req, r)
+		return r
+	}
+}
+
+// divertPush hands off the given reply to the push handler, or sets it aside,
+// and returns true if it's a push reply which doesn't belong to req
+func (c *Client) divertPush(req *request, r *Reply) bool {
+	if r.Type != PushReply || isSubCmd(req.cmd) {
+		return false
+	}
+	if c.arena != nil && c.arena.active {
+		// Push replies outlive the command they arrived during, so they're
+		// copied out of the arena, which can then start over
+		r = r.clone()
+		c.arena.reset()
+	}
+	if c.pushHandler != nil {
+		c.pushHandler(r)
+	} else {
+		c.pushes = append(c.pushes, r)
+	}
+	return true
+}
+
+// track updates the connection's state based on the reply to req
+func (c *Client) track(req *request, r *Reply) {
+	c.finish(req, r.Err)
+	c.trackTx(req, r)
+	if r.Err == ErrOOM && c.OOMHandler != nil {
+		c.OOMHandler(req.cmd)
+	}
+	if r.Err == nil && strings.EqualFold(req.cmd, "HELLO") {
+		if m, err := r.Map(); err == nil && m["proto"] != nil {
+			if proto, err := m["proto"].Int(); err == nil {
+				c.proto = proto
+			}
+		}
+		if si, err := parseHello(r); err == nil {
+			c.server = si
+		}
+	}
+	if r.Type == StatusReply && len(req.args) == 1 && strings.EqualFold(req.cmd, "SELECT") {
+		if db, err := strconv.Atoi(fmt.Sprint(req.args[0])); err == nil {
+			c.db = db
+		}
+	}
+}
+
+// finish records the completion of req, with the given error if it failed
+func (c *Client) finish(req *request, err error) {
+	d := time.Since(req.start)
+	c.Counters.addCmd(d, err)
+	c.Latency.Record(req.cmd, d)
+	c.traceCompleted(req, err)
+	c.logCmd(req, err)
+}
+
+func isSubCmd(cmd string) bool {
+	switch strings.ToUpper(cmd) {
+	case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE",
+		"UNSUBSCRIBE", "PUNSUBSCRIBE", "SUNSUBSCRIBE":
+		return true
+	}
+	return false
+}
+
+// writeRequest writes the given requests to the connection. They're buffered
+// and flushed together, so that a whole pipeline usually goes out in a single
+// write.
 func (c *Client) writeRequest(requests ...*request) error {
+	if c.writer == nil {
+		c.writer = bufio.NewWriterSize(countingConn{c}, bufSize)
+	}
 	c.setWriteTimeout()
+	var err error
 	for i := range requests {
-		req := make([]interface{}, 0, len(requests[i].args)+1)
-		req = append(req, requests[i].cmd)
-		req = append(req, requests[i].args...)
-		err := resp.WriteArbitraryAsFlattenedStrings(c.Conn, req)
-		if err != nil {
-			c.Close()
-			return err
+		requests[i].start = time.Now()
+		c.traceStarted(requests[i])
+		if err == nil {
+			req := c.ArgCache.args(requests[i].cmd, requests[i].args)
+			err = resp.WriteArbitraryAsFlattenedStrings(c.writer, req)
 		}
 	}
-	return nil
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		c.Close()
+		// There's no telling which of the requests made it out, but none of
+		// them will have their replies read
+		for _, r := range requests {
+			c.finish(r, err)
+		}
+	}
+	return err
 }
 
 func (c *Client) parse() *Reply {
 	m, err := resp.ReadMessage(c.reader)
 	if err != nil {
-		if t, ok := err.(*net.OpError); !ok || !t.Timeout() {
+		if !IsTimeout(err) {
 			// close connection except timeout
 			c.Close()
 		}
 		return &Reply{Type: ErrorReply, Err: err}
 	}
-	r, err := messageToReply(m)
+	r, err := c.arena.messageToReply(m)
 	if err != nil {
 		return &Reply{Type: ErrorReply, Err: err}
 	}
+	c.trackSub(r)
 	return r
 }
 
+// ParseReply converts a message read using the resp package into a Reply, as a
+// Client would, for tools which read RESP themselves (e.g. a proxy using
+// resp.Reader) but want Reply's accessors. Errors sent by the server are
+// returned as the Reply's Err, the error return is for messages which can't be
+// converted.
+func ParseReply(m *resp.Message) (*Reply, error) {
+	return messageToReply(m)
+}
+
 // The error return parameter is for bubbling up parse errors and the like, if
 // the error is sent by redis itself as an Err message type, then it will be
 // sent back as an actual Reply (wrapped in a CmdError)
 func messageToReply(m *resp.Message) (*Reply, error) {
-	r := &Reply{}
+	return (*replyArena)(nil).messageToReply(m)
+}
+
+// messageToReply is like the function of the same name, but takes the Replies
+// from the arena if it's active
+func (a *replyArena) messageToReply(m *resp.Message) (*Reply, error) {
+	r := a.newReply()
 
 	switch m.Type {
 	case resp.Err:
@@ -191,6 +657,8 @@ func messageToReply(m *resp.Message) (*Reply, error) {
 		}
 		if strings.HasPrefix(errMsg.Error(), "LOADING") {
 			err = LoadingError
+		} else if strings.HasPrefix(errMsg.Error(), "OOM") {
+			err = ErrOOM
 		} else {
 			err = &CmdError{errMsg}
 		}
@@ -224,15 +692,52 @@ func messageToReply(m *resp.Message) (*Reply, error) {
 	case resp.Nil:
 		r.Type = NilReply
 
-	case resp.Array:
+	case resp.Double, resp.BigNumber, resp.VerbatimStr:
+		b, err := m.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		switch m.Type {
+		case resp.Double:
+			r.Type = DoubleReply
+		case resp.BigNumber:
+			r.Type = BigNumberReply
+		case resp.VerbatimStr:
+			if len(b) < 4 || b[3] != ':' {
+				return nil, errors.New("malformed verbatim string")
+			}
+			r.Type = VerbatimReply
+		}
+		r.buf = b
+
+	case resp.Bool:
+		b, err := m.Bool()
+		if err != nil {
+			return nil, err
+		}
+		r.Type = BooleanReply
+		if b {
+			r.int = 1
+		}
+
+	case resp.Array, resp.Map, resp.Set, resp.Push:
 		ms, err := m.Array()
 		if err != nil {
 			return nil, err
 		}
-		r.Type = MultiReply
-		r.Elems = make([]*Reply, len(ms))
+		switch m.Type {
+		case resp.Array:
+			r.Type = MultiReply
+		case resp.Map:
+			r.Type = MapReply
+		case resp.Set:
+			r.Type = SetReply
+		case resp.Push:
+			r.Type = PushReply
+		}
+		r.Elems = a.newElems(len(ms))
 		for i := range ms {
-			r.Elems[i], err = messageToReply(ms[i])
+			r.Elems[i], err = a.messageToReply(ms[i])
 			if err != nil {
 				return nil, err
 			}
@@ -3,7 +3,11 @@ package redis
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
 	. "testing"
 	"time"
 )
@@ -72,6 +76,13 @@ func TestParse(t *T) {
 	assert.Equal(t, ErrorReply, r.Type)
 	assert.Equal(t, LoadingError, r.Err)
 
+	// OOM error
+	r = parseString("-OOM command not allowed when used memory > 'maxmemory'.\r\n")
+	assert.Equal(t, ErrorReply, r.Type)
+	assert.Equal(t, ErrOOM, r.Err)
+	_, ok := r.Err.(*CmdError)
+	assert.True(t, ok)
+
 	// status reply
 	r = parseString("+OK\r\n")
 	assert.Equal(t, StatusReply, r.Type)
@@ -104,3 +115,577 @@ func TestParse(t *T) {
 	}
 	assert.Equal(t, []byte("foobar"), r.Elems[4].buf)
 }
+
+func TestServerCommands(t *T) {
+	c := dial(t)
+	m, err := c.ServerCommands()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, m["get"].Arity)
+	assert.True(t, m["get"].HasFlag("readonly"))
+
+	// Second call should be served from the cache
+	m2, err := c.ServerCommands()
+	assert.Nil(t, err)
+	assert.Equal(t, len(m), len(m2))
+}
+
+func TestParseRESP3(t *T) {
+	c := new(Client)
+
+	parseString := func(b string) *Reply {
+		c.reader = bufio.NewReader(bytes.NewBufferString(b))
+		return c.parse()
+	}
+
+	r := parseString("%2\r\n+foo\r\n:1\r\n+bar\r\n*1\r\n$3\r\nbaz\r\n")
+	assert.Equal(t, MapReply, r.Type)
+	m, err := r.Map()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), m["foo"].int)
+	assert.Equal(t, MultiReply, m["bar"].Type)
+
+	r = parseString("~2\r\n$3\r\nfoo\r\n$3\r\nbar\r\n")
+	assert.Equal(t, SetReply, r.Type)
+	l, err := r.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, l)
+
+	r = parseString(",3.5\r\n")
+	assert.Equal(t, DoubleReply, r.Type)
+	f, err := r.Float64()
+	assert.Nil(t, err)
+	assert.Equal(t, 3.5, f)
+
+	r = parseString("#f\r\n")
+	assert.Equal(t, BooleanReply, r.Type)
+	b, err := r.Bool()
+	assert.Nil(t, err)
+	assert.False(t, b)
+
+	r = parseString("(12345678901234567890\r\n")
+	assert.Equal(t, BigNumberReply, r.Type)
+	i, err := r.BigInt()
+	assert.Nil(t, err)
+	assert.Equal(t, "12345678901234567890", i.String())
+
+	r = parseString("=8\r\nmkd:ohey\r\n")
+	assert.Equal(t, VerbatimReply, r.Type)
+	format, text, err := r.Verbatim()
+	assert.Nil(t, err)
+	assert.Equal(t, "mkd", format)
+	assert.Equal(t, "ohey", text)
+	s, err := r.Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "ohey", s)
+
+	r = parseString("_\r\n")
+	assert.Equal(t, NilReply, r.Type)
+
+	r = parseString(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n")
+	assert.Equal(t, PushReply, r.Type)
+	assert.Equal(t, 2, len(r.Elems))
+}
+
+func TestPushesSetAside(t *T) {
+	c := new(Client)
+	c.reader = bufio.NewReader(bytes.NewBufferString(
+		">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n+OK\r\n",
+	))
+	r := c.readReplyFor(&request{cmd: "SET"})
+	assert.Equal(t, StatusReply, r.Type)
+
+	// The push should now be returned by ReadReply
+	r = c.ReadReply()
+	assert.Equal(t, PushReply, r.Type)
+
+	var pushed *Reply
+	c.SetPushHandler(func(r *Reply) { pushed = r })
+	c.reader = bufio.NewReader(bytes.NewBufferString(
+		">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n+OK\r\n",
+	))
+	r = c.readReplyFor(&request{cmd: "SET"})
+	assert.Equal(t, StatusReply, r.Type)
+	assert.NotNil(t, pushed)
+}
+
+func TestOOMHandler(t *T) {
+	var oomCmd string
+	c := new(Client)
+	c.OOMHandler = func(cmd string) { oomCmd = cmd }
+	c.reader = bufio.NewReader(bytes.NewBufferString("-OOM no more memory\r\n"))
+	r := c.readReplyFor(&request{cmd: "SET"})
+	assert.Equal(t, ErrOOM, r.Err)
+	assert.Equal(t, "SET", oomCmd)
+}
+
+func TestHello(t *T) {
+	c := dial(t)
+	assert.Equal(t, 2, c.Protocol())
+	if err := c.Hello(3).Err; err != nil {
+		// Server doesn't support RESP3, make sure we're still on RESP2
+		assert.Equal(t, 2, c.Protocol())
+		return
+	}
+	assert.Equal(t, 3, c.Protocol())
+
+	c.Cmd("DEL", "hello-test")
+	c.Cmd("HSET", "hello-test", "foo", "bar")
+	r := c.Cmd("HGETALL", "hello-test")
+	assert.Equal(t, MapReply, r.Type)
+	h, err := r.Hash()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", h["foo"])
+}
+
+func TestDB(t *T) {
+	c := dial(t)
+	defer c.Close()
+	assert.Equal(t, 0, c.DB())
+
+	assert.Nil(t, c.Cmd("SELECT", 2).Err)
+	assert.Equal(t, 2, c.DB())
+
+	// A failed SELECT leaves things as they were
+	assert.NotNil(t, c.Cmd("SELECT", "foo").Err)
+	assert.Equal(t, 2, c.DB())
+
+	assert.Nil(t, c.Cmd("SELECT", "0").Err)
+	assert.Equal(t, 0, c.DB())
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestCmdWriteTo(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	val := strings.Repeat("foo", 10000)
+	assert.Nil(t, c.Cmd("SET", "writeto", strings.NewReader(val)).Err)
+	defer c.Cmd("DEL", "writeto")
+
+	buf := bytes.NewBuffer([]byte{})
+	n, err := c.CmdWriteTo(buf, "GET", "writeto")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(val)), n)
+	assert.Equal(t, val, buf.String())
+
+	_, err = c.CmdWriteTo(buf, "GET", "writeto-nope")
+	assert.Equal(t, ErrNil, err)
+
+	// The connection is still usable after a failed write
+	_, err = c.CmdWriteTo(failWriter{}, "GET", "writeto")
+	assert.NotNil(t, err)
+	v, _ := c.Cmd("ECHO", "foo").Str()
+	assert.Equal(t, "foo", v)
+}
+
+func TestCmdContext(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Nil(t, c.CmdContext(ctx, "ECHO", "foo").Err)
+	cancel()
+	assert.Equal(t, context.Canceled, c.CmdContext(ctx, "ECHO", "foo").Err)
+
+	// With CancelDrain the reply to the cancelled command is discarded and the
+	// connection is kept
+	c.CancelPolicy = CancelDrain
+	c.CancelDrainTimeout = 2 * time.Second
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := c.CmdContext(ctx, "BLPOP", "cmdcontext", 1)
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+	v, _ := c.Cmd("ECHO", "foo").Str()
+	assert.Equal(t, "foo", v)
+
+	// With CancelClose the connection is closed straight away
+	c.CancelPolicy = CancelClose
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	r = c.CmdContext(ctx, "BLPOP", "cmdcontext", 1)
+	assert.Equal(t, context.DeadlineExceeded, r.Err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.True(t, IsNetworkErr(c.Cmd("ECHO", "foo").Err))
+}
+
+func TestPipelineType(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	p := c.Pipeline()
+	assert.Nil(t, p.Flush())
+	assert.Equal(t, 0, len(p.Replies()))
+
+	foo := p.Queue("ECHO", "foo")
+	bad := p.Queue("non-existant-cmd")
+	bar := p.Queue("ECHO", "bar")
+	assert.Equal(t, 3, p.Len())
+	assert.Nil(t, p.Flush())
+	assert.Equal(t, 0, p.Len())
+
+	v, _ := p.Reply(foo).Str()
+	assert.Equal(t, "foo", v)
+	assert.NotNil(t, p.Reply(bad).Err)
+	v, _ = p.Reply(bar).Str()
+	assert.Equal(t, "bar", v)
+	assert.Equal(t, ErrPipelineIndex, p.Reply(3).Err)
+
+	// Reused for a second batch
+	p.Queue("ECHO", "baz")
+	assert.Nil(t, p.Flush())
+	v, _ = p.Reply(0).Str()
+	assert.Equal(t, "baz", v)
+
+	// A network error fails the rest of the batch and closes the connection
+	c.Conn.Close()
+	p.Queue("ECHO", "foo")
+	p.Queue("ECHO", "bar")
+	assert.NotNil(t, p.Flush())
+	assert.True(t, IsNetworkErr(p.Reply(1).Err))
+}
+
+func TestClientInfo(t *T) {
+	c, err := DialWithOpts("tcp", "127.0.0.1:6379", DialOpts{SetLibInfo: true})
+	assert.Nil(t, err)
+	defer c.Close()
+
+	ci, err := c.ClientInfo()
+	assert.Nil(t, err)
+	assert.Equal(t, LibName, ci.LibName)
+	assert.Equal(t, LibVersion, ci.LibVer)
+	assert.Equal(t, 2, ci.Resp)
+	assert.Equal(t, "client|info", ci.LastCmd)
+}
+
+func TestWatch(t *T) {
+	c := dial(t)
+	defer c.Close()
+	c2 := dial(t)
+	defer c2.Close()
+	c.Cmd("SET", "watch", 1)
+	defer c.Cmd("DEL", "watch")
+
+	// The first attempt is interfered with by c2, the second goes through
+	calls := 0
+	rs, err := c.Watch("watch").Do(func(tx *Tx) error {
+		calls++
+		i, err := tx.Cmd("GET", "watch").Int()
+		if err != nil {
+			return err
+		}
+		if calls == 1 {
+			c2.Cmd("INCR", "watch")
+		}
+		tx.Queue("SET", "watch", i*10)
+		tx.Queue("GET", "watch")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 2, len(rs))
+	v, _ := rs[1].Int()
+	assert.Equal(t, 20, v)
+
+	// Every attempt interfered with
+	_, err = c.Watch("watch").Attempts(2).Do(func(tx *Tx) error {
+		c2.Cmd("INCR", "watch")
+		tx.Queue("GET", "watch")
+		return nil
+	})
+	assert.Equal(t, ErrTxConflict, err)
+
+	// An error from fn abandons the transaction
+	fnErr := errors.New("nope")
+	_, err = c.Watch("watch").Do(func(tx *Tx) error {
+		tx.Queue("SET", "watch", 0)
+		return fnErr
+	})
+	assert.Equal(t, fnErr, err)
+	v, _ = c.Cmd("GET", "watch").Int()
+	assert.Equal(t, 22, v)
+}
+
+func TestScript(t *T) {
+	c := dial(t)
+	defer c.Close()
+	defer c.Cmd("DEL", "script")
+
+	s := NewScript(1, `redis.call("SET", KEYS[1], ARGV[1]) return redis.call("GET", KEYS[1])`)
+	assert.Nil(t, c.Cmd("SCRIPT", "FLUSH").Err)
+
+	// The first call falls back to EVAL, the second uses EVALSHA
+	for i := 0; i < 2; i++ {
+		v, err := s.Cmd(c, "script", "foo").Str()
+		assert.Nil(t, err)
+		assert.Equal(t, "foo", v)
+		exists := c.Cmd("SCRIPT", "EXISTS", s.SHA())
+		assert.Equal(t, 1, len(exists.Elems))
+		n, _ := exists.Elems[0].Int()
+		assert.Equal(t, 1, n)
+	}
+}
+
+func TestLibrary(t *T) {
+	c := dial(t)
+	defer c.Close()
+	defer c.Cmd("DEL", "library")
+
+	l := MustLibrary(`#!lua name=radixtest
+		redis.register_function("setget", function(keys, args)
+			redis.call("SET", keys[1], args[1])
+			return redis.call("GET", keys[1])
+		end)
+		redis.register_function{
+			function_name="get",
+			callback=function(keys) return redis.call("GET", keys[1]) end,
+			flags={"no-writes"},
+		}
+	`)
+	c.Cmd("FUNCTION", "DELETE", l.Name())
+	defer c.Cmd("FUNCTION", "DELETE", l.Name())
+
+	// The first call loads the library
+	v, err := l.FCall(c, "setget", []string{"library"}, "foo").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", v)
+
+	v, err = l.FCallRO(c, "get", []string{"library"}).Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", v)
+}
+
+func TestOnce(t *T) {
+	c, other := dial(t), dial(t)
+	defer c.Close()
+	defer other.Close()
+	c.Cmd("DEL", "once")
+	defer c.Cmd("DEL", "once")
+
+	// A failed attempt leaves the key free for another
+	status, err := Once(c, "once", time.Second, func() error { return errors.New("failed") })
+	assert.Equal(t, OnceRan, status)
+	assert.NotNil(t, err)
+
+	ran := 0
+	status, err = Once(c, "once", time.Second, func() error {
+		ran++
+		// Performed from within fn, as another instance would
+		status, err := Once(other, "once", time.Second, func() error {
+			ran++
+			return nil
+		})
+		assert.Equal(t, OnceRunning, status)
+		assert.Nil(t, err)
+		return nil
+	})
+	assert.Equal(t, OnceRan, status)
+	assert.Nil(t, err)
+
+	status, err = Once(c, "once", time.Second, func() error {
+		ran++
+		return nil
+	})
+	assert.Equal(t, OnceDone, status)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, ran)
+}
+
+func TestScanner(t *T) {
+	c := dial(t)
+	defer c.Close()
+	c.Cmd("DEL", "scanner")
+	defer c.Cmd("DEL", "scanner")
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, c.Cmd("SADD", "scanner", i).Err)
+	}
+
+	seen := map[string]bool{}
+	s := NewScanner(c, ScanOpts{Command: "SSCAN", Key: "scanner", Count: 10})
+	for s.Next() {
+		seen[s.Value()] = true
+	}
+	assert.Nil(t, s.Err())
+	assert.Equal(t, 100, len(seen))
+	assert.False(t, s.Next())
+
+	s = NewScanner(c, ScanOpts{Pattern: "scanner"})
+	assert.True(t, s.Next())
+	assert.Equal(t, "scanner", s.Value())
+	for s.Next() {
+		assert.Equal(t, "scanner", s.Value())
+	}
+	assert.Nil(t, s.Err())
+}
+
+func TestWith(t *T) {
+	c := dial(t)
+	defer c.Close()
+	defer c.Cmd("DEL", "with:foo")
+
+	h := c.With(WithKeyPrefix("with:"), WithTimeout(time.Second))
+	assert.Nil(t, h.Cmd("SET", "foo", "bar").Err)
+	v, _ := c.Cmd("GET", "with:foo").Str()
+	assert.Equal(t, "bar", v)
+	v, _ = h.Cmd("GET", "foo").Str()
+	assert.Equal(t, "bar", v)
+
+	// The Client's own timeout is left alone
+	assert.Equal(t, 10*time.Second, c.timeout)
+	assert.Equal(t, time.Second, h.opts.Timeout)
+	assert.Equal(t, "", h.With(WithKeyPrefix("")).opts.KeyPrefix)
+}
+
+func TestKeysGuard(t *T) {
+	c := dial(t)
+	defer c.Close()
+	for i := 0; i < 30; i++ {
+		key := "keysguard:" + strconv.Itoa(i)
+		assert.Nil(t, c.Cmd("SET", key, i).Err)
+		defer c.Cmd("DEL", key)
+	}
+
+	h := c.With(WithKeysGuard(KeysReject, 0))
+	assert.Equal(t, ErrKeysRejected, h.Cmd("keys", "keysguard:*").Err)
+	assert.Nil(t, h.Cmd("GET", "keysguard:0").Err)
+
+	h = c.With(WithKeysGuard(KeysScan, 0))
+	keys, err := h.Cmd("KEYS", "keysguard:*").List()
+	assert.Nil(t, err)
+	assert.Equal(t, 30, len(keys))
+	keys, err = h.Cmd("KEYS", "keysguard:1*").List()
+	assert.Nil(t, err)
+	assert.Equal(t, 11, len(keys))
+
+	h = c.With(WithKeysGuard(KeysScan, 10))
+	assert.Equal(t, ErrKeysLimit, h.Cmd("KEYS", "keysguard:*").Err)
+}
+
+func TestPipelineSend(t *T) {
+	c := dial(t)
+	defer c.Close()
+
+	p := c.Pipeline()
+	assert.Equal(t, PipelineQueueEmptyError, p.ReadReply().Err)
+
+	p.Queue("ECHO", "foo")
+	p.Queue("ECHO", "bar")
+	assert.Nil(t, p.Send())
+	v, _ := p.ReadReply().Str()
+	assert.Equal(t, "foo", v)
+
+	// Queue more based on the reply, while bar is still on its way
+	baz := p.Queue("ECHO", v+"baz")
+	assert.Equal(t, 2, p.Len())
+	assert.Nil(t, p.Send())
+	v, _ = p.ReadReply().Str()
+	assert.Equal(t, "bar", v)
+
+	// Flush reads whatever is left
+	assert.Nil(t, p.Flush())
+	assert.Equal(t, 0, p.Len())
+	v, _ = p.Reply(baz).Str()
+	assert.Equal(t, "foobaz", v)
+	assert.Equal(t, 3, len(p.Replies()))
+}
+
+func TestWatchErrors(t *T) {
+	c := dial(t)
+	defer c.Close()
+	c.Cmd("SET", "watchErrors", "foo")
+	defer c.Cmd("DEL", "watchErrors")
+
+	// INCR on a string fails while the block is performed
+	rs, err := c.Watch().Do(func(tx *Tx) error {
+		tx.Queue("INCR", "watchErrors")
+		tx.Queue("GET", "watchErrors")
+		return nil
+	})
+	txErr, ok := err.(*TxError)
+	assert.True(t, ok)
+	assert.False(t, txErr.Aborted)
+	assert.NotNil(t, txErr.Errs[0])
+	v, _ := rs[1].Str()
+	assert.Equal(t, "foo", v)
+
+	// A wrong number of arguments fails while queueing, aborting the block
+	rs, err = c.Watch().Do(func(tx *Tx) error {
+		tx.Queue("SET", "watchErrors", "bar")
+		tx.Queue("GET")
+		return nil
+	})
+	txErr, ok = err.(*TxError)
+	assert.True(t, ok)
+	assert.True(t, txErr.Aborted)
+	assert.Equal(t, 1, len(txErr.Errs))
+	assert.NotNil(t, rs[0].Err)
+	v, _ = c.Cmd("GET", "watchErrors").Str()
+	assert.Equal(t, "foo", v)
+}
+
+func TestMonitor(t *T) {
+	mc := dial(t)
+	m, err := mc.Monitor()
+	assert.Nil(t, err)
+
+	c := dial(t)
+	defer c.Close()
+	assert.Nil(t, c.Cmd("ECHO", "foo \"bar\"").Err)
+
+	select {
+	case l := <-m.Ch:
+		assert.Nil(t, l.Err)
+		assert.Equal(t, "ECHO", strings.ToUpper(l.Cmd))
+		assert.Equal(t, []string{"foo \"bar\""}, l.Args)
+		assert.Equal(t, 0, l.DB)
+	case <-time.After(time.Second):
+		t.Fatal("no monitor line received")
+	}
+
+	assert.Nil(t, m.Close())
+	for range m.Ch {
+	}
+}
+
+func TestZPager(t *T) {
+	c := dial(t)
+	defer c.Close()
+	key := "zpager-test"
+	c.Cmd("DEL", key)
+	defer c.Cmd("DEL", key)
+
+	// Runs of equal scores which straddle page boundaries
+	scores := []int{1, 2, 2, 2, 2, 2, 3, 4, 4, 5}
+	for i, s := range scores {
+		assert.Nil(t, c.Cmd("ZADD", key, s, "m"+strconv.Itoa(i)).Err)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		p := NewZPager(c, ZPageOpts{Key: key, PageSize: 3, Reverse: reverse})
+		var got []string
+		for p.Next() {
+			assert.True(t, len(p.Page()) <= 3)
+			for _, m := range p.Page() {
+				got = append(got, m.Member)
+			}
+		}
+		assert.Nil(t, p.Err())
+		assert.Equal(t, len(scores), len(got))
+		seen := map[string]bool{}
+		for _, m := range got {
+			assert.False(t, seen[m], "%s returned twice", m)
+			seen[m] = true
+		}
+	}
+
+	p := NewZPager(c, ZPageOpts{Key: key, Min: "(2", Max: "4"})
+	assert.True(t, p.Next())
+	assert.Equal(t, []ZMember{{"m6", 3}, {"m7", 4}, {"m8", 4}}, p.Page())
+	assert.False(t, p.Next())
+}
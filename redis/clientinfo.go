@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The library name and version which are reported to the server, see
+// DialOpts.SetLibInfo
+const (
+	LibName    = "radix"
+	LibVersion = "0.5.0"
+)
+
+// setLibInfo sends CLIENT SETINFO for the library name and version, for
+// DialOpts.SetLibInfo. Only
+// network errors are returned, older servers will reply with an error which is
+// of no interest.
+func (c *Client) setLibInfo() error {
+	c.Append("CLIENT", "SETINFO", "LIB-NAME", LibName)
+	c.Append("CLIENT", "SETINFO", "LIB-VER", LibVersion)
+	for i := 0; i < 2; i++ {
+		if r := c.GetReply(); IsNetworkErr(r.Err) {
+			c.Close()
+			return r.Err
+		}
+	}
+	return nil
+}
+
+// ClientInfo describes a single connection as the server sees it, as returned
+// by CLIENT INFO or one line of CLIENT LIST
+type ClientInfo struct {
+	ID      int64
+	Addr    string // Address of the client
+	LAddr   string // Address the client connected to
+	Name    string // Set by CLIENT SETNAME
+	User    string // The ACL user the connection is authenticated as
+	Age     time.Duration
+	Idle    time.Duration
+	Flags   string // e.g. "N" for a normal client, see the CLIENT LIST docs
+	DB      int
+	Resp    int    // Version of RESP the connection is using
+	LibName string // Set by CLIENT SETINFO
+	LibVer  string // Set by CLIENT SETINFO
+	LastCmd string // The last command run, e.g. "client|info"
+
+	// All fields as given by the server, including those above
+	Fields map[string]string
+}
+
+// ClientInfo returns the server's view of this connection, using CLIENT INFO
+// (redis 6.2 and up)
+func (c *Client) ClientInfo() (*ClientInfo, error) {
+	s, err := c.Cmd("CLIENT", "INFO").Str()
+	if err != nil {
+		return nil, err
+	}
+	return ParseClientInfo(s)
+}
+
+// ParseClientInfo parses a line of space separated key=value pairs describing
+// a connection, as returned by CLIENT INFO and CLIENT LIST. Fields which are
+// missing (e.g. because the server is older) are left empty.
+func ParseClientInfo(s string) (*ClientInfo, error) {
+	ci := &ClientInfo{Fields: map[string]string{}}
+	for _, kv := range strings.Fields(s) {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return nil, errors.New("malformed client info field: " + kv)
+		}
+		ci.Fields[kv[:i]] = kv[i+1:]
+	}
+
+	f := ci.Fields
+	ci.Addr, ci.LAddr, ci.Name, ci.User = f["addr"], f["laddr"], f["name"], f["user"]
+	ci.Flags, ci.LibName, ci.LibVer, ci.LastCmd = f["flags"], f["lib-name"], f["lib-ver"], f["cmd"]
+
+	var err error
+	if ci.ID, err = clientInfoInt(f, "id"); err != nil {
+		return nil, err
+	}
+	var age, idle int64
+	if age, err = clientInfoInt(f, "age"); err != nil {
+		return nil, err
+	}
+	if idle, err = clientInfoInt(f, "idle"); err != nil {
+		return nil, err
+	}
+	ci.Age, ci.Idle = time.Duration(age)*time.Second, time.Duration(idle)*time.Second
+	var db, resp int64
+	if db, err = clientInfoInt(f, "db"); err != nil {
+		return nil, err
+	}
+	if resp, err = clientInfoInt(f, "resp"); err != nil {
+		return nil, err
+	}
+	ci.DB, ci.Resp = int(db), int(resp)
+	return ci, nil
+}
+
+func clientInfoInt(f map[string]string, key string) (int64, error) {
+	v, ok := f[key]
+	if !ok || v == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, errors.New("malformed client info field: " + key + "=" + v)
+	}
+	return i, nil
+}
@@ -0,0 +1,149 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CommandInfo describes a single command as returned by redis' COMMAND command
+type CommandInfo struct {
+	Name string
+
+	// Arity is the number of arguments the command takes, including the
+	// command name itself. A negative arity means the command takes at least
+	// -Arity arguments.
+	Arity int
+
+	Flags []string
+
+	// Positions of the first and last key arguments, and the step between
+	// them. All zero if the command takes no keys.
+	FirstKey, LastKey, KeyStep int
+
+	// Only filled in by redis 6 and up
+	ACLCategories []string
+}
+
+// HasFlag returns whether or not the command has the given flag (e.g.
+// "readonly", "write", "noscript")
+func (ci *CommandInfo) HasFlag(flag string) bool {
+	for _, f := range ci.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckArity returns an error if the given number of arguments (not including
+// the command name itself) is not valid for the command
+func (ci *CommandInfo) CheckArity(nargs int) error {
+	n := nargs + 1
+	if (ci.Arity >= 0 && n != ci.Arity) || (ci.Arity < 0 && n < -ci.Arity) {
+		return fmt.Errorf("wrong number of arguments for '%s' command", ci.Name)
+	}
+	return nil
+}
+
+// ParseCommandInfos parses the reply from a COMMAND call into a map of
+// lowercased command name -> CommandInfo
+func ParseCommandInfos(r *Reply) (map[string]*CommandInfo, error) {
+	if r.Type == ErrorReply {
+		return nil, r.Err
+	}
+	if r.Type != MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+
+	m := make(map[string]*CommandInfo, len(r.Elems))
+	for _, e := range r.Elems {
+		ci, err := parseCommandInfo(e)
+		if err != nil {
+			return nil, err
+		}
+		m[ci.Name] = ci
+	}
+	return m, nil
+}
+
+func parseCommandInfo(r *Reply) (*CommandInfo, error) {
+	if r.Type != MultiReply || len(r.Elems) < 6 {
+		return nil, errors.New("malformed COMMAND entry")
+	}
+
+	var ci CommandInfo
+	var err error
+	if ci.Name, err = r.Elems[0].Str(); err != nil {
+		return nil, err
+	}
+	ci.Name = strings.ToLower(ci.Name)
+	if ci.Arity, err = r.Elems[1].Int(); err != nil {
+		return nil, err
+	}
+	if ci.Flags, err = r.Elems[2].statusList(); err != nil {
+		return nil, err
+	}
+	if ci.FirstKey, err = r.Elems[3].Int(); err != nil {
+		return nil, err
+	}
+	if ci.LastKey, err = r.Elems[4].Int(); err != nil {
+		return nil, err
+	}
+	if ci.KeyStep, err = r.Elems[5].Int(); err != nil {
+		return nil, err
+	}
+	if len(r.Elems) > 6 {
+		if ci.ACLCategories, err = r.Elems[6].statusList(); err != nil {
+			return nil, err
+		}
+	}
+	return &ci, nil
+}
+
+// statusList is like List, but allows for StatusReply elements as well. Under
+// RESP3 the flags and ACL categories are sent as SetReplys.
+func (r *Reply) statusList() ([]string, error) {
+	if r.Type != MultiReply && r.Type != SetReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+	l := make([]string, len(r.Elems))
+	for i := range r.Elems {
+		s, err := r.Elems[i].Str()
+		if err != nil {
+			return nil, err
+		}
+		l[i] = s
+	}
+	return l, nil
+}
+
+// ServerCommands returns information about all commands supported by the
+// connected server, keyed by lowercased command name. The first call performs a
+// COMMAND call, subsequent calls return the same cached map, which should not
+// be modified.
+func (c *Client) ServerCommands() (map[string]*CommandInfo, error) {
+	if c.commands != nil {
+		return c.commands, nil
+	}
+	m, err := ParseCommandInfos(c.Cmd("COMMAND"))
+	if err != nil {
+		return nil, err
+	}
+	c.commands = m
+	return m, nil
+}
+
+// ServerCommands is like Client's ServerCommands. The cached COMMAND output is
+// kept across re-dials.
+func (p *PersistentClient) ServerCommands() (map[string]*CommandInfo, error) {
+	if p.commands != nil {
+		return p.commands, nil
+	}
+	m, err := ParseCommandInfos(p.Cmd("COMMAND"))
+	if err != nil {
+		return nil, err
+	}
+	p.commands = m
+	return m, nil
+}
@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DialOpts are the options a connection is made with by DialWithOpts
+type DialOpts struct {
+	// Read/write timeout for every command, as with DialTimeout
+	Timeout time.Duration
+
+	// If set, the connection is made over TLS using this config. If its
+	// ServerName isn't set it's taken from the address being dialed.
+	TLSConfig *tls.Config
+
+	// The interval between TCP keepalive probes, so that connections which sit
+	// idle for a long time aren't dropped by NATs and load balancers along the
+	// way. Zero uses Go's default of 15 seconds, and a negative value disables
+	// keepalives.
+	KeepAlive time.Duration
+
+	// If set, Nagle's algorithm is left on (TCP_NODELAY isn't set), so that
+	// small writes may be coalesced at the cost of latency. By default it's
+	// off, as it is for every TCP connection made by Go.
+	DisableNoDelay bool
+
+	// If set, the sizes of the socket's receive and send buffers (SO_RCVBUF
+	// and SO_SNDBUF), e.g. for pipelining large amounts of data over links with
+	// high latency. Zero leaves them at the operating system's default.
+	ReadBufferSize, WriteBufferSize int
+
+	// If Password is set the connection is authenticated using AUTH as soon
+	// as it's made. If Username is set too it's authenticated as that ACL user
+	// (redis 6 and up), otherwise as the default user.
+	Username, Password string
+
+	// If set, the connection's name is set to this using CLIENT SETNAME, so
+	// that it can be identified in the output of CLIENT LIST and the like
+	ClientName string
+
+	// The database the connection SELECTs once it's been made
+	DB int
+
+	// If set, the connection switches to RESP3 straight away using
+	// HelloFallback, so that it stays on RESP2 if the server doesn't support
+	// it. Check Protocol to see which was negotiated. Negotiate does the same
+	// and more.
+	PreferRESP3 bool
+
+	// If set, the connection switches to RESP3 if the server supports it
+	// (using HelloFallback, regardless of PreferRESP3) and finds out the
+	// server's version, see Client.Server. Usually this takes one extra round
+	// trip, since the reply to HELLO describes the server, but servers older
+	// than redis 6 are asked using INFO instead.
+	Negotiate bool
+
+	// If set, it's set as the Client's OOMHandler. It may be called from
+	// multiple routines at once if the options are shared, e.g. by a Pool.
+	OOMHandler func(cmd string)
+
+	// If set, the connection reports LibName and LibVersion to the server
+	// using CLIENT SETINFO (redis 7.2 and up), so that they show up in CLIENT
+	// LIST and CLIENT INFO. Servers which don't support it are ignored, but it
+	// costs a round trip.
+	SetLibInfo bool
+
+	// If set, it's set on the Client, and its ConnCreated callback is called
+	// once the connection has been made or failed to be, as with DialTrace
+	Trace *Trace
+}
+
+// DialWithOpts connects to the given Redis server using the given options
+func DialWithOpts(network, addr string, opts DialOpts) (*Client, error) {
+	return dialTrace(network, addr, opts)
+}
+
+// dialConn makes the connection for a Client, applying the socket options and
+// then, if TLSConfig is set, performing the TLS handshake. The socket options
+// only apply to TCP connections.
+func dialConn(network, addr string, opts DialOpts) (net.Conn, error) {
+	d := net.Dialer{KeepAlive: opts.KeepAlive}
+	conn, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if err = setSockOpts(tc, opts); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if opts.TLSConfig == nil {
+		return conn, nil
+	}
+
+	cfg := opts.TLSConfig
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if opts.Timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(opts.Timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+func setSockOpts(tc *net.TCPConn, opts DialOpts) error {
+	if opts.DisableNoDelay {
+		if err := tc.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if opts.ReadBufferSize > 0 {
+		if err := tc.SetReadBuffer(opts.ReadBufferSize); err != nil {
+			return err
+		}
+	}
+	if opts.WriteBufferSize > 0 {
+		if err := tc.SetWriteBuffer(opts.WriteBufferSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setup authenticates the connection, sets its name and selects its database,
+// if the options call for it, closing it if any of those fail
+func (c *Client) setup(opts DialOpts) error {
+	var r *Reply
+	if opts.Password != "" && opts.Username != "" {
+		r = c.Cmd("AUTH", opts.Username, opts.Password)
+	} else if opts.Password != "" {
+		r = c.Cmd("AUTH", opts.Password)
+	}
+	if r == nil || r.Err == nil {
+		if opts.ClientName != "" {
+			r = c.Cmd("CLIENT", "SETNAME", opts.ClientName)
+		}
+	}
+	if r == nil || r.Err == nil {
+		if opts.DB != 0 {
+			r = c.Cmd("SELECT", opts.DB)
+		}
+	}
+	if r != nil && r.Err != nil {
+		c.Close()
+		return r.Err
+	}
+	return nil
+}
+
+// ParseURL returns the network, address and options to connect with according
+// to the given URL, of the form:
+//
+//	redis://[[username][:password]@]host[:port][/db]
+//
+// The port and database default to 6379 and 0. To authenticate as the default
+// user leave out the username, as in redis://:password@host. A rediss:// URL
+// connects over TLS, verifying the server's certificate against the host's
+// name.
+func ParseURL(rawurl string) (network, addr string, opts DialOpts, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", "", opts, err
+	} else if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return "", "", opts, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	} else if u.Host == "" {
+		return "", "", opts, errors.New("URL has no host")
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return "", "", opts, fmt.Errorf("invalid URL database %q", db)
+		}
+	}
+
+	addr = u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if u.Scheme == "rediss" {
+		opts.TLSConfig = &tls.Config{ServerName: u.Hostname()}
+	}
+	return "tcp", addr, opts, nil
+}
+
+// DialURL connects to the Redis server given by the URL, see ParseURL
+func DialURL(rawurl string) (*Client, error) {
+	network, addr, opts, err := ParseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return DialWithOpts(network, addr, opts)
+}
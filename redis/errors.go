@@ -0,0 +1,65 @@
+package redis
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/fzzy/radix/redis/resp"
+)
+
+// ErrNil is returned by Reply's conversion methods (Str, Int, List, etc...)
+// when the reply is a NilReply, e.g. when GET is called on a key which doesn't
+// exist
+var ErrNil error = errors.New("reply is nil")
+
+// IsTimeout returns whether the given error is due to a read or write timeout
+// on the connection. A Client which has timed out is not closed, but the late
+// reply may still arrive on it, so it should usually be discarded anyway.
+func IsTimeout(err error) bool {
+	t, ok := err.(net.Error)
+	return ok && t.Timeout()
+}
+
+// IsNetworkErr returns whether the given error is a connection/transport level
+// error (including timeouts and malformed replies), as opposed to an
+// application level error sent by the server (CmdError, LoadingError, ErrOOM)
+// or an error from this package which doesn't involve the connection (ErrNil,
+// a failed conversion, etc...). A Client which has returned a network error
+// should not be used again.
+func IsNetworkErr(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	return err == io.EOF || err == io.ErrUnexpectedEOF || resp.IsParseErr(err)
+}
+
+// IsLoading returns whether the given error is due to the server still loading
+// its dataset into memory after a restart. The command can be retried later on
+// the same connection.
+func IsLoading(err error) bool {
+	return err == LoadingError
+}
+
+// IsTransientErr returns whether the given error was sent by a server which is
+// temporarily unable to perform the command, so that it can be retried after
+// a wait: LOADING (see IsLoading), READONLY (the server is a replica, usually
+// because it was demoted by a failover), CLUSTERDOWN, TRYAGAIN (a multi-key
+// command hit a slot which is being migrated) and MASTERDOWN (a replica lost
+// its primary). See also DefaultServerRetryPolicy.
+func IsTransientErr(err error) bool {
+	if err == LoadingError {
+		return true
+	}
+	if _, ok := err.(*CmdError); !ok {
+		return false
+	}
+	msg := err.Error()
+	for _, prefix := range []string{"READONLY ", "CLUSTERDOWN ", "TRYAGAIN ", "MASTERDOWN "} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+)
+
+// Library is a library of redis functions (redis 7 and up), which is loaded
+// using FUNCTION LOAD the first time one of its functions is called and isn't
+// found. Since FUNCTION LOAD takes no key, a Library can't be loaded through a
+// Cluster, it must be loaded onto every node beforehand.
+//
+//	var lib = redis.MustLibrary(`#!lua name=mylib
+//		redis.register_function("getset", function(keys, args)
+//			local old = redis.call("GET", keys[1])
+//			redis.call("SET", keys[1], args[1])
+//			return old
+//		end)
+//	`)
+//
+//	old, err := lib.FCall(client, "getset", []string{"foo"}, "bar").Str()
+type Library struct {
+	name string
+	src  string
+}
+
+// NewLibrary returns a Library for the given source, which must start with a
+// shebang line giving the library's engine and name, e.g. "#!lua name=mylib"
+func NewLibrary(src string) (*Library, error) {
+	line := src
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		line = src[:i]
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return nil, errors.New("library source is missing its shebang line")
+	}
+	for _, field := range strings.Fields(line[2:]) {
+		if strings.HasPrefix(field, "name=") && len(field) > 5 {
+			return &Library{name: field[5:], src: src}, nil
+		}
+	}
+	return nil, errors.New("library shebang line is missing its name")
+}
+
+// MustLibrary is like NewLibrary, but panics if the source is invalid. It's
+// intended for libraries declared at the package level.
+func MustLibrary(src string) *Library {
+	l, err := NewLibrary(src)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Name returns the name of the library, as given in its shebang line
+func (l *Library) Name() string {
+	return l.name
+}
+
+// Load loads the library using the given Cmder with FUNCTION LOAD, replacing
+// any library of the same name which is already loaded
+func (l *Library) Load(c Cmder) error {
+	if err := requireFeature(c, FeatureFunctions); err != nil {
+		return err
+	}
+	return c.Cmd("FUNCTION", "LOAD", "REPLACE", l.src).Err
+}
+
+// FCall calls the given function of the library using FCALL, with the given
+// keys and other arguments. If the server doesn't know the function the
+// library is loaded and the call is made again. If c is a *Client or
+// *PersistentClient whose server is known to be older than redis 7 an
+// *UnsupportedError is returned without anything being sent, see
+// Client.RequireFeature.
+func (l *Library) FCall(c Cmder, fn string, keys []string, args ...interface{}) *Reply {
+	return l.fcall(c, "FCALL", fn, keys, args)
+}
+
+// FCallRO is like FCall, but uses FCALL_RO, which only allows functions
+// registered with the no-writes flag and may be performed on replicas
+func (l *Library) FCallRO(c Cmder, fn string, keys []string, args ...interface{}) *Reply {
+	return l.fcall(c, "FCALL_RO", fn, keys, args)
+}
+
+func (l *Library) fcall(c Cmder, cmd, fn string, keys []string, args []interface{}) *Reply {
+	if err := requireFeature(c, FeatureFunctions); err != nil {
+		return &Reply{Type: ErrorReply, Err: err}
+	}
+	fargs := make([]interface{}, 0, len(keys)+len(args)+2)
+	fargs = append(fargs, fn, len(keys))
+	for _, key := range keys {
+		fargs = append(fargs, key)
+	}
+	fargs = append(fargs, args...)
+
+	r := c.Cmd(cmd, fargs...)
+	if !isNoFunction(r.Err) {
+		return r
+	}
+	if err := l.Load(c); err != nil {
+		return &Reply{Type: ErrorReply, Err: err}
+	}
+	return c.Cmd(cmd, fargs...)
+}
+
+func isNoFunction(err error) bool {
+	if _, ok := err.(*CmdError); !ok {
+		return false
+	}
+	return strings.HasPrefix(err.Error(), "ERR Function not found")
+}
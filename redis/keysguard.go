@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeysGuard determines what a handle does with KEYS commands, which block the
+// server while they go through the whole keyspace. See WithKeysGuard.
+type KeysGuard int
+
+const (
+	// KeysAllow sends KEYS to the server as-is
+	KeysAllow KeysGuard = iota
+
+	// KeysReject fails KEYS with ErrKeysRejected without sending it
+	KeysReject
+
+	// KeysScan performs the equivalent of KEYS using SCAN, which doesn't
+	// block the server, and returns the same reply KEYS would have. If more
+	// keys than the limit match, ErrKeysLimit is returned instead.
+	KeysScan
+)
+
+// DefaultKeysLimit is the limit used by KeysScan when none is given
+const DefaultKeysLimit = 10000
+
+// keysScanCount is the COUNT given to each SCAN performed by KeysScan
+const keysScanCount = 1000
+
+var (
+	// ErrKeysRejected is returned for KEYS commands when using KeysReject
+	ErrKeysRejected = errors.New("KEYS rejected by KeysGuard")
+
+	// ErrKeysLimit is returned for KEYS commands when using KeysScan and more
+	// keys match than the limit allows
+	ErrKeysLimit = errors.New("KEYS matched more keys than the KeysGuard limit")
+)
+
+// WithKeysGuard sets what's done with KEYS commands. The limit only applies to
+// KeysScan, zero means DefaultKeysLimit.
+func WithKeysGuard(g KeysGuard, limit int) Option {
+	return func(o *Options) {
+		o.KeysGuard = g
+		o.KeysLimit = limit
+	}
+}
+
+// guardKeys returns the reply to a KEYS command according to the Options, or
+// nil if the command isn't KEYS or should be sent as-is. Any SCANs are
+// performed using c.
+func (o *Options) guardKeys(c Cmder, cmd string, args []interface{}) *Reply {
+	if o.KeysGuard == KeysAllow || !strings.EqualFold(cmd, "KEYS") {
+		return nil
+	}
+	if o.KeysGuard == KeysReject {
+		return &Reply{Type: ErrorReply, Err: ErrKeysRejected}
+	}
+	if len(args) != 1 {
+		return &Reply{Type: ErrorReply, Err: errors.New("KEYS takes exactly one pattern")}
+	}
+
+	limit := o.KeysLimit
+	if limit <= 0 {
+		limit = DefaultKeysLimit
+	}
+	s := NewScanner(c, ScanOpts{Pattern: fmt.Sprint(args[0]), Count: keysScanCount})
+	seen := map[string]bool{}
+	r := &Reply{Type: MultiReply}
+	for s.Next() {
+		// SCAN may return the same key more than once
+		if seen[s.Value()] {
+			continue
+		}
+		if len(r.Elems) == limit {
+			return &Reply{Type: ErrorReply, Err: ErrKeysLimit}
+		}
+		seen[s.Value()] = true
+		r.Elems = append(r.Elems, &Reply{Type: BulkReply, buf: []byte(s.Value())})
+	}
+	if err := s.Err(); err != nil {
+		return &Reply{Type: ErrorReply, Err: err}
+	}
+	return r
+}
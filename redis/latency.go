@@ -0,0 +1,188 @@
+package redis
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyTracker records a histogram of the latencies of each command, by
+// command name, so that slow commands can be found without timing every call
+// site. Set it as the Latency field of a Client (or pool.Pool) to have it
+// record every command performed on that connection:
+//
+//	lt := redis.NewLatencyTracker()
+//	p.Latency = lt
+//	...
+//	for cmd, h := range lt.Histograms() {
+//		log.Printf("%s: p50=%s p99=%s", cmd, h.Percentile(50), h.Percentile(99))
+//	}
+//
+// Latency is measured from writing a command to reading its reply, as for
+// Metrics, so commands which are pipelined include the time spent waiting on
+// the replies ahead of them. Its methods are safe to use from multiple routines
+// at once, and a single LatencyTracker may be shared by many Clients.
+type LatencyTracker struct {
+	buckets []time.Duration
+
+	lock sync.RWMutex
+	cmds map[string]*cmdLatency
+}
+
+// cmdLatency is the histogram of a single command. counts has a final bucket
+// for latencies above the largest bound. Its fields are updated atomically.
+type cmdLatency struct {
+	counts   []uint64
+	sum, max uint64
+}
+
+// NewLatencyTracker returns a LatencyTracker whose histograms use the given
+// bucket upper bounds, which are sorted. If none are given LatencyBuckets are
+// used.
+func NewLatencyTracker(buckets ...time.Duration) *LatencyTracker {
+	if len(buckets) == 0 {
+		buckets = LatencyBuckets[:]
+	}
+	buckets = append([]time.Duration(nil), buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return &LatencyTracker{
+		buckets: buckets,
+		cmds:    map[string]*cmdLatency{},
+	}
+}
+
+// Record records a command taking the given amount of time. The command name
+// is case-insensitive. Clients call this themselves, it's only needed for
+// recording commands performed by other means. A nil LatencyTracker records
+// nothing.
+func (lt *LatencyTracker) Record(cmd string, d time.Duration) {
+	if lt == nil {
+		return
+	}
+	cmd = strings.ToUpper(cmd)
+	lt.lock.RLock()
+	cl := lt.cmds[cmd]
+	lt.lock.RUnlock()
+	if cl == nil {
+		lt.lock.Lock()
+		if cl = lt.cmds[cmd]; cl == nil {
+			cl = &cmdLatency{counts: make([]uint64, len(lt.buckets)+1)}
+			lt.cmds[cmd] = cl
+		}
+		lt.lock.Unlock()
+	}
+
+	i := sort.Search(len(lt.buckets), func(i int) bool { return d <= lt.buckets[i] })
+	atomic.AddUint64(&cl.counts[i], 1)
+	atomic.AddUint64(&cl.sum, uint64(d))
+	for {
+		max := atomic.LoadUint64(&cl.max)
+		if uint64(d) <= max || atomic.CompareAndSwapUint64(&cl.max, max, uint64(d)) {
+			break
+		}
+	}
+}
+
+// Histogram returns a snapshot of the histogram of the given command, which is
+// empty if the command hasn't been recorded
+func (lt *LatencyTracker) Histogram(cmd string) LatencyHistogram {
+	lt.lock.RLock()
+	defer lt.lock.RUnlock()
+	return lt.histogram(lt.cmds[strings.ToUpper(cmd)])
+}
+
+// Histograms returns a snapshot of the histograms of every command which has
+// been recorded, keyed by upper-cased command name
+func (lt *LatencyTracker) Histograms() map[string]LatencyHistogram {
+	lt.lock.RLock()
+	defer lt.lock.RUnlock()
+	hs := make(map[string]LatencyHistogram, len(lt.cmds))
+	for cmd, cl := range lt.cmds {
+		hs[cmd] = lt.histogram(cl)
+	}
+	return hs
+}
+
+// Reset forgets everything which has been recorded
+func (lt *LatencyTracker) Reset() {
+	lt.lock.Lock()
+	defer lt.lock.Unlock()
+	lt.cmds = map[string]*cmdLatency{}
+}
+
+func (lt *LatencyTracker) histogram(cl *cmdLatency) LatencyHistogram {
+	h := LatencyHistogram{
+		Buckets: lt.buckets,
+		Counts:  make([]uint64, len(lt.buckets)),
+	}
+	if cl == nil {
+		return h
+	}
+	for i := range cl.counts {
+		h.Count += atomic.LoadUint64(&cl.counts[i])
+		if i < len(h.Counts) {
+			h.Counts[i] = h.Count
+		}
+	}
+	h.Sum = time.Duration(atomic.LoadUint64(&cl.sum))
+	h.Max = time.Duration(atomic.LoadUint64(&cl.max))
+	return h
+}
+
+// LatencyHistogram is a snapshot of the latencies of a single command, as
+// returned by LatencyTracker
+type LatencyHistogram struct {
+	// The upper bounds of the buckets, shared with the LatencyTracker
+	Buckets []time.Duration
+
+	// Counts[i] is the number of commands which took no longer than
+	// Buckets[i], so that like Metrics' Latency the counts are cumulative,
+	// and Count is the count for the implicit +Inf bucket
+	Counts []uint64
+	Count  uint64
+
+	// The total and the largest of all of the latencies
+	Sum, Max time.Duration
+}
+
+// Mean returns the mean latency, or 0 if nothing was recorded
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Percentile returns an estimate of the given percentile (e.g. 99 for the
+// 99th) of the latencies, or 0 if nothing was recorded. As with Prometheus'
+// histogram_quantile the estimate is interpolated linearly within the bucket
+// the percentile falls in, so its accuracy depends on the buckets. It's never
+// more than Max, which is also what's returned for percentiles which fall
+// beyond the largest bucket.
+func (h LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	if p < 0 {
+		p = 0
+	} else if p > 100 {
+		p = 100
+	}
+	rank := p / 100 * float64(h.Count)
+	var lower time.Duration
+	var below uint64
+	for i, n := range h.Counts {
+		if float64(n) >= rank && n > below {
+			frac := (rank - float64(below)) / float64(n-below)
+			d := lower + time.Duration(frac*float64(h.Buckets[i]-lower))
+			if d > h.Max {
+				d = h.Max
+			}
+			return d
+		}
+		lower, below = h.Buckets[i], n
+	}
+	return h.Max
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces the arguments removed by redaction rules
+const Redacted = "(redacted)"
+
+// CmdLogger is called once for every command performed on a Client whose
+// Logger is set, after its reply has been read (or failed to be). The
+// arguments have already been redacted, see RegisterRedaction, so they can be
+// logged as-is.
+type CmdLogger interface {
+	LogCmd(cmd string, args []interface{}, d time.Duration, err error)
+}
+
+// CmdLoggerFunc adapts an ordinary function into a CmdLogger
+type CmdLoggerFunc func(cmd string, args []interface{}, d time.Duration, err error)
+
+// LogCmd implements the method for the CmdLogger interface
+func (f CmdLoggerFunc) LogCmd(cmd string, args []interface{}, d time.Duration, err error) {
+	f(cmd, args, d, err)
+}
+
+// RedactFunc redacts the sensitive arguments of a command, by replacing them
+// with Redacted. It's given a copy of the arguments as they were passed to the
+// Client, so it may modify them in place.
+type RedactFunc func(args []interface{})
+
+var (
+	redactionsLock sync.RWMutex
+	redactions     = map[string]RedactFunc{
+		"AUTH":    redactAuth,
+		"HELLO":   redactHello,
+		"MIGRATE": redactMigrate,
+		"CONFIG":  redactConfig,
+		"ACL":     redactACL,
+	}
+)
+
+// RegisterRedaction sets the rule used to redact the arguments of the given
+// command before they're passed to a CmdLogger, replacing any existing rule for
+// it. Rules for AUTH, HELLO, MIGRATE, CONFIG SET and ACL SETUSER are registered
+// by default. A nil RedactFunc removes the command's rule.
+func RegisterRedaction(cmd string, fn RedactFunc) {
+	redactionsLock.Lock()
+	defer redactionsLock.Unlock()
+	if fn == nil {
+		delete(redactions, strings.ToUpper(cmd))
+		return
+	}
+	redactions[strings.ToUpper(cmd)] = fn
+}
+
+// Redact returns the arguments of the given command with the sensitive ones
+// replaced by Redacted, according to the rules set with RegisterRedaction. The
+// given slice is never modified.
+func Redact(cmd string, args []interface{}) []interface{} {
+	redactionsLock.RLock()
+	fn := redactions[strings.ToUpper(cmd)]
+	redactionsLock.RUnlock()
+	if fn == nil {
+		return args
+	}
+	cp := make([]interface{}, len(args))
+	copy(cp, args)
+	fn(cp)
+	return cp
+}
+
+func (c *Client) logCmd(req *request, err error) {
+	if c.Logger != nil {
+		c.Logger.LogCmd(req.cmd, Redact(req.cmd, req.args), time.Since(req.start), err)
+	}
+}
+
+// argIs returns whether the i'th argument is the given keyword
+func argIs(args []interface{}, i int, kw string) bool {
+	if i >= len(args) {
+		return false
+	} else if b, ok := args[i].([]byte); ok {
+		return strings.EqualFold(string(b), kw)
+	}
+	return strings.EqualFold(fmt.Sprint(args[i]), kw)
+}
+
+// AUTH [username] password
+func redactAuth(args []interface{}) {
+	if len(args) > 0 {
+		args[len(args)-1] = Redacted
+	}
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func redactHello(args []interface{}) {
+	for i := range args {
+		if argIs(args, i, "AUTH") && i+2 < len(args) {
+			args[i+2] = Redacted
+		}
+	}
+}
+
+// MIGRATE ... [AUTH password | AUTH2 username password] [KEYS key ...]
+func redactMigrate(args []interface{}) {
+	for i := range args {
+		if argIs(args, i, "KEYS") {
+			return
+		} else if argIs(args, i, "AUTH") && i+1 < len(args) {
+			args[i+1] = Redacted
+		} else if argIs(args, i, "AUTH2") && i+2 < len(args) {
+			args[i+2] = Redacted
+		}
+	}
+}
+
+// CONFIG SET parameter value [parameter value ...]
+func redactConfig(args []interface{}) {
+	if !argIs(args, 0, "SET") {
+		return
+	}
+	for i := 1; i+1 < len(args); i += 2 {
+		if argIs(args, i, "requirepass") || argIs(args, i, "masterauth") {
+			args[i+1] = Redacted
+		}
+	}
+}
+
+// ACL SETUSER username [rule ...], where >password, <password, #hash and !hash
+// rules contain secrets
+func redactACL(args []interface{}) {
+	if !argIs(args, 0, "SETUSER") {
+		return
+	}
+	for i := 2; i < len(args); i++ {
+		if s := fmt.Sprint(args[i]); s != "" && strings.IndexByte("><#!", s[0]) >= 0 {
+			args[i] = Redacted
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package redis
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of Metrics' Latency
+// histogram
+var LatencyBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Metrics is a snapshot of cumulative counters, as returned by Client.Metrics,
+// PersistentClient.Metrics and pool.Pool.Metrics. It's a plain struct so that
+// it can be handed straight to expvar, or translated into Prometheus metrics by
+// whatever scrapes it.
+type Metrics struct {
+	// Commands whose reply has been read, or which failed to be written
+	Cmds uint64
+
+	// Bytes read from and written to the connection(s)
+	BytesIn, BytesOut uint64
+
+	// Commands which failed, by class. ServerErrs are error replies sent by
+	// the server (CmdError and LoadingError), NetworkErrs are failures to write
+	// a command or read its reply, and TimeoutErrs are the subset of the
+	// latter which were timeouts.
+	NetworkErrs, TimeoutErrs, ServerErrs uint64
+
+	// Connections dialed, dials which failed, and connections re-dialed after
+	// a network error. A plain Client only counts its own dial, failures are
+	// only recorded by whatever does the dialing on the Client's behalf (e.g.
+	// a PersistentClient or pool.Pool).
+	Dials, DialErrs, Reconnects uint64
+
+	// Histogram of the time between writing a command and reading its reply.
+	// Latency[i] is the number of commands which took no longer than
+	// LatencyBuckets[i], so that like in Prometheus the counts are cumulative,
+	// and Cmds is the count for the implicit +Inf bucket. LatencySum is the
+	// total of all the commands' latencies.
+	Latency    [len(LatencyBuckets)]uint64
+	LatencySum time.Duration
+}
+
+// Counters accumulates the counters reported as Metrics. Its methods are safe
+// to use from multiple routines at once, and a single Counters may be shared by
+// many Clients (which is what pool.Pool does) by setting their Counters field
+// to it. The zero value is ready to use.
+type Counters struct {
+	cmds, bytesIn, bytesOut                 uint64
+	networkErrs, timeoutErrs, serverErrs    uint64
+	dials, dialErrs, reconnects, latencySum uint64
+	latency                                 [len(LatencyBuckets)]uint64
+}
+
+// Metrics returns a snapshot of the counters. A nil Counters returns zero
+// Metrics.
+func (m *Counters) Metrics() Metrics {
+	if m == nil {
+		return Metrics{}
+	}
+	s := Metrics{
+		Cmds:        atomic.LoadUint64(&m.cmds),
+		BytesIn:     atomic.LoadUint64(&m.bytesIn),
+		BytesOut:    atomic.LoadUint64(&m.bytesOut),
+		NetworkErrs: atomic.LoadUint64(&m.networkErrs),
+		TimeoutErrs: atomic.LoadUint64(&m.timeoutErrs),
+		ServerErrs:  atomic.LoadUint64(&m.serverErrs),
+		Dials:       atomic.LoadUint64(&m.dials),
+		DialErrs:    atomic.LoadUint64(&m.dialErrs),
+		Reconnects:  atomic.LoadUint64(&m.reconnects),
+		LatencySum:  time.Duration(atomic.LoadUint64(&m.latencySum)),
+	}
+	var n uint64
+	for i := range m.latency {
+		n += atomic.LoadUint64(&m.latency[i])
+		s.Latency[i] = n
+	}
+	return s
+}
+
+// AddDial records a connection being dialed, or failing to be if err is set.
+// It's for use by things which dial Clients on behalf of others.
+func (m *Counters) AddDial(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		atomic.AddUint64(&m.dialErrs, 1)
+		return
+	}
+	atomic.AddUint64(&m.dials, 1)
+}
+
+// AddReconnect records a connection being re-dialed after a network error.
+// It's for use by things which dial Clients on behalf of others.
+func (m *Counters) AddReconnect() {
+	if m != nil {
+		atomic.AddUint64(&m.reconnects, 1)
+	}
+}
+
+func (m *Counters) addCmd(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.cmds, 1)
+	if _, ok := err.(*CmdError); ok || err == LoadingError {
+		atomic.AddUint64(&m.serverErrs, 1)
+	} else if err != nil {
+		atomic.AddUint64(&m.networkErrs, 1)
+		if IsTimeout(err) {
+			atomic.AddUint64(&m.timeoutErrs, 1)
+		}
+	}
+
+	atomic.AddUint64(&m.latencySum, uint64(d))
+	for i, b := range LatencyBuckets {
+		if d <= b {
+			atomic.AddUint64(&m.latency[i], 1)
+			break
+		}
+	}
+}
+
+// Metrics returns a snapshot of the Client's Counters. If those are shared with
+// other Clients, e.g. because the Client came from a pool.Pool, the snapshot
+// covers all of them.
+func (c *Client) Metrics() Metrics {
+	return c.Counters.Metrics()
+}
+
+// countingConn reads from and writes to a Client's connection, adding to its
+// Counters as it goes. It reads the Counters field on every call, so that the
+// Client's Counters can be swapped out after dialing.
+type countingConn struct {
+	c *Client
+}
+
+func (cc countingConn) Read(b []byte) (int, error) {
+	n, err := cc.c.Conn.Read(b)
+	if m := cc.c.Counters; m != nil && n > 0 {
+		atomic.AddUint64(&m.bytesIn, uint64(n))
+	}
+	return n, err
+}
+
+func (cc countingConn) Write(b []byte) (int, error) {
+	n, err := cc.c.Conn.Write(b)
+	if m := cc.c.Counters; m != nil && n > 0 {
+		atomic.AddUint64(&m.bytesOut, uint64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,172 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MonitorLine is a single command reported by MONITOR
+type MonitorLine struct {
+	Time time.Time
+	DB   int
+
+	// Address of the client which sent the command, "lua" for commands
+	// called from scripts, or "unix:<path>" for unix socket clients
+	ClientAddr string
+
+	Cmd  string
+	Args []string
+
+	// Set if reading from the connection failed, in which case this is the
+	// last value sent on Monitor.Ch and none of the other fields are set
+	Err error
+}
+
+// Monitor streams the commands processed by the server, see Client.Monitor
+type Monitor struct {
+	// Every command reported by the server is sent on this channel. It's
+	// closed once the Monitor is closed or the connection fails.
+	Ch chan *MonitorLine
+
+	c         *Client
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Monitor sends MONITOR, after which every command processed by the server is
+// sent on the returned Monitor's Ch, for debugging and auditing. Since nothing
+// else can be done on the connection afterwards, the Client is owned by the
+// Monitor from then on, and is closed along with it. The Client's timeout
+// doesn't apply while waiting for commands.
+func (c *Client) Monitor() (*Monitor, error) {
+	if err := c.Cmd("MONITOR").Err; err != nil {
+		return nil, err
+	}
+	m := &Monitor{
+		Ch:      make(chan *MonitorLine),
+		c:       c,
+		closeCh: make(chan struct{}),
+	}
+	go m.spin()
+	return m, nil
+}
+
+func (m *Monitor) spin() {
+	defer close(m.Ch)
+	m.c.Conn.SetReadDeadline(time.Time{})
+	for {
+		r := m.c.parse()
+		var l *MonitorLine
+		if r.Err != nil {
+			l = &MonitorLine{Err: r.Err}
+		} else if s, err := r.Str(); err != nil {
+			l = &MonitorLine{Err: err}
+		} else if l, err = ParseMonitorLine(s); err != nil {
+			// A line we don't understand isn't reason enough to stop
+			continue
+		}
+
+		select {
+		case m.Ch <- l:
+		case <-m.closeCh:
+			return
+		}
+		if l.Err != nil {
+			return
+		}
+	}
+}
+
+// Close closes the Monitor's connection. Ch is closed shortly afterwards. It's
+// safe to call more than once.
+func (m *Monitor) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closeCh)
+		err = m.c.Close()
+	})
+	return err
+}
+
+// ParseMonitorLine parses a line of MONITOR output, such as:
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func ParseMonitorLine(s string) (*MonitorLine, error) {
+	malformed := errors.New("malformed monitor line")
+
+	i := strings.Index(s, " [")
+	j := strings.Index(s, "] ")
+	if i < 0 || j < i {
+		return nil, malformed
+	}
+
+	l := &MonitorLine{}
+	secs, frac := s[:i], ""
+	if k := strings.IndexByte(secs, '.'); k >= 0 {
+		secs, frac = secs[:k], secs[k+1:]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return nil, malformed
+	}
+	var usec int64
+	if frac != "" {
+		if usec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return nil, malformed
+		}
+	}
+	l.Time = time.Unix(sec, usec*int64(time.Microsecond))
+
+	src := strings.SplitN(s[i+2:j], " ", 2)
+	if len(src) != 2 {
+		return nil, malformed
+	}
+	if l.DB, err = strconv.Atoi(src[0]); err != nil {
+		return nil, malformed
+	}
+	l.ClientAddr = src[1]
+
+	args, err := splitMonitorArgs(s[j+2:])
+	if err != nil || len(args) == 0 {
+		return nil, malformed
+	}
+	l.Cmd, l.Args = args[0], args[1:]
+	return l, nil
+}
+
+// splitMonitorArgs splits the space separated, quoted and escaped arguments of
+// a monitor line. The escaping redis uses is a subset of Go's.
+func splitMonitorArgs(s string) ([]string, error) {
+	var args []string
+	for s != "" {
+		if s[0] == ' ' {
+			s = s[1:]
+			continue
+		} else if s[0] != '"' {
+			return nil, errors.New("unquoted argument")
+		}
+
+		end := -1
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, errors.New("unterminated argument")
+		}
+		arg, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		s = s[end+1:]
+	}
+	return args, nil
+}
@@ -0,0 +1,122 @@
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// OnceStatus describes the outcome of a call to Once
+type OnceStatus int
+
+const (
+	// OnceRan means this call ran the function. If it returned an error the
+	// key was cleared, so that some other call may run it again.
+	OnceRan OnceStatus = iota
+
+	// OnceRunning means another call, probably on another instance, is
+	// currently running the function
+	OnceRunning
+
+	// OnceDone means the function has already been run to completion
+	OnceDone
+)
+
+func (s OnceStatus) String() string {
+	switch s {
+	case OnceRan:
+		return "ran"
+	case OnceRunning:
+		return "running"
+	case OnceDone:
+		return "done"
+	}
+	return "unknown"
+}
+
+// The value the key of a Once is left with once its function has completed
+const onceDoneValue = "done"
+
+var onceExtendScript = NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+var onceFinishScript = NewScript(1, `
+	if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+		return 0
+	end
+	if ARGV[2] == "" then
+		return redis.call("DEL", KEYS[1])
+	end
+	redis.call("SET", KEYS[1], ARGV[2])
+	return 1
+`)
+
+// Once makes sure fn, for example a migration or the seeding of some data, is
+// run by exactly one caller across every instance of a program using the same
+// key. The caller which manages to set the key (using SET NX) runs fn, all
+// others return right away with a status saying whether it's still running or
+// has completed.
+//
+// While fn runs the key's TTL is extended every third of ttl, so ttl only
+// needs to be long enough to cover a crashed instance, not fn itself. If fn
+// completes successfully the key is left set forever, and subsequent calls
+// return OnceDone. If it returns an error the key is deleted, and the error is
+// returned along with OnceRan.
+//
+// The Cmder is used from a separate routine while fn runs, so it must be safe
+// for concurrent use, e.g. a Pool or a Cluster rather than a Client.
+func Once(c Cmder, key string, ttl time.Duration, fn func() error) (OnceStatus, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return OnceRan, err
+	}
+	token := hex.EncodeToString(b)
+	ttlMs := int64(ttl / time.Millisecond)
+
+	r := c.Cmd("SET", key, token, "PX", ttlMs, "NX")
+	if r.Err != nil {
+		return OnceRan, r.Err
+	} else if r.Type == NilReply {
+		v, err := c.Cmd("GET", key).Str()
+		if err == nil && v == onceDoneValue {
+			return OnceDone, nil
+		} else if err == ErrNil {
+			// It finished unsuccessfully in the meantime
+			err = nil
+		}
+		return OnceRunning, err
+	}
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		tick := time.NewTicker(ttl / 3)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				onceExtendScript.Cmd(c, key, token, ttlMs)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	err := fn()
+	close(stopCh)
+	<-doneCh
+
+	final := onceDoneValue
+	if err != nil {
+		final = ""
+	}
+	if ferr := onceFinishScript.Cmd(c, key, token, final).Err; err == nil {
+		err = ferr
+	}
+	return OnceRan, err
+}
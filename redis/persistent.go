@@ -0,0 +1,233 @@
+package redis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/redis/resp"
+)
+
+// Defaults used by DialPersistent for re-dialing after a network error
+const (
+	DefaultMaxDialAttempts = 10
+	DefaultInitialBackoff  = 100 * time.Millisecond
+	DefaultMaxBackoff      = 10 * time.Second
+)
+
+// PersistentClient wraps a single Client and transparently re-dials the
+// connection whenever a network error is encountered. Any connection setup
+// performed through the PersistentClient (HELLO, AUTH, SELECT and CLIENT
+// SETNAME) is remembered and replayed on every new connection, so callers can
+// keep using the same handle for the lifetime of their program instead of
+// writing their own reconnect loops.
+//
+// Unless a RetryPolicy is set, the reply of the command which encountered the
+// network error is still returned as-is, the re-dial happens on the next call.
+// Like Client, a PersistentClient is not safe to use from multiple routines at
+// once.
+type PersistentClient struct {
+	// Maximum number of dial attempts made when re-connecting, and the
+	// exponential backoff between them. These can be changed at any time.
+	MaxDialAttempts int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+
+	// If set, commands sent using Cmd which fail due to a network error will be
+	// re-dialed and retried according to this policy
+	RetryPolicy *RetryPolicy
+
+	network, addr string
+	opts          DialOpts
+	client        *Client
+	broken        bool
+	counters      Counters
+
+	hello, auth, sel, setname *request
+	commands                  map[string]*CommandInfo
+}
+
+// DialPersistentTimeout connects to the given Redis server with the given
+// timeout, returning a PersistentClient which will re-dial using the same
+// parameters as needed.
+func DialPersistentTimeout(
+	network, addr string, timeout time.Duration,
+) (
+	*PersistentClient, error,
+) {
+	return DialPersistentWithOpts(network, addr, DialOpts{Timeout: timeout})
+}
+
+// DialPersistentWithOpts connects to the given Redis server using the given
+// options, returning a PersistentClient which will re-dial using the same
+// options as needed.
+func DialPersistentWithOpts(network, addr string, opts DialOpts) (*PersistentClient, error) {
+	client, err := DialWithOpts(network, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	p := &PersistentClient{
+		MaxDialAttempts: DefaultMaxDialAttempts,
+		InitialBackoff:  DefaultInitialBackoff,
+		MaxBackoff:      DefaultMaxBackoff,
+		network:         network,
+		addr:            addr,
+		opts:            opts,
+		client:          client,
+	}
+	p.counters.AddDial(nil)
+	client.Counters = &p.counters
+	return p, nil
+}
+
+// DialPersistent connects to the given Redis server, returning a
+// PersistentClient.
+func DialPersistent(network, addr string) (*PersistentClient, error) {
+	return DialPersistentTimeout(network, addr, time.Duration(0))
+}
+
+// Client returns the Client currently being used. This will change after a
+// re-dial, so don't hold onto it.
+func (p *PersistentClient) Client() *Client {
+	return p.client
+}
+
+// Metrics returns a snapshot of the counters of every connection the
+// PersistentClient has used, including Reconnects.
+func (p *PersistentClient) Metrics() Metrics {
+	return p.counters.Metrics()
+}
+
+// Close closes the current connection. The PersistentClient should not be used
+// after this.
+func (p *PersistentClient) Close() error {
+	return p.client.Close()
+}
+
+// Cmd calls the given Redis command, re-dialing beforehand if a previous
+// command encountered a network error. If the command is part of the
+// connection's setup (HELLO, AUTH, SELECT or CLIENT SETNAME) and it succeeds
+// outside of a transaction it will be replayed on all future connections.
+func (p *PersistentClient) Cmd(cmd string, args ...interface{}) *Reply {
+	return p.cmd(p.RetryPolicy, cmd, args)
+}
+
+// Do runs the Action using the PersistentClient, see Action
+func (p *PersistentClient) Do(a Action) error {
+	return a.Run(p)
+}
+
+// CmdNoRetry is like Cmd, but the command will never be retried regardless of
+// RetryPolicy. Use this for commands which are not idempotent.
+func (p *PersistentClient) CmdNoRetry(cmd string, args ...interface{}) *Reply {
+	return p.cmd(nil, cmd, args)
+}
+
+func (p *PersistentClient) cmd(rp *RetryPolicy, cmd string, args []interface{}) *Reply {
+	for attempt := 1; ; attempt++ {
+		var r *Reply
+		if err := p.ensureConn(); err != nil {
+			r = &Reply{Type: ErrorReply, Err: err}
+		} else {
+			// Commands queued inside a transaction only take effect if it's
+			// EXECed, so aren't recorded
+			inTx := p.client.InTransaction()
+			r = p.checkReply(p.client.Cmd(cmd, args...))
+			if r.Err == nil && !inTx {
+				p.recordSetup(&request{cmd: cmd, args: args})
+			}
+		}
+		if !rp.ShouldRetry(attempt, r.Err) {
+			return r
+		}
+		time.Sleep(rp.Backoff(attempt))
+	}
+}
+
+// Append adds the given call to the pipeline queue of the current connection.
+// Use GetReply() to read the reply. If the connection is re-dialed any calls
+// which have not yet been sent are lost.
+func (p *PersistentClient) Append(cmd string, args ...interface{}) {
+	// If the re-dial fails the old, closed, client is kept around and the error
+	// will surface when GetReply is called
+	p.ensureConn()
+	p.client.Append(cmd, args...)
+}
+
+// GetReply returns the reply for the next request in the pipeline queue. See
+// Client's GetReply.
+func (p *PersistentClient) GetReply() *Reply {
+	return p.checkReply(p.client.GetReply())
+}
+
+// checkReply marks the connection as broken if the given reply indicates a
+// network error.
+func (p *PersistentClient) checkReply(r *Reply) *Reply {
+	if IsNetworkErr(r.Err) {
+		p.broken = true
+	}
+	return r
+}
+
+// recordSetup saves the given request if it's one that's part of a
+// connection's setup.
+func (p *PersistentClient) recordSetup(req *request) {
+	switch strings.ToUpper(req.cmd) {
+	case "HELLO":
+		p.hello = req
+	case "AUTH":
+		p.auth = req
+	case "SELECT":
+		p.sel = req
+	case "CLIENT":
+		if argIs(resp.Flatten(req.args), 0, "SETNAME") {
+			p.setname = req
+		}
+	}
+}
+
+func (p *PersistentClient) ensureConn() error {
+	if !p.broken {
+		return nil
+	}
+	p.client.Close()
+
+	backoff := p.InitialBackoff
+	var err error
+	for i := 0; i == 0 || i < p.MaxDialAttempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > p.MaxBackoff {
+				backoff = p.MaxBackoff
+			}
+		}
+
+		var client *Client
+		client, err = DialWithOpts(p.network, p.addr, p.opts)
+		p.counters.AddDial(err)
+		if err != nil {
+			continue
+		}
+		client.Counters = &p.counters
+		if err = p.replaySetup(client); err != nil {
+			client.Close()
+			continue
+		}
+		p.client = client
+		p.broken = false
+		p.counters.AddReconnect()
+		return nil
+	}
+	return err
+}
+
+func (p *PersistentClient) replaySetup(client *Client) error {
+	for _, req := range []*request{p.hello, p.auth, p.sel, p.setname} {
+		if req == nil {
+			continue
+		}
+		if err := client.Cmd(req.cmd, req.args...).Err; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	. "testing"
+	"time"
+)
+
+func TestPersistentClient(t *T) {
+	p, err := DialPersistentTimeout("tcp", "127.0.0.1:6379", 10*time.Second)
+	assert.Nil(t, err)
+	defer p.Close()
+
+	assert.Nil(t, p.Cmd("SELECT", 1).Err)
+	assert.Nil(t, p.Cmd("CLIENT", "SETNAME", "persistent-test").Err)
+	assert.Nil(t, p.Cmd("SET", "persistent-test", "foo").Err)
+
+	// Kill the connection out from under the client. The next command will
+	// fail, and the one after that should be on a new connection with the same
+	// setup
+	p.Client().Conn.Close()
+	assert.NotNil(t, p.Cmd("PING").Err)
+
+	name, err := p.Cmd("CLIENT", "GETNAME").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "persistent-test", name)
+
+	v, err := p.Cmd("GET", "persistent-test").Str()
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", v)
+}
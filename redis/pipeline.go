@@ -0,0 +1,146 @@
+package redis
+
+// Pipeline collects commands to be sent to redis all at once, and holds their
+// replies once they've been sent. Unlike with Append and GetReply there's no
+// way to leave replies unread on the connection: Flush reads all of them, and
+// if it can't the connection is closed rather than being left in an unknown
+// state.
+//
+//	p := client.Pipeline()
+//	get := p.Queue("GET", "foo")
+//	p.Queue("INCR", "bar")
+//	if err := p.Flush(); err != nil {
+//		// handle err
+//	}
+//	foo, err := p.Reply(get).Str()
+//
+// A Pipeline can be reused once it's been flushed, queueing commands for a new
+// batch.
+//
+// Flush can also be broken up into Send and ReadReply, so that later commands
+// in a batch can be queued based on the replies to earlier ones without
+// waiting for the whole batch to complete:
+//
+//	p.Queue("GET", "foo")
+//	p.Send()
+//	for p.Len() > 0 {
+//		key, err := p.ReadReply().Str()
+//		if err == nil {
+//			p.Queue("INCR", key)
+//			p.Send()
+//		}
+//	}
+type Pipeline struct {
+	c *Client
+
+	// All of the current batch's commands, the number which have been sent,
+	// and the replies which have been read so far
+	reqs    []*request
+	sent    int
+	replies []*Reply
+
+	// Whether the current batch has been flushed, in which case the next
+	// command starts a new one
+	flushed bool
+
+	// The network error encountered during the current batch, if any
+	err error
+}
+
+// Pipeline returns a new, empty Pipeline for the Client. The Client shouldn't
+// be used for anything else while commands are being flushed.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+func (p *Pipeline) resetIfFlushed() {
+	if p.flushed {
+		p.reqs, p.sent, p.replies = nil, 0, nil
+		p.flushed, p.err = false, nil
+	}
+}
+
+// Queue adds the given command to the batch which will be sent by the next
+// call to Send or Flush. It returns the index its reply can be retrieved with.
+func (p *Pipeline) Queue(cmd string, args ...interface{}) int {
+	p.resetIfFlushed()
+	p.reqs = append(p.reqs, &request{cmd: cmd, args: args})
+	return len(p.reqs) - 1
+}
+
+// Len returns the number of commands in the current batch whose replies
+// haven't been read yet
+func (p *Pipeline) Len() int {
+	if p.flushed {
+		return 0
+	}
+	return len(p.reqs) - len(p.replies)
+}
+
+// Send writes all queued commands which haven't been sent yet to the
+// connection, without reading any replies. They're buffered and flushed
+// together, so a batch usually goes out in a single write. If a network error
+// is encountered the connection is closed, and it's returned here and in the
+// replies to every command which hasn't been read yet.
+func (p *Pipeline) Send() error {
+	p.resetIfFlushed()
+	if p.err == nil && p.sent < len(p.reqs) {
+		p.err = p.c.writeRequest(p.reqs[p.sent:]...)
+	}
+	p.sent = len(p.reqs)
+	return p.err
+}
+
+// ReadReply reads and returns the reply to the earliest sent command whose
+// reply hasn't been read yet. It is also stored, to be retrieved later by
+// Reply. If there are no such commands an ErrorReply with
+// PipelineQueueEmptyError is returned.
+func (p *Pipeline) ReadReply() *Reply {
+	i := len(p.replies)
+	if p.flushed || i >= p.sent {
+		return &Reply{Type: ErrorReply, Err: PipelineQueueEmptyError}
+	}
+
+	var r *Reply
+	if p.err != nil {
+		r = &Reply{Type: ErrorReply, Err: p.err}
+	} else if r = p.c.readReplyFor(p.reqs[i]); IsNetworkErr(r.Err) {
+		// The rest of the replies are lost, there's no telling where the
+		// connection is at so it can't be used again
+		p.err = r.Err
+		p.c.Close()
+	}
+	p.replies = append(p.replies, r)
+	return r
+}
+
+// Flush sends all queued commands and reads all of the replies which haven't
+// been read yet. This completes the batch: its replies remain available until
+// a command is queued for the next one. If a network error is encountered it
+// is returned, the commands which didn't get a reply are given an ErrorReply
+// with that error, and the connection is closed. Errors replied by redis to
+// individual commands are not returned, they are only found in their Reply.
+func (p *Pipeline) Flush() error {
+	p.Send()
+	for len(p.replies) < len(p.reqs) {
+		p.ReadReply()
+	}
+	p.flushed = true
+	return p.err
+}
+
+// Reply returns the reply to the command which was given the index i by Queue,
+// either in the last flushed batch or, if it's already been read by ReadReply,
+// the current one
+func (p *Pipeline) Reply(i int) *Reply {
+	if i < 0 || i >= len(p.replies) {
+		return &Reply{Type: ErrorReply, Err: ErrPipelineIndex}
+	}
+	return p.replies[i]
+}
+
+// Replies returns all replies in the last flushed batch (or those read so far
+// in the current one), in the order their commands were queued
+func (p *Pipeline) Replies() []*Reply {
+	return p.replies
+}
@@ -1,8 +1,17 @@
 package redis
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"strconv"
+	"strings"
+
+	"github.com/fzzy/radix/redis/resp"
 )
 
 // A CmdError implements the error interface and is what is returned when the
@@ -19,6 +28,9 @@ import (
 //			// Is other error
 //		}
 //	}
+//
+// For most purposes though it's easier to use IsNetworkErr, IsTimeout and
+// IsLoading, along with checking against ErrNil and ErrOOM.
 type CmdError struct {
 	Err error
 }
@@ -40,6 +52,17 @@ IntegerReply -- integer reply
 NilReply -- nil reply
 BulkReply -- bulk reply
 MultiReply -- multi bulk reply
+
+The following are only returned by connections which have negotiated RESP3
+using HELLO:
+
+MapReply -- map reply, whose keys and values are interleaved in Elems
+SetReply -- set reply
+DoubleReply -- double reply
+BooleanReply -- boolean reply
+BigNumberReply -- big number reply
+VerbatimReply -- verbatim string reply
+PushReply -- out-of-band push reply (pub/sub messages, invalidations, etc...)
 */
 type ReplyType uint8
 
@@ -50,6 +73,13 @@ const (
 	NilReply
 	BulkReply
 	MultiReply
+	MapReply
+	SetReply
+	DoubleReply
+	BooleanReply
+	BigNumberReply
+	VerbatimReply
+	PushReply
 )
 
 // Reply holds a Redis reply.
@@ -61,17 +91,74 @@ type Reply struct {
 	int   int64
 }
 
-// Bytes returns the reply value as a byte string or
-// an error, if the reply type is not StatusReply or BulkReply.
+// NewReply returns a Reply holding the given value, for use as a canned reply
+// when testing code which takes a Cmder or Conn. Strings and []byte become
+// BulkReplys, ints IntegerReplys, nil a NilReply, errors ErrorReplys, and
+// slices of those (or of *Reply) MultiReplys. A *Reply is returned as-is.
+// Anything else results in an ErrorReply.
+func NewReply(v interface{}) *Reply {
+	switch v := v.(type) {
+	case *Reply:
+		return v
+	case nil:
+		return &Reply{Type: NilReply}
+	case error:
+		return &Reply{Type: ErrorReply, Err: v}
+	case string:
+		return &Reply{Type: BulkReply, buf: []byte(v)}
+	case []byte:
+		return &Reply{Type: BulkReply, buf: v}
+	case int:
+		return &Reply{Type: IntegerReply, int: int64(v)}
+	case int64:
+		return &Reply{Type: IntegerReply, int: v}
+	case []string:
+		r := &Reply{Type: MultiReply, Elems: make([]*Reply, len(v))}
+		for i := range v {
+			r.Elems[i] = NewReply(v[i])
+		}
+		return r
+	case []interface{}:
+		r := &Reply{Type: MultiReply, Elems: make([]*Reply, len(v))}
+		for i := range v {
+			r.Elems[i] = NewReply(v[i])
+		}
+		return r
+	case []*Reply:
+		return &Reply{Type: MultiReply, Elems: v}
+	default:
+		return &Reply{Type: ErrorReply, Err: fmt.Errorf("can't make a reply from %T", v)}
+	}
+}
+
+// Bytes returns the reply value as a byte string or an error, if the reply type
+// is not StatusReply or BulkReply (or DoubleReply, BigNumberReply or
+// VerbatimReply, in which case their textual form is returned).
 func (r *Reply) Bytes() ([]byte, error) {
-	if r.Type == ErrorReply {
+	switch r.Type {
+	case ErrorReply:
 		return nil, r.Err
-	}
-	if !(r.Type == StatusReply || r.Type == BulkReply) {
+	case NilReply:
+		return nil, ErrNil
+	case StatusReply, BulkReply, DoubleReply, BigNumberReply:
+		return r.buf, nil
+	case VerbatimReply:
+		return r.buf[4:], nil
+	default:
 		return nil, errors.New("string value is not available for this reply type")
 	}
+}
 
-	return r.buf, nil
+// WriteTo writes the reply value, as returned by Bytes, to w. It implements
+// io.WriterTo, so the value of a reply can be passed straight to io.Copy and
+// friends. See also Client.CmdWriteTo.
+func (r *Reply) WriteTo(w io.Writer) (int64, error) {
+	b, err := r.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
 }
 
 // Str is a convenience method for calling Reply.Bytes() and converting it to string
@@ -91,10 +178,17 @@ func (r *Reply) Int64() (int64, error) {
 	if r.Type == ErrorReply {
 		return 0, r.Err
 	}
+	if r.Type == NilReply {
+		return 0, ErrNil
+	}
 	if r.Type != IntegerReply {
-		s, err := r.Str()
+		b, err := r.Bytes()
 		if err == nil {
-			i64, err := strconv.ParseInt(s, 10, 64)
+			i64, err := resp.ParseInt(b)
+			if err != nil {
+				// ParseInt is stricter than strconv, e.g. about a leading +
+				i64, err = strconv.ParseInt(string(b), 10, 64)
+			}
 			if err != nil {
 				return 0, errors.New("failed to parse integer value from string value")
 			} else {
@@ -118,12 +212,19 @@ func (r *Reply) Int() (int, error) {
 	return int(i64), nil
 }
 
-// Bool returns false, if the reply value equals to 0 or "0", otherwise true; or
-// an error, if the reply type is not IntegerReply or BulkReply.
+// Bool returns false, if the reply value equals to 0 or "0", otherwise true
+// (so the OK StatusReply is true); or an error, if the reply type is not
+// IntegerReply, StatusReply, BulkReply or BooleanReply.
 func (r *Reply) Bool() (bool, error) {
 	if r.Type == ErrorReply {
 		return false, r.Err
 	}
+	if r.Type == NilReply {
+		return false, ErrNil
+	}
+	if r.Type == BooleanReply {
+		return r.int != 0, nil
+	}
 	i, err := r.Int()
 	if err == nil {
 		if i == 0 {
@@ -145,8 +246,123 @@ func (r *Reply) Bool() (bool, error) {
 	return false, errors.New("boolean value is not available for this reply type")
 }
 
+// Uint64 returns the reply value as a uint64 or an error, if the reply type is
+// not IntegerReply (with a non-negative value) or the reply type BulkReply
+// could not be parsed to a uint64.
+func (r *Reply) Uint64() (uint64, error) {
+	if r.Type == ErrorReply {
+		return 0, r.Err
+	}
+	if r.Type == IntegerReply {
+		if r.int < 0 {
+			return 0, errors.New("integer value is negative")
+		}
+		return uint64(r.int), nil
+	}
+	s, err := r.Str()
+	if err == ErrNil {
+		return 0, err
+	} else if err != nil {
+		return 0, errors.New("integer value is not available for this reply type")
+	}
+	u64, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.New("failed to parse integer value from string value")
+	}
+	return u64, nil
+}
+
+// Float64 returns the reply value as a float64 or an error, if the reply type
+// is not DoubleReply or IntegerReply, or the reply type BulkReply could not be
+// parsed to a float64 (e.g. the reply to ZSCORE or INCRBYFLOAT).
+func (r *Reply) Float64() (float64, error) {
+	if r.Type == ErrorReply {
+		return 0, r.Err
+	}
+	if r.Type == IntegerReply {
+		return float64(r.int), nil
+	}
+	s, err := r.Str()
+	if err == ErrNil {
+		return 0, err
+	} else if err != nil {
+		return 0, errors.New("float value is not available for this reply type")
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.New("failed to parse float value from string value")
+	}
+	return f, nil
+}
+
+// BigInt returns the reply value as a *big.Int or an error, if the reply type
+// is not BigNumberReply or IntegerReply.
+func (r *Reply) BigInt() (*big.Int, error) {
+	switch r.Type {
+	case ErrorReply:
+		return nil, r.Err
+	case NilReply:
+		return nil, ErrNil
+	case IntegerReply:
+		return big.NewInt(r.int), nil
+	case BigNumberReply:
+		i, ok := new(big.Int).SetString(string(r.buf), 10)
+		if !ok {
+			return nil, errors.New("failed to parse big number value from string value")
+		}
+		return i, nil
+	default:
+		return nil, errors.New("big number value is not available for this reply type")
+	}
+}
+
+// Verbatim returns the format (e.g. "txt" or "mkd") and the text of the reply
+// value, or an error if the reply type is not VerbatimReply. Use Str to get
+// only the text.
+func (r *Reply) Verbatim() (string, string, error) {
+	if r.Type == ErrorReply {
+		return "", "", r.Err
+	}
+	if r.Type == NilReply {
+		return "", "", ErrNil
+	}
+	if r.Type != VerbatimReply {
+		return "", "", errors.New("verbatim value is not available for this reply type")
+	}
+	return string(r.buf[:3]), string(r.buf[4:]), nil
+}
+
+// Map returns a MapReply, or a MultiReply in "key value key value..." order, as
+// a map[string]*Reply or an error. Keys must all have a string value. Unlike
+// Hash the values may be of any type.
+func (r *Reply) Map() (map[string]*Reply, error) {
+	if r.Type == ErrorReply {
+		return nil, r.Err
+	}
+	if r.Type == NilReply {
+		return nil, ErrNil
+	}
+	if r.Type != MapReply && r.Type != MultiReply {
+		return nil, errors.New("reply type is not MapReply or MultiReply")
+	}
+	if len(r.Elems)%2 != 0 {
+		return nil, errors.New("reply has odd number of elements")
+	}
+
+	m := make(map[string]*Reply, len(r.Elems)/2)
+	for i := 0; i < len(r.Elems); i += 2 {
+		key, err := r.Elems[i].Str()
+		if err != nil {
+			return nil, errors.New("key element has no string reply")
+		}
+		m[key] = r.Elems[i+1]
+	}
+	return m, nil
+}
+
 // List returns a multi bulk reply as a slice of strings or an error.
-// The reply type must be MultiReply and its elements' types must all be either BulkReply or NilReply.
+// The reply type must be MultiReply (or SetReply or PushReply) and its
+// elements' types must all be either BulkReply or NilReply.
 // Nil elements are returned as empty strings.
 // Useful for list commands.
 func (r *Reply) List() ([]string, error) {
@@ -155,7 +371,10 @@ func (r *Reply) List() ([]string, error) {
 	if r.Type == ErrorReply {
 		return nil, r.Err
 	}
-	if r.Type != MultiReply {
+	if r.Type == NilReply {
+		return nil, ErrNil
+	}
+	if r.Type != MultiReply && r.Type != SetReply && r.Type != PushReply {
 		return nil, errors.New("reply type is not MultiReply")
 	}
 
@@ -174,14 +393,18 @@ func (r *Reply) List() ([]string, error) {
 }
 
 // ListBytes returns a multi bulk reply as a slice of bytes slices or an error.
-// The reply type must be MultiReply and its elements' types must all be either BulkReply or NilReply.
+// The reply type must be MultiReply (or SetReply or PushReply) and its
+// elements' types must all be either BulkReply or NilReply.
 // Nil elements are returned as nil.
 // Useful for list commands.
 func (r *Reply) ListBytes() ([][]byte, error) {
 	if r.Type == ErrorReply {
 		return nil, r.Err
 	}
-	if r.Type != MultiReply {
+	if r.Type == NilReply {
+		return nil, ErrNil
+	}
+	if r.Type != MultiReply && r.Type != SetReply && r.Type != PushReply {
 		return nil, errors.New("reply type is not MultiReply")
 	}
 
@@ -200,7 +423,7 @@ func (r *Reply) ListBytes() ([][]byte, error) {
 }
 
 // Hash returns a multi bulk reply as a map[string]string or an error.
-// The reply type must be MultiReply,
+// The reply type must be MultiReply (or MapReply),
 // it must have an even number of elements,
 // they must be in a "key value key value..." order and
 // values must all be either BulkReply or NilReply.
@@ -210,9 +433,12 @@ func (r *Reply) Hash() (map[string]string, error) {
 	if r.Type == ErrorReply {
 		return nil, r.Err
 	}
+	if r.Type == NilReply {
+		return nil, ErrNil
+	}
 	rmap := map[string]string{}
 
-	if r.Type != MultiReply {
+	if r.Type != MultiReply && r.Type != MapReply {
 		return nil, errors.New("reply type is not MultiReply")
 	}
 
@@ -244,26 +470,236 @@ func (r *Reply) Hash() (map[string]string, error) {
 // String returns a string representation of the reply and its sub-replies.
 // This method is for debugging.
 // Use method Reply.Str() for reading string reply.
+//
+// A reply which isn't an aggregate is returned as its plain value, e.g. the
+// contents of a bulk string. Aggregates are laid out as redis-cli does, one
+// element per line, numbered and indented by how deeply they're nested, with
+// strings quoted and other types labelled:
+//
+//	fmt.Println(conn.Cmd("EXEC"))
+//	1) "foo"
+//	2) (integer) 5
+//	3) 1) "bar"
+//	   2) (nil)
 func (r *Reply) String() string {
 	switch r.Type {
 	case ErrorReply:
 		return r.Err.Error()
-	case StatusReply:
-		fallthrough
-	case BulkReply:
+	case StatusReply, BulkReply, DoubleReply, BigNumberReply, VerbatimReply:
 		return string(r.buf)
 	case IntegerReply:
 		return strconv.FormatInt(r.int, 10)
+	case BooleanReply:
+		return strconv.FormatBool(r.int != 0)
 	case NilReply:
 		return "<nil>"
-	case MultiReply:
-		s := "[ "
-		for _, e := range r.Elems {
-			s = s + e.String() + " "
-		}
-		return s + "]"
+	case MultiReply, MapReply, SetReply, PushReply:
+		buf := new(bytes.Buffer)
+		r.format(buf, 0)
+		return buf.String()
 	}
 
 	// This should never execute
 	return ""
 }
+
+// format writes the reply to buf in the layout described by String, with any
+// lines after the first indented by indent spaces
+func (r *Reply) format(buf *bytes.Buffer, indent int) {
+	switch r.Type {
+	case ErrorReply:
+		buf.WriteString("(error) " + r.Err.Error())
+	case StatusReply:
+		buf.Write(r.buf)
+	case BulkReply:
+		buf.WriteString(strconv.Quote(string(r.buf)))
+	case VerbatimReply:
+		buf.WriteString(strconv.Quote(string(r.buf[4:])))
+	case IntegerReply:
+		buf.WriteString("(integer) " + strconv.FormatInt(r.int, 10))
+	case DoubleReply:
+		buf.WriteString("(double) " + string(r.buf))
+	case BigNumberReply:
+		buf.WriteString("(big number) " + string(r.buf))
+	case BooleanReply:
+		buf.WriteString("(" + strconv.FormatBool(r.int != 0) + ")")
+	case NilReply:
+		buf.WriteString("(nil)")
+	case MultiReply, MapReply, SetReply, PushReply:
+		r.formatElems(buf, indent)
+	}
+}
+
+func (r *Reply) formatElems(buf *bytes.Buffer, indent int) {
+	marker, step := ')', 1
+	switch r.Type {
+	case MapReply:
+		marker, step = '#', 2
+	case SetReply:
+		marker = '~'
+	}
+	n := len(r.Elems) / step
+	if n == 0 {
+		buf.WriteString("(empty)")
+		return
+	}
+
+	width := len(strconv.Itoa(n))
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			buf.WriteByte('\n')
+			buf.WriteString(strings.Repeat(" ", indent))
+		}
+		start := buf.Len()
+		fmt.Fprintf(buf, "%*d%c ", width, i+1, marker)
+		e := r.Elems[i*step]
+		if step == 2 {
+			e.format(buf, indent+buf.Len()-start)
+			buf.WriteString(" => ")
+			e = r.Elems[i*step+1]
+		}
+		e.format(buf, indent+buf.Len()-start)
+	}
+}
+
+// Preview returns a representation of the reply and its sub-replies like
+// String does, but with strings quoted and escaped (see strconv.QuoteToASCII),
+// so that binary values are safe to print, and with the result truncated to at
+// most maxBytes followed by "..." (a negative maxBytes is treated as 0). It's
+// intended for logging replies which may be arbitrarily large, e.g. on error
+// paths.
+func (r *Reply) Preview(maxBytes int) string {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	buf := new(bytes.Buffer)
+	r.preview(buf, maxBytes)
+	if buf.Len() <= maxBytes {
+		return buf.String()
+	}
+	// Everything written is ASCII, so this can't split a character
+	return string(buf.Bytes()[:maxBytes]) + "..."
+}
+
+// preview writes the reply to buf, stopping once more than maxBytes have been
+// written
+func (r *Reply) preview(buf *bytes.Buffer, maxBytes int) {
+	if buf.Len() > maxBytes {
+		return
+	}
+	quote := func(b []byte) {
+		// Only quote as much as could possibly be shown
+		if room := maxBytes - buf.Len() + 1; len(b) > room {
+			b = b[:room]
+		}
+		buf.WriteString(strconv.QuoteToASCII(string(b)))
+	}
+
+	switch r.Type {
+	case ErrorReply:
+		quote([]byte(r.Err.Error()))
+	case StatusReply, BulkReply, VerbatimReply:
+		quote(r.buf)
+	case MultiReply, MapReply, SetReply, PushReply:
+		buf.WriteString("[ ")
+		for _, e := range r.Elems {
+			if buf.Len() > maxBytes {
+				return
+			}
+			e.preview(buf, maxBytes)
+			buf.WriteString(" ")
+		}
+		buf.WriteString("]")
+	default:
+		buf.WriteString(r.String())
+	}
+}
+
+// MarshalJSON implements json.Marshaler, mapping the reply onto the nearest
+// JSON types: strings (including bulk strings, which should therefore be
+// valid UTF-8) become strings, integers, doubles and big numbers become
+// numbers, booleans become booleans, nils become null, arrays, sets and push
+// replies become arrays, and maps become objects, with their keys in the order
+// they were received. Doubles which JSON can't represent (inf and nan) become
+// strings. Errors become an object of the form {"error": "message"}.
+func (r *Reply) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := r.writeJSON(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (r *Reply) writeJSON(buf *bytes.Buffer) error {
+	writeStr := func(s string) {
+		b, _ := json.Marshal(s)
+		buf.Write(b)
+	}
+
+	switch r.Type {
+	case ErrorReply:
+		buf.WriteString(`{"error":`)
+		writeStr(r.Err.Error())
+		buf.WriteString("}")
+	case StatusReply, BulkReply, VerbatimReply:
+		s, _ := r.Str()
+		writeStr(s)
+	case IntegerReply:
+		buf.WriteString(strconv.FormatInt(r.int, 10))
+	case DoubleReply:
+		f, err := r.Float64()
+		if err != nil {
+			return err
+		}
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			writeStr(string(r.buf))
+		} else {
+			buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case BigNumberReply:
+		i, err := r.BigInt()
+		if err != nil {
+			return err
+		}
+		buf.WriteString(i.String())
+	case BooleanReply:
+		buf.WriteString(strconv.FormatBool(r.int != 0))
+	case NilReply:
+		buf.WriteString("null")
+	case MultiReply, SetReply, PushReply:
+		buf.WriteString("[")
+		for i, e := range r.Elems {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			if err := e.writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	case MapReply:
+		if len(r.Elems)%2 != 0 {
+			return errors.New("map reply has an odd number of elements")
+		}
+		buf.WriteString("{")
+		for i := 0; i < len(r.Elems); i += 2 {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			// Keys which aren't strings are keyed by their String form
+			k, err := r.Elems[i].Str()
+			if err != nil {
+				k = r.Elems[i].String()
+			}
+			writeStr(k)
+			buf.WriteString(":")
+			if err := r.Elems[i+1].writeJSON(buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("}")
+	default:
+		return fmt.Errorf("unknown reply type %d", r.Type)
+	}
+	return nil
+}
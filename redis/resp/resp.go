@@ -1,16 +1,42 @@
 // This package provides an easy to use interface for creating and parsing
 // messages encoded in the REdis Serialization Protocol (RESP). You can check
 // out more details about the protocol here: http://redis.io/topics/protocol
+//
+// Both RESP2 and the additional types introduced by RESP3 can be read. RESP3
+// attributes are read but discarded, the Message they were attached to is
+// returned in their place.
+//
+// The package doesn't depend on the rest of radix, and only deals with
+// io.Readers and io.Writers, so it can be used on its own by proxies, protocol
+// analyzers and the like. For example, relaying every message from one
+// connection to another while logging it:
+//
+//	r := resp.NewReader(src)
+//	for {
+//		m, err := r.ReadMessage()
+//		if err != nil {
+//			return err
+//		}
+//		log.Printf("%q", m.Raw())
+//		if err := resp.WriteMessage(dst, m); err != nil {
+//			return err
+//		}
+//	}
 package resp
 
 import (
 	"bufio"
 	"bytes"
+	"encoding"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
 	"reflect"
 	"strconv"
+	"sync"
+	"time"
 )
 
 var (
@@ -27,6 +53,15 @@ const (
 	BulkStr
 	Array
 	Nil
+
+	// RESP3 types
+	Map
+	Set
+	Double
+	Bool
+	BigNumber
+	VerbatimStr
+	Push
 )
 
 const (
@@ -35,6 +70,18 @@ const (
 	intPrefix       = ':'
 	bulkStrPrefix   = '$'
 	arrayPrefix     = '*'
+
+	// RESP3
+	nullPrefix      = '_'
+	doublePrefix    = ','
+	boolPrefix      = '#'
+	blobErrPrefix   = '!'
+	verbatimPrefix  = '='
+	bigNumPrefix    = '('
+	mapPrefix       = '%'
+	setPrefix       = '~'
+	attributePrefix = '|'
+	pushPrefix      = '>'
 )
 
 // Parse errors
@@ -43,6 +90,12 @@ var (
 	parseErr = errors.New("parse error")
 )
 
+// IsParseErr returns whether the given error was returned due to malformed
+// data being read
+func IsParseErr(err error) bool {
+	return err == badType || err == parseErr
+}
+
 type Message struct {
 	Type
 	val interface{}
@@ -75,13 +128,97 @@ func NewSimpleString(s string) *Message {
 	}
 }
 
+// NewBulkStr returns a Message holding the given bulk string, already encoded.
+// Writing it costs nothing more than copying its encoded form, so it can be
+// used to avoid re-encoding a value which is written over and over.
+func NewBulkStr(b []byte) *Message {
+	return &Message{
+		Type: BulkStr,
+		val:  b,
+		raw:  formatStr(b),
+	}
+}
+
 // ReadMessage attempts to read a message object from the given io.Reader, parse
-// it, and return a Message struct representing it
+// it, and return a Message struct representing it. Unless the io.Reader is a
+// *bufio.Reader, anything read past the end of the message is lost, so use a
+// Reader to read multiple messages from a stream.
 func ReadMessage(reader io.Reader) (*Message, error) {
-	r := bufio.NewReader(reader)
+	r, put := getBufioReader(reader)
+	defer put()
 	return bufioReadMessage(r)
 }
 
+var bufioReaderPool = sync.Pool{
+	New: func() interface{} { return bufio.NewReader(nil) },
+}
+
+// getBufioReader returns the given io.Reader as a *bufio.Reader, either as-is
+// or by taking one from bufioReaderPool, along with a function which puts it
+// back once it's no longer being used
+func getBufioReader(reader io.Reader) (*bufio.Reader, func()) {
+	if r, ok := reader.(*bufio.Reader); ok {
+		return r, func() {}
+	}
+	r := bufioReaderPool.Get().(*bufio.Reader)
+	r.Reset(reader)
+	return r, func() {
+		r.Reset(nil)
+		bufioReaderPool.Put(r)
+	}
+}
+
+// ParseInt parses the given decimal integer, as strconv.ParseInt(string(b),
+// 10, 64) does but without converting b to a string first
+func ParseInt(b []byte) (int64, error) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 19 {
+		return 0, parseErr
+	}
+	var n uint64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, parseErr
+		}
+		n = n*10 + uint64(c-'0')
+	}
+	if neg {
+		if n > 1<<63 {
+			return 0, parseErr
+		}
+		return -int64(n), nil
+	} else if n > 1<<63-1 {
+		return 0, parseErr
+	}
+	return int64(n), nil
+}
+
+// Reader reads consecutive messages from a stream, such as a connection,
+// buffering whatever is read past the end of each one for the next
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader reading from the given io.Reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadMessage reads the next message from the stream
+func (r *Reader) ReadMessage() (*Message, error) {
+	return bufioReadMessage(r.r)
+}
+
+// Buffered returns the number of bytes which have been read from the stream
+// but not yet consumed by ReadMessage. A server can use this to tell whether
+// more pipelined commands are waiting before flushing its replies.
+func (r *Reader) Buffered() int {
+	return r.r.Buffered()
+}
+
 func bufioReadMessage(r *bufio.Reader) (*Message, error) {
 	b, err := r.Peek(1)
 	if err != nil {
@@ -97,12 +234,131 @@ func bufioReadMessage(r *bufio.Reader) (*Message, error) {
 	case bulkStrPrefix:
 		return readBulkStr(r)
 	case arrayPrefix:
-		return readArray(r)
+		return readAggregate(r, Array, 1)
+	case nullPrefix:
+		return readNull(r)
+	case doublePrefix:
+		return readSimple(r, Double)
+	case boolPrefix:
+		return readBool(r)
+	case blobErrPrefix:
+		return readBlob(r, Err)
+	case verbatimPrefix:
+		return readBlob(r, VerbatimStr)
+	case bigNumPrefix:
+		return readSimple(r, BigNumber)
+	case mapPrefix:
+		return readAggregate(r, Map, 2)
+	case setPrefix:
+		return readAggregate(r, Set, 1)
+	case pushPrefix:
+		return readAggregate(r, Push, 1)
+	case attributePrefix:
+		return readAttribute(r)
 	default:
 		return nil, badType
 	}
 }
 
+// CopyBulkStr reads a message off of the given io.Reader. If it's a non-nil
+// BulkStr its contents are copied directly into w, rather than being read into
+// memory first, and the number of bytes copied is returned. Otherwise the
+// message is read and returned as ReadMessage would, and nothing is written to
+// w.
+//
+// If writing to w fails the rest of the BulkStr is still read and discarded,
+// so the reader is left at the start of the next message.
+func CopyBulkStr(w io.Writer, reader io.Reader) (int64, *Message, error) {
+	r, put := getBufioReader(reader)
+	defer put()
+	b, err := r.Peek(2)
+	if err != nil {
+		return 0, nil, err
+	}
+	if b[0] != bulkStrPrefix || b[1] == '-' {
+		m, err := bufioReadMessage(r)
+		return 0, m, err
+	}
+
+	if b, err = r.ReadSlice(delimEnd); err != nil {
+		return 0, nil, err
+	}
+	size, err := ParseInt(b[1 : len(b)-2])
+	if err != nil {
+		return 0, nil, parseErr
+	}
+
+	ew := &discardingWriter{w: w}
+	_, err = io.CopyN(ew, r, size)
+	if err == nil {
+		// There's a hanging \r\n there, gotta read past it
+		_, err = io.CopyN(ioutil.Discard, r, 2)
+	}
+	if err != nil {
+		return ew.n, nil, err
+	}
+	return ew.n, nil, ew.err
+}
+
+// ReadBulkStrInto reads a message off of the given io.Reader. If it's a
+// non-nil BulkStr its contents are appended to buf, which is returned, so that
+// no memory is allocated if buf has the capacity for them. Otherwise the
+// message is read and returned as ReadMessage would, and buf is returned
+// unchanged.
+func ReadBulkStrInto(reader io.Reader, buf []byte) ([]byte, *Message, error) {
+	r, put := getBufioReader(reader)
+	defer put()
+	b, err := r.Peek(2)
+	if err != nil {
+		return buf, nil, err
+	}
+	if b[0] != bulkStrPrefix || b[1] == '-' {
+		m, err := bufioReadMessage(r)
+		return buf, m, err
+	}
+
+	if b, err = r.ReadSlice(delimEnd); err != nil {
+		return buf, nil, err
+	}
+	size, err := ParseInt(b[1 : len(b)-2])
+	if err != nil {
+		return buf, nil, parseErr
+	}
+
+	l := len(buf)
+	if cap(buf)-l < int(size) {
+		grown := make([]byte, l, l+int(size))
+		copy(grown, buf)
+		buf = grown
+	}
+	buf = buf[:l+int(size)]
+	if _, err := io.ReadFull(r, buf[l:]); err != nil {
+		return buf[:l], nil, err
+	}
+	// There's a hanging \r\n there, gotta read past it
+	if _, err := r.Discard(2); err != nil {
+		return buf[:l], nil, err
+	}
+	return buf, nil, nil
+}
+
+// discardingWriter writes to its underlying io.Writer until that returns an
+// error, after which it keeps the error and discards everything written to it
+type discardingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (dw *discardingWriter) Write(b []byte) (int, error) {
+	if dw.err == nil {
+		n, err := dw.w.Write(b)
+		dw.n += int64(n)
+		dw.err = err
+	}
+	return len(b), nil
+}
+
 func readSimpleStr(r *bufio.Reader) (*Message, error) {
 	b, err := r.ReadBytes(delimEnd)
 	if err != nil {
@@ -119,12 +375,43 @@ func readError(r *bufio.Reader) (*Message, error) {
 	return &Message{Type: Err, val: b[1 : len(b)-2], raw: b}, nil
 }
 
+func readSimple(r *bufio.Reader, t Type) (*Message, error) {
+	b, err := r.ReadBytes(delimEnd)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: t, val: b[1 : len(b)-2], raw: b}, nil
+}
+
+func readNull(r *bufio.Reader) (*Message, error) {
+	b, err := r.ReadBytes(delimEnd)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: Nil, raw: b}, nil
+}
+
+func readBool(r *bufio.Reader) (*Message, error) {
+	b, err := r.ReadBytes(delimEnd)
+	if err != nil {
+		return nil, err
+	}
+	switch string(b[1 : len(b)-2]) {
+	case "t":
+		return &Message{Type: Bool, val: true, raw: b}, nil
+	case "f":
+		return &Message{Type: Bool, val: false, raw: b}, nil
+	default:
+		return nil, parseErr
+	}
+}
+
 func readInt(r *bufio.Reader) (*Message, error) {
 	b, err := r.ReadBytes(delimEnd)
 	if err != nil {
 		return nil, err
 	}
-	i, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	i, err := ParseInt(b[1 : len(b)-2])
 	if err != nil {
 		return nil, parseErr
 	}
@@ -132,52 +419,44 @@ func readInt(r *bufio.Reader) (*Message, error) {
 }
 
 func readBulkStr(r *bufio.Reader) (*Message, error) {
-	b, err := r.ReadBytes(delimEnd)
+	return readBlob(r, BulkStr)
+}
+
+// readBlob reads a length-prefixed string, returning a message of the given
+// type. The header, string and trailing \r\n are all read into a single
+// slice, which the message's value is a sub-slice of.
+func readBlob(r *bufio.Reader, t Type) (*Message, error) {
+	// The header is only valid until the next read, but it's copied into raw
+	// straight away
+	b, err := r.ReadSlice(delimEnd)
 	if err != nil {
 		return nil, err
 	}
-	size, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	size, err := ParseInt(b[1 : len(b)-2])
 	if err != nil {
 		return nil, parseErr
 	}
 	if size < 0 {
-		return &Message{Type: Nil, raw: b}, nil
-	}
-	total := make([]byte, size)
-	b2 := total
-	var n int
-	for len(b2) > 0 {
-		n, err = r.Read(b2)
-		if err != nil {
-			return nil, err
-		}
-		b2 = b2[n:]
+		return &Message{Type: Nil, raw: append([]byte(nil), b...)}, nil
 	}
 
-	// There's a hanging \r\n there, gotta read past it
-	trail := make([]byte, 2)
-	for i := 0; i < 2; i++ {
-		if c, err := r.ReadByte(); err != nil {
-			return nil, err
-		} else {
-			trail[i] = c
-		}
+	raw := make([]byte, len(b)+int(size)+2)
+	n := copy(raw, b)
+	if _, err := io.ReadFull(r, raw[n:]); err != nil {
+		return nil, err
 	}
-
-	blens := len(b) + len(total)
-	raw := make([]byte, 0, blens+2)
-	raw = append(raw, b...)
-	raw = append(raw, total...)
-	raw = append(raw, trail...)
-	return &Message{Type: BulkStr, val: total, raw: raw}, nil
+	return &Message{Type: t, val: raw[n : n+int(size)], raw: raw}, nil
 }
 
-func readArray(r *bufio.Reader) (*Message, error) {
+// readAggregate reads an array-like message of the given type, where each
+// counted entry is made up of perEntry messages (e.g. 2 for a map's key and
+// value), which are all flattened into a single slice
+func readAggregate(r *bufio.Reader, t Type, perEntry int64) (*Message, error) {
 	b, err := r.ReadBytes(delimEnd)
 	if err != nil {
 		return nil, err
 	}
-	size, err := strconv.ParseInt(string(b[1:len(b)-2]), 10, 64)
+	size, err := ParseInt(b[1 : len(b)-2])
 	if err != nil {
 		return nil, parseErr
 	}
@@ -185,7 +464,7 @@ func readArray(r *bufio.Reader) (*Message, error) {
 		return &Message{Type: Nil, raw: b}, nil
 	}
 
-	arr := make([]*Message, size)
+	arr := make([]*Message, size*perEntry)
 	for i := range arr {
 		m, err := bufioReadMessage(r)
 		if err != nil {
@@ -194,12 +473,20 @@ func readArray(r *bufio.Reader) (*Message, error) {
 		arr[i] = m
 		b = append(b, m.raw...)
 	}
-	return &Message{Type: Array, val: arr, raw: b}, nil
+	return &Message{Type: t, val: arr, raw: b}, nil
+}
+
+func readAttribute(r *bufio.Reader) (*Message, error) {
+	if _, err := readAggregate(r, Map, 2); err != nil {
+		return nil, err
+	}
+	return bufioReadMessage(r)
 }
 
 // Bytes returns a byte slice representing the value of the Message. Only valid
-// for a Message of type SimpleStr, Err, and BulkStr. Others will return an
-// error
+// for a Message of type SimpleStr, Err, BulkStr, Double, BigNumber and
+// VerbatimStr (in which case the format prefix is included). Others will return
+// an error
 func (m *Message) Bytes() ([]byte, error) {
 	if b, ok := m.val.([]byte); ok {
 		return b, nil
@@ -239,8 +526,56 @@ func (m *Message) Err() (error, error) {
 	return errors.New(s), nil
 }
 
+// Float returns a float64 representing the value of the Message. Only valid
+// for Double messages
+func (m *Message) Float() (float64, error) {
+	if m.Type != Double {
+		return 0, badType
+	}
+	f, err := strconv.ParseFloat(string(m.val.([]byte)), 64)
+	if err != nil {
+		return 0, parseErr
+	}
+	return f, nil
+}
+
+// Bool returns the value of the Message. Only valid for Bool messages
+func (m *Message) Bool() (bool, error) {
+	if b, ok := m.val.(bool); ok {
+		return b, nil
+	}
+	return false, badType
+}
+
+// BigInt returns a *big.Int representing the value of the Message. Only valid
+// for BigNumber messages
+func (m *Message) BigInt() (*big.Int, error) {
+	if m.Type != BigNumber {
+		return nil, badType
+	}
+	i, ok := new(big.Int).SetString(string(m.val.([]byte)), 10)
+	if !ok {
+		return nil, parseErr
+	}
+	return i, nil
+}
+
+// Verbatim returns the format (e.g. "txt" or "mkd") and the text of the
+// Message. Only valid for VerbatimStr messages
+func (m *Message) Verbatim() (string, []byte, error) {
+	if m.Type != VerbatimStr {
+		return "", nil, badType
+	}
+	b := m.val.([]byte)
+	if len(b) < 4 || b[3] != ':' {
+		return "", nil, parseErr
+	}
+	return string(b[:3]), b[4:], nil
+}
+
 // Array returns the Message slice encompassed by this Messsage, assuming the
-// Message is of type Array
+// Message is of type Array, Set or Push. For a Map the keys and values are
+// returned interleaved, i.e. key, value, key, value...
 func (m *Message) Array() ([]*Message, error) {
 	if a, ok := m.val.([]*Message); ok {
 		return a, nil
@@ -248,6 +583,12 @@ func (m *Message) Array() ([]*Message, error) {
 	return nil, badType
 }
 
+// Raw returns the Message's encoded form, exactly as it was read (or as it's
+// written by WriteMessage). It must not be modified.
+func (m *Message) Raw() []byte {
+	return m.raw
+}
+
 // WriteMessage takes in the given Message and writes its encoded form to the
 // given io.Writer
 func WriteMessage(w io.Writer, m *Message) error {
@@ -255,6 +596,67 @@ func WriteMessage(w io.Writer, m *Message) error {
 	return err
 }
 
+// LenReader is an io.Reader whose length is known ahead of time, which is
+// written as a BulkStr holding its contents. One returned by NewLenReader is
+// copied straight from the reader when given to
+// WriteArbitraryAsFlattenedStrings, so its contents never need to be held in
+// memory, but it's consumed by being written and so can only be written once.
+// Other LenReaders, such as *bytes.Buffer, *bytes.Reader and *strings.Reader,
+// are formatted from their contents without being consumed where possible, so
+// that a command which is retried sends the same value again.
+type LenReader interface {
+	io.Reader
+	Len() int
+}
+
+type lenReader struct {
+	io.Reader
+	n int
+}
+
+func (lr *lenReader) Len() int {
+	return lr.n
+}
+
+// sizedReaderAt is implemented by *bytes.Reader and *strings.Reader, whose
+// unread contents can be read using ReadAt without consuming them
+type sizedReaderAt interface {
+	io.ReaderAt
+	Len() int
+	Size() int64
+}
+
+// lenReaderBytes returns the contents of the LenReader, only consuming them if
+// there's no other way to get at them
+func lenReaderBytes(lr LenReader) ([]byte, error) {
+	switch r := lr.(type) {
+	case *bytes.Buffer:
+		return r.Bytes(), nil
+	case sizedReaderAt:
+		b := make([]byte, r.Len())
+		if len(b) == 0 {
+			return b, nil
+		}
+		n, err := r.ReadAt(b, r.Size()-int64(len(b)))
+		if n == len(b) {
+			err = nil
+		}
+		return b, err
+	}
+	b := make([]byte, lr.Len())
+	_, err := io.ReadFull(lr, b)
+	return b, err
+}
+
+// NewLenReader returns a LenReader which streams n bytes from r, see
+// LenReader. If r has fewer than n bytes to give the write it's used in will
+// fail. Since r can only be read once, the LenReader mustn't be given to a
+// command which may be retried, e.g. by a Pool with a RetryPolicy or a
+// PersistentClient.
+func NewLenReader(r io.Reader, n int) LenReader {
+	return &lenReader{io.LimitReader(r, int64(n)), n}
+}
+
 // WriteArbitrary takes in any primitive golang value, or Message, and writes
 // its encoded form to the given io.Writer, inferring types where appropriate.
 func WriteArbitrary(w io.Writer, m interface{}) error {
@@ -278,13 +680,53 @@ func WriteArbitraryAsString(w io.Writer, m interface{}) error {
 // given as an array of bulk strings. If the argument isn't already in a slice
 // or map it will be wrapped so that it is written as an Array of size one.
 //
+// Structs (and pointers to them) are flattened into field name/value pairs,
+// such as HMSET takes. A field is named after its `redis:"name"` tag, or its Go
+// name if it has no tag. Fields tagged with `redis:"-"`, unexported fields, and
+// fields whose value can't be written as a single string (slices other than
+// []byte, maps and structs) are skipped.
+//
+// time.Time values are written as Unix seconds, other values implementing
+// encoding.TextMarshaler are written as the text they marshal to, and are
+// never flattened.
+//
 // Note that if a Message type is found it will *not* be encoded to a BulkStr,
 // but will simply be passed through as whatever type it already represents.
 func WriteArbitraryAsFlattenedStrings(w io.Writer, m interface{}) error {
 	fm := flatten(m)
+	for i := range fm {
+		if _, ok := fm[i].(*lenReader); ok {
+			return writeStreamed(w, fm)
+		}
+	}
 	return WriteArbitraryAsString(w, fm)
 }
 
+// writeStreamed writes the given flattened values as an Array of BulkStrs,
+// copying the contents of any created by NewLenReader directly into w
+func writeStreamed(w io.Writer, fm []interface{}) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte(arrayPrefix)
+	bw.WriteString(strconv.Itoa(len(fm)))
+	bw.Write(delim)
+	for i := range fm {
+		lr, ok := fm[i].(*lenReader)
+		if !ok {
+			bw.Write(format(fm[i], true))
+			continue
+		}
+		l := lr.Len()
+		bw.WriteByte(bulkStrPrefix)
+		bw.WriteString(strconv.Itoa(l))
+		bw.Write(delim)
+		if _, err := io.CopyN(bw, lr, int64(l)); err != nil {
+			return err
+		}
+		bw.Write(delim)
+	}
+	return bw.Flush()
+}
+
 func format(m interface{}, forceString bool) []byte {
 	switch mt := m.(type) {
 	case []byte:
@@ -335,6 +777,20 @@ func format(m interface{}, forceString bool) []byte {
 		} else {
 			return formatErr(mt)
 		}
+	case time.Time:
+		return formatInt(mt.Unix(), forceString)
+	case encoding.TextMarshaler:
+		b, err := mt.MarshalText()
+		if err != nil {
+			return format(err, forceString)
+		}
+		return formatStr(b)
+	case LenReader:
+		b, err := lenReaderBytes(mt)
+		if err != nil {
+			return format(err, forceString)
+		}
+		return formatStr(b)
 
 	// We duplicate the below code here a bit, since this is the common case and
 	// it'd be better to not get the reflect package involved here
@@ -391,11 +847,18 @@ func format(m interface{}, forceString bool) []byte {
 
 var typeOfBytes = reflect.TypeOf([]byte(nil))
 
+// Flatten returns the individual arguments WriteArbitraryAsFlattenedStrings
+// would write the given value as, without converting them to strings
+func Flatten(m interface{}) []interface{} {
+	return flatten(m)
+}
+
 func flatten(m interface{}) []interface{} {
 	t := reflect.TypeOf(m)
 
-	// If it's a byte-slice we don't want to flatten
-	if t == typeOfBytes {
+	// If it's a byte-slice, or something with its own encoding, we don't want
+	// to flatten
+	if t == nil || t == typeOfBytes || singular(m) {
 		return []interface{}{m}
 	}
 
@@ -422,11 +885,58 @@ func flatten(m interface{}) []interface{} {
 		}
 		return ret
 
+	case reflect.Ptr:
+		rm := reflect.ValueOf(m)
+		if rm.IsNil() || t.Elem().Kind() != reflect.Struct {
+			return []interface{}{m}
+		}
+		return flattenStruct(rm.Elem())
+
+	case reflect.Struct:
+		return flattenStruct(reflect.ValueOf(m))
+
 	default:
 		return []interface{}{m}
 	}
 }
 
+// singular returns whether the given value is written as a single string even
+// though it may be a compound type
+func singular(m interface{}) bool {
+	switch m.(type) {
+	case time.Time, encoding.TextMarshaler, LenReader, *Message:
+		return true
+	}
+	return false
+}
+
+func flattenStruct(v reflect.Value) []interface{} {
+	t := v.Type()
+	ret := make([]interface{}, 0, t.NumField()*2)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		name := f.Tag.Get("redis")
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+
+		fv := v.Field(i).Interface()
+		switch f.Type.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Struct:
+			if f.Type != typeOfBytes && !singular(fv) {
+				continue
+			}
+		}
+		ret = append(ret, name, fv)
+	}
+	return ret
+}
+
 func formatStr(b []byte) []byte {
 	l := strconv.Itoa(len(b))
 	bs := make([]byte, 0, len(l)+len(b)+5)
@@ -1,10 +1,16 @@
 package resp
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"strings"
 	. "testing"
+	"time"
 )
 
 func TestRead(t *T) {
@@ -99,6 +105,82 @@ func TestRead(t *T) {
 	assert.Equal(t, []byte("bar"), m.val.([]*Message)[1].val.([]byte))
 }
 
+func TestReadRESP3(t *T) {
+	var m *Message
+	var err error
+
+	// Null
+	m, _ = NewMessage([]byte("_\r\n"))
+	assert.Equal(t, Nil, m.Type)
+
+	// Double
+	m, _ = NewMessage([]byte(",1.23\r\n"))
+	assert.Equal(t, Double, m.Type)
+	f, err := m.Float()
+	assert.Nil(t, err)
+	assert.Equal(t, 1.23, f)
+
+	m, _ = NewMessage([]byte(",-inf\r\n"))
+	f, err = m.Float()
+	assert.Nil(t, err)
+	assert.True(t, f < 0 && f*2 == f)
+
+	// Bool
+	m, _ = NewMessage([]byte("#t\r\n"))
+	assert.Equal(t, Bool, m.Type)
+	b, err := m.Bool()
+	assert.Nil(t, err)
+	assert.True(t, b)
+
+	_, err = NewMessage([]byte("#x\r\n"))
+	assert.NotNil(t, err)
+
+	// Blob error
+	m, _ = NewMessage([]byte("!9\r\nERR oh no\r\n"))
+	assert.Equal(t, Err, m.Type)
+	e, _ := m.Err()
+	assert.Equal(t, "ERR oh no", e.Error())
+
+	// Verbatim string
+	m, _ = NewMessage([]byte("=9\r\ntxt:ohey!\r\n"))
+	assert.Equal(t, VerbatimStr, m.Type)
+	format, text, err := m.Verbatim()
+	assert.Nil(t, err)
+	assert.Equal(t, "txt", format)
+	assert.Equal(t, []byte("ohey!"), text)
+
+	// Big number
+	m, _ = NewMessage([]byte("(3492890328409238509324850943850943825024385\r\n"))
+	assert.Equal(t, BigNumber, m.Type)
+	i, err := m.BigInt()
+	assert.Nil(t, err)
+	assert.Equal(t, "3492890328409238509324850943850943825024385", i.String())
+
+	// Map
+	m, _ = NewMessage([]byte("%2\r\n+foo\r\n:1\r\n+bar\r\n:2\r\n"))
+	assert.Equal(t, Map, m.Type)
+	ms, err := m.Array()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(ms))
+	assert.Equal(t, []byte("bar"), ms[2].val)
+	assert.Equal(t, int64(2), ms[3].val)
+
+	// Set
+	m, _ = NewMessage([]byte("~2\r\n+foo\r\n+bar\r\n"))
+	assert.Equal(t, Set, m.Type)
+	assert.Equal(t, 2, len(m.val.([]*Message)))
+
+	// Push
+	m, _ = NewMessage([]byte(">3\r\n+message\r\n+chan\r\n+hi\r\n"))
+	assert.Equal(t, Push, m.Type)
+	assert.Equal(t, 3, len(m.val.([]*Message)))
+
+	// Attributes are discarded
+	m, _ = NewMessage([]byte("|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.19\r\n:5\r\n"))
+	assert.Equal(t, Int, m.Type)
+	assert.Equal(t, int64(5), m.val)
+}
+
 type arbitraryTest struct {
 	val    interface{}
 	expect []byte
@@ -155,6 +237,44 @@ var arbitraryAsFlattenedStringsTests = []arbitraryTest{
 		}},
 		[]byte("*3\r\n$3\r\nwat\r\n$3\r\nfoo\r\n$1\r\n1\r\n"),
 	},
+	{
+		[]interface{}{"HMSET", "key", &flattenTest{
+			Name:    "bob",
+			Age:     42,
+			Created: time.Unix(1400000000, 0),
+			IP:      net.IPv4(127, 0, 0, 1),
+			Tags:    []string{"skipped"},
+			Skipped: "skipped",
+			Raw:     []byte("raw"),
+		}},
+		[]byte("*12\r\n$5\r\nHMSET\r\n$3\r\nkey\r\n" +
+			"$4\r\nname\r\n$3\r\nbob\r\n$3\r\nage\r\n$2\r\n42\r\n" +
+			"$7\r\ncreated\r\n$10\r\n1400000000\r\n" +
+			"$2\r\nip\r\n$9\r\n127.0.0.1\r\n$3\r\nRaw\r\n$3\r\nraw\r\n"),
+	},
+	{
+		[]interface{}{"SET", "key", net.IPv4(127, 0, 0, 1), nil},
+		[]byte("*4\r\n$3\r\nSET\r\n$3\r\nkey\r\n$9\r\n127.0.0.1\r\n$0\r\n\r\n"),
+	},
+	{
+		[]interface{}{"SET", "key", strings.NewReader("foo"), 1},
+		[]byte("*4\r\n$3\r\nSET\r\n$3\r\nkey\r\n$3\r\nfoo\r\n$1\r\n1\r\n"),
+	},
+	{
+		[]interface{}{"SET", "key", NewLenReader(strings.NewReader("foobar"), 3)},
+		[]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$3\r\nfoo\r\n"),
+	},
+}
+
+type flattenTest struct {
+	Name    string    `redis:"name"`
+	Age     int       `redis:"age"`
+	Created time.Time `redis:"created"`
+	IP      net.IP    `redis:"ip"`
+	Tags    []string  `redis:"tags"`
+	Skipped string    `redis:"-"`
+	Raw     []byte
+	private int
 }
 
 func TestWriteArbitrary(t *T) {
@@ -193,6 +313,94 @@ func TestWriteArbitraryAsFlattenedStrings(t *T) {
 	}
 }
 
+func TestWriteShortLenReader(t *T) {
+	buf := bytes.NewBuffer([]byte{})
+	r := NewLenReader(strings.NewReader("fo"), 3)
+	assert.NotNil(t, WriteArbitraryAsFlattenedStrings(buf, []interface{}{"SET", "key", r}))
+}
+
+func TestWriteLenReaderTwice(t *T) {
+	// Buffers and readers other than those from NewLenReader aren't consumed
+	// by writing them, so that retried commands send the same value
+	strR := strings.NewReader("xfoo")
+	strR.ReadByte()
+	for _, lr := range []LenReader{bytes.NewBufferString("foo"), bytes.NewReader([]byte("foo")), strR} {
+		for i := 0; i < 2; i++ {
+			buf := bytes.NewBuffer([]byte{})
+			assert.Nil(t, WriteArbitraryAsFlattenedStrings(buf, []interface{}{"SET", "key", lr}))
+			assert.Equal(t, []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$3\r\nfoo\r\n"), buf.Bytes())
+		}
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestCopyBulkStr(t *T) {
+	r := bufio.NewReader(bytes.NewBufferString("$3\r\nfoo\r\n$-1\r\n:5\r\n$3\r\nbar\r\n+OK\r\n"))
+	buf := bytes.NewBuffer([]byte{})
+
+	n, m, err := CopyBulkStr(buf, r)
+	assert.Nil(t, err)
+	assert.Nil(t, m)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, "foo", buf.String())
+
+	// Anything else is returned as a Message
+	buf.Reset()
+	_, m, err = CopyBulkStr(buf, r)
+	assert.Nil(t, err)
+	assert.Equal(t, Nil, m.Type)
+	_, m, err = CopyBulkStr(buf, r)
+	assert.Nil(t, err)
+	assert.Equal(t, Int, m.Type)
+	assert.Equal(t, 0, buf.Len())
+
+	// A failed write leaves the reader at the next message
+	_, _, err = CopyBulkStr(failWriter{}, r)
+	assert.NotNil(t, err)
+	m, err = ReadMessage(r)
+	assert.Nil(t, err)
+	assert.Equal(t, SimpleStr, m.Type)
+}
+
+func TestReadBulkStrInto(t *T) {
+	r := bufio.NewReader(bytes.NewBufferString("$3\r\nfoo\r\n$0\r\n\r\n$-1\r\n:5\r\n$6\r\nfoobar\r\n"))
+	buf := make([]byte, 0, 4)
+
+	b, m, err := ReadBulkStrInto(r, buf)
+	assert.Nil(t, err)
+	assert.Nil(t, m)
+	assert.Equal(t, "foo", string(b))
+	assert.True(t, &b[0] == &buf[:1][0])
+
+	b, m, err = ReadBulkStrInto(r, buf)
+	assert.Nil(t, err)
+	assert.Nil(t, m)
+	assert.Equal(t, 0, len(b))
+
+	// Anything else is returned as a Message
+	b, m, err = ReadBulkStrInto(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, Nil, m.Type)
+	assert.Equal(t, 0, len(b))
+	_, m, err = ReadBulkStrInto(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, Int, m.Type)
+
+	// buf is grown if need be, keeping its contents
+	b, m, err = ReadBulkStrInto(r, append(buf, 'x'))
+	assert.Nil(t, err)
+	assert.Nil(t, m)
+	assert.Equal(t, "xfoobar", string(b))
+
+	_, _, err = ReadBulkStrInto(r, buf)
+	assert.Equal(t, io.EOF, err)
+}
+
 func TestMessageWrite(t *T) {
 	var err error
 	var m *Message
@@ -207,3 +415,44 @@ func TestMessageWrite(t *T) {
 		assert.Equal(t, test.expect, buf.Bytes())
 	}
 }
+
+func TestReader(t *T) {
+	in := "+OK\r\n:5\r\n*2\r\n$3\r\nfoo\r\n$-1\r\n"
+	r := NewReader(bytes.NewBufferString(in))
+
+	var raw []byte
+	for _, typ := range []Type{SimpleStr, Int, Array} {
+		m, err := r.ReadMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, typ, m.Type)
+		raw = append(raw, m.Raw()...)
+	}
+	assert.Equal(t, in, string(raw))
+
+	_, err := r.ReadMessage()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestParseInt(t *T) {
+	for _, s := range []string{"0", "5", "-5", "1234567890", "9223372036854775807", "-9223372036854775808"} {
+		i, err := ParseInt([]byte(s))
+		assert.Nil(t, err, s)
+		assert.Equal(t, s, strconv.FormatInt(i, 10))
+	}
+	for _, s := range []string{"", "-", "+5", "5a", " 5", "9223372036854775808", "-9223372036854775809", "99999999999999999999"} {
+		_, err := ParseInt([]byte(s))
+		assert.True(t, IsParseErr(err), s)
+	}
+}
+
+func BenchmarkReadMessage(b *B) {
+	in := []byte("*3\r\n$3\r\nfoo\r\n:42\r\n$5\r\nhello\r\n")
+	r := bytes.NewReader(in)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(in)
+		if _, err := ReadMessage(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"time"
+)
+
+// RetryPolicy describes how a command which failed due to a network-level
+// error (or, depending on RetryOn, some other error) should be retried. It is
+// consulted by PersistentClient and the pool package, each of which have a
+// CmdNoRetry method for calls which are not idempotent and so must never be
+// retried, and by the cluster package for server errors.
+type RetryPolicy struct {
+	// Maximum number of times a command will be attempted, including the first
+	// attempt. Zero or one means no retries.
+	MaxAttempts int
+
+	// Backoff before the first retry. Each subsequent retry doubles it, up to
+	// MaxBackoff (if set)
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// RetryOn decides whether the given error warrants a retry. If nil, all
+	// errors for which IsNetworkErr is true are retried. ErrOOM is never
+	// retried, regardless of RetryOn.
+	RetryOn func(err error) bool
+}
+
+// DefaultServerRetryPolicy is a RetryPolicy for commands refused by a server in
+// a transient state (see IsTransientErr), rather than for network errors. It
+// waits two and a half seconds in total before giving up, which covers
+// most failovers. It's used by the cluster package by default, and can be used
+// elsewhere by setting it as a RetryPolicy, e.g. of a pool.
+var DefaultServerRetryPolicy = &RetryPolicy{
+	MaxAttempts:    6,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	RetryOn:        IsTransientErr,
+}
+
+// ShouldRetry returns whether or not a command which returned the given error
+// on the given attempt (starting at 1) should be tried again. It is safe to
+// call on a nil RetryPolicy, which never retries.
+func (rp *RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	if rp == nil || err == nil || err == ErrOOM || attempt >= rp.MaxAttempts {
+		return false
+	}
+	if rp.RetryOn != nil {
+		return rp.RetryOn(err)
+	}
+	return IsNetworkErr(err)
+}
+
+// Backoff returns how long to wait before making the attempt after the given
+// one (starting at 1).
+func (rp *RetryPolicy) Backoff(attempt int) time.Duration {
+	d := rp.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		if d *= 2; rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+			return rp.MaxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,167 @@
+package redis
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+var (
+	typeOfTime            = reflect.TypeOf(time.Time{})
+	typeOfTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Scan populates the fields of the struct pointed to by dest from the reply.
+// By default the reply is expected to be made up of name/value pairs, such as
+// the reply to HGETALL (either a MultiReply or a MapReply). If names are given
+// the reply is instead expected to be a list of values in the same order, such
+// as the reply to MGET or HMGET called with those names.
+//
+// Each value is stored in the field whose `redis:"name"` tag matches its name,
+// or whose Go name matches if the field has no tag. Fields tagged with
+// `redis:"-"`, and values which have no matching field or are nil, are
+// skipped. Values are converted to the type of their field, which may be a
+// string, []byte, bool, any int, uint or float type, time.Time (stored as Unix
+// seconds), or anything implementing encoding.TextUnmarshaler.
+func (r *Reply) Scan(dest interface{}, names ...string) error {
+	if r.Type == ErrorReply {
+		return r.Err
+	}
+	if r.Type == NilReply {
+		return ErrNil
+	}
+	if r.Type != MultiReply && r.Type != MapReply {
+		return errors.New("reply type is not MultiReply or MapReply")
+	}
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("dest must be a pointer to a struct")
+	}
+	fields := scanFields(v.Elem())
+
+	if len(names) > 0 {
+		if len(names) != len(r.Elems) {
+			return errors.New("number of names does not match number of elements")
+		}
+		for i, name := range names {
+			if err := scanInto(fields, name, r.Elems[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(r.Elems)%2 != 0 {
+		return errors.New("reply has odd number of elements")
+	}
+	for i := 0; i < len(r.Elems); i += 2 {
+		name, err := r.Elems[i].Str()
+		if err != nil {
+			return errors.New("key element has no string reply")
+		}
+		if err := scanInto(fields, name, r.Elems[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanFields returns the settable fields of the given struct value, keyed by
+// the name they are populated from
+func scanFields(v reflect.Value) map[string]reflect.Value {
+	t := v.Type()
+	fields := make(map[string]reflect.Value, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		name := f.Tag.Get("redis")
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+func scanInto(fields map[string]reflect.Value, name string, r *Reply) error {
+	f, ok := fields[name]
+	if !ok || r.Type == NilReply {
+		return nil
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		if r.Type != IntegerReply {
+			return fmt.Errorf("field %q: %s", name, err)
+		}
+		b = []byte(strconv.FormatInt(r.int, 10))
+	}
+	if err := setField(f, b); err != nil {
+		return fmt.Errorf("field %q: %s", name, err)
+	}
+	return nil
+}
+
+func setField(f reflect.Value, b []byte) error {
+	if f.Type() == typeOfTime {
+		if len(b) == 0 {
+			f.Set(reflect.Zero(typeOfTime))
+			return nil
+		}
+		ts, err := strconv.ParseInt(string(b), 10, 64)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(time.Unix(ts, 0)))
+		return nil
+	}
+
+	// Checked after time.Time, which is a TextUnmarshaler itself
+	if f.CanAddr() && f.Addr().Type().Implements(typeOfTextUnmarshaler) {
+		return f.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(b)
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(string(b))
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", f.Type())
+		}
+		f.SetBytes(append([]byte(nil), b...))
+	case reflect.Bool:
+		v, err := strconv.ParseBool(string(b))
+		if err != nil {
+			return err
+		}
+		f.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(string(b), 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(string(b), 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(string(b), f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(v)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
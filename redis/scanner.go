@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+)
+
+// ScanOpts describe what a Scanner iterates over
+type ScanOpts struct {
+	// One of SCAN, HSCAN, SSCAN or ZSCAN. Defaults to SCAN.
+	Command string
+
+	// The key to iterate over, for every command but SCAN
+	Key string
+
+	// If set, passed along as the MATCH and COUNT options
+	Pattern string
+	Count   int
+}
+
+// Scanner iterates over the results of one of the SCAN family of commands,
+// keeping track of the cursor and making calls as needed.
+//
+//	s := redis.NewScanner(client, redis.ScanOpts{Pattern: "user:*"})
+//	for s.Next() {
+//		fmt.Println(s.Value())
+//	}
+//	if err := s.Err(); err != nil {
+//		// handle error
+//	}
+//
+// As with the commands themselves, an element may be returned more than once.
+// For HSCAN and ZSCAN the elements alternate between a field or member and its
+// value or score.
+type Scanner struct {
+	c      Cmder
+	opts   ScanOpts
+	cursor string
+	buf    []string
+	val    string
+	err    error
+}
+
+// NewScanner returns a Scanner which performs its calls using the given Cmder.
+// Since SCAN takes no key, SCAN can't be used with a Cluster, only the other
+// commands can.
+func NewScanner(c Cmder, opts ScanOpts) *Scanner {
+	if opts.Command == "" {
+		opts.Command = "SCAN"
+	}
+	return &Scanner{c: c, opts: opts, cursor: "0"}
+}
+
+// Next advances the Scanner to the next element, which is then returned by
+// Value. It returns false once there are no more elements, or if an error was
+// encountered, see Err.
+func (s *Scanner) Next() bool {
+	for len(s.buf) == 0 {
+		if s.err != nil || s.cursor == "" {
+			return false
+		}
+		s.err = s.scan()
+	}
+	s.val, s.buf = s.buf[0], s.buf[1:]
+	return true
+}
+
+func (s *Scanner) scan() error {
+	args := make([]interface{}, 0, 6)
+	if !strings.EqualFold(s.opts.Command, "SCAN") {
+		args = append(args, s.opts.Key)
+	}
+	args = append(args, s.cursor)
+	if s.opts.Pattern != "" {
+		args = append(args, "MATCH", s.opts.Pattern)
+	}
+	if s.opts.Count > 0 {
+		args = append(args, "COUNT", s.opts.Count)
+	}
+
+	r := s.c.Cmd(s.opts.Command, args...)
+	if r.Err != nil {
+		return r.Err
+	} else if r.Type != MultiReply || len(r.Elems) != 2 {
+		return errors.New("reply is not formatted as a scan reply")
+	}
+	cursor, err := r.Elems[0].Str()
+	if err != nil {
+		return err
+	}
+	if s.buf, err = r.Elems[1].List(); err != nil {
+		return err
+	}
+
+	// A cursor of 0 means the iteration is over
+	if cursor == "0" {
+		cursor = ""
+	}
+	s.cursor = cursor
+	return nil
+}
+
+// Value returns the element the last call to Next advanced to
+func (s *Scanner) Value() string {
+	return s.val
+}
+
+// Err returns the error which stopped iteration, if any
+func (s *Scanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// Script is a lua script which is performed using EVALSHA, so that its source
+// only needs to be sent to the server the first time it's used.
+//
+//	var getset = redis.NewScript(1, `
+//		local old = redis.call("GET", KEYS[1])
+//		redis.call("SET", KEYS[1], ARGV[1])
+//		return old
+//	`)
+//
+//	old, err := getset.Cmd(client, "foo", "bar").Str()
+type Script struct {
+	numKeys int
+	src     string
+	sha     string
+}
+
+// NewScript returns a Script for the given source, which takes the given number
+// of keys
+func NewScript(numKeys int, src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{
+		numKeys: numKeys,
+		src:     src,
+		sha:     hex.EncodeToString(sum[:]),
+	}
+}
+
+// SHA returns the hex encoded SHA1 digest of the script's source, which is what
+// the server knows it by
+func (s *Script) SHA() string {
+	return s.sha
+}
+
+// Cmd performs the script using the given Cmder, which may be a Client, a
+// Pool, a Cluster, or anything else with a Cmd method. keysAndArgs must start
+// with the script's keys, followed by its other arguments. The script is
+// performed using EVALSHA, and if the server doesn't have it yet EVAL is used
+// instead, which also loads it for next time.
+func (s *Script) Cmd(c Cmder, keysAndArgs ...interface{}) *Reply {
+	args := make([]interface{}, 0, len(keysAndArgs)+2)
+	args = append(args, s.sha, s.numKeys)
+	args = append(args, keysAndArgs...)
+	r := c.Cmd("EVALSHA", args...)
+	if !isNoScript(r.Err) {
+		return r
+	}
+	args[0] = s.src
+	return c.Cmd("EVAL", args...)
+}
+
+func isNoScript(err error) bool {
+	if _, ok := err.(*CmdError); !ok {
+		return false
+	}
+	return strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
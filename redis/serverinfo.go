@@ -0,0 +1,275 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of a redis server, e.g. 7.2.4
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses a version of the form "major.minor.patch", as given by
+// HELLO and INFO. The minor and patch numbers may be left out, and anything
+// following a number (e.g. the "-rc1" of "7.4.0-rc1") is ignored.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	parts := strings.SplitN(s, ".", 3)
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			return Version{}, fmt.Errorf("malformed version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns whether v is the given version or later
+func (v Version) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	} else if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// Feature is a server feature which not every version of redis has, see
+// ServerInfo.Supports
+type Feature int
+
+// The features which can be checked for, along with the redis version each
+// appeared in
+const (
+	FeatureObjectFreq    Feature = iota // OBJECT FREQ, 4.0
+	FeatureRESP3                        // HELLO and RESP3, 6.0
+	FeatureACL                          // ACL users, 6.0
+	FeatureClientInfo                   // CLIENT INFO, 6.2
+	FeatureFunctions                    // FUNCTION and FCALL, 7.0
+	FeatureShardedPubSub                // SSUBSCRIBE and SPUBLISH, 7.0
+	FeatureClientSetInfo                // CLIENT SETINFO, 7.2
+)
+
+var features = map[Feature]struct {
+	name    string
+	version Version
+}{
+	FeatureObjectFreq:    {"OBJECT FREQ", Version{4, 0, 0}},
+	FeatureRESP3:         {"RESP3", Version{6, 0, 0}},
+	FeatureACL:           {"ACL", Version{6, 0, 0}},
+	FeatureClientInfo:    {"CLIENT INFO", Version{6, 2, 0}},
+	FeatureFunctions:     {"functions", Version{7, 0, 0}},
+	FeatureShardedPubSub: {"sharded pub/sub", Version{7, 0, 0}},
+	FeatureClientSetInfo: {"CLIENT SETINFO", Version{7, 2, 0}},
+}
+
+func (f Feature) String() string {
+	if ff, ok := features[f]; ok {
+		return ff.name
+	}
+	return "Feature(" + strconv.Itoa(int(f)) + ")"
+}
+
+// MinVersion returns the first version of redis with the feature
+func (f Feature) MinVersion() Version {
+	return features[f].version
+}
+
+// ServerInfo describes the server a connection is to, see Client.Server
+type ServerInfo struct {
+	// The server's name, "redis" unless it's a compatible server (e.g.
+	// "valkey") which says otherwise in its reply to HELLO
+	Name string
+
+	Version Version
+
+	// "standalone", "cluster" or "sentinel"
+	Mode string
+
+	// "master" or "replica"
+	Role string
+
+	// Names of the modules loaded into the server, only known when the
+	// ServerInfo came from HELLO
+	Modules []string
+}
+
+// Supports returns whether the server's version has the given feature. Some
+// features need to be enabled as well, e.g. OBJECT FREQ only works with an LFU
+// maxmemory-policy, which this doesn't check.
+func (s *ServerInfo) Supports(f Feature) bool {
+	v := f.MinVersion()
+	return s.Version.AtLeast(v.Major, v.Minor, v.Patch)
+}
+
+// UnsupportedError is returned by Client.RequireFeature, and the features
+// which check it, when the server is known to be too old for a feature
+type UnsupportedError struct {
+	Feature Feature
+	Server  *ServerInfo
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf(
+		"%s requires redis %s or later, server is %s %s",
+		e.Feature, e.Feature.MinVersion(), e.Server.Name, e.Server.Version,
+	)
+}
+
+// Server returns what's known about the server the connection is to, or nil
+// if nothing is. It's known once HELLO has succeeded on the connection (e.g.
+// due to DialOpts.Negotiate or PreferRESP3), or once DetectServer has been
+// called.
+func (c *Client) Server() *ServerInfo {
+	return c.server
+}
+
+// DetectServer finds out about the server the connection is to using INFO,
+// which unlike HELLO works with every version of redis and doesn't change the
+// connection's protocol. Afterwards Server returns the same ServerInfo.
+func (c *Client) DetectServer() (*ServerInfo, error) {
+	s, err := c.Cmd("INFO").Str()
+	if err != nil {
+		return nil, err
+	}
+	si, err := parseInfoServer(s)
+	if err != nil {
+		return nil, err
+	}
+	c.server = si
+	return si, nil
+}
+
+// RequireFeature returns an *UnsupportedError if the server is known to not
+// have the given feature. If nothing is known about the server (see Server)
+// nil is returned, leaving it to the server to reject the commands involved.
+func (c *Client) RequireFeature(f Feature) error {
+	if c.server != nil && !c.server.Supports(f) {
+		return &UnsupportedError{Feature: f, Server: c.server}
+	}
+	return nil
+}
+
+// Server returns what's known about the server the current connection is to,
+// see Client.Server
+func (p *PersistentClient) Server() *ServerInfo {
+	return p.client.Server()
+}
+
+// RequireFeature checks the server the current connection is to for the
+// given feature, see Client.RequireFeature
+func (p *PersistentClient) RequireFeature(f Feature) error {
+	return p.client.RequireFeature(f)
+}
+
+// requireFeature calls RequireFeature on c if it's a connection which knows
+// about its server, e.g. a *Client
+func requireFeature(c Cmder, f Feature) error {
+	if fc, ok := c.(interface{ RequireFeature(Feature) error }); ok {
+		return fc.RequireFeature(f)
+	}
+	return nil
+}
+
+// negotiate switches the connection to RESP3 if the server supports it, and
+// finds out about the server along the way, for DialOpts.Negotiate. Only
+// network errors are returned, a server which refuses both HELLO and INFO
+// (e.g. a restrictive proxy) is left unknown.
+func (c *Client) negotiate() error {
+	if r := c.HelloFallback(); IsNetworkErr(r.Err) {
+		return r.Err
+	}
+	if c.server != nil {
+		return nil
+	}
+	// Servers before redis 6 have no HELLO, and a proxy may refuse HELLO 3
+	if r := c.Hello(2); IsNetworkErr(r.Err) {
+		return r.Err
+	} else if r.Err == nil {
+		return nil
+	}
+	if _, err := c.DetectServer(); IsNetworkErr(err) {
+		return err
+	}
+	return nil
+}
+
+// parseHello returns the ServerInfo given by the reply to a HELLO command
+func parseHello(r *Reply) (*ServerInfo, error) {
+	m, err := r.Map()
+	if err != nil {
+		return nil, err
+	}
+	si := &ServerInfo{Name: "redis"}
+	var version string
+	for key, dst := range map[string]*string{
+		"server": &si.Name, "version": &version, "mode": &si.Mode, "role": &si.Role,
+	} {
+		if m[key] == nil {
+			continue
+		} else if *dst, err = m[key].Str(); err != nil {
+			return nil, fmt.Errorf("HELLO reply field %q: %s", key, err)
+		}
+	}
+	if si.Version, err = ParseVersion(version); err != nil {
+		return nil, err
+	}
+	if m["modules"] != nil {
+		for _, mod := range m["modules"].Elems {
+			if mm, err := mod.Map(); err == nil && mm["name"] != nil {
+				name, _ := mm["name"].Str()
+				si.Modules = append(si.Modules, name)
+			}
+		}
+	}
+	return si, nil
+}
+
+// parseInfoServer returns the ServerInfo given by the output of INFO
+func parseInfoServer(info string) (*ServerInfo, error) {
+	si := &ServerInfo{Name: "redis", Mode: "standalone"}
+	var version string
+	s := bufio.NewScanner(strings.NewReader(info))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		i := strings.IndexByte(line, ':')
+		if i < 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch key, val := line[:i], line[i+1:]; key {
+		case "redis_version":
+			version = val
+		case "redis_mode", "server_mode":
+			si.Mode = val
+		case "role":
+			si.Role = val
+			if val == "slave" {
+				si.Role = "replica"
+			}
+		case "server_name":
+			si.Name = val
+		}
+	}
+	if version == "" {
+		return nil, errors.New("INFO has no redis_version")
+	}
+	var err error
+	if si.Version, err = ParseVersion(version); err != nil {
+		return nil, err
+	}
+	return si, nil
+}
@@ -0,0 +1,108 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+)
+
+var errResetSubscribed = errors.New("can't reset the state of a subscribed connection")
+
+// Subscribed returns whether the connection is subscribed to any channels or
+// patterns, as tracked from the replies to the SUBSCRIBE family of commands
+// read from it. A subscribed connection can't be used for anything else (other
+// than with RESP3) until it has unsubscribed from all of them.
+func (c *Client) Subscribed() bool {
+	return c.subscribed
+}
+
+// InTransaction returns whether a MULTI has been performed on the connection
+// without a matching EXEC or DISCARD, in which case commands are being queued
+// rather than performed
+func (c *Client) InTransaction() bool {
+	return c.inTx
+}
+
+// NeedsReset returns whether the connection has state left over from its use
+// which a freshly made connection with the given database selected wouldn't
+// have, see ResetState
+func (c *Client) NeedsReset(db int) bool {
+	return len(c.pending) > 0 || len(c.completed) > 0 || c.subscribed ||
+		c.inTx || c.watching || c.db != db
+}
+
+// ResetState returns the connection to the state of a freshly made one with the
+// given database selected, so that it can be handed to someone else: calls
+// which were Appended, or whose replies were read but not yet returned by
+// GetReply, are dropped, an open transaction is DISCARDed (or else any
+// WATCHed keys are UNWATCHed), and the database is SELECTed if it isn't
+// already. Subscribed connections can't be reset. If an error is returned the
+// connection's state is unknown, and it should be closed.
+func (c *Client) ResetState(db int) error {
+	if c.subscribed {
+		return errResetSubscribed
+	}
+	c.pending, c.completed = nil, nil
+	if c.inTx {
+		if err := c.Cmd("DISCARD").Err; err != nil {
+			return err
+		}
+	} else if c.watching {
+		if err := c.Cmd("UNWATCH").Err; err != nil {
+			return err
+		}
+	}
+	if c.db != db {
+		if err := c.Cmd("SELECT", db).Err; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackSub updates whether the connection is subscribed, if the reply is one
+// confirming a subscription or unsubscription, i.e. of the form [kind, name,
+// count]. With RESP3 those are always push replies.
+func (c *Client) trackSub(r *Reply) {
+	if r.Type != PushReply && (c.proto == 3 || r.Type != MultiReply) {
+		return
+	} else if len(r.Elems) != 3 || r.Elems[2].Type != IntegerReply {
+		return
+	}
+	kind, err := r.Elems[0].Str()
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(kind) {
+	case "subscribe", "psubscribe", "ssubscribe",
+		"unsubscribe", "punsubscribe", "sunsubscribe":
+		c.subscribed = r.Elems[2].int > 0
+	}
+}
+
+// trackTx updates whether the connection is in a transaction or has keys
+// WATCHed, based on the reply to req
+func (c *Client) trackTx(req *request, r *Reply) {
+	switch strings.ToUpper(req.cmd) {
+	case "MULTI":
+		if r.Err == nil {
+			c.inTx = true
+		}
+	case "WATCH":
+		if r.Err == nil {
+			c.watching = true
+		}
+	case "EXEC", "DISCARD":
+		if !IsNetworkErr(r.Err) {
+			c.inTx, c.watching = false, false
+		}
+	case "UNWATCH":
+		if r.Err == nil {
+			c.watching = false
+		}
+	case "RESET":
+		if r.Err == nil {
+			c.inTx, c.watching, c.subscribed = false, false, false
+			c.db = 0
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Trace is a set of optional callbacks which are called as connections are
+// made and closed and commands are performed on them, for wiring in tracing
+// (e.g. OpenTelemetry) or structured logging. Any of the callbacks may be nil.
+// A Trace may be shared by many Clients, so its callbacks may be called from
+// multiple routines at once.
+type Trace struct {
+	// Called once a connection has been dialed, or has failed to be
+	ConnCreated func(TraceConn)
+
+	// Called once a connection is closed, whether by Close or because of a
+	// network error
+	ConnClosed func(TraceConn)
+
+	// Called as a command is written to the connection, and once its reply
+	// has been read (or failed to be). Commands in a pipeline are all started
+	// before any of them complete.
+	CmdStarted   func(TraceCmd)
+	CmdCompleted func(TraceCmd)
+}
+
+// TraceConn describes a connection being created or closed
+type TraceConn struct {
+	Network, Addr string
+
+	// How long dialing took, for ConnCreated
+	Duration time.Duration
+
+	// Why dialing failed, for ConnCreated
+	Err error
+}
+
+// TraceCmd describes a command being started or completed
+type TraceCmd struct {
+	Cmd  string
+	Args []interface{}
+
+	// How long it took between writing the command and reading its reply, and
+	// the reply's error, for CmdCompleted
+	Duration time.Duration
+	Err      error
+}
+
+// DialTrace is like DialTimeout, but sets the given Trace on the Client, and
+// calls its ConnCreated callback once the connection has been made or failed
+// to be
+func DialTrace(network, addr string, timeout time.Duration, trace *Trace) (*Client, error) {
+	return DialWithOpts(network, addr, DialOpts{Timeout: timeout, Trace: trace})
+}
+
+// dialTrace dials, calling the ConnCreated callback of the options' Trace once
+// it's done
+func dialTrace(network, addr string, opts DialOpts) (*Client, error) {
+	start := time.Now()
+	c, err := dialOpts(network, addr, opts)
+	if trace := opts.Trace; trace != nil && trace.ConnCreated != nil {
+		trace.ConnCreated(TraceConn{
+			Network:  network,
+			Addr:     addr,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}
+	return c, err
+}
+
+func (c *Client) traceClosed() {
+	if c.Trace == nil || c.Trace.ConnClosed == nil || !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+	addr := c.Conn.RemoteAddr()
+	if addr == nil {
+		c.Trace.ConnClosed(TraceConn{})
+		return
+	}
+	c.Trace.ConnClosed(TraceConn{Network: addr.Network(), Addr: addr.String()})
+}
+
+func (c *Client) traceStarted(req *request) {
+	if c.Trace != nil && c.Trace.CmdStarted != nil {
+		c.Trace.CmdStarted(TraceCmd{Cmd: req.cmd, Args: req.args})
+	}
+}
+
+func (c *Client) traceCompleted(req *request, err error) {
+	if c.Trace == nil || c.Trace.CmdCompleted == nil {
+		return
+	}
+	c.Trace.CmdCompleted(TraceCmd{
+		Cmd:      req.cmd,
+		Args:     req.args,
+		Duration: time.Since(req.start),
+		Err:      err,
+	})
+}
@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultTxAttempts is the number of times Watch.Do attempts a transaction if
+// Attempts hasn't been called
+const DefaultTxAttempts = 5
+
+// Watch is an optimistically locked transaction on a set of keys, created by
+// Client.Watch. See Do.
+type Watch struct {
+	c        *Client
+	keys     []interface{}
+	attempts int
+}
+
+// Watch returns a Watch for performing transactions which are aborted if any of
+// the given keys are modified by someone else in the meantime. With no keys
+// the transaction is a plain MULTI/EXEC, and is never aborted.
+//
+//	err := client.Watch("foo").Do(func(tx *redis.Tx) error {
+//		i, err := tx.Cmd("GET", "foo").Int()
+//		if err != nil {
+//			return err
+//		}
+//		tx.Queue("SET", "foo", i*2)
+//		return nil
+//	})
+func (c *Client) Watch(keys ...string) *Watch {
+	w := &Watch{c: c, attempts: DefaultTxAttempts}
+	for _, k := range keys {
+		w.keys = append(w.keys, k)
+	}
+	return w
+}
+
+// Attempts sets the maximum number of times Do will attempt the transaction,
+// and returns the Watch
+func (w *Watch) Attempts(n int) *Watch {
+	w.attempts = n
+	return w
+}
+
+// Tx is passed to the function given to Watch.Do, and is used to perform
+// commands within the transaction
+type Tx struct {
+	c    *Client
+	reqs []*request
+}
+
+// Cmd performs the given command immediately, outside of the MULTI block. This
+// is used for reading the watched keys.
+func (tx *Tx) Cmd(cmd string, args ...interface{}) *Reply {
+	return tx.c.Cmd(cmd, args...)
+}
+
+// Queue adds the given command to the MULTI block, which is sent once the
+// function given to Do returns. It returns the index of the command's reply in
+// the slice returned by Do.
+func (tx *Tx) Queue(cmd string, args ...interface{}) int {
+	tx.reqs = append(tx.reqs, &request{cmd: cmd, args: args})
+	return len(tx.reqs) - 1
+}
+
+// Do WATCHes the keys, calls fn, and then performs the commands fn queued in a
+// MULTI/EXEC block, returning the replies from EXEC. If a watched key was
+// modified before EXEC the whole thing is run again, including fn, up to the
+// number of attempts set. If all attempts are aborted ErrTxConflict is
+// returned. If any of the commands failed the replies are returned along with
+// a *TxError, see ParseExec.
+//
+// If fn returns an error the transaction is abandoned and the error is
+// returned. If fn doesn't queue any commands nothing is sent.
+func (w *Watch) Do(fn func(tx *Tx) error) ([]*Reply, error) {
+	for attempt := 0; attempt < w.attempts; attempt++ {
+		if len(w.keys) > 0 {
+			if err := w.c.Cmd("WATCH", w.keys...).Err; err != nil {
+				return nil, err
+			}
+		}
+
+		tx := &Tx{c: w.c}
+		if err := fn(tx); err != nil {
+			w.unwatch()
+			return nil, err
+		} else if len(tx.reqs) == 0 {
+			w.unwatch()
+			return nil, nil
+		}
+
+		p := w.c.Pipeline()
+		p.Queue("MULTI")
+		for _, req := range tx.reqs {
+			p.Queue(req.cmd, req.args...)
+		}
+		exec := p.Queue("EXEC")
+		if err := p.Flush(); err != nil {
+			return nil, err
+		}
+
+		rs, err := ParseExec(p.Replies()[1:exec], p.Reply(exec))
+		if err == ErrTxConflict {
+			continue
+		}
+		return rs, err
+	}
+	return nil, ErrTxConflict
+}
+
+func (w *Watch) unwatch() {
+	if len(w.keys) > 0 {
+		w.c.Cmd("UNWATCH")
+	}
+}
+
+// TxError is returned when one or more commands in a MULTI/EXEC block failed
+type TxError struct {
+	// The errors of the commands which failed, keyed by the index of the
+	// command within the block
+	Errs map[int]error
+
+	// If true the server refused to perform the block at all (EXECABORT),
+	// because the commands in Errs could not be queued, e.g. due to a wrong
+	// number of arguments. Otherwise all commands were performed, and the ones
+	// in Errs failed while being performed, e.g. due to a key of the wrong
+	// type.
+	Aborted bool
+}
+
+func (e *TxError) Error() string {
+	idx := make([]int, 0, len(e.Errs))
+	for i := range e.Errs {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	msgs := make([]string, len(idx))
+	for j, i := range idx {
+		msgs[j] = fmt.Sprintf("command %d: %s", i, e.Errs[i])
+	}
+
+	prefix := "transaction had failed commands"
+	if e.Aborted {
+		prefix = "transaction aborted"
+	}
+	return prefix + ": " + strings.Join(msgs, ", ")
+}
+
+// ParseExec takes the replies to the commands queued after a MULTI (each of
+// which is a QUEUED status or an error) and the reply to the EXEC which
+// followed them, and returns a reply for each queued command. It's used by
+// Watch.Do, but can be used for blocks sent by other means, e.g. a Pipeline.
+//
+// If a watched key was modified, so the block wasn't performed, ErrTxConflict
+// is returned. If any of the commands failed, either while being queued or
+// while being performed, a *TxError is returned along with the replies, in
+// which those commands have an ErrorReply. Any other error from EXEC itself is
+// returned as-is.
+func ParseExec(queued []*Reply, exec *Reply) ([]*Reply, error) {
+	if exec.Type == NilReply {
+		return nil, ErrTxConflict
+	}
+
+	if exec.Type == ErrorReply {
+		txErr := &TxError{Errs: map[int]error{}, Aborted: true}
+		rs := make([]*Reply, len(queued))
+		for i, q := range queued {
+			if q.Err != nil {
+				rs[i] = q
+				txErr.Errs[i] = q.Err
+			} else {
+				rs[i] = &Reply{Type: ErrorReply, Err: exec.Err}
+			}
+		}
+		if len(txErr.Errs) == 0 {
+			return nil, exec.Err
+		}
+		return rs, txErr
+	}
+
+	if exec.Type != MultiReply || len(exec.Elems) != len(queued) {
+		return nil, errors.New("reply is not formatted as an EXEC reply")
+	}
+	txErr := &TxError{Errs: map[int]error{}}
+	for i, r := range exec.Elems {
+		if r.Err != nil {
+			txErr.Errs[i] = r.Err
+		}
+	}
+	if len(txErr.Errs) > 0 {
+		return exec.Elems, txErr
+	}
+	return exec.Elems, nil
+}
@@ -0,0 +1,190 @@
+package redis
+
+import (
+	"errors"
+)
+
+// This file holds accessors for replies which are nested arrays whose layout
+// depends on the command, so that callers don't have to index into Elems.
+
+// StreamEntry is a single entry of a stream, as returned by XRANGE, XREAD and
+// the like
+type StreamEntry struct {
+	ID string
+
+	// The entry's fields and values. It's nil for an entry which was deleted
+	// while pending in a consumer group, which XCLAIM and XREADGROUP can return.
+	Fields map[string]string
+}
+
+// GeoLocation is a member of a geospatial index, as returned by GEOSEARCH and
+// GEORADIUS. Dist, Hash and the coordinates are only set if asked for using
+// WITHDIST, WITHHASH and WITHCOORD.
+type GeoLocation struct {
+	Member string
+
+	// Distance from the center of the search, in the unit of the search
+	Dist float64
+
+	// The member's position as a raw geohash
+	Hash int64
+
+	Longitude, Latitude float64
+}
+
+// ZMembers returns the members and scores of a reply to a sorted set command
+// given WITHSCORES (ZRANGE, ZRANGEBYSCORE, ZUNION, etc...) or of ZPOPMIN and
+// ZPOPMAX. That's either a flat list of members and scores, or (with RESP3) a
+// list of member/score pairs.
+func (r *Reply) ZMembers() ([]ZMember, error) {
+	if r.Type == ErrorReply {
+		return nil, r.Err
+	} else if r.Type != MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+
+	elems := r.Elems
+	if len(elems) > 0 && elems[0].Type == MultiReply {
+		flat := make([]*Reply, 0, len(elems)*2)
+		for _, e := range elems {
+			flat = append(flat, e.Elems...)
+		}
+		elems = flat
+	}
+	if len(elems)%2 != 0 {
+		return nil, errors.New("reply has odd number of elements")
+	}
+
+	ms := make([]ZMember, len(elems)/2)
+	for i := range ms {
+		var err error
+		if ms[i].Member, err = elems[i*2].Str(); err != nil {
+			return nil, err
+		}
+		if ms[i].Score, err = elems[i*2+1].Float64(); err != nil {
+			return nil, err
+		}
+	}
+	return ms, nil
+}
+
+// StreamEntries returns the entries of a reply to XRANGE, XREVRANGE or XCLAIM,
+// which is a list of [id, [field, value, ...]] entries
+func (r *Reply) StreamEntries() ([]StreamEntry, error) {
+	if r.Type == ErrorReply {
+		return nil, r.Err
+	} else if r.Type != MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+
+	entries := make([]StreamEntry, len(r.Elems))
+	for i, er := range r.Elems {
+		if er.Type != MultiReply || len(er.Elems) != 2 {
+			return nil, errors.New("stream entry is not an id and fields")
+		}
+		var err error
+		if entries[i].ID, err = er.Elems[0].Str(); err != nil {
+			return nil, err
+		}
+		if er.Elems[1].Type == NilReply {
+			continue
+		}
+		if entries[i].Fields, err = er.Elems[1].Hash(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Streams returns the entries of a reply to XREAD or XREADGROUP, keyed by
+// stream. That's a list of [stream, entries] pairs, or (with RESP3) a map of
+// stream to entries. A nil reply, sent when a blocking read times out,
+// returns ErrNil.
+func (r *Reply) Streams() (map[string][]StreamEntry, error) {
+	switch r.Type {
+	case ErrorReply:
+		return nil, r.Err
+	case NilReply:
+		return nil, ErrNil
+	case MapReply:
+		m, err := r.Map()
+		if err != nil {
+			return nil, err
+		}
+		streams := make(map[string][]StreamEntry, len(m))
+		for stream, er := range m {
+			if streams[stream], err = er.StreamEntries(); err != nil {
+				return nil, err
+			}
+		}
+		return streams, nil
+	case MultiReply:
+		streams := make(map[string][]StreamEntry, len(r.Elems))
+		for _, sr := range r.Elems {
+			if sr.Type != MultiReply || len(sr.Elems) != 2 {
+				return nil, errors.New("stream is not a name and entries")
+			}
+			stream, err := sr.Elems[0].Str()
+			if err != nil {
+				return nil, err
+			}
+			if streams[stream], err = sr.Elems[1].StreamEntries(); err != nil {
+				return nil, err
+			}
+		}
+		return streams, nil
+	}
+	return nil, errors.New("reply type is not MultiReply or MapReply")
+}
+
+// GeoLocations returns the members of a reply to GEOSEARCH, GEORADIUS or
+// GEORADIUSBYMEMBER. Without any of the WITH options that's a list of members,
+// otherwise a list of [member, dist, hash, [longitude, latitude]] with only
+// the parts which were asked for.
+func (r *Reply) GeoLocations() ([]GeoLocation, error) {
+	if r.Type == ErrorReply {
+		return nil, r.Err
+	} else if r.Type != MultiReply {
+		return nil, errors.New("reply type is not MultiReply")
+	}
+
+	locs := make([]GeoLocation, len(r.Elems))
+	for i, lr := range r.Elems {
+		var err error
+		if lr.Type != MultiReply {
+			if locs[i].Member, err = lr.Str(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(lr.Elems) == 0 {
+			return nil, errors.New("geo location has no member")
+		}
+		if locs[i].Member, err = lr.Elems[0].Str(); err != nil {
+			return nil, err
+		}
+
+		// The parts which were asked for are always in the same order, and
+		// are told apart by type: the dist is a bulk string (or a double with
+		// RESP3), the hash an integer, and the coordinates an array
+		for _, e := range lr.Elems[1:] {
+			switch e.Type {
+			case IntegerReply:
+				locs[i].Hash, err = e.Int64()
+			case MultiReply:
+				if len(e.Elems) != 2 {
+					return nil, errors.New("geo coordinates are not a longitude and latitude")
+				}
+				if locs[i].Longitude, err = e.Elems[0].Float64(); err == nil {
+					locs[i].Latitude, err = e.Elems[1].Float64()
+				}
+			default:
+				locs[i].Dist, err = e.Float64()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return locs, nil
+}
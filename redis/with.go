@@ -0,0 +1,176 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/redis/resp"
+)
+
+// Options are the per-call behaviors which can be overridden on a derived
+// handle, see Client.With
+type Options struct {
+	// Read/write timeout for each call
+	Timeout time.Duration
+
+	// How calls which fail are retried, see RetryPolicy
+	RetryPolicy *RetryPolicy
+
+	// Prepended to every key argument of each call, see PrefixKeys
+	KeyPrefix string
+
+	// What's done with KEYS commands, and the limit on the number of keys
+	// returned when they're performed using SCAN. See WithKeysGuard.
+	KeysGuard KeysGuard
+	KeysLimit int
+}
+
+// Option overrides one of the fields of Options
+type Option func(*Options)
+
+// WithTimeout overrides the read/write timeout. Zero means no timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(o *Options) { o.Timeout = d }
+}
+
+// WithRetryPolicy overrides the RetryPolicy. Nil means calls are never retried.
+func WithRetryPolicy(rp *RetryPolicy) Option {
+	return func(o *Options) { o.RetryPolicy = rp }
+}
+
+// WithKeyPrefix sets the prefix prepended to every key argument. Prefixes of
+// nested handles are not combined, the innermost one wins.
+func WithKeyPrefix(prefix string) Option {
+	return func(o *Options) { o.KeyPrefix = prefix }
+}
+
+// ClientHandle is a lightweight handle on a Client, created by With, which
+// performs commands on the Client's connection with some of its behavior
+// overridden. Like Client, it's not safe to use from multiple routines at
+// once, nor at the same time as the Client itself.
+type ClientHandle struct {
+	c    *Client
+	opts Options
+}
+
+// With returns a handle on the Client with the given Options overridden, so
+// that, for example, a library can use a shorter timeout or its own key prefix
+// without needing a connection of its own. Options which aren't overridden are
+// the Client's own: its timeout, and no retries or prefix.
+//
+// Since a Client is closed when it encounters a network error, a RetryPolicy
+// is only useful here with a RetryOn for application level errors such as
+// LoadingError.
+//
+// There's no read preference option: a Client (or Pool) is only ever connected
+// to a single server, and Cluster doesn't route reads to replicas, so there's
+// nowhere else for reads to go yet.
+func (c *Client) With(opts ...Option) *ClientHandle {
+	h := &ClientHandle{c: c, opts: Options{Timeout: c.timeout}}
+	return h.With(opts...)
+}
+
+// With returns a new handle with the given Options overridden on top of this
+// handle's
+func (h *ClientHandle) With(opts ...Option) *ClientHandle {
+	h2 := &ClientHandle{c: h.c, opts: h.opts}
+	for _, o := range opts {
+		o(&h2.opts)
+	}
+	return h2
+}
+
+// Cmd performs the given command on the Client's connection, using the
+// handle's Options
+func (h *ClientHandle) Cmd(cmd string, args ...interface{}) *Reply {
+	if r := h.opts.guardKeys(h, cmd, args); r != nil {
+		return r
+	}
+	if h.opts.KeyPrefix != "" {
+		commands, err := h.c.ServerCommands()
+		if err != nil {
+			return &Reply{Type: ErrorReply, Err: err}
+		}
+		args = PrefixKeys(commands[strings.ToLower(cmd)], h.opts.KeyPrefix, args)
+	}
+
+	for attempt := 1; ; attempt++ {
+		old := h.c.timeout
+		h.c.timeout = h.opts.Timeout
+		r := h.c.Cmd(cmd, args...)
+		h.c.timeout = old
+		if old == 0 && h.opts.Timeout != 0 {
+			// The Client's own calls won't clear the deadlines set for this one
+			h.c.Conn.SetDeadline(time.Time{})
+		}
+		if !h.opts.RetryPolicy.ShouldRetry(attempt, r.Err) {
+			return r
+		}
+		time.Sleep(h.opts.RetryPolicy.Backoff(attempt))
+	}
+}
+
+// PrefixKeys returns a copy of the arguments to the given command with the
+// prefix prepended to each key argument. The arguments are flattened first, as
+// they are by Client.Cmd, and the key arguments are then found using the key
+// positions in the CommandInfo, or the numkeys argument for the EVAL and FCALL
+// families of commands. Any other keys (e.g. the source keys of
+// ZUNIONSTORE) are left as-is, as are all arguments if ci is nil.
+func PrefixKeys(ci *CommandInfo, prefix string, args []interface{}) []interface{} {
+	if ci == nil {
+		return args
+	}
+	args = resp.Flatten(args)
+	for _, i := range keyPositions(ci, args) {
+		args[i] = prefixArg(prefix, args[i])
+	}
+	return args
+}
+
+// keyPositions returns the indexes of the key arguments in args
+func keyPositions(ci *CommandInfo, args []interface{}) []int {
+	switch ci.Name {
+	case "eval", "evalsha", "eval_ro", "evalsha_ro", "fcall", "fcall_ro":
+		// script/sha/function, numkeys, key...
+		if len(args) < 2 {
+			return nil
+		}
+		n, err := strconv.Atoi(fmt.Sprint(args[1]))
+		if err != nil {
+			return nil
+		}
+		pos := make([]int, 0, n)
+		for i := 2; i < 2+n && i < len(args); i++ {
+			pos = append(pos, i)
+		}
+		return pos
+	}
+
+	if ci.FirstKey <= 0 || ci.KeyStep <= 0 {
+		return nil
+	}
+	// Positions count the command name as 0, and a negative last position
+	// counts back from the end
+	last := ci.LastKey
+	if last < 0 {
+		last = len(args) + 1 + last
+	}
+	var pos []int
+	for p := ci.FirstKey; p <= last && p <= len(args); p += ci.KeyStep {
+		pos = append(pos, p-1)
+	}
+	return pos
+}
+
+func prefixArg(prefix string, arg interface{}) interface{} {
+	switch a := arg.(type) {
+	case string:
+		return prefix + a
+	case []byte:
+		return append([]byte(prefix), a...)
+	default:
+		return prefix + fmt.Sprint(a)
+	}
+}
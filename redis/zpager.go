@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"strconv"
+)
+
+// DefaultZPageSize is the ZPageOpts PageSize used when none is given
+const DefaultZPageSize = 100
+
+// ZPageOpts describe the score range a ZPager pages through
+type ZPageOpts struct {
+	Key string
+
+	// Lowest and highest scores of the range, in any form ZRANGEBYSCORE
+	// accepts (e.g. "(5" for exclusive bounds). Default to "-inf" and "+inf".
+	Min, Max string
+
+	// If set, pages go from the highest scores to the lowest
+	Reverse bool
+
+	// Maximum number of members per page. Defaults to DefaultZPageSize.
+	PageSize int
+}
+
+// ZMember is a member of a sorted set along with its score
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZPager pages through a score range of a sorted set, using ZRANGEBYSCORE (or
+// ZREVRANGEBYSCORE) with LIMIT, for things like feeds.
+//
+//	p := redis.NewZPager(client, redis.ZPageOpts{Key: "feed", Reverse: true})
+//	for p.Next() {
+//		for _, m := range p.Page() {
+//			fmt.Println(m.Member, m.Score)
+//		}
+//	}
+//	if err := p.Err(); err != nil {
+//		// handle error
+//	}
+//
+// Each page starts from the last score of the previous one rather than from an
+// ever growing offset, so pages stay cheap however deep they go. Starting
+// after the last score (i.e. with an exclusive bound) would skip any members
+// sharing it which didn't fit on the previous page, so instead the bound is
+// inclusive and the members with that score which were already returned are
+// skipped using LIMIT's offset. Members added or removed during iteration may
+// still cause others to be skipped or repeated.
+type ZPager struct {
+	c    Cmder
+	opts ZPageOpts
+	page []ZMember
+	err  error
+	done bool
+
+	// The score the next page starts at, and how many members with it have
+	// already been returned
+	bound string
+	last  float64
+	skip  int
+}
+
+// NewZPager returns a ZPager which performs its calls using the given Cmder
+func NewZPager(c Cmder, opts ZPageOpts) *ZPager {
+	if opts.Min == "" {
+		opts.Min = "-inf"
+	}
+	if opts.Max == "" {
+		opts.Max = "+inf"
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultZPageSize
+	}
+	return &ZPager{c: c, opts: opts}
+}
+
+// Next retrieves the next page, which is then returned by Page. It returns
+// false once there are no more members, or if an error was encountered, see
+// Err.
+func (p *ZPager) Next() bool {
+	if p.done || p.err != nil {
+		return false
+	}
+
+	min, max := p.opts.Min, p.opts.Max
+	if p.bound != "" && p.opts.Reverse {
+		max = p.bound
+	} else if p.bound != "" {
+		min = p.bound
+	}
+	var r *Reply
+	if p.opts.Reverse {
+		r = p.c.Cmd("ZREVRANGEBYSCORE", p.opts.Key, max, min, "WITHSCORES", "LIMIT", p.skip, p.opts.PageSize)
+	} else {
+		r = p.c.Cmd("ZRANGEBYSCORE", p.opts.Key, min, max, "WITHSCORES", "LIMIT", p.skip, p.opts.PageSize)
+	}
+	if p.page, p.err = r.ZMembers(); p.err != nil {
+		return false
+	}
+	if len(p.page) < p.opts.PageSize {
+		p.done = true
+	}
+	if len(p.page) == 0 {
+		return false
+	}
+
+	last := p.page[len(p.page)-1].Score
+	ties := 0
+	for i := len(p.page) - 1; i >= 0 && p.page[i].Score == last; i-- {
+		ties++
+	}
+	if p.bound != "" && ties == len(p.page) && last == p.last {
+		// The whole page had the same score as the end of the previous one
+		p.skip += ties
+	} else {
+		p.skip = ties
+	}
+	p.last = last
+	p.bound = strconv.FormatFloat(last, 'g', -1, 64)
+	return true
+}
+
+// Page returns the members of the page the last call to Next retrieved
+func (p *ZPager) Page() []ZMember {
+	return p.page
+}
+
+// Err returns the error which stopped iteration, if any
+func (p *ZPager) Err() error {
+	return p.err
+}